@@ -0,0 +1,279 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/polarstreams/polar/internal/auth"
+	"github.com/polarstreams/polar/internal/conf"
+	"github.com/polarstreams/polar/internal/consuming"
+	"github.com/polarstreams/polar/internal/data/acls"
+	"github.com/polarstreams/polar/internal/data/topics"
+	"github.com/polarstreams/polar/internal/discovery"
+	"github.com/polarstreams/polar/internal/producing"
+	. "github.com/polarstreams/polar/internal/types"
+	"github.com/polarstreams/polar/internal/utils"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+const jsonMimeType = "application/json"
+
+// Admin represents the HTTP server that exposes the administrative API for operators
+type Admin interface {
+	Closer
+
+	AcceptConnections() error
+}
+
+func NewAdmin(
+	config conf.AdminConfig,
+	topologyGetter discovery.TopologyGetter,
+	topicHandler topics.TopicHandler,
+	aclHandler acls.AclHandler,
+	groupAdmin consuming.GroupAdmin,
+	deadLetterAdmin producing.DeadLetterAdmin,
+	authenticator auth.Authenticator,
+) Admin {
+	return &admin{
+		config:          config,
+		authenticator:   authenticator,
+		topologyGetter:  topologyGetter,
+		topicHandler:    topicHandler,
+		aclHandler:      aclHandler,
+		groupAdmin:      groupAdmin,
+		deadLetterAdmin: deadLetterAdmin,
+	}
+}
+
+type admin struct {
+	config          conf.AdminConfig
+	topologyGetter  discovery.TopologyGetter
+	topicHandler    topics.TopicHandler
+	aclHandler      acls.AclHandler
+	groupAdmin      consuming.GroupAdmin
+	deadLetterAdmin producing.DeadLetterAdmin
+	authenticator   auth.Authenticator
+	server          *http.Server
+}
+
+type groupCloneMessage struct {
+	Target string `json:"target"`
+	Topic  string `json:"topic"`
+}
+
+type topicCreateMessage struct {
+	Name     string        `json:"name"`
+	Settings TopicSettings `json:"settings"`
+}
+
+func (a *admin) AcceptConnections() error {
+	port := a.config.AdminPort()
+	address := utils.GetServiceAddress(port, a.topologyGetter.LocalInfo(), a.config)
+	router := httprouter.New()
+	router.GET(conf.StatusUrl, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		fmt.Fprintf(w, "Admin server listening on %d\n", port)
+	})
+
+	router.GET(conf.AdminTopicsUrl, utils.ToHandle(a.getTopicsHandler))
+	router.POST(conf.AdminTopicsUrl, utils.ToHandle(a.postTopicHandler))
+	router.GET(conf.AdminTopicUrl, utils.ToHandle(a.getTopicHandler))
+	router.PUT(conf.AdminTopicUrl, utils.ToHandle(a.putTopicHandler))
+	router.DELETE(conf.AdminTopicUrl, utils.ToHandle(a.deleteTopicHandler))
+	router.GET(conf.AdminTopicDeadLettersUrl, utils.ToHandle(a.getTopicDeadLettersHandler))
+	router.GET(conf.AdminGroupsUrl, utils.ToHandle(a.getGroupsHandler))
+	router.GET(conf.AdminGroupUrl, utils.ToHandle(a.getGroupHandler))
+	router.DELETE(conf.AdminGroupUrl, utils.ToHandle(a.deleteGroupHandler))
+	router.POST(conf.AdminGroupSeekUrl, utils.ToPostHandle(a.postGroupSeekHandler))
+	router.GET(conf.AdminGroupLagUrl, utils.ToHandle(a.getGroupLagHandler))
+	router.POST(conf.AdminGroupCloneUrl, utils.ToPostHandle(a.postGroupCloneHandler))
+	router.GET(conf.AdminAclsUrl, utils.ToHandle(a.getAclsHandler))
+	router.POST(conf.AdminAclsUrl, utils.ToHandle(a.postAclHandler))
+	router.DELETE(conf.AdminAclUrl, utils.ToHandle(a.deleteAclHandler))
+
+	// When authentication is enabled, only the admin principals are allowed
+	if a.authenticator.IsEnabled() && len(a.config.AdminPrincipals()) == 0 {
+		log.Warn().Msgf("No admin principals are set, the admin api requests will be rejected")
+	}
+	handler := auth.AdminHandler(a.authenticator, a.config.AdminPrincipals(), router)
+	tlsConfig, err := utils.NewServerTlsConfig(a.config, "h2", "http/1.1")
+	if err != nil {
+		return err
+	}
+	h2s := &http2.Server{}
+	server := &http.Server{
+		Addr:      address,
+		Handler:   h2c.NewHandler(handler, h2s),
+		TLSConfig: tlsConfig,
+	}
+
+	if err := http2.ConfigureServer(server, h2s); err != nil {
+		return err
+	}
+	listener, err := utils.Listen(address, tlsConfig)
+	if err != nil {
+		return err
+	}
+
+	c := make(chan bool, 1)
+	go func() {
+		c <- true
+		if err := server.Serve(listener); err != nil {
+			if err == http.ErrServerClosed {
+				log.Info().Msgf("Admin server stopped")
+			} else {
+				log.Err(err).Msgf("Admin server stopped serving")
+			}
+		}
+	}()
+
+	a.server = server
+
+	<-c
+	log.Info().Msgf("Start listening to admin requests on %s", address)
+
+	return nil
+}
+
+func (a *admin) Close() {
+	if a.server != nil {
+		_ = a.server.Close()
+	}
+}
+
+func (a *admin) getTopicsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) error {
+	return respondJson(w, http.StatusOK, a.topicHandler.List())
+}
+
+func (a *admin) postTopicHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) error {
+	var message topicCreateMessage
+	if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+		return NewHttpError(http.StatusBadRequest, "Invalid topic create message")
+	}
+
+	info, err := a.topicHandler.Create(message.Name, message.Settings)
+	if err != nil {
+		return err
+	}
+	return respondJson(w, http.StatusCreated, info)
+}
+
+func (a *admin) getTopicHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) error {
+	topic := ps.ByName("topic")
+	info := a.topicHandler.Get(topic)
+	if info == nil {
+		return NewHttpErrorf(http.StatusNotFound, "Topic '%s' not found", topic)
+	}
+	return respondJson(w, http.StatusOK, info)
+}
+
+func (a *admin) putTopicHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) error {
+	var settings TopicSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		return NewHttpError(http.StatusBadRequest, "Invalid topic settings message")
+	}
+
+	info, err := a.topicHandler.UpdateSettings(ps.ByName("topic"), settings)
+	if err != nil {
+		return err
+	}
+	return respondJson(w, http.StatusOK, info)
+}
+
+func (a *admin) deleteTopicHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) error {
+	if err := a.topicHandler.Delete(ps.ByName("topic")); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (a *admin) getTopicDeadLettersHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) error {
+	stats, err := a.deadLetterAdmin.DeadLetterStats(ps.ByName("topic"))
+	if err != nil {
+		return err
+	}
+	return respondJson(w, http.StatusOK, stats)
+}
+
+func (a *admin) getGroupsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) error {
+	groups, err := a.groupAdmin.ListGroups()
+	if err != nil {
+		return err
+	}
+	return respondJson(w, http.StatusOK, groups)
+}
+
+func (a *admin) getGroupHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) error {
+	info, err := a.groupAdmin.DescribeGroup(ps.ByName("group"))
+	if err != nil {
+		return err
+	}
+	return respondJson(w, http.StatusOK, info)
+}
+
+func (a *admin) deleteGroupHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) error {
+	if err := a.groupAdmin.DeleteGroup(ps.ByName("group")); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (a *admin) postGroupSeekHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) error {
+	var target SeekTarget
+	if err := json.NewDecoder(r.Body).Decode(&target); err != nil {
+		return NewHttpError(http.StatusBadRequest, "Invalid seek message")
+	}
+	return a.groupAdmin.SeekGroup(ps.ByName("group"), ps.ByName("topic"), &target)
+}
+
+func (a *admin) getGroupLagHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) error {
+	value, err := a.groupAdmin.GroupLag(ps.ByName("group"))
+	if err != nil {
+		return err
+	}
+	return respondJson(w, http.StatusOK, value)
+}
+
+func (a *admin) postGroupCloneHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) error {
+	var message groupCloneMessage
+	if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+		return NewHttpError(http.StatusBadRequest, "Invalid group clone message")
+	}
+	return a.groupAdmin.CloneGroup(ps.ByName("group"), message.Target, message.Topic)
+}
+
+func (a *admin) getAclsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) error {
+	return respondJson(w, http.StatusOK, a.aclHandler.List())
+}
+
+func (a *admin) postAclHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) error {
+	var rule AclRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		return NewHttpError(http.StatusBadRequest, "Invalid ACL rule message")
+	}
+
+	created, err := a.aclHandler.Create(rule)
+	if err != nil {
+		return err
+	}
+	return respondJson(w, http.StatusCreated, created)
+}
+
+func (a *admin) deleteAclHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) error {
+	if err := a.aclHandler.Delete(ps.ByName("id")); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func respondJson(w http.ResponseWriter, statusCode int, value interface{}) error {
+	w.Header().Set(ContentTypeHeaderKey, jsonMimeType)
+	w.WriteHeader(statusCode)
+	return json.NewEncoder(w).Encode(value)
+}
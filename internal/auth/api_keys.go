@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+)
+
+// The principals by the hash of the API key
+type apiKeys map[[sha256.Size]byte]string
+
+// Represents an entry of the API keys file, a principal can have multiple keys to support rotation
+type apiKeyEntry struct {
+	Principal string `json:"principal"`
+	Key       string `json:"key"`
+}
+
+func parseApiKeys(data []byte) (interface{}, error) {
+	var entries []apiKeyEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+
+	result := make(apiKeys, len(entries))
+	for i, entry := range entries {
+		if entry.Principal == "" || entry.Key == "" {
+			return nil, fmt.Errorf("API key entry %d must define the principal and the key", i)
+		}
+		if isReserved(entry.Principal) {
+			return nil, fmt.Errorf("API key entry %d uses the reserved principal name '%s'", i, entry.Principal)
+		}
+		hash := hashApiKey(entry.Key)
+		if existing, found := result[hash]; found && existing != entry.Principal {
+			return nil, fmt.Errorf("API key of '%s' is also used by '%s'", entry.Principal, existing)
+		}
+		result[hash] = entry.Principal
+	}
+	return result, nil
+}
+
+// Gets the hash of the key, used to look up the principal without comparing the key values
+func hashApiKey(key string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(key))
+}
@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/polarstreams/polar/internal/conf"
+	"github.com/polarstreams/polar/internal/metrics"
+	"github.com/polarstreams/polar/internal/types"
+	"github.com/polarstreams/polar/internal/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// The names of the servers authenticating clients, used in logs and metrics
+const (
+	ProducerServer       = "producer"
+	ProducerBinaryServer = "producerBinary"
+	ConsumerServer       = "consumer"
+	AdminServer          = "admin"
+)
+
+const authorizationHeader = "Authorization"
+const bearerPrefix = "Bearer "
+
+type principalKey struct{}
+
+// Authenticator verifies the credentials provided by the producer and consumer clients
+type Authenticator interface {
+	types.Initializer
+
+	// Determines whether the clients must provide credentials
+	IsEnabled() bool
+
+	// Verifies the token, an API key or a JWT, and returns the name of the authenticated principal
+	Authenticate(token string) (string, error)
+}
+
+func NewAuthenticator(config conf.AuthConfig) Authenticator {
+	a := &authenticator{config: config}
+	if config.AuthApiKeysFile() != "" {
+		a.apiKeys = newReloadingFile(config.AuthApiKeysFile(), parseApiKeys)
+	}
+	if config.AuthJwksFile() != "" {
+		a.jwks = newReloadingFile(config.AuthJwksFile(), parseJwks)
+	}
+	return a
+}
+
+type authenticator struct {
+	config  conf.AuthConfig
+	apiKeys *reloadingFile // The principals by API key hash
+	jwks    *reloadingFile // The public keys to verify JWT signatures
+}
+
+func (a *authenticator) Init() error {
+	for _, f := range []*reloadingFile{a.apiKeys, a.jwks} {
+		if f == nil {
+			continue
+		}
+		if err := f.reloadIfChanged(); err != nil {
+			return fmt.Errorf("Authentication file %s could not be loaded: %s", f.name, err.Error())
+		}
+		go f.reloadPeriodically(a.config.AuthReloadInterval())
+	}
+	if a.IsEnabled() {
+		log.Info().Msgf("Client authentication enabled")
+	}
+	return nil
+}
+
+func (a *authenticator) IsEnabled() bool {
+	return a.apiKeys != nil || a.jwks != nil
+}
+
+func (a *authenticator) Authenticate(token string) (string, error) {
+	if token == "" {
+		return "", fmt.Errorf("No credentials provided")
+	}
+	if a.jwks != nil && strings.Count(token, ".") == 2 {
+		principal, err := verifyJwt(token, a.jwks.get().(jwks), a.config.AuthJwtIssuer(), a.config.AuthJwtAudience())
+		if err == nil {
+			return principal, nil
+		}
+		// An API key can have the shape of a JWT
+		if principal, found := a.apiKeyPrincipal(token); found {
+			return principal, nil
+		}
+		return "", err
+	}
+	if principal, found := a.apiKeyPrincipal(token); found {
+		return principal, nil
+	}
+	return "", fmt.Errorf("Invalid credentials")
+}
+
+// Gets the principal of the API key, returning false when API keys are not enabled or the key is not found
+func (a *authenticator) apiKeyPrincipal(token string) (string, bool) {
+	if a.apiKeys == nil {
+		return "", false
+	}
+	principal, found := a.apiKeys.get().(apiKeys)[hashApiKey(token)]
+	return principal, found
+}
+
+// Wraps the handler validating the bearer token of the requests, except for the status requests, when authentication
+// is enabled.
+//
+// The authenticated principal is set in the request context.
+func Handler(a Authenticator, server string, next http.Handler) http.Handler {
+	if !a.IsEnabled() {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == conf.StatusUrl {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		principal, err := Authenticated(a, server, bearerToken(r), r.RemoteAddr)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+	})
+}
+
+// Wraps the handler of the admin api validating the bearer token of the requests, except for the status requests,
+// when authentication is enabled.
+//
+// Only the provided principals are allowed, the rest of the authenticated principals are rejected.
+func AdminHandler(a Authenticator, principals []string, next http.Handler) http.Handler {
+	if !a.IsEnabled() {
+		return next
+	}
+
+	return Handler(a, AdminServer, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != conf.StatusUrl && !utils.ContainsString(principals, Principal(r)) {
+			log.Warn().Str("principal", Principal(r)).Msgf("Principal is not allowed to use the admin api")
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// Authenticates the token of a client of the server, logging and tracking the result.
+//
+// It returns a generic error, without the details of the failure, that can be sent to the client.
+func Authenticated(a Authenticator, server string, token string, remoteAddr string) (string, error) {
+	principal, err := a.Authenticate(token)
+	if err != nil {
+		log.Warn().Err(err).Str("server", server).Msgf("Client %s could not be authenticated", remoteAddr)
+		metrics.ClientAuthenticationFailures.WithLabelValues(server).Inc()
+		return "", fmt.Errorf("Unauthorized")
+	}
+
+	log.Debug().Str("server", server).Str("principal", principal).Msgf("Authenticated client %s", remoteAddr)
+	metrics.ClientAuthenticated.WithLabelValues(server).Inc()
+	return principal, nil
+}
+
+// Gets the principal of the authenticated request, empty when authentication is not enabled
+func Principal(r *http.Request) string {
+	principal, _ := r.Context().Value(principalKey{}).(string)
+	return principal
+}
+
+// Determines whether the principal name is reserved for the brokers, like types.SystemPrincipal
+func isReserved(principal string) bool {
+	return strings.HasPrefix(principal, "$")
+}
+
+func bearerToken(r *http.Request) string {
+	value := r.Header.Get(authorizationHeader)
+	if len(value) < len(bearerPrefix) || !strings.EqualFold(value[:len(bearerPrefix)], bearerPrefix) {
+		return ""
+	}
+	return strings.TrimSpace(value[len(bearerPrefix):])
+}
@@ -0,0 +1,289 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/polarstreams/polar/internal/conf"
+	"github.com/polarstreams/polar/internal/test/conf/mocks"
+)
+
+func TestAuth(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Auth Suite")
+}
+
+var _ = Describe("authenticator", func() {
+	Describe("Authenticate()", func() {
+		It("should return the principal of the API key", func() {
+			dir := newTestDir()
+			keysFile := writeJson(dir, "keys.json", []apiKeyEntry{
+				{Principal: "billing", Key: "key1"},
+				{Principal: "billing", Key: "key2"},
+				{Principal: "orders", Key: "key3"},
+			})
+			a := newTestAuthenticator(keysFile, "", "")
+
+			Expect(a.Authenticate("key2")).To(Equal("billing"))
+			Expect(a.Authenticate("key3")).To(Equal("orders"))
+			_, err := a.Authenticate("key4")
+			Expect(err).To(MatchError("Invalid credentials"))
+			_, err = a.Authenticate("")
+			Expect(err).To(MatchError("No credentials provided"))
+		})
+
+		It("should look up the API keys with the shape of a JWT when JWTs are enabled", func() {
+			dir := newTestDir()
+			keysFile := writeJson(dir, "keys.json", []apiKeyEntry{{Principal: "billing", Key: "abc.def.ghi"}})
+			ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			Expect(err).NotTo(HaveOccurred())
+			jwksFile := writeJson(dir, "jwks.json", jsonWebKeySet{Keys: []jsonWebKey{
+				{Kid: "ec1", Kty: "EC", Crv: "P-256", X: encodeBigInt(ecKey.X), Y: encodeBigInt(ecKey.Y)},
+			}})
+			a := newTestAuthenticator(keysFile, jwksFile, "")
+
+			Expect(a.Authenticate("abc.def.ghi")).To(Equal("billing"))
+			_, err = a.Authenticate("abc.def.xyz")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should not load API keys of reserved principals", func() {
+			_, err := parseApiKeys([]byte(`[{"principal":"$system","key":"key1"}]`))
+			Expect(err).To(MatchError("API key entry 0 uses the reserved principal name '$system'"))
+		})
+
+		It("should reload the API keys when the file changes", func() {
+			dir := newTestDir()
+			keysFile := writeJson(dir, "keys.json", []apiKeyEntry{{Principal: "billing", Key: "key1"}})
+			a := newTestAuthenticator(keysFile, "", "")
+			Expect(a.Authenticate("key1")).To(Equal("billing"))
+
+			writeJson(dir, "keys.json", []apiKeyEntry{{Principal: "billing", Key: "key2"}})
+			future := time.Now().Add(time.Minute)
+			Expect(os.Chtimes(keysFile, future, future)).To(Succeed())
+			Eventually(func() error {
+				_, err := a.Authenticate("key1")
+				return err
+			}, 2*time.Second).Should(HaveOccurred())
+			Expect(a.Authenticate("key2")).To(Equal("billing"))
+		})
+
+		It("should verify JWTs signed with RSA and EC keys", func() {
+			dir := newTestDir()
+			rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+			Expect(err).NotTo(HaveOccurred())
+			ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			Expect(err).NotTo(HaveOccurred())
+			jwksFile := writeJson(dir, "jwks.json", jsonWebKeySet{Keys: []jsonWebKey{
+				{Kid: "rsa1", Kty: "RSA", N: encodeBigInt(rsaKey.N), E: encodeBigInt(big.NewInt(int64(rsaKey.E)))},
+				{Kid: "ec1", Kty: "EC", Crv: "P-256", X: encodeBigInt(ecKey.X), Y: encodeBigInt(ecKey.Y)},
+			}})
+			a := newTestAuthenticator("", jwksFile, "polar")
+			exp := time.Now().Add(time.Hour).Unix()
+
+			Expect(a.Authenticate(signJwt("RS256", "rsa1", rsaKey, map[string]interface{}{
+				"sub": "billing", "aud": "polar", "exp": exp,
+			}))).To(Equal("billing"))
+			Expect(a.Authenticate(signJwt("ES256", "ec1", ecKey, map[string]interface{}{
+				"sub": "orders", "aud": []string{"other", "polar"}, "exp": exp,
+			}))).To(Equal("orders"))
+
+			_, err = a.Authenticate(signJwt("RS256", "rsa1", rsaKey, map[string]interface{}{
+				"sub": "billing", "aud": "polar", "exp": time.Now().Add(-time.Hour).Unix(),
+			}))
+			Expect(err).To(MatchError("JWT expired"))
+
+			_, err = a.Authenticate(signJwt("RS256", "rsa1", rsaKey, map[string]interface{}{
+				"sub": "billing", "aud": "other", "exp": exp,
+			}))
+			Expect(err).To(MatchError("JWT audience does not include 'polar'"))
+
+			// Signed with a key not included in the JWKS
+			otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = a.Authenticate(signJwt("RS256", "rsa1", otherKey, map[string]interface{}{
+				"sub": "billing", "aud": "polar", "exp": exp,
+			}))
+			Expect(err).To(MatchError("Invalid JWT signature"))
+
+			_, err = a.Authenticate(signJwt("ES256", "rsa1", ecKey, map[string]interface{}{
+				"sub": "billing", "aud": "polar", "exp": exp,
+			}))
+			Expect(err).To(MatchError("JWT algorithm ES256 does not match the key type"))
+
+			_, err = a.Authenticate(signJwt("RS256", "unknown", rsaKey, map[string]interface{}{
+				"sub": "billing", "aud": "polar", "exp": exp,
+			}))
+			Expect(err).To(MatchError("JWT key 'unknown' not found"))
+
+			_, err = a.Authenticate(signJwt("RS256", "rsa1", rsaKey, map[string]interface{}{
+				"sub": "$system", "aud": "polar", "exp": exp,
+			}))
+			Expect(err).To(MatchError("JWT subject '$system' is reserved"))
+		})
+	})
+})
+
+var _ = Describe("Handler()", func() {
+	dir := ""
+	var a Authenticator
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(Principal(r)))
+	})
+
+	BeforeEach(func() {
+		dir = newTestDir()
+		a = newTestAuthenticator(writeJson(dir, "keys.json", []apiKeyEntry{{Principal: "billing", Key: "key1"}}), "", "")
+	})
+
+	It("should set the principal of the request", func() {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/v1/topic/abc/messages", nil)
+		r.Header.Set("Authorization", "Bearer key1")
+		Handler(a, ProducerServer, handler).ServeHTTP(w, r)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(Equal("billing"))
+	})
+
+	It("should respond with 401 when the credentials are not valid", func() {
+		for _, value := range []string{"", "Bearer key2", "Basic key1"} {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/v1/topic/abc/messages", nil)
+			r.Header.Set("Authorization", value)
+			Handler(a, ProducerServer, handler).ServeHTTP(w, r)
+			Expect(w.Code).To(Equal(http.StatusUnauthorized))
+			Expect(w.Header().Get("WWW-Authenticate")).To(Equal("Bearer"))
+		}
+	})
+
+	It("should not authenticate status requests", func() {
+		w := httptest.NewRecorder()
+		Handler(a, ProducerServer, handler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, conf.StatusUrl, nil))
+		Expect(w.Code).To(Equal(http.StatusOK))
+	})
+
+	It("should not wrap the handler when authentication is not enabled", func() {
+		a = newTestAuthenticator("", "", "")
+		w := httptest.NewRecorder()
+		Handler(a, ProducerServer, handler).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/topic/abc/messages", nil))
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(Equal(""))
+	})
+})
+
+var _ = Describe("AdminHandler()", func() {
+	var a Authenticator
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(Principal(r)))
+	})
+
+	BeforeEach(func() {
+		keys := []apiKeyEntry{{Principal: "ops", Key: "key1"}, {Principal: "billing", Key: "key2"}}
+		a = newTestAuthenticator(writeJson(newTestDir(), "keys.json", keys), "", "")
+	})
+
+	It("should allow the admin principals", func() {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/v1/acls", nil)
+		r.Header.Set("Authorization", "Bearer key1")
+		AdminHandler(a, []string{"ops"}, handler).ServeHTTP(w, r)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(Equal("ops"))
+	})
+
+	It("should respond with 403 when the principal is not an admin principal", func() {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/v1/acls", nil)
+		r.Header.Set("Authorization", "Bearer key2")
+		AdminHandler(a, []string{"ops"}, handler).ServeHTTP(w, r)
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("should respond with 401 when the credentials are not valid", func() {
+		w := httptest.NewRecorder()
+		AdminHandler(a, []string{"ops"}, handler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/acls", nil))
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should not authorize status requests", func() {
+		w := httptest.NewRecorder()
+		AdminHandler(a, nil, handler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, conf.StatusUrl, nil))
+		Expect(w.Code).To(Equal(http.StatusOK))
+	})
+
+	It("should not wrap the handler when authentication is not enabled", func() {
+		a = newTestAuthenticator("", "", "")
+		w := httptest.NewRecorder()
+		AdminHandler(a, nil, handler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/acls", nil))
+		Expect(w.Code).To(Equal(http.StatusOK))
+	})
+})
+
+func newTestAuthenticator(apiKeysFile string, jwksFile string, audience string) Authenticator {
+	config := new(mocks.Config)
+	config.On("AuthApiKeysFile").Return(apiKeysFile)
+	config.On("AuthJwksFile").Return(jwksFile)
+	config.On("AuthJwtIssuer").Return("")
+	config.On("AuthJwtAudience").Return(audience)
+	config.On("AuthReloadInterval").Return(20 * time.Millisecond)
+	a := NewAuthenticator(config)
+	Expect(a.Init()).To(Succeed())
+	return a
+}
+
+func writeJson(dir string, name string, value interface{}) string {
+	data, err := json.Marshal(value)
+	Expect(err).NotTo(HaveOccurred())
+	fileName := filepath.Join(dir, name)
+	Expect(os.WriteFile(fileName, data, 0600)).To(Succeed())
+	return fileName
+}
+
+func signJwt(alg string, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	header, err := json.Marshal(jwtHeader{Alg: alg, Kid: kid})
+	Expect(err).NotTo(HaveOccurred())
+	payload, err := json.Marshal(claims)
+	Expect(err).NotTo(HaveOccurred())
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		Expect(err).NotTo(HaveOccurred())
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		Expect(err).NotTo(HaveOccurred())
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func encodeBigInt(value *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(value.Bytes())
+}
+
+func newTestDir() string {
+	dir, err := ioutil.TempDir("", "auth_test")
+	Expect(err).NotTo(HaveOccurred())
+	return dir
+}
@@ -0,0 +1,272 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// The maximum clock difference with the issuer when validating the expiration and not before claims
+const jwtClockSkew = 30 * time.Second
+
+// The hash functions of the supported JWT signature algorithms
+var jwtAlgorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+// The curves of the ECDSA algorithms
+var jwtCurves = map[string]elliptic.Curve{
+	"ES256": elliptic.P256(),
+	"ES384": elliptic.P384(),
+	"ES512": elliptic.P521(),
+}
+
+// The public keys to verify the JWT signatures by key id
+type jwks map[string]crypto.PublicKey
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`   // RSA modulus
+	E   string `json:"e"`   // RSA exponent
+	Crv string `json:"crv"` // EC curve
+	X   string `json:"x"`   // EC x coordinate
+	Y   string `json:"y"`   // EC y coordinate
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Subject   string      `json:"sub"`
+	Issuer    string      `json:"iss"`
+	Audience  jwtAudience `json:"aud"`
+	ExpiresAt *float64    `json:"exp"`
+	NotBefore *float64    `json:"nbf"`
+}
+
+// The audience claim can be a single value or an array
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		*a = jwtAudience{value}
+		return nil
+	}
+	var values []string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	*a = values
+	return nil
+}
+
+func (a jwtAudience) contains(audience string) bool {
+	for _, value := range a {
+		if value == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses the RSA and EC signing keys of the JWKS
+func parseJwks(data []byte) (interface{}, error) {
+	var set jsonWebKeySet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	result := make(jwks, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("Invalid key '%s': %s", k.Kid, err.Error())
+		}
+		result[k.Kid] = key
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("No signing keys found")
+	}
+	return result, nil
+}
+
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("Invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("Unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("Point is not on curve %s", k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("Unsupported key type %s", k.Kty)
+}
+
+// Verifies the signature and the claims of the JWT, returning the subject
+func verifyJwt(token string, keys jwks, issuer string, audience string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("Invalid JWT format")
+	}
+
+	var header jwtHeader
+	if err := decodeJwtPart(parts[0], &header); err != nil {
+		return "", fmt.Errorf("Invalid JWT header: %s", err.Error())
+	}
+	key, found := keys[header.Kid]
+	if !found {
+		return "", fmt.Errorf("JWT key '%s' not found", header.Kid)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("Invalid JWT signature encoding")
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return "", err
+	}
+
+	var claims jwtClaims
+	if err := decodeJwtPart(parts[1], &claims); err != nil {
+		return "", fmt.Errorf("Invalid JWT claims: %s", err.Error())
+	}
+	now := time.Now()
+	if claims.ExpiresAt == nil {
+		return "", fmt.Errorf("JWT must define the expiration time")
+	}
+	if now.Add(-jwtClockSkew).After(numericDate(*claims.ExpiresAt)) {
+		return "", fmt.Errorf("JWT expired")
+	}
+	if claims.NotBefore != nil && now.Add(jwtClockSkew).Before(numericDate(*claims.NotBefore)) {
+		return "", fmt.Errorf("JWT not valid yet")
+	}
+	if issuer != "" && claims.Issuer != issuer {
+		return "", fmt.Errorf("Unexpected JWT issuer '%s'", claims.Issuer)
+	}
+	if audience != "" && !claims.Audience.contains(audience) {
+		return "", fmt.Errorf("JWT audience does not include '%s'", audience)
+	}
+	if claims.Subject == "" {
+		return "", fmt.Errorf("JWT must define the subject")
+	}
+	if isReserved(claims.Subject) {
+		return "", fmt.Errorf("JWT subject '%s' is reserved", claims.Subject)
+	}
+	return claims.Subject, nil
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	hash, found := jwtAlgorithms[alg]
+	if !found {
+		return fmt.Errorf("Unsupported JWT algorithm '%s'", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch {
+	case strings.HasPrefix(alg, "RS"):
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("JWT algorithm %s does not match the key type", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(rsaKey, hash, digest, signature); err != nil {
+			return fmt.Errorf("Invalid JWT signature")
+		}
+		return nil
+	case strings.HasPrefix(alg, "ES"):
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || ecKey.Curve != jwtCurves[alg] {
+			return fmt.Errorf("JWT algorithm %s does not match the key type", alg)
+		}
+		// The signature is the concatenation of r and s, each one of the size of the curve
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("Invalid JWT signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return fmt.Errorf("Invalid JWT signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("Unsupported JWT algorithm '%s'", alg)
+}
+
+func decodeJwtPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, fmt.Errorf("Invalid base64url value")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// Gets the time of a JWT NumericDate, the seconds since epoch
+func numericDate(value float64) time.Time {
+	return time.Unix(0, int64(value*float64(time.Second)))
+}
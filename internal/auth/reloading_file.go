@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Parses the file again when it changes on disk, the previous value is used when the file can not be parsed
+type reloadingFile struct {
+	name    string
+	parse   func([]byte) (interface{}, error)
+	value   atomic.Value
+	modTime time.Time // The modification time of the file when last loaded
+}
+
+func newReloadingFile(name string, parse func([]byte) (interface{}, error)) *reloadingFile {
+	return &reloadingFile{name: name, parse: parse}
+}
+
+func (f *reloadingFile) reloadPeriodically(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if err := f.reloadIfChanged(); err != nil {
+			log.Err(err).Msgf("Authentication file %s could not be reloaded", f.name)
+		}
+	}
+}
+
+func (f *reloadingFile) reloadIfChanged() error {
+	info, err := os.Stat(f.name)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(f.modTime) {
+		return nil
+	}
+
+	data, err := os.ReadFile(f.name)
+	if err != nil {
+		return err
+	}
+	value, err := f.parse(data)
+	if err != nil {
+		return err
+	}
+
+	f.value.Store(value)
+	f.modTime = info.ModTime()
+	log.Info().Msgf("Loaded authentication file %s", f.name)
+	return nil
+}
+
+func (f *reloadingFile) get() interface{} {
+	return f.value.Load()
+}
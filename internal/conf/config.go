@@ -17,6 +17,7 @@ const (
 	filePermissions        = 0755
 	SegmentFileExtension   = "dlog"
 	IndexFileExtension     = "index"
+	TimeIndexFileExtension = "timeindex"
 	ProducerOffsetFileName = "producer.offset"
 	TopologyFileName       = "topology.txt" // Used for non-k8s envs
 )
@@ -29,16 +30,23 @@ const (
 	envConsumerPort                    = "POLAR_CONSUMER_PORT"
 	envClientDiscoveryPort             = "POLAR_CLIENT_DISCOVERY_PORT"
 	envMetricsPort                     = "POLAR_METRICS_PORT"
+	envAdminPort                       = "POLAR_ADMIN_PORT"
+	envAdminPrincipals                 = "POLAR_ADMIN_PRINCIPALS"
 	envGossipPort                      = "POLAR_GOSSIP_PORT"
 	envGossipDataPort                  = "POLAR_GOSSIP_DATA_PORT"
 	envSegmentFlushIntervalMs          = "POLAR_SEGMENT_FLUSH_INTERVAL_MS"
 	envLogRetentionDuration            = "POLAR_LOG_RETENTION_DURATION"
+	envLogRetentionBytes               = "POLAR_LOG_RETENTION_BYTES"
 	envReplicationTimeoutDuration      = "POLAR_REPLICATION_TIMEOUT_DURATION"
 	envReplicationWriteTimeoutDuration = "POLAR_REPLICATION_WRITE_TIMEOUT_DURATION"
 	envMaxSegmentSize                  = "POLAR_MAX_SEGMENT_FILE_SIZE"
 	envProducerBufferPoolSize          = "POLAR_PRODUCER_BUFFER_POOL_SIZE"
+	envProducerMaxDeliveryDelay        = "POLAR_PRODUCER_MAX_DELIVERY_DELAY_MS"
 	envConsumerAddDelay                = "POLAR_CONSUMER_ADD_DELAY_MS"
 	envConsumerReadTimeout             = "POLAR_CONSUMER_READ_TIMEOUT_MS"
+	envConsumerMaxPollWait             = "POLAR_CONSUMER_MAX_POLL_WAIT_MS"
+	envConsumerAckTimeout              = "POLAR_CONSUMER_ACK_TIMEOUT_MS"
+	envConsumerMaxNacks                = "POLAR_CONSUMER_MAX_NACKS"
 	envConsumerRanges                  = "POLAR_CONSUMER_RANGES"
 	envTopologyFilePollDelayMs         = "POLAR_TOPOLOGY_FILE_POLL_DELAY_MS"
 	envShutdownDelaySecs               = "POLAR_SHUTDOWN_DELAY_SECS"
@@ -49,6 +57,29 @@ const (
 	EnvDebug                           = "POLAR_DEBUG"
 	envMaxMessageSize                  = "POLAR_MAX_MESSAGE_SIZE"
 	envMaxGroupSize                    = "POLAR_MAX_GROUP_SIZE"
+	envTopicAutoCreate                 = "POLAR_TOPIC_AUTO_CREATE"
+	envTlsCertFile                     = "POLAR_TLS_CERT_FILE"
+	envTlsKeyFile                      = "POLAR_TLS_KEY_FILE"
+	envTlsClientCaFile                 = "POLAR_TLS_CLIENT_CA_FILE"
+	envTlsReloadIntervalMs             = "POLAR_TLS_RELOAD_INTERVAL_MS"
+	envGossipTlsCertFile               = "POLAR_GOSSIP_TLS_CERT_FILE"
+	envGossipTlsKeyFile                = "POLAR_GOSSIP_TLS_KEY_FILE"
+	envGossipTlsCaFile                 = "POLAR_GOSSIP_TLS_CA_FILE"
+	envGossipSecret                    = "POLAR_GOSSIP_SECRET"
+	envAuthApiKeysFile                 = "POLAR_AUTH_API_KEYS_FILE"
+	envAuthJwksFile                    = "POLAR_AUTH_JWKS_FILE"
+	envAuthJwtIssuer                   = "POLAR_AUTH_JWT_ISSUER"
+	envAuthJwtAudience                 = "POLAR_AUTH_JWT_AUDIENCE"
+	envAuthReloadIntervalMs            = "POLAR_AUTH_RELOAD_INTERVAL_MS"
+	envAuthAclEnabled                  = "POLAR_AUTH_ACL_ENABLED"
+	envQuotaClientProduceBytes         = "POLAR_QUOTA_CLIENT_PRODUCE_BYTES_PER_SEC"
+	envQuotaClientProduceRequests      = "POLAR_QUOTA_CLIENT_PRODUCE_REQUESTS_PER_SEC"
+	envQuotaClientConsumeBytes         = "POLAR_QUOTA_CLIENT_CONSUME_BYTES_PER_SEC"
+	envQuotaClientConsumeRequests      = "POLAR_QUOTA_CLIENT_CONSUME_REQUESTS_PER_SEC"
+	envQuotaTopicProduceBytes          = "POLAR_QUOTA_TOPIC_PRODUCE_BYTES_PER_SEC"
+	envQuotaTopicProduceRequests       = "POLAR_QUOTA_TOPIC_PRODUCE_REQUESTS_PER_SEC"
+	envQuotaTopicConsumeBytes          = "POLAR_QUOTA_TOPIC_CONSUME_BYTES_PER_SEC"
+	envQuotaTopicConsumeRequests       = "POLAR_QUOTA_TOPIC_CONSUME_REQUESTS_PER_SEC"
 )
 
 // Port defaults
@@ -60,6 +91,7 @@ const (
 	DefaultProducerBinaryPort  = 9254
 	DefaultGossipPort          = 9255
 	DefaultGossipDataPort      = 9256
+	DefaultAdminPort           = 9257
 )
 
 const (
@@ -79,6 +111,10 @@ type Config interface {
 	ProducerConfig
 	ConsumerConfig
 	DiscovererConfig
+	TopicsConfig
+	AclConfig
+	QuotaConfig
+	AdminConfig
 	MetricsPort() int
 	CreateAllDirs() error
 }
@@ -94,6 +130,35 @@ type BasicConfig interface {
 	ConsumerPort() int
 }
 
+// TlsConfig contains the settings of the TLS connections of the client-facing listeners: producer, consumer, admin and
+// client discovery
+type TlsConfig interface {
+	TlsCertFile() string              // The path to the PEM encoded certificate, TLS is disabled when empty
+	TlsKeyFile() string               // The path to the PEM encoded private key of the certificate
+	TlsClientCaFile() string          // The path to the PEM encoded CAs to verify client certificates, enabling mTLS
+	TlsReloadInterval() time.Duration // The interval to check the certificate files for changes
+}
+
+// AuthConfig contains the settings to authenticate the producer and consumer clients, authentication is disabled when
+// neither the API keys nor the JWKS file are set
+type AuthConfig interface {
+	AuthApiKeysFile() string           // The path to the JSON file containing the API keys of the principals
+	AuthJwksFile() string              // The path to the JWKS file containing the keys to verify JWT bearer tokens
+	AuthJwtIssuer() string             // The expected issuer of the JWT bearer tokens, not validated when empty
+	AuthJwtAudience() string           // The expected audience of the JWT bearer tokens, not validated when empty
+	AuthReloadInterval() time.Duration // The interval to check the API keys and JWKS files for changes
+	AuthAclEnabled() bool              // Determines whether the principals need an ACL rule to produce and consume
+}
+
+// GossipTlsConfig contains the settings to authenticate the connections between brokers, on the gossip and data ports
+type GossipTlsConfig interface {
+	GossipTlsCertFile() string        // The path to the PEM encoded broker certificate, mTLS is disabled when empty
+	GossipTlsKeyFile() string         // The path to the PEM encoded private key of the broker certificate
+	GossipTlsCaFile() string          // The path to the PEM encoded CAs to verify the peer certificates
+	GossipSecret() string             // The secret shared by the brokers, used when mTLS is not enabled
+	TlsReloadInterval() time.Duration // The interval to check the certificate files for changes
+}
+
 type LocalDbConfig interface {
 	LocalDbPath() string
 }
@@ -110,11 +175,13 @@ type DatalogConfig interface {
 	IndexFilePeriodBytes() int // How frequently write to the index file based on the segment size.
 	SegmentFlushInterval() time.Duration
 	LogRetentionDuration() *time.Duration // The amount of time to keep a log file before deleting it (default = 7d)
+	LogRetentionBytes() int64             // The max amount of bytes of log files in the broker, zero for unlimited
 	StreamBufferSize() int                // Max size of the file stream buffers (2 of them atm)
 }
 
 type DiscovererConfig interface {
 	BasicConfig
+	TlsConfig
 	Ordinal() int
 	ClientDiscoveryPort() int // port number of the HTTP discovery service to expose to client libraries
 	// BaseHostName is name prefix that should be concatenated with the ordinal to
@@ -128,21 +195,58 @@ type DiscovererConfig interface {
 
 type ProducerConfig interface {
 	BasicConfig
+	TlsConfig
+	AuthConfig
 	DatalogConfig
 	ProducerBufferPoolSize() int
+	ProducerMaxDeliveryDelay() time.Duration // The maximum time a record can be scheduled ahead for delivery
 }
 
 type ConsumerConfig interface {
 	BasicConfig
+	TlsConfig
+	AuthConfig
 	DatalogConfig
 	ConsumerAddDelay() time.Duration
 	ConsumerReadTimeout() time.Duration // The interval to set the deadline in the consumer connection
 	ConsumerReadThreshold() int         // The minimum amount of bytes once reached the consumer poll is fulfilled
+	ConsumerMaxPollWait() time.Duration // The maximum time a consumer poll can be held open waiting for data
+	ConsumerAckTimeout() time.Duration  // The time after which a record delivered in ack mode is delivered again
+	ConsumerMaxNacks() int              // The amount of nacks after which a record is routed to the dead-letter topic
+}
+
+type AclConfig interface {
+	BasicConfig
+	AuthConfig
+}
+
+// QuotaConfig contains the maximum rates enforced by each broker, zero values are not limited.
+// A client is identified by the authenticated principal or by the remote host when authentication is not enabled.
+type QuotaConfig interface {
+	ClientProduceQuota() Quota // The maximum produce rates of each client
+	ClientConsumeQuota() Quota // The maximum consume rates of each client
+	TopicProduceQuota() Quota  // The maximum produce rates of each topic, it can be overridden in the topic settings
+	TopicConsumeQuota() Quota  // The maximum consume rates of each topic, it can be overridden in the topic settings
+}
+
+type TopicsConfig interface {
+	BasicConfig
+	DatalogConfig
+	TopicAutoCreate() bool // Determines whether a topic should be created when first used by a producer or consumer
+}
+
+type AdminConfig interface {
+	BasicConfig
+	TlsConfig
+	AuthConfig
+	AdminPort() int            // port number of the HTTP admin api
+	AdminPrincipals() []string // The authenticated principals allowed to use the admin api
 }
 
 type GossipConfig interface {
 	BasicConfig
 	DatalogConfig
+	GossipTlsConfig
 	GossipPort() int
 	GossipDataPort() int
 	ReplicationTimeout() time.Duration
@@ -202,9 +306,46 @@ func (c *config) Init() error {
 	if _, err := time.ParseDuration(value); err != nil && value != "null" {
 		return fmt.Errorf("Log retention duration '%s' is not a valid value", value)
 	}
+	if c.LogRetentionBytes() < 0 {
+		return fmt.Errorf("Log retention bytes can not be a negative number")
+	}
 	if c.replicationTimeout <= 0 || c.replicationWriteTimeout <= 0 || c.replicationWriteTimeout > c.replicationTimeout {
 		return fmt.Errorf("Invalid replication timeouts")
 	}
+	if (c.TlsCertFile() == "") != (c.TlsKeyFile() == "") {
+		return fmt.Errorf("TLS certificate and key files should be set together")
+	}
+	if c.TlsClientCaFile() != "" && c.TlsCertFile() == "" {
+		return fmt.Errorf("TLS client CA file can only be set when the TLS certificate is set")
+	}
+	if c.TlsReloadInterval() <= 0 {
+		return fmt.Errorf("TLS reload interval should be a positive number")
+	}
+	if c.AuthReloadInterval() <= 0 {
+		return fmt.Errorf("Authentication reload interval should be a positive number")
+	}
+	if c.AuthAclEnabled() && c.AuthApiKeysFile() == "" && c.AuthJwksFile() == "" {
+		return fmt.Errorf("ACLs can only be enabled when client authentication is enabled")
+	}
+	if c.AuthAclEnabled() && len(c.AdminPrincipals()) == 0 {
+		return fmt.Errorf("ACLs can only be enabled when the admin principals are set")
+	}
+	if len(c.AdminPrincipals()) > 0 && c.AuthApiKeysFile() == "" && c.AuthJwksFile() == "" {
+		return fmt.Errorf("Admin principals can only be set when client authentication is enabled")
+	}
+	for _, q := range []Quota{
+		c.ClientProduceQuota(), c.ClientConsumeQuota(), c.TopicProduceQuota(), c.TopicConsumeQuota()} {
+		if q.BytesPerSecond < 0 || q.RequestsPerSecond < 0 {
+			return fmt.Errorf("Quota rates can not be negative numbers")
+		}
+	}
+	gossipTls := c.GossipTlsCertFile() != ""
+	if gossipTls != (c.GossipTlsKeyFile() != "") || gossipTls != (c.GossipTlsCaFile() != "") {
+		return fmt.Errorf("Gossip TLS certificate, key and CA files should be set together")
+	}
+	if gossipTls && c.GossipSecret() != "" {
+		return fmt.Errorf("Gossip shared secret can not be set when gossip TLS is enabled")
+	}
 
 	return nil
 }
@@ -229,6 +370,20 @@ func (c *config) MetricsPort() int {
 	return envInt(envMetricsPort, DefaultMetricsPort)
 }
 
+func (c *config) AdminPort() int {
+	return envInt(envAdminPort, DefaultAdminPort)
+}
+
+func (c *config) AdminPrincipals() []string {
+	result := make([]string, 0)
+	for _, principal := range strings.Split(env(envAdminPrincipals, ""), ",") {
+		if principal = strings.TrimSpace(principal); principal != "" {
+			result = append(result, principal)
+		}
+	}
+	return result
+}
+
 func (c *config) GossipPort() int {
 	return envInt(envGossipPort, DefaultGossipPort)
 }
@@ -249,6 +404,10 @@ func (c *config) ConsumerRanges() int {
 	return envInt(envConsumerRanges, 4)
 }
 
+func (c *config) TopicAutoCreate() bool {
+	return os.Getenv(envTopicAutoCreate) != "false"
+}
+
 func (c *config) MaxMessageSize() int {
 	return envInt(envMaxMessageSize, MiB)
 }
@@ -279,6 +438,20 @@ func (c *config) ConsumerReadThreshold() int {
 	return c.MaxGroupSize()
 }
 
+func (c *config) ConsumerMaxPollWait() time.Duration {
+	ms := envInt(envConsumerMaxPollWait, 30000)
+	return time.Duration(ms) * time.Millisecond
+}
+
+func (c *config) ConsumerAckTimeout() time.Duration {
+	ms := envInt(envConsumerAckTimeout, 30000)
+	return time.Duration(ms) * time.Millisecond
+}
+
+func (c *config) ConsumerMaxNacks() int {
+	return envInt(envConsumerMaxNacks, 5)
+}
+
 func (c *config) IndexFilePeriodBytes() int {
 	return int(0.05 * float64(c.MaxSegmentSize()))
 }
@@ -301,6 +474,10 @@ func (c *config) LogRetentionDuration() *time.Duration {
 	return &t
 }
 
+func (c *config) LogRetentionBytes() int64 {
+	return int64(envInt(envLogRetentionBytes, 0))
+}
+
 func (c *config) ReplicationTimeout() time.Duration {
 	return c.replicationTimeout
 }
@@ -325,6 +502,11 @@ func (c *config) ProducerBufferPoolSize() int {
 	return envInt(envProducerBufferPoolSize, defaultProducerBufferPoolSize)
 }
 
+func (c *config) ProducerMaxDeliveryDelay() time.Duration {
+	ms := envInt(envProducerMaxDeliveryDelay, 7*24*60*60*1000)
+	return time.Duration(ms) * time.Millisecond
+}
+
 func (c *config) SegmentBufferSize() int {
 	return 8 * MiB
 }
@@ -390,6 +572,80 @@ func (c *config) FixedTopologyFilePollDelay() time.Duration {
 	return time.Duration(ms) * time.Millisecond
 }
 
+func (c *config) TlsCertFile() string {
+	return env(envTlsCertFile, "")
+}
+
+func (c *config) TlsKeyFile() string {
+	return env(envTlsKeyFile, "")
+}
+
+func (c *config) TlsClientCaFile() string {
+	return env(envTlsClientCaFile, "")
+}
+
+func (c *config) TlsReloadInterval() time.Duration {
+	ms := envInt(envTlsReloadIntervalMs, 60000)
+	return time.Duration(ms) * time.Millisecond
+}
+
+func (c *config) AuthApiKeysFile() string {
+	return env(envAuthApiKeysFile, "")
+}
+
+func (c *config) AuthJwksFile() string {
+	return env(envAuthJwksFile, "")
+}
+
+func (c *config) AuthJwtIssuer() string {
+	return env(envAuthJwtIssuer, "")
+}
+
+func (c *config) AuthJwtAudience() string {
+	return env(envAuthJwtAudience, "")
+}
+
+func (c *config) AuthReloadInterval() time.Duration {
+	ms := envInt(envAuthReloadIntervalMs, 60000)
+	return time.Duration(ms) * time.Millisecond
+}
+
+func (c *config) AuthAclEnabled() bool {
+	return os.Getenv(envAuthAclEnabled) == "true"
+}
+
+func (c *config) ClientProduceQuota() Quota {
+	return envQuota(envQuotaClientProduceBytes, envQuotaClientProduceRequests)
+}
+
+func (c *config) ClientConsumeQuota() Quota {
+	return envQuota(envQuotaClientConsumeBytes, envQuotaClientConsumeRequests)
+}
+
+func (c *config) TopicProduceQuota() Quota {
+	return envQuota(envQuotaTopicProduceBytes, envQuotaTopicProduceRequests)
+}
+
+func (c *config) TopicConsumeQuota() Quota {
+	return envQuota(envQuotaTopicConsumeBytes, envQuotaTopicConsumeRequests)
+}
+
+func (c *config) GossipTlsCertFile() string {
+	return env(envGossipTlsCertFile, "")
+}
+
+func (c *config) GossipTlsKeyFile() string {
+	return env(envGossipTlsKeyFile, "")
+}
+
+func (c *config) GossipTlsCaFile() string {
+	return env(envGossipTlsCaFile, "")
+}
+
+func (c *config) GossipSecret() string {
+	return env(envGossipSecret, "")
+}
+
 func env(name string, defaultValue string) string {
 	value := os.Getenv(name)
 	if value == "" {
@@ -410,6 +666,13 @@ func envInt(name string, defaultValue int) int {
 	return intValue
 }
 
+func envQuota(bytesName string, requestsName string) Quota {
+	return Quota{
+		BytesPerSecond:    int64(envInt(bytesName, 0)),
+		RequestsPerSecond: int64(envInt(requestsName, 0)),
+	}
+}
+
 // Gets the formatted file name based on the segment id
 func SegmentFileName(segmentId int64) string {
 	return fmt.Sprintf("%s.%s", SegmentFilePrefix(segmentId), SegmentFileExtension)
@@ -17,9 +17,29 @@ const (
 	// Url consuming messages
 	ConsumerRegisterUrl     = "/v1/consumer/register"
 	ConsumerPollUrl         = "/v1/consumer/poll"
+	ConsumerStreamUrl       = "/v1/consumer/stream"
 	ConsumerManualCommitUrl = "/v1/consumer/commit"
+	ConsumerAckUrl          = "/v1/consumer/ack"
+	ConsumerNackUrl         = "/v1/consumer/nack"
+	ConsumerOffsetsUrl      = "/v1/consumer/offsets"
 	ConsumerGoodbye         = "/v1/consumer/goodbye"
 
+	// Admin Urls
+
+	AdminTopicsUrl = "/v1/topics"
+	AdminTopicUrl  = "/v1/topics/:topic"
+
+	AdminTopicDeadLettersUrl = "/v1/topics/:topic/dead-letters"
+
+	AdminGroupsUrl     = "/v1/groups"
+	AdminGroupUrl      = "/v1/groups/:group"
+	AdminGroupSeekUrl  = "/v1/groups/:group/topics/:topic/seek"
+	AdminGroupLagUrl   = "/v1/groups/:group/lag"
+	AdminGroupCloneUrl = "/v1/groups/:group/clone"
+
+	AdminAclsUrl = "/v1/acls"
+	AdminAclUrl  = "/v1/acls/:id"
+
 	// Gossip Urls
 
 	// Url for getting/setting the generation by token
@@ -37,14 +57,27 @@ const (
 	GossipTokenInRange          = "/v1/token/%s/in-range"
 	GossipBrokerIdentifyUrl     = "/v1/broker/identify" // Send/receive my info to the peer
 	GossipHostIsUpUrl           = "/v1/broker/%s/is-up"
-	GossipConsumerGroupsInfoUrl = "/v1/consumer/groups-info"          // Send/receive consumer groups info
-	GossipConsumerOffsetUrl     = "/v1/consumer/offsets"              // Send/receive consumer offsets
-	GossipConsumerRegisterUrl   = "/v1/consumer/register"             // Send/receive consumer register from peer
-	GossipConsumerCommitUrl     = "/v1/consumer/commit/%s"            // Send/receive consumer manual commit from peer
-	GossipConsumerUnregisterUrl = "/v1/consumer/unregister/%s"        // Send/receive consumer unregister from peer
-	GossipReadProducerOffsetUrl = "/v1/producer/offset/%s/%s/%s/%s"   // Reads the producer offset, with params: topic, token, range, version
-	GossipReadFileStructureUrl  = "/v1/file-structure/%s/%s/%s/%s/%s" // Reads the file names of a given topic & offset (topic, token, range, version and offset)
-	GossipGoodbyeUrl            = "/v1/goodbye"                       // Send/receive message that a broker is shutting down
+	GossipConsumerGroupsInfoUrl = "/v1/consumer/groups-info"              // Send/receive consumer groups info
+	GossipConsumerOffsetUrl     = "/v1/consumer/offsets"                  // Send/receive consumer offsets
+	GossipConsumerRegisterUrl   = "/v1/consumer/register"                 // Send/receive consumer register from peer
+	GossipConsumerCommitUrl     = "/v1/consumer/commit/%s"                // Send/receive consumer manual commit from peer
+	GossipConsumerUnregisterUrl = "/v1/consumer/unregister/%s"            // Send/receive consumer unregister from peer
+	GossipConsumerSeekUrl       = "/v1/consumer/seek"                     // Send/receive a consumer group seek from peer
+	GossipConsumerAckUrl        = "/v1/consumer/ack"                      // Send/receive the acks or nacks of records from peer
+	GossipConsumerCommitOffsets = "/v1/consumer/commit-offsets"           // Send/receive the offsets explicitly committed
+	GossipConsumerLagUrl        = "/v1/consumer/lag/%s"                   // Reads the lag of a consumer group on the tokens led by the peer
+	GossipConsumerGroupListUrl  = "/v1/consumer/groups"                   // Reads the consumer groups known by the peer
+	GossipConsumerGroupDelete   = "/v1/consumer/groups/%s/delete"         // Send/receive the removal of a consumer group offsets
+	GossipConsumerGroupClone    = "/v1/consumer/group-clone"              // Send/receive the copy of a consumer group offsets
+	GossipReadProducerOffsetUrl = "/v1/producer/offset/%s/%s/%s/%s"       // Reads the producer offset, with params: topic, token, range, version
+	GossipReadFileStructureUrl  = "/v1/file-structure/%s/%s/%s/%s/%s"     // Reads the file names of a given topic & offset (topic, token, range, version and offset)
+	GossipProducerSequencesUrl  = "/v1/producer/sequences/%s/%s/%s/%s/%s" // Reads the idempotent producer sequences (topic, token, range, version and since micros)
+	GossipGoodbyeUrl            = "/v1/goodbye"                           // Send/receive message that a broker is shutting down
+	GossipTopicsUrl             = "/v1/topics"                            // Send/receive topic metadata
+	GossipAclsUrl               = "/v1/acls"                              // Send/receive ACL rules
+	GossipScheduledRecordUrl    = "/v1/scheduled-records"                 // Send/receive a record scheduled for delivery
+	GossipScheduledDeleteUrl    = "/v1/scheduled-records/%s/delete"       // Send/receive the removal of a delivered scheduled record
+	GossipDeadLetterStatsUrl    = "/v1/dead-letters/%s"                   // Reads the amount of records of a topic routed by the peer to the dead-letter topic
 
 	// Routing Urls (using gossip http/2 interface)
 
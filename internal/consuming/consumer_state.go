@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/polarstreams/polar/internal/conf"
+	"github.com/polarstreams/polar/internal/data"
 	"github.com/polarstreams/polar/internal/discovery"
 	. "github.com/polarstreams/polar/internal/types"
 )
@@ -32,8 +33,9 @@ type ConsumerState struct {
 	recentlyRemoved  map[consumerKey]removedInfo        // Consumers which connections were recently removed by key
 
 	// Snapshot information recalculated periodically
-	groups    atomic.Value // Precalculated info of consumer groups for peers
-	consumers atomic.Value // Precalculated info of consumers by connection uuid
+	groups     atomic.Value // Precalculated info of consumer groups for peers
+	consumers  atomic.Value // Precalculated info of consumers by connection uuid
+	groupsInfo atomic.Value // Precalculated info of consumer groups with the members assignments
 }
 
 type peerGroupInfo struct {
@@ -143,6 +145,17 @@ func (m *ConsumerState) GetInfoForPeers() []ConsumerGroup {
 	return value.([]ConsumerGroup)
 }
 
+// Gets a snapshot of the consumer groups known by this broker, including the ones provided by peers,
+// with the token ranges assigned to each member
+func (m *ConsumerState) GroupsInfo() []ConsumerGroupInfo {
+	value := m.groupsInfo.Load()
+
+	if value == nil {
+		return []ConsumerGroupInfo{}
+	}
+	return value.([]ConsumerGroupInfo)
+}
+
 // Returns the tokens and topics that a consumer should read
 func (m *ConsumerState) CanConsume(id string) (string, []TokenRanges, []string) {
 	value := m.consumers.Load()
@@ -178,6 +191,76 @@ func (m *ConsumerState) OffsetPolicy(connId string) OffsetResetPolicy {
 	return info.OnNewGroup
 }
 
+// Gets the ack settings provided by the consumer when registering
+func (m *ConsumerState) AckSettings(connId string) AckSettings {
+	value := m.consumers.Load()
+
+	if value == nil {
+		return AckSettings{}
+	}
+
+	consumers := value.(map[string]ConsumerInfo)
+	return consumers[connId].AckSettings
+}
+
+// Gets the filter expression provided by the consumer when registering
+func (m *ConsumerState) Filter(connId string) string {
+	value := m.consumers.Load()
+
+	if value == nil {
+		return ""
+	}
+
+	consumers := value.(map[string]ConsumerInfo)
+	return consumers[connId].Filter
+}
+
+// Gets the token ranges manually assigned to the consumer, nil when the ranges are assigned by the group rebalancing
+func (m *ConsumerState) Assignment(connId string) *ManualAssignment {
+	value := m.consumers.Load()
+
+	if value == nil {
+		return nil
+	}
+
+	consumers := value.(map[string]ConsumerInfo)
+	return consumers[connId].Assignment
+}
+
+// Determines whether the group has members that were assigned token ranges by the group rebalancing
+func (m *ConsumerState) HasRebalancedMembers(group string) bool {
+	for _, info := range m.GroupsInfo() {
+		if info.Name == group && len(info.Members) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Determines whether consumers with manually assigned token ranges are registered with the group on this broker
+func (m *ConsumerState) HasManualAssignments(group string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, info := range m.connections {
+		if info.Group == group && info.Assignment != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Gets the latest record format supported by the consumer for binary responses
+func (m *ConsumerState) RecordFormat(connId string) byte {
+	value := m.consumers.Load()
+
+	if value == nil {
+		return data.RecordFormatLegacy
+	}
+
+	consumers := value.(map[string]ConsumerInfo)
+	return consumers[connId].RecordFormat
+}
+
 func (m *ConsumerState) Rebalance() bool {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -190,6 +273,10 @@ func (m *ConsumerState) Rebalance() bool {
 
 	// From the local connections, create the consumer groups
 	for _, info := range m.connections {
+		if info.Assignment != nil {
+			// Consumers with manually assigned token ranges don't participate in the group rebalancing
+			continue
+		}
 		addToGroup(groupBuilders, consumers, info)
 	}
 
@@ -199,6 +286,9 @@ func (m *ConsumerState) Rebalance() bool {
 			delete(m.recentlyRemoved, k)
 			continue
 		}
+		if removed.consumer.Assignment != nil {
+			continue
+		}
 		addToGroup(groupBuilders, consumers, removed.consumer)
 	}
 
@@ -236,6 +326,7 @@ func (m *ConsumerState) Rebalance() bool {
 	fullConsumerInfo := map[consumerKey]ConsumerInfo{}
 	topology := m.topologyGetter.Topology()
 	groupsForPeers := []ConsumerGroup{}
+	groupsInfo := []ConsumerGroupInfo{}
 	rangesPerToken := m.config.ConsumerRanges()
 
 	for group, builder := range groupBuilders {
@@ -250,11 +341,21 @@ func (m *ConsumerState) Rebalance() bool {
 			Topics:     topics,
 			OnNewGroup: builder.onNewGroup,
 		})
+		groupsInfo = append(groupsInfo, ConsumerGroupInfo{
+			Name:    group,
+			Topics:  topics,
+			Members: toMembers(keys, fullConsumerInfo),
+		})
 	}
 
 	// Prepare snapshot values: consumer by connection
 	consumersByConnection := make(map[string]ConsumerInfo, len(m.connections))
 	for id, info := range m.connections {
+		if info.Assignment != nil {
+			info.assignedTokens = manualTokenRanges(topology, info.Assignment, rangesPerToken)
+			consumersByConnection[id] = info
+			continue
+		}
 		consumersByConnection[id] = fullConsumerInfo[info.key()]
 	}
 
@@ -262,8 +363,13 @@ func (m *ConsumerState) Rebalance() bool {
 		return groupsForPeers[i].Name < groupsForPeers[j].Name
 	})
 
+	sort.Slice(groupsInfo, func(i, j int) bool {
+		return groupsInfo[i].Name < groupsInfo[j].Name
+	})
+
 	prevGroups := m.groups.Swap(groupsForPeers)
 	m.consumers.Swap(consumersByConnection)
+	m.groupsInfo.Swap(groupsInfo)
 
 	// Determine if there was a change
 	hasChanged := !reflect.DeepEqual(prevGroups, groupsForPeers)
@@ -281,6 +387,28 @@ func toIds(keys []string, consumers map[consumerKey]ConsumerInfo) []string {
 	return result
 }
 
+func toMembers(keys []string, consumers map[consumerKey]ConsumerInfo) []ConsumerGroupMember {
+	result := make([]ConsumerGroupMember, len(keys))
+
+	for i, k := range keys {
+		info := consumers[consumerKey(k)]
+		assignments := make([]ConsumerAssignment, len(info.assignedTokens))
+		for j, t := range info.assignedTokens {
+			indices := make([]int, len(t.Indices))
+			for n, index := range t.Indices {
+				indices[n] = int(index)
+			}
+			assignments[j] = ConsumerAssignment{Token: t.Token, ClusterSize: t.ClusterSize, RangeIndices: indices}
+		}
+		sort.Slice(assignments, func(a, b int) bool {
+			return assignments[a].Token < assignments[b].Token
+		})
+		result[i] = ConsumerGroupMember{Id: info.Id, Assignments: assignments}
+	}
+
+	return result
+}
+
 func addToGroup(
 	groupBuilders map[string]*groupInfoBuilder,
 	consumers map[consumerKey]ConsumerInfo,
@@ -342,6 +470,8 @@ func setConsumerAssignment(
 		c.Id = info.Id
 		c.Group = info.Group
 		c.OnNewGroup = info.OnNewGroup
+		c.Filter = info.Filter
+		c.AckSettings = info.AckSettings
 		c.assignedTokens = mapToTokenRange(consumerTokensByIndex[i], brokerLength)
 		c.Topics = topics
 
@@ -209,6 +209,64 @@ var _ = Describe("ConsumerState", func() {
 			Expect(state.GetInfoForPeers()[0].Topics).To(ConsistOf(expectedTopics))
 			Expect(state.GetInfoForPeers()[0].Ids).To(ConsistOf("a", "b"))
 		})
+
+		It("should expose the members of the groups with the assigned token ranges", func() {
+			state := newConsumerState(brokerLength)
+			addConnection(state, "a", "g1", StartFromLatest, "tA")
+			state.peerGroups = map[int]peerGroupInfo{
+				1: {
+					groups:    []ConsumerGroup{{Name: "g1", Ids: []string{"b"}, Topics: []string{"tB"}}},
+					timestamp: time.Now(),
+				},
+			}
+
+			state.Rebalance()
+
+			groups := state.GroupsInfo()
+			Expect(groups).To(HaveLen(1))
+			Expect(groups[0].Name).To(Equal("g1"))
+			Expect(groups[0].Topics).To(Equal([]string{"tA", "tB"}))
+			Expect(groups[0].Members).To(HaveLen(2))
+			topology := newTestTopology(brokerLength, 3)
+			for i, m := range groups[0].Members {
+				Expect(m.Id).To(Equal([]string{"a", "b"}[i]))
+				Expect(m.Assignments).To(HaveLen(brokerLength))
+				for j, assignment := range m.Assignments {
+					Expect(assignment).To(Equal(ConsumerAssignment{
+						Token:        topology.GetToken(BrokerIndex(j)),
+						ClusterSize:  brokerLength,
+						RangeIndices: []int{i, i + 2, i + 4, i + 6},
+					}))
+				}
+			}
+		})
+
+		It("should not include consumers with manually assigned ranges in the group rebalancing", func() {
+			state := newConsumerState(brokerLength)
+			topology := newTestTopology(brokerLength, 3)
+			id1 := addConnection(state, "a", "g1", StartFromEarliest, "tA")
+			tc := newTrackedConsumerHandler(&fakes.Connection{})
+			tc.TrackAsConnectionBound()
+			assignment := &ManualAssignment{Ranges: []AssignedRange{{Token: topology.GetToken(1), RangeIndex: 2}}}
+			state.AddConnection(tc, ConsumerInfo{Id: "b", Group: "g1", Topics: []string{"tA"}, Assignment: assignment})
+			id2 := tc.Id()
+
+			state.Rebalance()
+
+			_, tokens1, _ := state.CanConsume(id1)
+			Expect(tokens1).To(HaveLen(brokerLength))
+			for _, t := range tokens1 {
+				Expect(t.Indices).To(HaveLen(consumerRanges))
+			}
+			group, tokens2, topics := state.CanConsume(id2)
+			Expect(group).To(Equal("g1"))
+			Expect(topics).To(Equal([]string{"tA"}))
+			Expect(tokens2).To(Equal([]TokenRanges{
+				{Token: topology.GetToken(1), Indices: []RangeIndex{2}, ClusterSize: brokerLength}}))
+			Expect(state.Assignment(id2)).To(Equal(assignment))
+			Expect(state.Assignment(id1)).To(BeNil())
+			Expect(state.GetInfoForPeers()[0].Ids).To(ConsistOf("a"))
+		})
 	})
 })
 
@@ -0,0 +1,236 @@
+package consuming
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/polarstreams/polar/internal/conf"
+	"github.com/polarstreams/polar/internal/data"
+	. "github.com/polarstreams/polar/internal/types"
+)
+
+// The maximum amount of records delivered and not acknowledged per group, once reached no new data is delivered
+const maxPendingRecords = 100_000
+
+// The maximum size of the records delivered and not acknowledged per group, once reached no new data is delivered
+const maxPendingBytes = 64 * conf.MiB
+
+// Tracks the records delivered to the consumers of a group in ack mode that were not acknowledged yet, per topic and
+// token range.
+//
+// It's only accessed from the group read queue goroutine.
+type ackTracker struct {
+	pending map[TopicDataId]map[int64]*pendingRecord // The pending records by offset
+	length  int
+	size    int // The sum of the sizes of the pending records
+}
+
+// Represents a record that was delivered and not acknowledged, including the information to deliver it again
+type pendingRecord struct {
+	format   byte
+	header   data.RecordHeader
+	body     []byte
+	deadline time.Time // The time after which the record is delivered again
+	nacks    int
+	routing  bool // Determines whether the record is being routed to the dead-letter topic
+}
+
+// Represents a record that should be delivered again or routed to the dead-letter topic
+type pendingRecordRef struct {
+	topic  TopicDataId
+	offset int64
+	record *pendingRecord
+}
+
+func newAckTracker() *ackTracker {
+	return &ackTracker{pending: make(map[TopicDataId]map[int64]*pendingRecord)}
+}
+
+// Adds the records of the chunk as pending until the deadline, tombstones are not tracked as they are not
+// delivered.
+func (t *ackTracker) track(topic TopicDataId, chunk SegmentChunk, decoder *zstd.Decoder, deadline time.Time) error {
+	if err := decoder.Reset(bytes.NewReader(chunk.DataBlock())); err != nil {
+		return err
+	}
+
+	records := t.pending[topic]
+	if records == nil {
+		records = make(map[int64]*pendingRecord)
+		t.pending[topic] = records
+	}
+
+	recordReader := data.NewRecordReader(decoder)
+	for offset := chunk.StartOffset(); ; offset++ {
+		header, err := recordReader.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		body := make([]byte, header.Length)
+		if _, err := io.ReadFull(decoder, body); err != nil {
+			return err
+		}
+		if header.IsTombstone() {
+			continue
+		}
+
+		header.Key = append([]byte(nil), header.Key...) // The key slice is reused by the reader
+		record, found := records[offset]
+		if !found {
+			record = &pendingRecord{}
+			records[offset] = record
+			t.length++
+		}
+		t.size += len(body) + len(header.Key) - record.size()
+		record.format = recordReader.Format()
+		record.header = *header
+		record.body = body
+		record.deadline = deadline
+	}
+}
+
+// Removes the records from the pending records, returning the amount of records that were pending
+func (t *ackTracker) ack(topic TopicDataId, offsets []int64) int {
+	records := t.pending[topic]
+	removed := 0
+	for _, offset := range offsets {
+		if record, found := records[offset]; found {
+			delete(records, offset)
+			t.size -= record.size()
+			removed++
+		}
+	}
+	t.length -= removed
+	if len(records) == 0 {
+		delete(t.pending, topic)
+	}
+	return removed
+}
+
+// Sets the pending records to be delivered again right away.
+//
+// It returns the records that reached the max amount of nacks, which are not delivered again while they are routed
+// to the dead-letter topic.
+func (t *ackTracker) nack(topic TopicDataId, offsets []int64, maxNacks int, now time.Time) []pendingRecordRef {
+	records := t.pending[topic]
+	result := make([]pendingRecordRef, 0)
+	for _, offset := range offsets {
+		record, found := records[offset]
+		if !found || record.routing {
+			continue
+		}
+		record.nacks++
+		if record.nacks >= maxNacks {
+			record.routing = true
+			result = append(result, pendingRecordRef{topic: topic, offset: offset, record: record})
+			continue
+		}
+		record.deadline = now
+	}
+	return result
+}
+
+// Sets a record that could not be routed to the dead-letter topic to be delivered again right away, when it's still
+// pending
+func (t *ackTracker) restore(r pendingRecordRef, now time.Time) {
+	if record, found := t.pending[r.topic][r.offset]; found && record == r.record {
+		record.routing = false
+		record.deadline = now
+	}
+}
+
+// Removes a record that was routed to the dead-letter topic, when it's still pending
+func (t *ackTracker) routed(r pendingRecordRef) {
+	if record, found := t.pending[r.topic][r.offset]; found && record == r.record {
+		t.ack(r.topic, []int64{r.offset})
+	}
+}
+
+// Gets the pending records of the topics which deadline expired, sorted by topic and offset
+func (t *ackTracker) due(topics []string, now time.Time) []pendingRecordRef {
+	result := make([]pendingRecordRef, 0)
+	for _, topic := range topics {
+		for topicId, records := range t.pending {
+			if topicId.Name != topic {
+				continue
+			}
+			for offset, record := range records {
+				if !record.routing && !record.deadline.After(now) {
+					result = append(result, pendingRecordRef{topic: topicId, offset: offset, record: record})
+				}
+			}
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.topic != b.topic {
+			return a.topic.String() < b.topic.String()
+		}
+		return a.offset < b.offset
+	})
+	return result
+}
+
+// Gets the lowest offset that was not acknowledged for the topic range or OffsetCompleted when there are no pending
+// records
+func (t *ackTracker) commitLimit(topic *TopicDataId) int64 {
+	result := int64(OffsetCompleted)
+	for offset := range t.pending[*topic] {
+		if offset < result {
+			result = offset
+		}
+	}
+	return result
+}
+
+// Removes the pending records of the topic
+func (t *ackTracker) remove(topic string) {
+	for topicId, records := range t.pending {
+		if topicId.Name == topic {
+			t.length -= len(records)
+			for _, record := range records {
+				t.size -= record.size()
+			}
+			delete(t.pending, topicId)
+		}
+	}
+}
+
+func (t *ackTracker) hasPending(topic *TopicDataId) bool {
+	return len(t.pending[*topic]) > 0
+}
+
+// Determines whether the max amount or size of pending records was reached
+func (t *ackTracker) isFull() bool {
+	return t.length >= maxPendingRecords || t.size >= maxPendingBytes
+}
+
+// Gets the size of the record body and key, which are held in memory while the record is pending
+func (r *pendingRecord) size() int {
+	return len(r.body) + len(r.header.Key)
+}
+
+// Gets a chunk containing only the record, compressed using the encoder
+func (r *pendingRecordRef) toChunk(encoder *zstd.Encoder) (SegmentChunk, error) {
+	buf := new(bytes.Buffer)
+	if err := data.WriteRecordFormat(buf, r.record.format); err != nil {
+		return nil, err
+	}
+	if err := data.WriteRecordHeader(buf, r.record.format, &r.record.header); err != nil {
+		return nil, err
+	}
+	buf.Write(r.record.body)
+
+	return &data.ReadSegmentChunk{
+		Buffer: encoder.EncodeAll(buf.Bytes(), nil),
+		Start:  r.offset,
+		Length: 1,
+	}, nil
+}
@@ -0,0 +1,148 @@
+package consuming
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/polarstreams/polar/internal/data"
+	. "github.com/polarstreams/polar/internal/types"
+)
+
+var _ = Describe("ackTracker", func() {
+	topic := TopicDataId{Name: "t1", Token: -100, RangeIndex: 1, Version: 2}
+	decoder, err := zstd.NewReader(bytes.NewReader(make([]byte, 0)), zstd.WithDecoderConcurrency(1))
+	Expect(err).NotTo(HaveOccurred())
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	Expect(err).NotTo(HaveOccurred())
+
+	Describe("track()", func() {
+		It("should track the records of the chunk except tombstones", func() {
+			t := newAckTracker()
+			deadline := time.Now().Add(time.Minute)
+			Expect(t.track(topic, newTestKeyedChunk(encoder, 20), decoder, deadline)).NotTo(HaveOccurred())
+
+			Expect(t.hasPending(&topic)).To(BeTrue())
+			Expect(t.length).To(Equal(2))
+			Expect(t.pending[topic]).To(HaveKey(int64(20)))
+			Expect(t.pending[topic]).To(HaveKey(int64(22)))
+			Expect(string(t.pending[topic][20].body)).To(Equal(`{"a":1}`))
+			Expect(string(t.pending[topic][20].header.Key)).To(Equal("k1"))
+			Expect(t.commitLimit(&topic)).To(Equal(int64(20)))
+
+			Expect(t.ack(topic, []int64{20, 21})).To(Equal(1))
+			Expect(t.commitLimit(&topic)).To(Equal(int64(22)))
+			Expect(t.ack(topic, []int64{22})).To(Equal(1))
+			Expect(t.hasPending(&topic)).To(BeFalse())
+			Expect(t.commitLimit(&topic)).To(Equal(int64(OffsetCompleted)))
+		})
+	})
+
+	Describe("nack()", func() {
+		It("should set the records as due until reaching the max nacks", func() {
+			t := newAckTracker()
+			now := time.Now()
+			Expect(t.track(topic, newTestKeyedChunk(encoder, 0), decoder, now.Add(time.Minute))).NotTo(HaveOccurred())
+			Expect(t.due([]string{topic.Name}, now)).To(BeEmpty())
+
+			Expect(t.nack(topic, []int64{2}, 2, now)).To(BeEmpty())
+			due := t.due([]string{topic.Name, "t2"}, now)
+			Expect(due).To(HaveLen(1))
+			Expect(due[0].offset).To(Equal(int64(2)))
+			Expect(t.due([]string{"t2"}, now)).To(BeEmpty())
+
+			deadLetter := t.nack(topic, []int64{2}, 2, now)
+			Expect(deadLetter).To(HaveLen(1))
+			Expect(deadLetter[0].record.nacks).To(Equal(2))
+			Expect(deadLetter[0].record.routing).To(BeTrue())
+			Expect(t.length).To(Equal(2))
+			Expect(t.commitLimit(&topic)).To(Equal(int64(0)))
+
+			// It's not delivered again or nacked while being routed
+			Expect(t.due([]string{topic.Name}, now)).To(BeEmpty())
+			Expect(t.nack(topic, []int64{2}, 2, now)).To(BeEmpty())
+
+			t.restore(deadLetter[0], now)
+			Expect(t.due([]string{topic.Name}, now)).To(HaveLen(1))
+			Expect(deadLetter[0].record.routing).To(BeFalse())
+		})
+
+		It("should remove the records once routed to the dead-letter topic", func() {
+			t := newAckTracker()
+			now := time.Now()
+			Expect(t.track(topic, newTestKeyedChunk(encoder, 0), decoder, now)).NotTo(HaveOccurred())
+			deadLetter := t.nack(topic, []int64{0}, 1, now)
+			Expect(deadLetter).To(HaveLen(1))
+
+			t.routed(deadLetter[0])
+			Expect(t.length).To(Equal(1))
+			Expect(t.pending[topic]).NotTo(HaveKey(int64(0)))
+			Expect(t.commitLimit(&topic)).To(Equal(int64(2)))
+
+			// Records that are no longer pending are not restored
+			t.restore(deadLetter[0], now)
+			Expect(t.length).To(Equal(1))
+		})
+	})
+
+	Describe("isFull()", func() {
+		It("should consider the size of the pending records", func() {
+			t := newAckTracker()
+			Expect(t.track(topic, newTestKeyedChunk(encoder, 0), decoder, time.Now())).NotTo(HaveOccurred())
+			Expect(t.size).To(Equal(len(`{"a":1}`) + len(`{"c":3}`) + len("k1") + len("k3")))
+			Expect(t.isFull()).To(BeFalse())
+
+			t.size = maxPendingBytes
+			Expect(t.isFull()).To(BeTrue())
+
+			t.size = 0
+			t.length = maxPendingRecords
+			Expect(t.isFull()).To(BeTrue())
+		})
+	})
+
+	Describe("due()", func() {
+		It("should return the records with expired deadlines sorted by offset", func() {
+			t := newAckTracker()
+			now := time.Now()
+			Expect(t.track(topic, newTestKeyedChunk(encoder, 10), decoder, now)).NotTo(HaveOccurred())
+
+			due := t.due([]string{topic.Name}, now)
+			Expect(due).To(HaveLen(2))
+			Expect([]int64{due[0].offset, due[1].offset}).To(Equal([]int64{10, 12}))
+
+			// The chunk of a single record can be decoded
+			chunk, err := due[1].toChunk(encoder)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(chunk.StartOffset()).To(Equal(int64(12)))
+			Expect(chunk.RecordLength()).To(Equal(uint32(1)))
+			t2 := newAckTracker()
+			Expect(t2.track(topic, chunk, decoder, now)).NotTo(HaveOccurred())
+			Expect(string(t2.pending[topic][12].body)).To(Equal(`{"c":3}`))
+			Expect(string(t2.pending[topic][12].header.Key)).To(Equal("k3"))
+		})
+	})
+})
+
+// Creates a chunk with 3 records in the keyed format, the second one being a tombstone
+func newTestKeyedChunk(encoder *zstd.Encoder, start int64) SegmentChunk {
+	buf := new(bytes.Buffer)
+	Expect(data.WriteRecordFormat(buf, data.RecordFormatKeyed)).NotTo(HaveOccurred())
+	records := []struct {
+		key  string
+		body string
+	}{{"k1", `{"a":1}`}, {"k2", ""}, {"k3", `{"c":3}`}}
+	for _, r := range records {
+		header := &data.RecordHeader{Timestamp: 1, Length: uint32(len(r.body)), Key: []byte(r.key)}
+		Expect(data.WriteRecordHeader(buf, data.RecordFormatKeyed, header)).NotTo(HaveOccurred())
+		buf.WriteString(r.body)
+	}
+
+	return &data.ReadSegmentChunk{
+		Buffer: encoder.EncodeAll(buf.Bytes(), nil),
+		Start:  start,
+		Length: uint32(len(records)),
+	}
+}
@@ -0,0 +1,236 @@
+package consuming
+
+import (
+	"net/http"
+	"sort"
+
+	. "github.com/polarstreams/polar/internal/types"
+	. "github.com/polarstreams/polar/internal/utils"
+	"github.com/rs/zerolog/log"
+)
+
+func (c *consumer) ListGroups() ([]ConsumerGroupInfo, error) {
+	groups, err := c.clusterGroups()
+	if err != nil {
+		return nil, err
+	}
+	for i := range groups {
+		groups[i].Members = nil
+	}
+	return groups, nil
+}
+
+func (c *consumer) DescribeGroup(group string) (*ConsumerGroupInfo, error) {
+	if group == "" {
+		return nil, NewHttpError(http.StatusBadRequest, "Consumer group can not be empty")
+	}
+
+	groups, err := c.clusterGroups()
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range groups {
+		if info.Name == group {
+			if info.Members == nil {
+				info.Members = []ConsumerGroupMember{}
+			}
+			return &info, nil
+		}
+	}
+	return nil, NewHttpErrorf(http.StatusNotFound, "Consumer group '%s' not found", group)
+}
+
+func (c *consumer) DeleteGroup(group string) error {
+	info, err := c.DescribeGroup(group)
+	if err != nil {
+		return err
+	}
+	if len(info.Members) > 0 {
+		return NewHttpErrorf(
+			http.StatusConflict, "Consumer group '%s' can not be deleted as it has %d active members", group, len(info.Members))
+	}
+
+	// Each broker removes the offsets it stores
+	peers := c.topologyGetter.Topology().Peers()
+	err = InParallelAnyError(len(peers), func(i int) error {
+		return c.gossiper.SendConsumerGroupDelete(peers[i].Ordinal, group)
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.deleteGroupLocal(group)
+}
+
+func (c *consumer) CloneGroup(group string, target string, topic string) error {
+	if group == "" || target == "" {
+		return NewHttpError(http.StatusBadRequest, "Consumer group can not be empty")
+	}
+	if group == target {
+		return NewHttpError(http.StatusBadRequest, "Target consumer group must be different from the source")
+	}
+	if topic != "" && !c.topicGetter.Exists(topic) {
+		return NewHttpErrorf(http.StatusNotFound, "Topic '%s' not found", topic)
+	}
+
+	groups, err := c.clusterGroups()
+	if err != nil {
+		return err
+	}
+	if !containsGroup(groups, group) {
+		return NewHttpErrorf(http.StatusNotFound, "Consumer group '%s' not found", group)
+	}
+	if containsGroup(groups, target) {
+		return NewHttpErrorf(http.StatusConflict, "Target consumer group '%s' already exists", target)
+	}
+
+	// Each broker copies the offsets it stores
+	peers := c.topologyGetter.Topology().Peers()
+	errs := CollectErrors(InParallel(len(peers), func(i int) error {
+		return c.gossiper.SendConsumerGroupClone(peers[i].Ordinal, group, target, topic)
+	}))
+	if err := AnyError(errs); err != nil {
+		// The target group didn't exist, remove the offsets copied by the other peers
+		for i, e := range errs {
+			if e != nil {
+				continue
+			}
+			if deleteErr := c.gossiper.SendConsumerGroupDelete(peers[i].Ordinal, target); deleteErr != nil {
+				log.Err(deleteErr).Msgf(
+					"Offsets of consumer group %s could not be removed from peer B%d after a failed clone",
+					target, peers[i].Ordinal)
+			}
+		}
+		return err
+	}
+
+	c.cloneGroupLocal(group, target, topic)
+	return nil
+}
+
+func (c *consumer) OnGroupsFromPeer() []ConsumerGroupInfo {
+	return c.localGroups()
+}
+
+func (c *consumer) OnGroupDeleteFromPeer(group string) error {
+	return c.deleteGroupLocal(group)
+}
+
+func (c *consumer) OnGroupCloneFromPeer(group string, target string, topic string) error {
+	c.cloneGroupLocal(group, target, topic)
+	return nil
+}
+
+// Gets the consumer groups known by this broker merged with the ones known by the peers.
+// It returns an error when the groups could not be retrieved from one of the peers.
+func (c *consumer) clusterGroups() ([]ConsumerGroupInfo, error) {
+	peers := c.topologyGetter.Topology().Peers()
+	peerGroups := make([][]ConsumerGroupInfo, len(peers))
+	err := AnyError(CollectErrors(InParallel(len(peers), func(i int) error {
+		value, err := c.gossiper.ReadConsumerGroups(peers[i].Ordinal)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Consumer groups could not be retrieved from peer B%d", peers[i].Ordinal)
+			return err
+		}
+		peerGroups[i] = value
+		return nil
+	})))
+	if err != nil {
+		return nil, NewHttpErrorf(
+			http.StatusServiceUnavailable, "Consumer groups could not be retrieved from all the brokers: %s", err)
+	}
+
+	return mergeGroups(append([][]ConsumerGroupInfo{c.localGroups()}, peerGroups...)...), nil
+}
+
+// Gets the consumer groups with active members, including the ones gossiped by peers, and the groups with offsets
+// stored in this broker
+func (c *consumer) localGroups() []ConsumerGroupInfo {
+	stored := make([]ConsumerGroupInfo, 0)
+	for _, key := range c.offsetState.Keys() {
+		stored = append(stored, ConsumerGroupInfo{Name: key.Group, Topics: []string{key.Topic}})
+	}
+	return mergeGroups(c.state.GroupsInfo(), stored)
+}
+
+// Removes the offsets of the group stored in this broker, closing the readers of the group
+func (c *consumer) deleteGroupLocal(group string) error {
+	if grq := c.readQueue(group); grq != nil {
+		grq.closeAll()
+	}
+	if err := c.offsetState.RemoveGroup(group); err != nil {
+		return err
+	}
+	log.Info().Msgf("Removed the offsets of consumer group %s", group)
+	return nil
+}
+
+// Copies the offsets of the group stored in this broker into the target group, committing them locally and in
+// the followers of each offset generation.
+//
+// The stored values are copied as is, including the offsets of previous generations and cluster sizes.
+func (c *consumer) cloneGroupLocal(group string, target string, topic string) {
+	total := 0
+	for _, key := range c.offsetState.Keys() {
+		if key.Group != group || (topic != "" && key.Topic != topic) {
+			continue
+		}
+		for _, value := range c.offsetState.Values(group, key.Topic) {
+			c.offsetState.Set(target, key.Topic, value, OffsetCommitAll)
+			total++
+		}
+	}
+	log.Info().Msgf("Copied %d offsets of consumer group %s into %s", total, group, target)
+}
+
+func containsGroup(groups []ConsumerGroupInfo, name string) bool {
+	for _, g := range groups {
+		if g.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Merges the groups by name, with the union of the topics and the members
+func mergeGroups(lists ...[]ConsumerGroupInfo) []ConsumerGroupInfo {
+	type groupBuilder struct {
+		topics  StringSet
+		members map[string]ConsumerGroupMember
+	}
+
+	builders := make(map[string]*groupBuilder)
+	for _, groups := range lists {
+		for _, group := range groups {
+			builder, found := builders[group.Name]
+			if !found {
+				builder = &groupBuilder{topics: make(StringSet), members: make(map[string]ConsumerGroupMember)}
+				builders[group.Name] = builder
+			}
+			builder.topics.Add(group.Topics...)
+			for _, m := range group.Members {
+				// The assignment is calculated in the same way on all brokers
+				if _, exists := builder.members[m.Id]; !exists {
+					builder.members[m.Id] = m
+				}
+			}
+		}
+	}
+
+	result := make([]ConsumerGroupInfo, 0, len(builders))
+	for name, builder := range builders {
+		info := ConsumerGroupInfo{Name: name, Topics: builder.topics.ToSortedSlice()}
+		for _, m := range builder.members {
+			info.Members = append(info.Members, m)
+		}
+		sort.Slice(info.Members, func(i, j int) bool {
+			return info.Members[i].Id < info.Members[j].Id
+		})
+		result = append(result, info)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
@@ -0,0 +1,224 @@
+package consuming
+
+import (
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	cMocks "github.com/polarstreams/polar/internal/test/conf/mocks"
+	dMocks "github.com/polarstreams/polar/internal/test/discovery/mocks"
+	iMocks "github.com/polarstreams/polar/internal/test/interbroker/mocks"
+	tMocks "github.com/polarstreams/polar/internal/test/types/mocks"
+	. "github.com/polarstreams/polar/internal/types"
+	. "github.com/polarstreams/polar/internal/utils"
+	"github.com/stretchr/testify/mock"
+)
+
+var _ = Describe("consumer", func() {
+	Describe("ListGroups()", func() {
+		It("should merge the groups with active members, stored offsets and the ones from peers", func() {
+			state := newConsumerState(3)
+			addConnection(state, "a", "g1", DefaultOffsetResetPolicy, "t1")
+			state.Rebalance()
+
+			offsetState := new(tMocks.OffsetState)
+			offsetState.On("Keys").Return([]OffsetStoreKey{{Group: "g1", Topic: "t2"}, {Group: "g2", Topic: "t1"}})
+			gossiper := new(iMocks.Gossiper)
+			gossiper.On("ReadConsumerGroups", 1).Return([]ConsumerGroupInfo{{Name: "g3", Topics: []string{"t3"}}}, nil)
+			gossiper.On("ReadConsumerGroups", 2).Return([]ConsumerGroupInfo{{Name: "g1", Topics: []string{"t3"}}}, nil)
+
+			c := newGroupAdminTestConsumer(state, offsetState, gossiper)
+
+			groups, err := c.ListGroups()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(groups).To(Equal([]ConsumerGroupInfo{
+				{Name: "g1", Topics: []string{"t1", "t2", "t3"}},
+				{Name: "g2", Topics: []string{"t1"}},
+				{Name: "g3", Topics: []string{"t3"}},
+			}))
+		})
+
+		It("should return an error when the groups could not be retrieved from a peer", func() {
+			offsetState := new(tMocks.OffsetState)
+			offsetState.On("Keys").Return([]OffsetStoreKey{{Group: "g1", Topic: "t1"}})
+			gossiper := new(iMocks.Gossiper)
+			gossiper.On("ReadConsumerGroups", 1).Return([]ConsumerGroupInfo{}, nil)
+			gossiper.On("ReadConsumerGroups", 2).Return(nil, NewHttpError(http.StatusInternalServerError, "Test error"))
+
+			c := newGroupAdminTestConsumer(newConsumerState(3), offsetState, gossiper)
+
+			_, err := c.ListGroups()
+			Expect(err).To(HaveOccurred())
+			Expect(err.(HttpError).StatusCode()).To(Equal(http.StatusServiceUnavailable))
+		})
+	})
+
+	Describe("DescribeGroup()", func() {
+		It("should include the members with the assignments", func() {
+			state := newConsumerState(3)
+			addConnection(state, "a", "g1", DefaultOffsetResetPolicy, "t1")
+			state.Rebalance()
+
+			offsetState := new(tMocks.OffsetState)
+			offsetState.On("Keys").Return([]OffsetStoreKey{})
+			gossiper := new(iMocks.Gossiper)
+			gossiper.On("ReadConsumerGroups", 1).Return([]ConsumerGroupInfo{{
+				Name:    "g1",
+				Topics:  []string{"t1"},
+				Members: []ConsumerGroupMember{{Id: "b"}},
+			}}, nil)
+			gossiper.On("ReadConsumerGroups", 2).Return([]ConsumerGroupInfo{}, nil)
+
+			c := newGroupAdminTestConsumer(state, offsetState, gossiper)
+
+			info, err := c.DescribeGroup("g1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Topics).To(Equal([]string{"t1"}))
+			Expect(info.Members).To(HaveLen(2))
+			Expect(info.Members[0].Id).To(Equal("a"))
+			Expect(info.Members[0].Assignments).To(HaveLen(3))
+			Expect(info.Members[1].Id).To(Equal("b"))
+
+			_, err = c.DescribeGroup("g2")
+			Expect(err).To(HaveOccurred())
+			Expect(err.(HttpError).StatusCode()).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("DeleteGroup()", func() {
+		It("should remove the offsets on all the brokers", func() {
+			offsetState := new(tMocks.OffsetState)
+			offsetState.On("Keys").Return([]OffsetStoreKey{{Group: "g1", Topic: "t1"}})
+			offsetState.On("RemoveGroup", "g1").Return(nil)
+			gossiper := new(iMocks.Gossiper)
+			gossiper.On("ReadConsumerGroups", 1).Return([]ConsumerGroupInfo{}, nil)
+			gossiper.On("ReadConsumerGroups", 2).Return([]ConsumerGroupInfo{}, nil)
+			gossiper.On("SendConsumerGroupDelete", 1, "g1").Return(nil)
+			gossiper.On("SendConsumerGroupDelete", 2, "g1").Return(nil)
+
+			c := newGroupAdminTestConsumer(newConsumerState(3), offsetState, gossiper)
+
+			Expect(c.DeleteGroup("g1")).NotTo(HaveOccurred())
+			offsetState.AssertCalled(GinkgoT(), "RemoveGroup", "g1")
+			gossiper.AssertNumberOfCalls(GinkgoT(), "SendConsumerGroupDelete", 2)
+
+			// The read queue of the group is not created
+			Expect(c.readQueue("g1")).To(BeNil())
+		})
+
+		It("should not remove the offsets when the group has active members", func() {
+			state := newConsumerState(3)
+			addConnection(state, "a", "g1", DefaultOffsetResetPolicy, "t1")
+			state.Rebalance()
+
+			offsetState := new(tMocks.OffsetState)
+			offsetState.On("Keys").Return([]OffsetStoreKey{})
+			gossiper := new(iMocks.Gossiper)
+			gossiper.On("ReadConsumerGroups", 1).Return([]ConsumerGroupInfo{}, nil)
+			gossiper.On("ReadConsumerGroups", 2).Return([]ConsumerGroupInfo{}, nil)
+
+			c := newGroupAdminTestConsumer(state, offsetState, gossiper)
+
+			err := c.DeleteGroup("g1")
+			Expect(err).To(HaveOccurred())
+			Expect(err.(HttpError).StatusCode()).To(Equal(http.StatusConflict))
+			offsetState.AssertNotCalled(GinkgoT(), "RemoveGroup", "g1")
+			gossiper.AssertNotCalled(GinkgoT(), "SendConsumerGroupDelete", 1, "g1")
+		})
+	})
+
+	Describe("CloneGroup()", func() {
+		It("should copy the stored values of all the topics on all the brokers", func() {
+			previous := Offset{Token: -100, Index: 1, Version: 1, ClusterSize: 3, Offset: 10}
+			current := Offset{Token: -100, Index: 1, Version: 2, ClusterSize: 6, Offset: 20}
+			offsetState := new(tMocks.OffsetState)
+			offsetState.On("Keys").Return([]OffsetStoreKey{
+				{Group: "g1", Topic: "t1"}, {Group: "g1", Topic: "t2"}, {Group: "g2", Topic: "t1"}})
+			offsetState.On("Values", "g1", "t1").Return([]Offset{previous, current})
+			offsetState.On("Values", "g1", "t2").Return([]Offset{current})
+			offsetState.On("Set", "g3", mock.Anything, mock.Anything, OffsetCommitAll).Return(true)
+			gossiper := new(iMocks.Gossiper)
+			gossiper.On("ReadConsumerGroups", mock.Anything).Return([]ConsumerGroupInfo{}, nil)
+			gossiper.On("SendConsumerGroupClone", mock.Anything, "g1", "g3", "").Return(nil)
+
+			c := newGroupAdminTestConsumer(newConsumerState(3), offsetState, gossiper)
+
+			Expect(c.CloneGroup("g1", "g3", "")).NotTo(HaveOccurred())
+			gossiper.AssertNumberOfCalls(GinkgoT(), "SendConsumerGroupClone", 2)
+			offsetState.AssertNumberOfCalls(GinkgoT(), "Set", 3)
+			offsetState.AssertCalled(GinkgoT(), "Set", "g3", "t1", previous, OffsetCommitAll)
+			offsetState.AssertCalled(GinkgoT(), "Set", "g3", "t1", current, OffsetCommitAll)
+			offsetState.AssertCalled(GinkgoT(), "Set", "g3", "t2", current, OffsetCommitAll)
+			offsetState.AssertNotCalled(GinkgoT(), "Values", "g2", mock.Anything)
+		})
+
+		It("should remove the copied offsets when a peer fails", func() {
+			offsetState := new(tMocks.OffsetState)
+			offsetState.On("Keys").Return([]OffsetStoreKey{{Group: "g1", Topic: "t1"}})
+			gossiper := new(iMocks.Gossiper)
+			gossiper.On("ReadConsumerGroups", mock.Anything).Return([]ConsumerGroupInfo{}, nil)
+			gossiper.On("SendConsumerGroupClone", 1, "g1", "g3", "").Return(nil)
+			gossiper.On("SendConsumerGroupClone", 2, "g1", "g3", "").
+				Return(NewHttpError(http.StatusInternalServerError, "Test error"))
+			gossiper.On("SendConsumerGroupDelete", 1, "g3").Return(nil)
+
+			c := newGroupAdminTestConsumer(newConsumerState(3), offsetState, gossiper)
+
+			Expect(c.CloneGroup("g1", "g3", "")).To(HaveOccurred())
+			gossiper.AssertCalled(GinkgoT(), "SendConsumerGroupDelete", 1, "g3")
+			gossiper.AssertNumberOfCalls(GinkgoT(), "SendConsumerGroupDelete", 1)
+			offsetState.AssertNotCalled(GinkgoT(), "Set", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+
+		It("should not copy the offsets when the groups could not be retrieved from a peer", func() {
+			offsetState := new(tMocks.OffsetState)
+			offsetState.On("Keys").Return([]OffsetStoreKey{{Group: "g1", Topic: "t1"}})
+			gossiper := new(iMocks.Gossiper)
+			gossiper.On("ReadConsumerGroups", 1).Return([]ConsumerGroupInfo{}, nil)
+			gossiper.On("ReadConsumerGroups", 2).Return(nil, NewHttpError(http.StatusInternalServerError, "Test error"))
+
+			c := newGroupAdminTestConsumer(newConsumerState(3), offsetState, gossiper)
+
+			err := c.CloneGroup("g1", "g3", "")
+			Expect(err).To(HaveOccurred())
+			Expect(err.(HttpError).StatusCode()).To(Equal(http.StatusServiceUnavailable))
+			gossiper.AssertNotCalled(GinkgoT(), "SendConsumerGroupClone", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+
+		It("should not copy the offsets when the target group exists", func() {
+			offsetState := new(tMocks.OffsetState)
+			offsetState.On("Keys").Return([]OffsetStoreKey{{Group: "g1", Topic: "t1"}, {Group: "g2", Topic: "t1"}})
+			gossiper := new(iMocks.Gossiper)
+			gossiper.On("ReadConsumerGroups", mock.Anything).Return([]ConsumerGroupInfo{}, nil)
+
+			c := newGroupAdminTestConsumer(newConsumerState(3), offsetState, gossiper)
+
+			err := c.CloneGroup("g1", "g2", "")
+			Expect(err).To(HaveOccurred())
+			Expect(err.(HttpError).StatusCode()).To(Equal(http.StatusConflict))
+			gossiper.AssertNotCalled(GinkgoT(), "SendConsumerGroupClone", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			offsetState.AssertNotCalled(GinkgoT(), "Set", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+	})
+})
+
+func newGroupAdminTestConsumer(
+	state *ConsumerState,
+	offsetState *tMocks.OffsetState,
+	gossiper *iMocks.Gossiper,
+) *consumer {
+	topology := newTestTopology(3, 0)
+	discoverer := new(dMocks.Discoverer)
+	discoverer.On("Topology").Return(&topology)
+	config := new(cMocks.Config)
+	config.On("MaxGroupSize").Return(1024)
+
+	return &consumer{
+		config:         config,
+		topologyGetter: discoverer,
+		gossiper:       gossiper,
+		state:          state,
+		offsetState:    offsetState,
+		readQueues:     NewCopyOnWriteMap(),
+	}
+}
@@ -0,0 +1,321 @@
+package consuming
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/polarstreams/polar/internal/data"
+	"github.com/polarstreams/polar/internal/metrics"
+	. "github.com/polarstreams/polar/internal/types"
+	"github.com/rs/zerolog/log"
+)
+
+// The maximum amount of chunks to read in a single poll from a reader when all the records of the chunks were skipped
+const maxSkippedChunkReads = 32
+
+// Reads the next chunk of the reader, skipping the records which time-to-live expired and the ones that don't match
+// the filter of the consumer.
+//
+// It returns the chunks containing the records that can be delivered along with the last chunk read. When all the
+// records of a chunk were skipped, it continues reading from the reader as the skipped records count as consumed.
+func (q *groupReadQueue) readChunks(
+	reader *data.SegmentReader,
+	connId string,
+	commitOnly bool,
+) ([]SegmentChunk, SegmentChunk, error) {
+	ttl := q.topicTtl(reader.Topic.Name)
+	filter := q.consumerFilter(connId)
+	manual := q.state.Assignment(connId) != nil
+	for i := 0; ; i++ {
+		segmentReadItem := newSegmentReadItem(connId, commitOnly, q.commitLimit(reader, manual))
+		reader.Items <- segmentReadItem
+		err, chunk := segmentReadItem.result()
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(chunk.DataBlock()) == 0 || commitOnly {
+			return []SegmentChunk{chunk}, chunk, nil
+		}
+
+		now := time.Now()
+		expired := 0
+		chunks, err := q.removeRecords(chunk, func(header *data.RecordHeader, body []byte) bool {
+			if header.IsExpired(ttl, now) {
+				expired++
+				return true
+			}
+			return filter != nil && !filter.matches(header, body)
+		})
+		if err != nil {
+			log.Warn().Err(err).Msgf("Records could not be filtered from chunk of %s", &reader.Topic)
+			return []SegmentChunk{chunk}, chunk, nil
+		}
+		if expired > 0 {
+			metrics.ConsumerExpiredRecords.WithLabelValues(reader.Topic.Name).Add(float64(expired))
+		}
+		if len(chunks) > 0 || i+1 >= maxSkippedChunkReads {
+			return chunks, chunk, nil
+		}
+	}
+}
+
+// Gets the chunks held for the consumer on its previous poll or reads the next chunks of the reader.
+//
+// The held chunks are discarded when another consumer reads, as the reader moves back to the last committed offset.
+func (q *groupReadQueue) nextChunks(
+	reader *data.SegmentReader,
+	connId string,
+	commitOnly bool,
+) ([]SegmentChunk, SegmentChunk, error) {
+	if held, found := q.held[reader.Topic]; found && !commitOnly {
+		delete(q.held, reader.Topic)
+		if held.origin == connId {
+			return held.chunks, held.chunks[0], nil
+		}
+	}
+	return q.readChunks(reader, connId, commitOnly)
+}
+
+// Gets the chunks up to the max amount of records, splitting the chunk that exceeds it.
+//
+// The records left out are held to be delivered on the next poll of the consumer, the reader does not commit past them.
+func (q *groupReadQueue) limitRecords(
+	reader *data.SegmentReader,
+	connId string,
+	chunks []SegmentChunk,
+	maxRecords int,
+) []SegmentChunk {
+	total := 0
+	for i, chunk := range chunks {
+		if total+int(chunk.RecordLength()) <= maxRecords {
+			total += int(chunk.RecordLength())
+			continue
+		}
+
+		result := chunks[:i:i]
+		held := chunks[i:]
+		if remaining := maxRecords - total; remaining > 0 {
+			head, tail, err := q.splitRecords(chunk, remaining)
+			if err != nil {
+				// Deliver the whole chunk, exceeding the limit
+				log.Warn().Err(err).Msgf("Records could not be split from chunk of %s", &reader.Topic)
+				head = chunk
+			}
+			result = append(result, head)
+			held = chunks[i+1:]
+			if tail != nil {
+				held = append([]SegmentChunk{tail}, held...)
+			}
+		}
+		if len(held) > 0 {
+			q.held[reader.Topic] = &heldChunks{origin: connId, chunks: held}
+		}
+		return result
+	}
+	return chunks
+}
+
+// Splits the chunk into a chunk containing the first n records and a chunk containing the rest
+func (q *groupReadQueue) splitRecords(chunk SegmentChunk, n int) (SegmentChunk, SegmentChunk, error) {
+	index := 0
+	head, err := q.removeRecords(chunk, func(*data.RecordHeader, []byte) bool {
+		index++
+		return index > n
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	index = 0
+	tail, err := q.removeRecords(chunk, func(*data.RecordHeader, []byte) bool {
+		index++
+		return index <= n
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(head) != 1 || len(tail) != 1 {
+		return nil, nil, fmt.Errorf("Chunk with %d records could not be split at %d", chunk.RecordLength(), n)
+	}
+	return head[0], tail[0], nil
+}
+
+// Gets the chunks containing the contiguous records of the chunk that should not be skipped.
+//
+// When no records are skipped, the original chunk is returned.
+func (q *groupReadQueue) removeRecords(
+	chunk SegmentChunk,
+	skip func(header *data.RecordHeader, body []byte) bool,
+) ([]SegmentChunk, error) {
+	payload, err := q.decoder.DecodeAll(chunk.DataBlock(), q.filterBuffer[:0])
+	if err != nil {
+		return nil, err
+	}
+	q.filterBuffer = payload
+
+	// The position of each record in the payload and whether it's skipped
+	type recordPosition struct {
+		start   int
+		end     int
+		skipped bool
+	}
+
+	reader := bytes.NewReader(payload)
+	recordReader := data.NewRecordReader(reader)
+	positions := make([]recordPosition, 0, chunk.RecordLength())
+	skipped := 0
+	start := -1
+	for {
+		header, err := recordReader.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if start == -1 {
+			// The first record starts after the format
+			start = 0
+			if recordReader.Format() != data.RecordFormatLegacy {
+				start = 1
+			}
+		}
+		if _, err := reader.Seek(int64(header.Length), io.SeekCurrent); err != nil {
+			return nil, err
+		}
+
+		end := len(payload) - reader.Len()
+		isSkipped := skip(header, payload[end-int(header.Length):end])
+		if isSkipped {
+			skipped++
+		}
+		positions = append(positions, recordPosition{start: start, end: end, skipped: isSkipped})
+		start = end
+	}
+
+	if skipped == 0 {
+		return []SegmentChunk{chunk}, nil
+	}
+
+	result := make([]SegmentChunk, 0)
+	for i := 0; i < len(positions); i++ {
+		if positions[i].skipped {
+			continue
+		}
+		first := i
+		for i+1 < len(positions) && !positions[i+1].skipped {
+			i++
+		}
+
+		buf := new(bytes.Buffer)
+		if err := data.WriteRecordFormat(buf, recordReader.Format()); err != nil {
+			return nil, err
+		}
+		buf.Write(payload[positions[first].start:positions[i].end])
+		result = append(result, &data.ReadSegmentChunk{
+			Buffer: q.getEncoder().EncodeAll(buf.Bytes(), nil),
+			Start:  chunk.StartOffset() + int64(first),
+			Length: uint32(i - first + 1),
+		})
+	}
+	return result, nil
+}
+
+// Converts the chunks of the response items using a newer record format than the one supported by the consumer
+func (q *groupReadQueue) convertRecordFormats(items []consumerResponseItem, format byte) {
+	for i, item := range items {
+		chunk, err := q.convertRecordFormat(item.chunk, format)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Records could not be converted to the format %d for %s", format, &item.topic)
+			continue
+		}
+		items[i].chunk = chunk
+	}
+}
+
+// Gets a chunk containing the records using the provided format, dropping the keys and headers not supported by it.
+//
+// When the chunk is not using a newer format, the original chunk is returned.
+func (q *groupReadQueue) convertRecordFormat(chunk SegmentChunk, format byte) (SegmentChunk, error) {
+	if len(chunk.DataBlock()) == 0 {
+		return chunk, nil
+	}
+	payload, err := q.decoder.DecodeAll(chunk.DataBlock(), q.filterBuffer[:0])
+	if err != nil {
+		return nil, err
+	}
+	q.filterBuffer = payload
+
+	reader := bytes.NewReader(payload)
+	recordReader := data.NewRecordReader(reader)
+	var buf *bytes.Buffer
+	for {
+		header, err := recordReader.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if buf == nil {
+			if recordReader.Format() <= format {
+				return chunk, nil
+			}
+			buf = new(bytes.Buffer)
+			if err := data.WriteRecordFormat(buf, format); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := data.WriteRecordHeader(buf, format, header); err != nil {
+			return nil, err
+		}
+		if reader.Len() < int(header.Length) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		start := len(payload) - reader.Len()
+		if _, err := reader.Seek(int64(header.Length), io.SeekCurrent); err != nil {
+			return nil, err
+		}
+		buf.Write(payload[start : start+int(header.Length)])
+	}
+
+	if buf == nil {
+		return chunk, nil
+	}
+	return &data.ReadSegmentChunk{
+		Buffer: q.getEncoder().EncodeAll(buf.Bytes(), nil),
+		Start:  chunk.StartOffset(),
+		Length: chunk.RecordLength(),
+	}, nil
+}
+
+// Gets the time-to-live defined for the topic or zero when not set
+func (q *groupReadQueue) topicTtl(topic string) time.Duration {
+	info := q.topicGetter.Get(topic)
+	if info == nil {
+		return 0
+	}
+	return info.Ttl()
+}
+
+// Gets the parsed filter provided by the consumer when registering or nil when not set
+func (q *groupReadQueue) consumerFilter(connId string) *recordFilter {
+	expression := q.state.Filter(connId)
+	if expression == "" {
+		return nil
+	}
+	if filter, found := q.filters[expression]; found {
+		return filter
+	}
+
+	// The expression was validated when registering the consumer
+	filter, err := parseRecordFilter(expression)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Invalid filter for consumer %s", connId)
+	}
+	q.filters[expression] = filter
+	return filter
+}
@@ -0,0 +1,176 @@
+package consuming
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/polarstreams/polar/internal/data"
+	. "github.com/polarstreams/polar/internal/types"
+)
+
+var _ = Describe("groupReadQueue", func() {
+	Describe("removeRecords()", func() {
+		topic := TopicDataId{Name: "t1", Token: -100, RangeIndex: 1, Version: 2}
+		decoder, err := zstd.NewReader(bytes.NewReader(make([]byte, 0)), zstd.WithDecoderConcurrency(1))
+		Expect(err).NotTo(HaveOccurred())
+		now := time.UnixMilli(10_000_000)
+
+		It("should return the chunks with the contiguous records that were not skipped", func() {
+			q := &groupReadQueue{decoder: decoder}
+			old := now.Add(-2 * time.Minute).UnixMicro()
+			buf := new(bytes.Buffer)
+			Expect(data.WriteRecordFormat(buf, data.RecordFormatHeaders)).NotTo(HaveOccurred())
+			records := []struct {
+				timestamp int64
+				ttl       string
+				body      string
+			}{{old, "", "a"}, {now.UnixMicro(), "", "b"}, {old, "3600000", "c"}, {old, "", "d"}, {now.UnixMicro(), "", "e"}}
+			for _, r := range records {
+				header := &data.RecordHeader{Timestamp: r.timestamp, Length: uint32(len(r.body)), Key: []byte("k")}
+				if r.ttl != "" {
+					header.Headers = []data.RecordHeaderEntry{{Name: data.RecordHeaderTtl, Value: r.ttl}}
+				}
+				Expect(data.WriteRecordHeader(buf, data.RecordFormatHeaders, header)).NotTo(HaveOccurred())
+				buf.WriteString(r.body)
+			}
+			chunk := &data.ReadSegmentChunk{
+				Buffer: q.getEncoder().EncodeAll(buf.Bytes(), nil),
+				Start:  100,
+				Length: uint32(len(records)),
+			}
+
+			chunks, err := q.removeRecords(chunk, func(header *data.RecordHeader, body []byte) bool {
+				return header.IsExpired(time.Minute, now)
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(chunks).To(HaveLen(2))
+			Expect(chunks[0].StartOffset()).To(Equal(int64(101)))
+			Expect(chunks[0].RecordLength()).To(Equal(uint32(2)))
+			Expect(chunks[1].StartOffset()).To(Equal(int64(104)))
+			Expect(chunks[1].RecordLength()).To(Equal(uint32(1)))
+
+			t := newAckTracker()
+			for _, c := range chunks {
+				Expect(t.track(topic, c, decoder, now)).NotTo(HaveOccurred())
+			}
+			Expect(t.length).To(Equal(3))
+			Expect(string(t.pending[topic][101].body)).To(Equal("b"))
+			Expect(string(t.pending[topic][102].body)).To(Equal("c"))
+			Expect(string(t.pending[topic][104].body)).To(Equal("e"))
+		})
+
+		It("should return the original chunk when no records are skipped", func() {
+			q := &groupReadQueue{decoder: decoder}
+			chunk := newTestKeyedChunk(q.getEncoder(), 10)
+
+			bodies := make([]string, 0)
+			chunks, err := q.removeRecords(chunk, func(header *data.RecordHeader, body []byte) bool {
+				bodies = append(bodies, string(body))
+				return false
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(bodies).To(Equal([]string{`{"a":1}`, "", `{"c":3}`}))
+			Expect(chunks).To(Equal([]SegmentChunk{chunk}))
+		})
+	})
+
+	Describe("limitRecords()", func() {
+		topic := TopicDataId{Name: "t1", Token: -100, RangeIndex: 1, Version: 2}
+		reader := &data.SegmentReader{Topic: topic}
+		decoder, err := zstd.NewReader(bytes.NewReader(make([]byte, 0)), zstd.WithDecoderConcurrency(1))
+		Expect(err).NotTo(HaveOccurred())
+
+		It("should split the chunk exceeding the max records and hold the rest", func() {
+			q := &groupReadQueue{decoder: decoder, acks: newAckTracker(), held: map[TopicDataId]*heldChunks{}}
+			first := newTestKeyedChunk(q.getEncoder(), 10)
+			second := newTestKeyedChunk(q.getEncoder(), 20)
+
+			chunks := q.limitRecords(reader, "c1", []SegmentChunk{first, second}, 4)
+			Expect(chunks).To(HaveLen(2))
+			Expect(chunks[0]).To(BeIdenticalTo(first))
+			Expect(chunks[1].StartOffset()).To(Equal(int64(20)))
+			Expect(chunks[1].RecordLength()).To(Equal(uint32(1)))
+
+			held := q.held[topic]
+			Expect(held).NotTo(BeNil())
+			Expect(held.origin).To(Equal("c1"))
+			Expect(held.chunks).To(HaveLen(1))
+			Expect(held.chunks[0].StartOffset()).To(Equal(int64(21)))
+			Expect(held.chunks[0].RecordLength()).To(Equal(uint32(2)))
+			payload, err := decoder.DecodeAll(held.chunks[0].DataBlock(), nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(payload)).To(ContainSubstring(`{"c":3}`))
+			Expect(string(payload)).NotTo(ContainSubstring(`{"a":1}`))
+
+			// The reader does not commit past the held records
+			Expect(q.commitLimit(reader, false)).To(Equal(int64(21)))
+
+			// The held records are delivered on the next poll of the same consumer
+			next, chunk, err := q.nextChunks(reader, "c1", false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(next).To(Equal(held.chunks))
+			Expect(chunk).To(BeIdenticalTo(held.chunks[0]))
+			Expect(q.held).To(BeEmpty())
+			Expect(q.commitLimit(reader, false)).To(Equal(int64(OffsetCompleted)))
+		})
+
+		It("should hold the chunks after the max records", func() {
+			q := &groupReadQueue{decoder: decoder, held: map[TopicDataId]*heldChunks{}}
+			first := newTestKeyedChunk(q.getEncoder(), 10)
+			second := newTestKeyedChunk(q.getEncoder(), 20)
+
+			chunks := q.limitRecords(reader, "c1", []SegmentChunk{first, second}, 3)
+			Expect(chunks).To(Equal([]SegmentChunk{first}))
+			Expect(q.held[topic].chunks).To(Equal([]SegmentChunk{second}))
+		})
+
+		It("should return the chunks when they don't exceed the max records", func() {
+			q := &groupReadQueue{decoder: decoder, held: map[TopicDataId]*heldChunks{}}
+			original := []SegmentChunk{newTestKeyedChunk(q.getEncoder(), 10)}
+
+			Expect(q.limitRecords(reader, "c1", original, 3)).To(Equal(original))
+			Expect(q.held).To(BeEmpty())
+		})
+	})
+
+	Describe("convertRecordFormat()", func() {
+		decoder, err := zstd.NewReader(bytes.NewReader(make([]byte, 0)), zstd.WithDecoderConcurrency(1))
+		Expect(err).NotTo(HaveOccurred())
+
+		It("should convert the records to the legacy format", func() {
+			q := &groupReadQueue{decoder: decoder}
+			chunk, err := q.convertRecordFormat(newTestKeyedChunk(q.getEncoder(), 10), data.RecordFormatLegacy)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(chunk.StartOffset()).To(Equal(int64(10)))
+			Expect(chunk.RecordLength()).To(Equal(uint32(3)))
+
+			payload, err := decoder.DecodeAll(chunk.DataBlock(), nil)
+			Expect(err).NotTo(HaveOccurred())
+			reader := bytes.NewReader(payload)
+			recordReader := data.NewRecordReader(reader)
+			bodies := make([]string, 0)
+			for i := 0; i < 3; i++ {
+				header, err := recordReader.Next()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(header.Key).To(BeEmpty())
+				body := make([]byte, header.Length)
+				_, _ = reader.Read(body)
+				bodies = append(bodies, string(body))
+			}
+			Expect(recordReader.Format()).To(Equal(data.RecordFormatLegacy))
+			Expect(bodies).To(Equal([]string{`{"a":1}`, "", `{"c":3}`}))
+			Expect(reader.Len()).To(BeZero())
+		})
+
+		It("should return the original chunk when the format is supported", func() {
+			q := &groupReadQueue{decoder: decoder}
+			original := newTestKeyedChunk(q.getEncoder(), 10)
+			chunk, err := q.convertRecordFormat(original, data.RecordFormatHeaders)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(chunk).To(BeIdenticalTo(original))
+		})
+	})
+})
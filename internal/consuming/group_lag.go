@@ -0,0 +1,162 @@
+package consuming
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/polarstreams/polar/internal/metrics"
+	. "github.com/polarstreams/polar/internal/types"
+	. "github.com/polarstreams/polar/internal/utils"
+	"github.com/rs/zerolog/log"
+)
+
+const lagMetricsInterval = 30 * time.Second
+
+func (c *consumer) GroupLag(group string) ([]ConsumerLag, error) {
+	if group == "" {
+		return nil, NewHttpError(http.StatusBadRequest, "Consumer group can not be empty")
+	}
+
+	// Each broker calculates the lag of the tokens it leads
+	result := c.localLag(group)
+	peers := c.topologyGetter.Topology().Peers()
+	peerLags := make([][]ConsumerLag, len(peers))
+	err := AnyError(CollectErrors(InParallel(len(peers), func(i int) error {
+		value, err := c.gossiper.ReadConsumerLag(peers[i].Ordinal, group)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Lag of group %s could not be retrieved from peer B%d", group, peers[i].Ordinal)
+			return err
+		}
+		peerLags[i] = value
+		return nil
+	})))
+	if err != nil {
+		// A partial result would underreport the lag
+		return nil, NewHttpErrorf(
+			http.StatusServiceUnavailable, "Lag of group %s could not be retrieved from all the brokers: %s", group, err)
+	}
+
+	for _, value := range peerLags {
+		result = append(result, value...)
+	}
+	sortLag(result)
+	return result, nil
+}
+
+func (c *consumer) OnLagFromPeer(group string) ([]ConsumerLag, error) {
+	return c.localLag(group), nil
+}
+
+// Gets the lag of the group on the tokens led by this broker, including the previous generations that were not
+// completely consumed. When the group is empty, it gets the lag of all the groups.
+func (c *consumer) localLag(group string) []ConsumerLag {
+	result := make([]ConsumerLag, 0)
+	maxProduced := make(map[TopicDataId]int64)
+	for _, key := range c.offsetState.Keys() {
+		if group != "" && key.Group != group {
+			continue
+		}
+		result = append(result, c.keyLag(key, maxProduced)...)
+	}
+	sortLag(result)
+	return result
+}
+
+func (c *consumer) keyLag(key OffsetStoreKey, maxProduced map[TopicDataId]int64) []ConsumerLag {
+	result := make([]ConsumerLag, 0)
+	seen := make(map[TopicDataId]bool)
+	topology := c.topologyGetter.Topology()
+
+	for i := range topology.Brokers {
+		gen := c.topologyGetter.Generation(topology.GetToken(BrokerIndex(i)))
+		if gen == nil || gen.Leader != topology.MyOrdinal() {
+			continue
+		}
+
+		for index := RangeIndex(0); index < RangeIndex(c.config.ConsumerRanges()); index++ {
+			offsets := c.offsetState.GetAllWithDefaults(
+				key.Group, key.Topic, gen.Start, index, gen.ClusterSize, DefaultOffsetResetPolicy)
+
+			for _, offset := range offsets {
+				if offset.Offset == OffsetCompleted {
+					continue
+				}
+				topicId := TopicDataId{
+					Name:       key.Topic,
+					Token:      offset.Token,
+					RangeIndex: offset.Index,
+					Version:    offset.Version,
+				}
+				if seen[topicId] {
+					// Offsets of previous generations can span multiple ranges
+					continue
+				}
+				seen[topicId] = true
+
+				value, found := maxProduced[topicId]
+				if !found {
+					var err error
+					if value, err = c.offsetState.MaxProducedOffset(&topicId); err != nil {
+						log.Debug().Err(err).Msgf("Max produced offset could not be retrieved for %s", &topicId)
+						value = offsetNoData
+					}
+					maxProduced[topicId] = value
+				}
+
+				result = append(result, ConsumerLag{
+					Group:       key.Group,
+					Topic:       key.Topic,
+					Token:       offset.Token,
+					RangeIndex:  offset.Index,
+					Version:     offset.Version,
+					Offset:      offset.Offset,
+					MaxProduced: value,
+					Lag:         recordsBehind(offset.Offset, value),
+				})
+			}
+		}
+	}
+	return result
+}
+
+// Gets the amount of records from the next offset to read until the last produced offset
+func recordsBehind(offset int64, maxProduced int64) int64 {
+	if maxProduced < offset {
+		return 0
+	}
+	return maxProduced - offset + 1
+}
+
+func sortLag(values []ConsumerLag) {
+	sort.Slice(values, func(i, j int) bool {
+		a, b := values[i], values[j]
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		if a.Topic != b.Topic {
+			return a.Topic < b.Topic
+		}
+		if a.Token != b.Token {
+			return a.Token < b.Token
+		}
+		if a.RangeIndex != b.RangeIndex {
+			return a.RangeIndex < b.RangeIndex
+		}
+		return a.Version < b.Version
+	})
+}
+
+// Periodically exposes the lag of the groups on the tokens led by this broker
+func (c *consumer) updateLagMetrics() {
+	for !c.localDb.IsShuttingDown() {
+		time.Sleep(lagMetricsInterval)
+		values := c.localLag("")
+		metrics.ConsumerGroupLag.Reset()
+		for _, v := range values {
+			metrics.ConsumerGroupLag.
+				WithLabelValues(v.Group, v.Topic, v.Token.String(), v.RangeIndex.String(), v.Version.String()).
+				Set(float64(v.Lag))
+		}
+	}
+}
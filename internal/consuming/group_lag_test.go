@@ -0,0 +1,76 @@
+package consuming
+
+import (
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	cMocks "github.com/polarstreams/polar/internal/test/conf/mocks"
+	dMocks "github.com/polarstreams/polar/internal/test/discovery/mocks"
+	iMocks "github.com/polarstreams/polar/internal/test/interbroker/mocks"
+	tMocks "github.com/polarstreams/polar/internal/test/types/mocks"
+	. "github.com/polarstreams/polar/internal/types"
+	"github.com/stretchr/testify/mock"
+)
+
+var _ = Describe("consumer", func() {
+	Describe("localLag()", func() {
+		It("should include the lag of the previous generations of the tokens led by the broker", func() {
+			const topic = "t1"
+			topology := newTestTopology(3, 0)
+			t0 := topology.GetToken(0)
+			discoverer := new(dMocks.Discoverer)
+			discoverer.On("Topology").Return(&topology)
+			discoverer.On("Generation", t0).Return(&Generation{Start: t0, Version: 2, Leader: 0, ClusterSize: 3})
+			discoverer.On("Generation", mock.Anything).Return(&Generation{Leader: 1})
+
+			config := new(cMocks.Config)
+			config.On("ConsumerRanges").Return(1)
+
+			previous := Offset{Token: t0, Index: 0, Version: 1, ClusterSize: 3, Offset: 10}
+			current := Offset{Token: t0, Index: 0, Version: 2, ClusterSize: 3, Offset: 5}
+			offsetState := new(tMocks.OffsetState)
+			offsetState.On("Keys").Return([]OffsetStoreKey{{Group: "g1", Topic: topic}, {Group: "g2", Topic: topic}})
+			offsetState.On("GetAllWithDefaults", "g1", topic, t0, RangeIndex(0), 3, DefaultOffsetResetPolicy).
+				Return([]Offset{previous, current})
+			offsetState.On("MaxProducedOffset", &TopicDataId{Name: topic, Token: t0, Version: 1}).Return(int64(19), nil)
+			offsetState.On("MaxProducedOffset", &TopicDataId{Name: topic, Token: t0, Version: 2}).Return(int64(4), nil)
+
+			c := &consumer{
+				config:         config,
+				topologyGetter: discoverer,
+				offsetState:    offsetState,
+			}
+
+			Expect(c.localLag("g1")).To(Equal([]ConsumerLag{
+				{Group: "g1", Topic: topic, Token: t0, Version: 1, Offset: 10, MaxProduced: 19, Lag: 10},
+				{Group: "g1", Topic: topic, Token: t0, Version: 2, Offset: 5, MaxProduced: 4, Lag: 0},
+			}))
+			offsetState.AssertNotCalled(
+				GinkgoT(), "GetAllWithDefaults", "g2", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+	})
+
+	Describe("GroupLag()", func() {
+		It("should return an error when the lag could not be retrieved from a peer", func() {
+			topology := newTestTopology(3, 0)
+			discoverer := new(dMocks.Discoverer)
+			discoverer.On("Topology").Return(&topology)
+			offsetState := new(tMocks.OffsetState)
+			offsetState.On("Keys").Return([]OffsetStoreKey{})
+			gossiper := new(iMocks.Gossiper)
+			gossiper.On("ReadConsumerLag", 1, "g1").Return([]ConsumerLag{{Group: "g1", Topic: "t1", Lag: 1}}, nil)
+			gossiper.On("ReadConsumerLag", 2, "g1").Return(nil, NewHttpError(http.StatusInternalServerError, "Test error"))
+
+			c := &consumer{
+				topologyGetter: discoverer,
+				offsetState:    offsetState,
+				gossiper:       gossiper,
+			}
+
+			_, err := c.GroupLag("g1")
+			Expect(err).To(HaveOccurred())
+			Expect(err.(HttpError).StatusCode()).To(Equal(http.StatusServiceUnavailable))
+		})
+	})
+})
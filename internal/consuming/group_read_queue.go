@@ -2,7 +2,9 @@ package consuming
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"encoding/json"
 	"net/http"
 	"time"
 
@@ -11,16 +13,30 @@ import (
 	"github.com/polarstreams/polar/internal/conf"
 	"github.com/polarstreams/polar/internal/data"
 	. "github.com/polarstreams/polar/internal/data"
+	"github.com/polarstreams/polar/internal/data/topics"
 	"github.com/polarstreams/polar/internal/discovery"
 	"github.com/polarstreams/polar/internal/interbroker"
+	"github.com/polarstreams/polar/internal/metrics"
+	"github.com/polarstreams/polar/internal/quotas"
 	. "github.com/polarstreams/polar/internal/types"
 	"github.com/polarstreams/polar/internal/utils"
 	"github.com/rs/zerolog/log"
 )
 
 const refreshPeriod = 2 * time.Second
+
+// The max interval to check for new data while a poll is held open, the poll is woken up when data of the topics is
+// written to the segment files on this broker
+const pollWaitInterval = time.Second
+
 const offsetNoData = -1 // We should use types and flags in the future
 
+// The record headers added to the records routed to a dead-letter topic
+const (
+	deadLetterSourceTopicHeader = RecordHeaderPrefix + "Source-Topic"
+	deadLetterSourceGroupHeader = RecordHeaderPrefix + "Source-Group"
+)
+
 // Receives read requests per group on a single thread.
 //
 // It should close unused readers
@@ -34,12 +50,21 @@ type groupReadQueue struct {
 	topologyGetter discovery.TopologyGetter
 	datalog        data.Datalog
 	gossiper       interbroker.Gossiper
+	topicGetter    topics.TopicGetter
 	rrFactory      ReplicationReaderFactory
 	config         conf.ConsumerConfig
 	readerIndex    uint16
 	readers        map[string]map[readerKey]*SegmentReader // map of readers per topic with map of token+index+clusterSize as keys
 	decoder        *zstd.Decoder                           // Decoder used for json consumer responses
 	decoderBuffer  []byte                                  // Small buffer for reading the decoded payload
+	encoder        *zstd.Encoder                           // Encoder used for the records delivered again, lazily created
+	filterBuffer   []byte                                  // Buffer for the decoded payload when removing records
+	filters        map[string]*recordFilter                // The parsed filters of the consumers by expression
+	acks           *ackTracker                             // The records delivered in ack mode that were not acknowledged
+	committed      map[TopicDataId]int64                   // The offsets committed by consumers with manually assigned ranges
+	held           map[TopicDataId]*heldChunks             // The chunks read that were left out of a poll by its max records
+	producer       RecordProducer                          // Used to route records to the dead-letter topic
+	limiter        quotas.Limiter                          // Charges the bytes served to the consumer quotas
 }
 
 func newGroupReadQueue(
@@ -49,8 +74,11 @@ func newGroupReadQueue(
 	topologyGetter discovery.TopologyGetter,
 	datalog data.Datalog,
 	gossiper interbroker.Gossiper,
+	topicGetter topics.TopicGetter,
 	rrFactory ReplicationReaderFactory,
 	config conf.ConsumerConfig,
+	producer RecordProducer,
+	limiter quotas.Limiter,
 ) *groupReadQueue {
 	decoder, err := zstd.NewReader(bytes.NewReader(make([]byte, 0)),
 		zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(uint64(config.MaxGroupSize())))
@@ -65,10 +93,17 @@ func newGroupReadQueue(
 		topologyGetter: topologyGetter,
 		datalog:        datalog,
 		gossiper:       gossiper,
+		topicGetter:    topicGetter,
 		rrFactory:      rrFactory,
 		config:         config,
 		decoder:        decoder,
 		decoderBuffer:  make([]byte, 16_384),
+		acks:           newAckTracker(),
+		filters:        make(map[string]*recordFilter),
+		committed:      make(map[TopicDataId]int64),
+		held:           make(map[TopicDataId]*heldChunks),
+		producer:       producer,
+		limiter:        limiter,
 	}
 	go queue.process()
 	go queue.refreshPeriodically()
@@ -77,11 +112,43 @@ func newGroupReadQueue(
 
 type readQueueItem struct {
 	connId     string
+	client     string // The client the bytes served are charged to, empty when the reads are not charged
 	writer     http.ResponseWriter
 	done       chan bool // Gets a single value when it's done writing the response
 	commitOnly bool
 	refresh    bool // Determines whether the item was meant for the read queue to re-evaluate internal maps
 	format     responseFormat
+	options    *pollOptions   // The limits of the poll
+	canWait    bool           // Determines whether the caller will retry when there's no data, instead of responding
+	stream     *bytes.Buffer  // When set, the response items are marshalled into the buffer instead of the writer
+	seek       *seekItem      // The offsets to move to, when the position of the group is explicitly moved
+	closeAll   bool           // Determines whether the item was meant to close all the readers of the group
+	acks       *ackItem       // The records acknowledged by a consumer in ack mode
+	offsets    []OffsetCommit // The offsets explicitly committed by a consumer with manually assigned ranges
+	deadLetter *deadLetterResult
+}
+
+type seekItem struct {
+	topic   string
+	offsets []Offset
+}
+
+type ackItem struct {
+	values []RecordAck
+	nack   bool
+}
+
+// The outcome of routing a record to the dead-letter topic
+type deadLetterResult struct {
+	record pendingRecordRef
+	err    error
+}
+
+// Represents the records read for a consumer that were not delivered as the poll reached the max records, they are
+// delivered on the following poll of the same consumer
+type heldChunks struct {
+	origin string
+	chunks []SegmentChunk
 }
 
 func (q *groupReadQueue) process() {
@@ -92,33 +159,67 @@ func (q *groupReadQueue) process() {
 			continue
 		}
 
+		if item.seek != nil {
+			q.seekReaders(item.seek)
+			item.done <- true
+			continue
+		}
+
+		if item.closeAll {
+			q.closeAllReaders()
+			item.done <- true
+			continue
+		}
+
+		if item.acks != nil {
+			q.processAcks(item.connId, item.acks)
+			item.done <- true
+			continue
+		}
+
+		if item.offsets != nil {
+			q.processOffsetCommits(item.connId, item.offsets)
+			item.done <- true
+			continue
+		}
+
+		if item.deadLetter != nil {
+			q.processDeadLetterResult(item.deadLetter)
+			item.done <- true
+			continue
+		}
+
 		group, tokens, topics := logsToServe(q.state, q.topologyGetter, item.connId)
 		if group != q.group {
 			// There was a change in topology, tell the client to poll again
-			utils.NoContentResponse(item.writer, 0)
-			item.done <- true
+			q.noContentResponse(&item, 0)
 			continue
 		}
 		if len(tokens) == 0 || len(topics) == 0 {
-			utils.NoContentResponse(item.writer, consumerNoOwnedDataDelay)
-			item.done <- true
+			q.noContentResponse(&item, consumerNoOwnedDataDelay)
 			continue
 		}
 
 		responseItems := make([]consumerResponseItem, 0, 1)
 		errors := make([]error, 0)
+		ackMode := q.state.AckSettings(item.connId).AckMode && !item.commitOnly
 
-		if len(responseItems) == 0 {
+		if ackMode {
+			// The records that were not acknowledged in time are delivered first
+			responseItems = q.dueResponseItems(topics, item.options)
+		}
+
+		if len(responseItems) == 0 && !(ackMode && q.acks.isFull()) {
 			readers := q.getReaders(tokens, topics, q.state.OffsetPolicy(item.connId))
 			totalSize := 0
 
-			for i := 0; i < len(readers) && totalSize < q.config.ConsumerReadThreshold(); i++ {
+			totalRecords := 0
+
+			for i := 0; i < len(readers) && !item.options.isFulfilled(totalSize, totalRecords); i++ {
 				// Use an incremental index to try to be fair between calls by round robin through readers
 				reader := readers[int(q.readerIndex)%len(readers)]
 				q.readerIndex++
-				segmentReadItem := newSegmentReadItem(item.connId, item.commitOnly)
-				reader.Items <- segmentReadItem
-				err, chunk := segmentReadItem.result()
+				chunks, chunk, err := q.nextChunks(reader, item.connId, item.commitOnly)
 
 				if err != nil {
 					log.Warn().Err(err).Msgf("There was an error reading for %s", &reader.Topic)
@@ -126,25 +227,44 @@ func (q *groupReadQueue) process() {
 					continue
 				}
 
-				size := len(chunk.DataBlock())
-				if size > 0 {
-					// A non-empty data block
-					responseItems = append(responseItems, consumerResponseItem{chunk: chunk, topic: reader.Topic})
-					totalSize += size
+				if len(chunk.DataBlock()) > 0 {
+					// A non-empty data block, the expired and filtered records were removed
+					if item.options.maxRecords > 0 {
+						chunks = q.limitRecords(reader, item.connId, chunks, item.options.maxRecords-totalRecords)
+					}
+					for _, c := range chunks {
+						responseItems = append(responseItems, consumerResponseItem{chunk: c, topic: reader.Topic})
+						totalSize += len(c.DataBlock())
+						totalRecords += int(c.RecordLength())
+						if ackMode {
+							deadline := time.Now().Add(q.config.ConsumerAckTimeout())
+							if err := q.acks.track(reader.Topic, c, q.decoder, deadline); err != nil {
+								log.Err(err).Msgf("Records delivered in ack mode could not be tracked for %s", &reader.Topic)
+							}
+						}
+					}
 				} else if !item.commitOnly {
 					// No data from this reader since we last read
-					q.maybeCloseReader(reader, chunk)
+					q.maybeCloseReader(reader, chunk, q.state.Assignment(item.connId) != nil)
 				}
 			}
 		}
 
 		if len(responseItems) == 0 {
-			if len(errors) > 0 {
+			// Errors are not written to streams, the caller will read again
+			if len(errors) > 0 && item.stream == nil {
 				if !item.commitOnly {
 					http.Error(item.writer, "Unexpected error while reading", http.StatusInternalServerError)
 				} else {
 					http.Error(item.writer, "Manual commit ignored: another origin reading", http.StatusConflict)
 				}
+			} else if item.canWait {
+				// The caller will try again
+				item.done <- false
+				continue
+			} else if item.options.wait > 0 {
+				// The consumer already waited for data, it can poll again right away
+				utils.NoContentResponse(item.writer, 0)
 			} else {
 				utils.NoContentResponse(item.writer, consumerNoDataDelay)
 			}
@@ -153,6 +273,24 @@ func (q *groupReadQueue) process() {
 			continue
 		}
 
+		if item.client != "" {
+			for _, r := range responseItems {
+				q.limiter.ChargeBytes(
+					quotas.OperationConsume, item.client, r.topic.Name, int64(len(r.chunk.DataBlock())))
+			}
+		}
+
+		if item.stream != nil {
+			// The caller writes the events to the consumer, allowing slow consumers not to block the queue
+			q.marshalStreamResponse(item.stream, item.format, responseItems)
+			item.done <- true
+			continue
+		}
+
+		if item.format == compressedBinaryFormat {
+			q.convertRecordFormats(responseItems, q.state.RecordFormat(item.connId))
+		}
+
 		err := q.marshalResponse(item.writer, item.format, responseItems)
 		if err != nil {
 			// There was an error writing to the consumer
@@ -169,6 +307,17 @@ func (q *groupReadQueue) process() {
 	}
 }
 
+// Responds to the poll with no data.
+// Stream items are not responded, the caller determines whether to continue streaming.
+func (q *groupReadQueue) noContentResponse(item *readQueueItem, retryAfter int) {
+	if item.stream != nil {
+		item.done <- false
+		return
+	}
+	utils.NoContentResponse(item.writer, retryAfter)
+	item.done <- true
+}
+
 func (q *groupReadQueue) refreshReaders() {
 	// TODO: From time to time, we should check for readers for which the broker is not longer the leader and close,
 	// using the offset and leadership info
@@ -226,6 +375,7 @@ func (q *groupReadQueue) refreshReaders() {
 		}
 		t := &reader.Topic
 		delete(readersByTopic, newReaderKey(t.Token, t.RangeIndex, reader.TopicRangeClusterSize))
+		delete(q.held, *t)
 	}
 }
 
@@ -280,23 +430,136 @@ func (q *groupReadQueue) marshalJsonResponse(w http.ResponseWriter, responseItem
 	return err
 }
 
-func (q *groupReadQueue) readNext(connId string, format responseFormat, w http.ResponseWriter) {
+// Writes each response item as a JSON object in a single line, as a line of newline delimited JSON or as the data of
+// a server-sent event
+func (q *groupReadQueue) marshalStreamResponse(
+	buf *bytes.Buffer,
+	format responseFormat,
+	responseItems []consumerResponseItem,
+) {
+	itemBuf := new(bytes.Buffer)
+	for _, item := range responseItems {
+		itemBuf.Reset()
+		if err := item.MarshalJson(jsonwriter.New(itemBuf), q.decoder, q.decoderBuffer); err != nil {
+			// Skip the item, the rest of the items can still be written
+			log.Err(err).Msgf(
+				"Stream item with offset %d of %s could not be written in json", item.chunk.StartOffset(), &item.topic)
+			continue
+		}
+
+		if format == eventStreamFormat {
+			buf.WriteString("data: ")
+		}
+		// The values are included as produced, remove the line breaks
+		if err := json.Compact(buf, itemBuf.Bytes()); err != nil {
+			log.Warn().Err(err).Msgf("Invalid JSON values could not be compacted for %s", &item.topic)
+			buf.Write(bytes.ReplaceAll(itemBuf.Bytes(), []byte("\n"), []byte(" ")))
+		}
+		buf.WriteByte('\n')
+		if format == eventStreamFormat {
+			buf.WriteByte('\n')
+		}
+	}
+}
+
+// Reads the next events of a stream into the buffer.
+// Returns false when there's no data available.
+func (q *groupReadQueue) readStream(
+	connId string,
+	client string,
+	format responseFormat,
+	options *pollOptions,
+	buf *bytes.Buffer,
+) bool {
 	done := make(chan bool, 1)
 	q.items <- readQueueItem{
-		connId: connId,
-		writer: w,
-		format: format,
-		done:   done,
+		connId:  connId,
+		client:  client,
+		format:  format,
+		options: options,
+		canWait: true,
+		stream:  buf,
+		done:    done,
+	}
+
+	return <-done
+}
+
+// Commits the position of the stream readers
+func (q *groupReadQueue) commitStream(connId string) {
+	done := make(chan bool, 1)
+	q.items <- readQueueItem{
+		connId:     connId,
+		options:    &pollOptions{maxBytes: q.config.ConsumerReadThreshold()},
+		canWait:    true,
+		stream:     new(bytes.Buffer),
+		done:       done,
+		commitOnly: true,
 	}
 
 	<-done
 }
 
+// Reads the data for the consumer and writes the response.
+//
+// When there's no data, the request is held open until data of the topics is written or the wait of the poll expires.
+func (q *groupReadQueue) readNext(
+	ctx context.Context,
+	connId string,
+	client string,
+	topics []string,
+	format responseFormat,
+	options *pollOptions,
+	w http.ResponseWriter,
+) {
+	deadline := time.Now().Add(options.wait)
+	written := make(chan struct{}, 1)
+	stopWaiting := data.WaitForWrites(topics, written)
+	defer stopWaiting()
+	woken := false
+	for {
+		remaining := time.Until(deadline)
+		done := make(chan bool, 1)
+		q.items <- readQueueItem{
+			connId:  connId,
+			client:  client,
+			writer:  w,
+			format:  format,
+			options: options,
+			canWait: remaining > 0,
+			done:    done,
+		}
+
+		if written := <-done; written {
+			return
+		}
+
+		if woken {
+			// The readers start reading the new data from disk after responding with no data, try again right away
+			woken = false
+			continue
+		}
+
+		if remaining > pollWaitInterval {
+			remaining = pollWaitInterval
+		}
+		select {
+		case <-ctx.Done():
+			// The consumer is no longer waiting for the response
+			return
+		case <-written:
+			woken = true
+		case <-time.After(remaining):
+		}
+	}
+}
+
 func (q *groupReadQueue) manualCommit(connId string, w http.ResponseWriter) {
 	done := make(chan bool, 1)
 	q.items <- readQueueItem{
 		connId:     connId,
 		writer:     w,
+		options:    &pollOptions{maxBytes: q.config.ConsumerReadThreshold()},
 		done:       done,
 		commitOnly: true,
 	}
@@ -304,8 +567,245 @@ func (q *groupReadQueue) manualCommit(connId string, w http.ResponseWriter) {
 	<-done
 }
 
-func (q *groupReadQueue) maybeCloseReader(reader *SegmentReader, chunk SegmentChunk) {
+// Moves the position of the group on the topic, closing the readers to be re-created using the new offsets
+func (q *groupReadQueue) seek(topic string, offsets []Offset) {
+	done := make(chan bool, 1)
+	q.items <- readQueueItem{
+		seek: &seekItem{topic: topic, offsets: offsets},
+		done: done,
+	}
+
+	<-done
+}
+
+func (q *groupReadQueue) seekReaders(item *seekItem) {
+	for _, reader := range q.readers[item.topic] {
+		q.closeReader(reader)
+	}
+	q.acks.remove(item.topic)
+
+	for _, offset := range item.offsets {
+		q.offsetState.Set(q.group, item.topic, offset, OffsetCommitAll)
+	}
+}
+
+// Closes the readers of all the topics, used before removing the stored offsets of the group
+func (q *groupReadQueue) closeAll() {
+	done := make(chan bool, 1)
+	q.items <- readQueueItem{closeAll: true, done: done}
+
+	<-done
+}
+
+func (q *groupReadQueue) closeAllReaders() {
+	for _, readersByTopic := range q.readers {
+		for _, reader := range readersByTopic {
+			q.closeReader(reader)
+		}
+	}
+	q.acks = newAckTracker()
+}
+
+// Acknowledges or negatively acknowledges records delivered by this broker in ack mode
+func (q *groupReadQueue) ack(connId string, values []RecordAck, nack bool) {
+	done := make(chan bool, 1)
+	q.items <- readQueueItem{
+		connId: connId,
+		acks:   &ackItem{values: values, nack: nack},
+		done:   done,
+	}
+
+	<-done
+}
+
+func (q *groupReadQueue) processAcks(connId string, item *ackItem) {
+	deadLetterTopic := q.state.AckSettings(connId).DeadLetterTopic
+	now := time.Now()
+	for _, value := range item.values {
+		topic := value.TopicId()
+		if !item.nack {
+			q.acks.ack(topic, value.Offsets)
+			continue
+		}
+
+		for _, r := range q.acks.nack(topic, value.Offsets, q.config.ConsumerMaxNacks(), now) {
+			q.deadLetter(deadLetterTopic, r)
+		}
+	}
+}
+
+// Commits the offsets explicitly provided by a consumer with manually assigned ranges
+func (q *groupReadQueue) commitOffsets(connId string, offsets []OffsetCommit) {
+	if len(offsets) == 0 {
+		return
+	}
+	done := make(chan bool, 1)
+	q.items <- readQueueItem{
+		connId:  connId,
+		offsets: offsets,
+		done:    done,
+	}
+
+	<-done
+}
+
+func (q *groupReadQueue) processOffsetCommits(connId string, offsets []OffsetCommit) {
+	for _, value := range offsets {
+		topicId := value.TopicId()
+		reader := q.findReader(&topicId)
+		if reader == nil {
+			q.storeCommittedOffset(&topicId, value.Offset)
+			continue
+		}
+
+		// The reader stores the offset, up to the position of the records delivered
+		q.committed[topicId] = value.Offset
+		segmentReadItem := newSegmentReadItem(connId, true, q.commitLimit(reader, true))
+		reader.Items <- segmentReadItem
+		if err, _ := segmentReadItem.result(); err != nil {
+			log.Warn().Err(err).Msgf("Offset %d could not be committed for %s", value.Offset, &topicId)
+		}
+	}
+}
+
+// Stores the committed offset of a range without an open reader, when this broker leads the token
+func (q *groupReadQueue) storeCommittedOffset(topicId *TopicDataId, value int64) {
+	current := q.topologyGetter.Generation(topicId.Token)
+	if current == nil || current.Leader != q.topologyGetter.Topology().MyOrdinal() {
+		return
+	}
+	gen := q.topologyGetter.GenerationInfo(topicId.GenId())
+	if gen == nil {
+		log.Warn().Msgf("Offset %d could not be committed, generation %s not found", value, topicId.GenId())
+		return
+	}
+	q.offsetState.Set(q.group, topicId.Name, NewOffset(topicId, gen.ClusterSize, current.Id(), value), OffsetCommitAll)
+}
+
+func (q *groupReadQueue) findReader(topicId *TopicDataId) *SegmentReader {
+	for _, reader := range q.readers[topicId.Name] {
+		if reader.Topic == *topicId {
+			return reader
+		}
+	}
+	return nil
+}
+
+// Gets the offset that the stored offset of the reader can not move past: the lowest offset that was not acknowledged
+// in ack mode, the first record held for the next poll and, for consumers with manually assigned ranges, the offset
+// they committed
+func (q *groupReadQueue) commitLimit(reader *SegmentReader, manual bool) int64 {
+	limit := q.acks.commitLimit(&reader.Topic)
+	if held, found := q.held[reader.Topic]; found && held.chunks[0].StartOffset() < limit {
+		limit = held.chunks[0].StartOffset()
+	}
+	if committed, found := q.committed[reader.Topic]; manual && found && committed < limit {
+		limit = committed
+	}
+	return limit
+}
+
+// Routes the record to the dead-letter topic in the background, when the record can not be produced it's delivered
+// again
+func (q *groupReadQueue) deadLetter(topic string, r pendingRecordRef) {
+	if topic == "" {
+		log.Warn().Msgf(
+			"Discarding record with offset %d of %s for group %s after %d nacks, there's no dead-letter topic",
+			r.offset, &r.topic, q.group, r.record.nacks)
+		q.acks.ack(r.topic, []int64{r.offset})
+		return
+	}
+
+	contentType := ""
+	recordHeaders := http.Header{}
+	for _, e := range r.record.header.Headers {
+		switch e.Name {
+		case ContentTypeHeaderKey:
+			contentType = e.Value
+		case RecordHeaderProducerId, RecordHeaderProducerSequence, RecordHeaderTtl:
+			// The sequence and time-to-live of the original record do not apply to the dead-letter topic
+		default:
+			recordHeaders[e.Name] = []string{e.Value}
+		}
+	}
+	recordHeaders[deadLetterSourceTopicHeader] = []string{r.topic.Name}
+	recordHeaders[deadLetterSourceGroupHeader] = []string{q.group}
+
+	key := string(r.record.header.Key)
+	body := r.record.body
+	go func() {
+		// Producing involves sending the record to the leader of the token, it should not block the queue
+		_, err := q.producer.ProduceRecord(topic, key, contentType, recordHeaders, body)
+		if err != nil {
+			log.Err(err).Msgf(
+				"Record with offset %d of %s could not be routed to dead-letter topic %s", r.offset, &r.topic, topic)
+		} else {
+			log.Info().Msgf(
+				"Routed record with offset %d of %s for group %s to dead-letter topic %s", r.offset, &r.topic, q.group, topic)
+		}
+
+		done := make(chan bool, 1)
+		q.items <- readQueueItem{deadLetter: &deadLetterResult{record: r, err: err}, done: done}
+		<-done
+	}()
+}
+
+// Removes the record routed to the dead-letter topic from the pending records or sets it to be delivered again when
+// it could not be routed
+func (q *groupReadQueue) processDeadLetterResult(result *deadLetterResult) {
+	if result.err != nil {
+		q.acks.restore(result.record, time.Now())
+		return
+	}
+	q.acks.routed(result.record)
+}
+
+// Gets the records which ack timeout expired as response items, up to the poll limits
+func (q *groupReadQueue) dueResponseItems(topics []string, options *pollOptions) []consumerResponseItem {
+	result := make([]consumerResponseItem, 0)
+	now := time.Now()
+	totalSize := 0
+	for _, r := range q.acks.due(topics, now) {
+		if options.isFulfilled(totalSize, len(result)) {
+			break
+		}
+		if r.record.header.IsExpired(q.topicTtl(r.topic.Name), now) {
+			// Expired records are not delivered again
+			q.acks.ack(r.topic, []int64{r.offset})
+			metrics.ConsumerExpiredRecords.WithLabelValues(r.topic.Name).Inc()
+			continue
+		}
+		chunk, err := r.toChunk(q.getEncoder())
+		if err != nil {
+			log.Err(err).Msgf("Record with offset %d of %s could not be delivered again", r.offset, &r.topic)
+			continue
+		}
+		r.record.deadline = now.Add(q.config.ConsumerAckTimeout())
+		result = append(result, consumerResponseItem{chunk: chunk, topic: r.topic})
+		totalSize += len(chunk.DataBlock())
+	}
+	return result
+}
+
+func (q *groupReadQueue) getEncoder() *zstd.Encoder {
+	if q.encoder == nil {
+		encoder, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		utils.PanicIfErr(err, "Invalid zstd writer settings")
+		q.encoder = encoder
+	}
+	return q.encoder
+}
+
+func (q *groupReadQueue) maybeCloseReader(reader *SegmentReader, chunk SegmentChunk, manual bool) {
+	if q.acks.hasPending(&reader.Topic) {
+		// The offset can not be marked as completed until all the records are acknowledged
+		return
+	}
 	nextReadOffset := chunk.StartOffset()
+	if committed, found := q.committed[reader.Topic]; manual && found && committed < nextReadOffset {
+		// The offset can not be marked as completed until the consumer commits all the records
+		nextReadOffset = committed
+	}
 	if reader.MaxProducedOffset != nil && nextReadOffset > *reader.MaxProducedOffset {
 		// There will be no more data, we should dispose the reader
 		q.closeReader(reader)
@@ -326,6 +826,8 @@ func (q *groupReadQueue) closeReader(reader *SegmentReader) {
 
 	key := newReaderKey(topicId.Token, topicId.RangeIndex, reader.TopicRangeClusterSize)
 	delete(topicReaders, key)
+	delete(q.committed, topicId)
+	delete(q.held, topicId)
 	close(reader.Items)
 }
 
@@ -387,6 +889,7 @@ func (q *groupReadQueue) getReaders(
 					}
 
 					topicReaders[key] = reader
+					q.committed[reader.Topic] = offset.Offset
 					result = append(result, reader)
 				}
 			}
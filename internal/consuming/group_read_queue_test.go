@@ -2,10 +2,14 @@ package consuming
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"net/http"
 	"net/http/httptest"
+	"time"
 
 	"github.com/klauspost/compress/zstd"
 	. "github.com/onsi/ginkgo"
@@ -95,5 +99,257 @@ var _ = Describe("groupReadQueue()", func() {
 			Expect(string(body)).To(Equal(expected))
 
 		})
+
+		It("should marshal records with headers", func() {
+			writeBuffer := &bytes.Buffer{}
+			compressor, _ := zstd.NewWriter(
+				writeBuffer, zstd.WithEncoderCRC(true), zstd.WithEncoderLevel(zstd.SpeedDefault))
+
+			msg1 := `{"hello": 1}`
+			msg2 := "plain \"text\""
+			Expect(data.WriteRecordFormat(compressor, data.RecordFormatHeaders)).NotTo(HaveOccurred())
+			records := []struct {
+				body    string
+				headers []data.RecordHeaderEntry
+			}{
+				{msg1, []data.RecordHeaderEntry{{Name: "X-Polar-Header-Trace", Value: "abc"}}},
+				{msg2, []data.RecordHeaderEntry{{Name: "Content-Type", Value: "text/plain"}}},
+			}
+			for _, r := range records {
+				header := &data.RecordHeader{Length: uint32(len(r.body)), Headers: r.headers}
+				Expect(data.WriteRecordHeader(compressor, data.RecordFormatHeaders, header)).NotTo(HaveOccurred())
+				_, err := compressor.Write([]byte(r.body))
+				Expect(err).NotTo(HaveOccurred())
+			}
+			compressor.Close()
+
+			w := httptest.NewRecorder()
+			responseItem := consumerResponseItem{
+				chunk: &data.ReadSegmentChunk{
+					Buffer: writeBuffer.Bytes(),
+					Start:  567,
+					Length: 2,
+				},
+				topic: topic,
+			}
+
+			err := q.marshalResponse(w, jsonFormat, []consumerResponseItem{responseItem})
+			Expect(err).NotTo(HaveOccurred())
+
+			resp := w.Result()
+			body, _ := io.ReadAll(resp.Body)
+			expected := fmt.Sprintf(
+				`[{"topic":"my-topic1","token":"-3074457345618259968","rangeIndex":2,"version":3,"startOffset":"567",`+
+					`"values":[%s,"%s"],`+
+					`"headers":[{"X-Polar-Header-Trace":"abc"},{"Content-Type":"text/plain"}]}]`,
+				msg1, base64.StdEncoding.EncodeToString([]byte(msg2)),
+			)
+			Expect(string(body)).To(Equal(expected))
+		})
+		It("should marshal tombstones as null values", func() {
+			encoder, _ := zstd.NewWriter(nil)
+			w := httptest.NewRecorder()
+			responseItem := consumerResponseItem{chunk: newTestKeyedChunk(encoder, 10), topic: topic}
+
+			err := q.marshalResponse(w, jsonFormat, []consumerResponseItem{responseItem})
+			Expect(err).NotTo(HaveOccurred())
+
+			resp := w.Result()
+			body, _ := io.ReadAll(resp.Body)
+			Expect(string(body)).To(Equal(
+				`[{"topic":"my-topic1","token":"-3074457345618259968","rangeIndex":2,"version":3,"startOffset":"10",` +
+					`"values":[{"a":1},null,{"c":3}]}]`))
+		})
+	})
+
+	Describe("marshalStreamResponse()", func() {
+		topic := TopicDataId{Name: "my-topic1", Token: -3074457345618259968, RangeIndex: 2, Version: 3}
+		config := new(cMocks.Config)
+		config.On("MaxGroupSize").Return(1 * conf.MiB)
+		decoder, err := zstd.NewReader(bytes.NewReader(make([]byte, 0)),
+			zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(uint64(config.MaxGroupSize())))
+		Expect(err).NotTo(HaveOccurred())
+		q := groupReadQueue{
+			config:        config,
+			decoder:       decoder,
+			decoderBuffer: make([]byte, 16_384),
+		}
+
+		writeBuffer := &bytes.Buffer{}
+		compressor, _ := zstd.NewWriter(
+			writeBuffer, zstd.WithEncoderCRC(true), zstd.WithEncoderLevel(zstd.SpeedDefault))
+		msg := "{\"hello\":\n 1}"
+		Expect(binary.Write(compressor, conf.Endianness, recordHeader{Length: uint32(len(msg))})).NotTo(HaveOccurred())
+		_, err = compressor.Write([]byte(msg))
+		Expect(err).NotTo(HaveOccurred())
+		compressor.Close()
+
+		responseItems := []consumerResponseItem{
+			{chunk: &data.ReadSegmentChunk{Buffer: writeBuffer.Bytes(), Start: 567, Length: 1}, topic: topic},
+			{chunk: data.NewEmptyChunk(123), topic: topic},
+		}
+		expected := []string{
+			`{"topic":"my-topic1","token":"-3074457345618259968","rangeIndex":2,"version":3,"startOffset":"567","values":[{"hello":1}]}`,
+			`{"topic":"my-topic1","token":"-3074457345618259968","rangeIndex":2,"version":3,"startOffset":"123","values":[]}`,
+		}
+
+		It("should write an object per line", func() {
+			buf := new(bytes.Buffer)
+			q.marshalStreamResponse(buf, ndjsonStreamFormat, responseItems)
+			Expect(buf.String()).To(Equal(expected[0] + "\n" + expected[1] + "\n"))
+		})
+
+		It("should write an event per item", func() {
+			buf := new(bytes.Buffer)
+			q.marshalStreamResponse(buf, eventStreamFormat, responseItems)
+			Expect(buf.String()).To(Equal("data: " + expected[0] + "\n\n" + "data: " + expected[1] + "\n\n"))
+		})
+	})
+
+	Describe("readNext()", func() {
+		It("should hold the poll until there's data", func() {
+			q := groupReadQueue{items: make(chan readQueueItem)}
+			canWaitValues := make([]bool, 0)
+			go func() {
+				for item := range q.items {
+					canWaitValues = append(canWaitValues, item.canWait)
+					// Respond on the second attempt
+					item.done <- len(canWaitValues) == 2
+				}
+			}()
+
+			options := &pollOptions{wait: 5 * time.Second}
+			q.readNext(context.Background(), "c1", "", []string{"t1"}, jsonFormat, options, httptest.NewRecorder())
+			close(q.items)
+			Expect(canWaitValues).To(Equal([]bool{true, true}))
+		})
+
+		It("should not wait after the wait expired", func() {
+			q := groupReadQueue{items: make(chan readQueueItem)}
+			canWaitValues := make([]bool, 0)
+			go func() {
+				for item := range q.items {
+					canWaitValues = append(canWaitValues, item.canWait)
+					item.done <- !item.canWait
+				}
+			}()
+
+			start := time.Now()
+			options := &pollOptions{wait: 150 * time.Millisecond}
+			q.readNext(context.Background(), "c1", "", []string{"t1"}, jsonFormat, options, httptest.NewRecorder())
+			close(q.items)
+			Expect(time.Since(start)).To(BeNumerically(">=", options.wait))
+			Expect(len(canWaitValues)).To(BeNumerically(">=", 2))
+			Expect(canWaitValues[0]).To(BeTrue())
+			Expect(canWaitValues[len(canWaitValues)-1]).To(BeFalse())
+		})
+
+		It("should not wait when the wait is not set", func() {
+			q := groupReadQueue{items: make(chan readQueueItem)}
+			canWaitValues := make([]bool, 0)
+			go func() {
+				for item := range q.items {
+					canWaitValues = append(canWaitValues, item.canWait)
+					item.done <- true
+				}
+			}()
+
+			q.readNext(context.Background(), "c1", "", []string{"t1"}, jsonFormat, &pollOptions{}, httptest.NewRecorder())
+			close(q.items)
+			Expect(canWaitValues).To(Equal([]bool{false}))
+		})
+	})
+
+	Describe("deadLetter()", func() {
+		topic := TopicDataId{Name: "t1", Token: 100, RangeIndex: 1, Version: 2}
+		encoder, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		Expect(err).NotTo(HaveOccurred())
+		decoder, err := zstd.NewReader(bytes.NewReader(make([]byte, 0)), zstd.WithDecoderConcurrency(1))
+		Expect(err).NotTo(HaveOccurred())
+
+		It("should produce in the background and deliver the record again when it fails", func() {
+			producer := &testRecordProducer{err: fmt.Errorf("Test error")}
+			q := groupReadQueue{items: make(chan readQueueItem), acks: newAckTracker(), producer: producer}
+			now := time.Now()
+			Expect(q.acks.track(topic, newTestKeyedChunk(encoder, 0), decoder, now.Add(time.Minute))).NotTo(HaveOccurred())
+			refs := q.acks.nack(topic, []int64{0}, 1, now)
+			Expect(refs).To(HaveLen(1))
+
+			q.deadLetter("dlq1", refs[0])
+			item := <-q.items
+			Expect(item.deadLetter).NotTo(BeNil())
+			Expect(item.deadLetter.err).To(HaveOccurred())
+			Expect(producer.topic).To(Equal("dlq1"))
+			Expect(producer.partitionKey).To(Equal("k1"))
+
+			q.processDeadLetterResult(item.deadLetter)
+			item.done <- true
+			due := q.acks.due([]string{topic.Name}, time.Now())
+			Expect(due).To(HaveLen(1))
+			Expect(due[0].offset).To(Equal(int64(0)))
+		})
+
+		It("should remove the record once produced", func() {
+			q := groupReadQueue{items: make(chan readQueueItem), acks: newAckTracker(), producer: &testRecordProducer{}}
+			now := time.Now()
+			Expect(q.acks.track(topic, newTestKeyedChunk(encoder, 0), decoder, now)).NotTo(HaveOccurred())
+			refs := q.acks.nack(topic, []int64{0}, 1, now)
+
+			q.deadLetter("dlq1", refs[0])
+			item := <-q.items
+			Expect(item.deadLetter.err).NotTo(HaveOccurred())
+			q.processDeadLetterResult(item.deadLetter)
+			item.done <- true
+			Expect(q.acks.pending[topic]).NotTo(HaveKey(int64(0)))
+			Expect(q.acks.commitLimit(&topic)).To(Equal(int64(2)))
+		})
+	})
+
+	Describe("commitLimit()", func() {
+		topic := TopicDataId{Name: "t1", Token: 100, RangeIndex: 1, Version: 2}
+		reader := &data.SegmentReader{Topic: topic}
+
+		It("should limit the commit to the offset committed by consumers with manually assigned ranges", func() {
+			q := groupReadQueue{acks: newAckTracker(), committed: map[TopicDataId]int64{topic: 20}}
+			Expect(q.commitLimit(reader, true)).To(Equal(int64(20)))
+			Expect(q.commitLimit(reader, false)).To(Equal(int64(OffsetCompleted)))
+		})
+
+		It("should not limit the commit when there's no committed offset for the reader", func() {
+			q := groupReadQueue{acks: newAckTracker(), committed: map[TopicDataId]int64{}}
+			Expect(q.commitLimit(reader, true)).To(Equal(int64(OffsetCompleted)))
+		})
 	})
 })
+
+var _ = Describe("pollOptions", func() {
+	Describe("isFulfilled()", func() {
+		It("should consider the bytes and the records when set", func() {
+			options := pollOptions{maxBytes: 100}
+			Expect(options.isFulfilled(99, 1000)).To(BeFalse())
+			Expect(options.isFulfilled(100, 0)).To(BeTrue())
+
+			options.maxRecords = 10
+			Expect(options.isFulfilled(0, 9)).To(BeFalse())
+			Expect(options.isFulfilled(0, 10)).To(BeTrue())
+		})
+	})
+})
+
+type testRecordProducer struct {
+	topic        string
+	partitionKey string
+	err          error
+}
+
+func (p *testRecordProducer) ProduceRecord(
+	topic string,
+	partitionKey string,
+	contentType string,
+	recordHeaders http.Header,
+	body []byte,
+) (*ProduceResponse, error) {
+	p.topic = topic
+	p.partitionKey = partitionKey
+	return nil, p.err
+}
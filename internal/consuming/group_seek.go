@@ -0,0 +1,171 @@
+package consuming
+
+import (
+	"net/http"
+	"time"
+
+	. "github.com/polarstreams/polar/internal/types"
+	. "github.com/polarstreams/polar/internal/utils"
+	"github.com/rs/zerolog/log"
+)
+
+func (c *consumer) SeekGroup(group string, topic string, target *SeekTarget) error {
+	if err := c.validateSeek(group, topic, target); err != nil {
+		return err
+	}
+
+	// Each broker moves the offsets of the tokens it leads
+	peers := c.topologyGetter.Topology().Peers()
+	err := InParallelAnyError(len(peers), func(i int) error {
+		return c.gossiper.SendConsumerSeek(peers[i].Ordinal, group, topic, target)
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.seekLocal(group, topic, target)
+}
+
+func (c *consumer) OnSeekFromPeer(group string, topic string, target *SeekTarget) error {
+	return c.seekLocal(group, topic, target)
+}
+
+func (c *consumer) validateSeek(group string, topic string, target *SeekTarget) error {
+	if group == "" {
+		return NewHttpError(http.StatusBadRequest, "Consumer group can not be empty")
+	}
+	if !c.topicGetter.Exists(topic) {
+		return NewHttpErrorf(http.StatusNotFound, "Topic '%s' not found", topic)
+	}
+
+	switch target.Position {
+	case SeekEarliest, SeekLatest:
+		return nil
+	case SeekTimestamp:
+		if target.Timestamp == nil {
+			return NewHttpError(http.StatusBadRequest, "Timestamp must be defined when seeking by timestamp")
+		}
+		return nil
+	case SeekOffsets:
+		if len(target.Offsets) == 0 {
+			return NewHttpError(http.StatusBadRequest, "Offsets must be defined when seeking by offsets")
+		}
+		for _, o := range target.Offsets {
+			if o.Offset < 0 || int(o.RangeIndex) >= c.config.ConsumerRanges() {
+				return NewHttpErrorf(http.StatusBadRequest, "Invalid offset %d for range %d/%d", o.Offset, o.Token, o.RangeIndex)
+			}
+			if c.topologyGetter.GenerationInfo(GenId{Start: o.Token, Version: o.Version}) == nil {
+				return NewHttpErrorf(http.StatusBadRequest, "Generation %d v%d not found", o.Token, o.Version)
+			}
+		}
+		return nil
+	default:
+		return NewHttpErrorf(http.StatusBadRequest, "Invalid seek position '%s'", target.Position)
+	}
+}
+
+// Moves the offsets of the group for the tokens led by this broker
+func (c *consumer) seekLocal(group string, topic string, target *SeekTarget) error {
+	offsets, err := c.seekOffsets(topic, target)
+	if err != nil {
+		return err
+	}
+	if len(offsets) == 0 {
+		return nil
+	}
+
+	c.getOrCreateReadQueue(group).seek(topic, offsets)
+	log.Info().Msgf("Moved %d offsets of group %s on topic '%s' to %s", len(offsets), group, topic, target.Position)
+	return nil
+}
+
+// Gets the offsets to move to for the tokens led by this broker
+func (c *consumer) seekOffsets(topic string, target *SeekTarget) ([]Offset, error) {
+	topology := c.topologyGetter.Topology()
+	source := OffsetSource{Timestamp: time.Now().UnixMicro(), Seek: true}
+	result := make([]Offset, 0)
+
+	if target.Position == SeekOffsets {
+		for _, o := range target.Offsets {
+			current := c.topologyGetter.Generation(o.Token)
+			if current == nil || current.Leader != topology.MyOrdinal() {
+				continue
+			}
+			gen := c.topologyGetter.GenerationInfo(GenId{Start: o.Token, Version: o.Version})
+			if gen == nil {
+				return nil, NewHttpErrorf(http.StatusBadRequest, "Generation %d v%d not found", o.Token, o.Version)
+			}
+			topicId := &TopicDataId{Name: topic, Token: gen.Start, RangeIndex: o.RangeIndex, Version: gen.Version}
+			value := NewDefaultOffset(topicId, gen.ClusterSize, o.Offset)
+			source.Id = current.Id()
+			value.Source = source
+			result = append(result, value)
+		}
+		return result, nil
+	}
+
+	for i := range topology.Brokers {
+		gen := c.topologyGetter.Generation(topology.GetToken(BrokerIndex(i)))
+		if gen == nil || gen.Leader != topology.MyOrdinal() {
+			continue
+		}
+
+		source.Id = gen.Id()
+		for index := RangeIndex(0); index < RangeIndex(c.config.ConsumerRanges()); index++ {
+			var values []Offset
+			switch target.Position {
+			case SeekEarliest:
+				values = c.offsetState.GetDefaults(topic, gen.Start, index, gen.ClusterSize, StartFromEarliest)
+			case SeekLatest:
+				values = c.offsetState.GetDefaults(topic, gen.Start, index, gen.ClusterSize, StartFromLatest)
+			case SeekTimestamp:
+				var err error
+				if values, err = c.offsetsByTimestamp(topic, gen, index, target.Timestamp.UnixMicro()); err != nil {
+					return nil, err
+				}
+			}
+
+			for _, value := range values {
+				value.Source = source
+				result = append(result, value)
+			}
+		}
+	}
+	return result, nil
+}
+
+// Walks back through the generations of the range with the same cluster size, looking for the first record with a
+// timestamp greater than or equal to the provided one.
+//
+// The data of the previous generations is only searched when it's available locally.
+func (c *consumer) offsetsByTimestamp(topic string, gen *Generation, index RangeIndex, timestamp int64) ([]Offset, error) {
+	var found *Offset
+	for g := gen; g != nil; {
+		topicId := &TopicDataId{Name: topic, Token: g.Start, RangeIndex: index, Version: g.Version}
+		value, err := c.datalog.OffsetByTimestamp(topicId, timestamp)
+		if err != nil {
+			return nil, err
+		}
+		if value == offsetNoData {
+			// All the records of the generation are older
+			break
+		}
+
+		offset := NewDefaultOffset(topicId, g.ClusterSize, value)
+		found = &offset
+		if len(g.Parents) != 1 {
+			break
+		}
+		parent := c.topologyGetter.GenerationInfo(g.Parents[0])
+		if parent == nil || parent.Start != g.Start || parent.ClusterSize != g.ClusterSize {
+			break
+		}
+		g = parent
+	}
+
+	if found == nil {
+		// All the records are older than the timestamp
+		return c.offsetState.GetDefaults(topic, gen.Start, index, gen.ClusterSize, StartFromLatest), nil
+	}
+	return []Offset{*found}, nil
+}
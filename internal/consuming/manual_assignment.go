@@ -0,0 +1,122 @@
+package consuming
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/polarstreams/polar/internal/types"
+	. "github.com/polarstreams/polar/internal/types"
+	"github.com/polarstreams/polar/internal/utils"
+)
+
+// The assign value to read all the token ranges led by the broker
+const assignOwned = "owned"
+
+// Parses the token ranges to assign from the query string values, in the form of "<token>/<rangeIndex>" or "owned".
+//
+// It returns nil when there are no values, as the ranges are assigned by the group rebalancing.
+func parseAssignment(values []string) (*ManualAssignment, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	result := &ManualAssignment{}
+	for _, value := range values {
+		if value == assignOwned {
+			result.Owned = true
+			continue
+		}
+
+		parts := strings.Split(value, "/")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid assign value '%s', expected <token>/<rangeIndex> or owned", value)
+		}
+		token, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid token in assign value '%s'", value)
+		}
+		index, err := strconv.ParseUint(parts[1], 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid range index in assign value '%s'", value)
+		}
+		result.Ranges = append(result.Ranges, AssignedRange{Token: Token(token), RangeIndex: RangeIndex(index)})
+	}
+	return result, nil
+}
+
+// Validates that the manually assigned token ranges exist in the current topology
+func (c *consumer) validateAssignment(assignment *ManualAssignment) error {
+	if assignment == nil {
+		return nil
+	}
+	if assignment.Owned && len(assignment.Ranges) > 0 {
+		return types.NewHttpError(http.StatusBadRequest, "Owned assignment can not be combined with token ranges")
+	}
+	if !assignment.Owned && len(assignment.Ranges) == 0 {
+		return types.NewHttpError(http.StatusBadRequest, "Assignment must define the token ranges or be owned")
+	}
+
+	for _, r := range assignment.Ranges {
+		if int(r.RangeIndex) >= c.config.ConsumerRanges() {
+			return types.NewHttpErrorf(http.StatusBadRequest, "Invalid range index %d for token %d", r.RangeIndex, r.Token)
+		}
+		if c.topologyGetter.Generation(r.Token) == nil {
+			return types.NewHttpErrorf(http.StatusBadRequest, "Token %d not found", r.Token)
+		}
+	}
+	return nil
+}
+
+// Validates that consumers with manually assigned token ranges don't share the group with consumers that are assigned
+// token ranges by the group rebalancing, as the readers and the offsets of the group are shared by its consumers
+func (c *consumer) validateGroupAssignment(info *ConsumerInfo) error {
+	group := utils.IfEmpty(info.Group, consumerGroupDefault)
+	if info.Assignment != nil && c.state.HasRebalancedMembers(group) {
+		return types.NewHttpErrorf(
+			http.StatusConflict,
+			"Consumer group '%s' has members assigned by the rebalancing, manually assigned consumers must use a "+
+				"different group",
+			group)
+	}
+	if info.Assignment == nil && c.state.HasManualAssignments(group) {
+		return types.NewHttpErrorf(
+			http.StatusConflict, "Consumer group '%s' is used by consumers with manually assigned token ranges", group)
+	}
+	return nil
+}
+
+// Gets the token ranges to read for a consumer with manually assigned token ranges.
+//
+// Owned assignments include all the token ranges, as each broker only serves the ones it leads.
+func manualTokenRanges(topology *TopologyInfo, assignment *ManualAssignment, rangesPerToken int) []TokenRanges {
+	clusterSize := topology.TotalBrokers()
+	indicesByToken := make(map[Token][]RangeIndex)
+
+	if assignment.Owned {
+		for brokerIndex := range topology.Brokers {
+			token := GetTokenAtIndex(clusterSize, brokerIndex)
+			for index := RangeIndex(0); index < RangeIndex(rangesPerToken); index++ {
+				indicesByToken[token] = append(indicesByToken[token], index)
+			}
+		}
+		return mapToTokenRange(indicesByToken, clusterSize)
+	}
+
+	for _, r := range assignment.Ranges {
+		if !containsIndex(indicesByToken[r.Token], r.RangeIndex) {
+			indicesByToken[r.Token] = append(indicesByToken[r.Token], r.RangeIndex)
+		}
+	}
+	return mapToTokenRange(indicesByToken, clusterSize)
+}
+
+func containsIndex(indices []RangeIndex, index RangeIndex) bool {
+	for _, value := range indices {
+		if value == index {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package consuming
+
+import (
+	"net/http"
+	"sort"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/polarstreams/polar/internal/types"
+)
+
+var _ = Describe("parseAssignment()", func() {
+	It("should return nil when there are no values", func() {
+		Expect(parseAssignment(nil)).To(BeNil())
+	})
+
+	It("should parse the token ranges", func() {
+		result, err := parseAssignment([]string{"-9223372036854775808/0", "3074457345618258602/7"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(&ManualAssignment{Ranges: []AssignedRange{
+			{Token: -9223372036854775808, RangeIndex: 0},
+			{Token: 3074457345618258602, RangeIndex: 7},
+		}}))
+	})
+
+	It("should parse the owned value", func() {
+		Expect(parseAssignment([]string{"owned"})).To(Equal(&ManualAssignment{Owned: true}))
+	})
+
+	It("should return an error when the values are not valid", func() {
+		for _, value := range []string{"abc", "1", "1/2/3", "a/1", "1/a", "1/256", "1/-1"} {
+			_, err := parseAssignment([]string{value})
+			Expect(err).To(HaveOccurred(), value)
+		}
+	})
+})
+
+var _ = Describe("manualTokenRanges()", func() {
+	topology := newTestTopology(3, 0)
+
+	It("should include all the token ranges for owned assignments", func() {
+		result := manualTokenRanges(&topology, &ManualAssignment{Owned: true}, 2)
+		sort.Slice(result, func(i, j int) bool {
+			return result[i].Token < result[j].Token
+		})
+		Expect(result).To(HaveLen(3))
+		for i, t := range result {
+			Expect(t).To(Equal(TokenRanges{
+				Token: topology.GetToken(BrokerIndex(i)), Indices: []RangeIndex{0, 1}, ClusterSize: 3}))
+		}
+	})
+
+	It("should group the range indices by token", func() {
+		assignment := &ManualAssignment{Ranges: []AssignedRange{
+			{Token: topology.GetToken(2), RangeIndex: 1},
+			{Token: topology.GetToken(2), RangeIndex: 0},
+			{Token: topology.GetToken(2), RangeIndex: 1},
+		}}
+		Expect(manualTokenRanges(&topology, assignment, 2)).To(Equal([]TokenRanges{
+			{Token: topology.GetToken(2), Indices: []RangeIndex{1, 0}, ClusterSize: 3},
+		}))
+	})
+})
+
+var _ = Describe("consumer", func() {
+	Describe("validateGroupAssignment()", func() {
+		assignment := &ManualAssignment{Owned: true}
+
+		It("should reject manually assigned consumers in groups with rebalanced members", func() {
+			state := NewConsumerState(nil, nil)
+			state.groupsInfo.Store([]ConsumerGroupInfo{{Name: "g1", Members: []ConsumerGroupMember{{Id: "c1"}}}})
+			c := &consumer{state: state}
+
+			err := c.validateGroupAssignment(&ConsumerInfo{Id: "c2", Group: "g1", Assignment: assignment})
+			Expect(err).To(HaveOccurred())
+			Expect(err.(HttpError).StatusCode()).To(Equal(http.StatusConflict))
+			Expect(c.validateGroupAssignment(&ConsumerInfo{Id: "c2", Group: "g2", Assignment: assignment})).To(Succeed())
+			Expect(c.validateGroupAssignment(&ConsumerInfo{Id: "c2", Group: "g1"})).To(Succeed())
+		})
+
+		It("should reject rebalanced consumers in groups with manually assigned consumers", func() {
+			state := NewConsumerState(nil, nil)
+			state.connections["conn1"] = ConsumerInfo{Id: "c1", Group: "g1", Assignment: assignment}
+			c := &consumer{state: state}
+
+			err := c.validateGroupAssignment(&ConsumerInfo{Id: "c2", Group: "g1"})
+			Expect(err).To(HaveOccurred())
+			Expect(err.(HttpError).StatusCode()).To(Equal(http.StatusConflict))
+			Expect(c.validateGroupAssignment(&ConsumerInfo{Id: "c2", Group: "g1", Assignment: assignment})).To(Succeed())
+			Expect(c.validateGroupAssignment(&ConsumerInfo{Id: "c2"})).To(Succeed())
+		})
+	})
+})
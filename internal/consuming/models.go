@@ -2,6 +2,7 @@ package consuming
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -28,6 +29,12 @@ type ConsumerInfo struct {
 	Group      string            `json:"group"` // A group unique id
 	Topics     []string          `json:"topics"`
 	OnNewGroup OffsetResetPolicy `json:"onNewGroup"`
+	Filter     string            `json:"filter,omitempty"`     // The expression the records must match to be delivered
+	Assignment *ManualAssignment `json:"assignment,omitempty"` // The token ranges to read, without joining the group rebalancing
+	AckSettings
+
+	// The latest record format supported by the client when reading binary responses, legacy by default
+	RecordFormat byte `json:"recordFormat,omitempty"`
 
 	// Only used internally
 	assignedTokens []TokenRanges
@@ -46,21 +53,42 @@ const (
 
 	// A JSON formatted response
 	jsonFormat
+
+	// A stream of newline delimited JSON objects
+	ndjsonStreamFormat
+
+	// A stream of server-sent events containing JSON objects
+	eventStreamFormat
 )
 
+// Represents the limits of a consumer poll
+type pollOptions struct {
+	maxRecords int           // The amount of records once reached the poll is fulfilled, zero when not limited
+	maxBytes   int           // The amount of bytes once reached the poll is fulfilled
+	wait       time.Duration // The maximum time to hold the poll open waiting for data
+}
+
+// Determines whether the response reached the limits.
+// The last chunk is split to fit the max records but not the max bytes, which can be exceeded by the last chunk.
+func (o *pollOptions) isFulfilled(totalBytes int, totalRecords int) bool {
+	return totalBytes >= o.maxBytes || (o.maxRecords > 0 && totalRecords >= o.maxRecords)
+}
+
 type segmentReadItem struct {
 	chunkResult chan SegmentChunk
 	errorResult chan error
 	origin      string
 	commitOnly  bool
+	commitLimit int64
 }
 
-func newSegmentReadItem(origin string, commitOnly bool) *segmentReadItem {
+func newSegmentReadItem(origin string, commitOnly bool, commitLimit int64) *segmentReadItem {
 	return &segmentReadItem{
 		chunkResult: make(chan SegmentChunk, 1),
 		errorResult: make(chan error, 1),
 		origin:      origin,
 		commitOnly:  commitOnly,
+		commitLimit: commitLimit,
 	}
 }
 
@@ -77,6 +105,10 @@ func (r *segmentReadItem) CommitOnly() bool {
 	return r.commitOnly
 }
 
+func (r *segmentReadItem) CommitLimit() int64 {
+	return r.commitLimit
+}
+
 func (r *segmentReadItem) result() (err error, chunk SegmentChunk) {
 	return <-r.errorResult, <-r.chunkResult
 }
@@ -148,42 +180,85 @@ func (i *consumerResponseItem) MarshalJson(
 
 		// Use strings for int64 values
 		writer.KeyString("startOffset", strconv.FormatInt(i.chunk.StartOffset(), 10))
+		var headers [][]data.RecordHeaderEntry
 		writer.Array("values", func() {
-			_ = writeJsonRecords(writer, decoder, decoderBuffer)
+			headers, _ = writeJsonRecords(writer, decoder, decoderBuffer)
 		})
+
+		if headers != nil {
+			// The headers of each value, in the same order
+			writer.Array("headers", func() {
+				for _, entries := range headers {
+					writer.ArrayObject(func() {
+						for _, e := range entries {
+							writer.KeyString(e.Name, e.Value)
+						}
+					})
+				}
+			})
+		}
 	})
 
 	return nil
 }
 
-// Writes records as JSON array items
+// Writes records as JSON array items.
+// Returns the headers of each record written when the payload uses the record format with headers.
 func writeJsonRecords(
 	writer *jsonwriter.Writer,
 	reader *zstd.Decoder,
 	readBuffer []byte,
-) error {
-	var header recordHeader
+) ([][]data.RecordHeaderEntry, error) {
+	var headers [][]data.RecordHeaderEntry
+	recordReader := data.NewRecordReader(reader)
 	for {
-		if err := binary.Read(reader, conf.Endianness, &header); err != nil {
+		header, err := recordReader.Next()
+		if err != nil {
 			if err == io.EOF {
-				return nil
+				return headers, nil
 			}
-			return err
+			return headers, err
 		}
+
 		writer.Separator()
 
+		if recordReader.Format() == data.RecordFormatHeaders {
+			headers = append(headers, header.Headers)
+		}
+
+		if header.IsTombstone() {
+			// Represent the deletion with a null value to maintain the position of the following values
+			_, _ = writer.W.Write([]byte("null"))
+			continue
+		}
+
+		var bodyWriter io.Writer = writer.W
+		var encoder io.WriteCloser
+		if contentType := header.HeaderValue(ContentTypeHeaderKey); contentType != "" {
+			// The body is not JSON, represent it as a base64 encoded string
+			_, _ = writer.W.Write([]byte{'"'})
+			encoder = base64.NewEncoder(base64.StdEncoding, writer.W)
+			bodyWriter = encoder
+		}
+
 		// TODO: Handle error
-		_ = writeRecordBody(int(header.Length), writer, reader, readBuffer)
+		_ = writeRecordBody(int(header.Length), bodyWriter, reader, readBuffer)
+
+		if encoder != nil {
+			_ = encoder.Close()
+			_, _ = writer.W.Write([]byte{'"'})
+		}
 	}
 }
 
-func writeRecordBody(bodyLength int, writer *jsonwriter.Writer, reader *zstd.Decoder, readBuffer []byte) error {
+func writeRecordBody(bodyLength int, writer io.Writer, reader *zstd.Decoder, readBuffer []byte) error {
 	read := 0
-	buf := readBuffer[0:utils.Min(bodyLength, len(readBuffer))]
 	for read < bodyLength {
+		// Don't read past the record body
+		buf := readBuffer[0:utils.Min(bodyLength-read, len(readBuffer))]
 		n, err := reader.Read(buf)
 		if n > 0 {
-			if err = utils.WriteBytes(writer.W, buf[0:n]); err != nil {
+			if err = utils.WriteBytes(writer, buf[0:n]); err != nil {
 				return err
 			}
 		}
@@ -213,6 +288,7 @@ func newReaderKey(token Token, index RangeIndex, clusterSize int) readerKey {
 	}
 }
 
+// The header of a record in the legacy format
 type recordHeader struct {
 	Timestamp int64
 	Length    uint32
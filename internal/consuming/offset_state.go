@@ -136,6 +136,66 @@ func (s *defaultOffsetState) GetAllWithDefaults(
 	return result
 }
 
+func (s *defaultOffsetState) GetDefaults(
+	topic string,
+	token Token,
+	rangeIndex RangeIndex,
+	clusterSize int,
+	policy OffsetResetPolicy,
+) []Offset {
+	start, end := RangeByTokenAndClusterSize(token, rangeIndex, s.config.ConsumerRanges(), clusterSize)
+	return s.defaultsForRange(topic, start, end, clusterSize, nil, policy)
+}
+
+func (s *defaultOffsetState) Keys() []OffsetStoreKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]OffsetStoreKey, 0, len(s.offsetMap))
+	for key := range s.offsetMap {
+		result = append(result, key)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Group != result[j].Group {
+			return result[i].Group < result[j].Group
+		}
+		return result[i].Topic < result[j].Topic
+	})
+	return result
+}
+
+func (s *defaultOffsetState) RemoveGroup(group string) error {
+	s.mu.Lock()
+	for key := range s.offsetMap {
+		if key.Group == group {
+			delete(s.offsetMap, key)
+		}
+	}
+	s.mu.Unlock()
+
+	return s.localDb.DeleteOffsets(group)
+}
+
+func (s *defaultOffsetState) Values(group string, topic string) []Offset {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// A value can be stored in multiple ranges when it was split after a change in the topology
+	seen := make(map[Offset]bool)
+	result := make([]Offset, 0)
+	for _, item := range s.offsetMap[OffsetStoreKey{Group: group, Topic: topic}] {
+		if !seen[item.value] {
+			seen[item.value] = true
+			result = append(result, item.value)
+		}
+	}
+
+	// Applying the values in the order they were recorded results in the same ranges
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Source.Timestamp < result[j].Source.Timestamp
+	})
+	return result
+}
+
 // Gets a default offset for ranges that are not present, depending on the policy.
 //
 // Starting on earliest:
@@ -782,6 +842,16 @@ func (s *defaultOffsetState) processCommit() {
 }
 
 func (s *defaultOffsetState) isOldValue(existing *Offset, newValue *Offset) bool {
+	if newValue.Source.Seek {
+		// The offset was explicitly moved, it replaces the previous values
+		return existing.Source.Timestamp > newValue.Source.Timestamp
+	}
+
+	if existing.Source.Seek && existing.Source.Timestamp > newValue.Source.Timestamp {
+		// The new value was recorded before the offset was explicitly moved
+		return true
+	}
+
 	if existing.Source.Id.Start == newValue.Source.Id.Start {
 		// Same tokens (most common case)
 		if existing.Source.Id.Version < newValue.Source.Id.Version {
@@ -435,6 +435,34 @@ var _ = Describe("defaultOffsetState", func() {
 			Expect(result).To(BeTrue(), "should return true when changing the actual value")
 		})
 
+		It("should replace newer offsets with a seek value and ignore previous commits", func() {
+			s := newTestOffsetState(offsetMap, consumerRanges)
+			now := time.Now()
+			current := valueC3_T0_1
+			current.Offset = 500
+			current.Source.Timestamp = now.UnixMicro()
+			Expect(s.Set(group, topic, current, OffsetCommitNone)).To(BeTrue())
+
+			seekValue := valueC3_T0_1
+			seekValue.Offset = 10
+			seekValue.Source = OffsetSource{Timestamp: now.Add(time.Second).UnixMicro(), Seek: true}
+			Expect(s.Set(group, topic, seekValue, OffsetCommitNone)).To(BeTrue(), "should move the offset backwards")
+			Expect(s.offsetMap[key][2].value).To(Equal(seekValue))
+
+			// A commit from a reader prior to the seek
+			previous := valueC3_T0_1
+			previous.Offset = 200
+			previous.Source.Timestamp = now.Add(500 * time.Millisecond).UnixMicro()
+			Expect(s.Set(group, topic, previous, OffsetCommitNone)).To(BeFalse())
+			Expect(s.offsetMap[key][2].value).To(Equal(seekValue))
+
+			// A commit after the seek
+			next := valueC3_T0_1
+			next.Offset = 20
+			next.Source.Timestamp = now.Add(2 * time.Second).UnixMicro()
+			Expect(s.Set(group, topic, next, OffsetCommitNone)).To(BeTrue())
+		})
+
 		It("should insert a range at the beginning", func() {
 			// Empty initial map
 			s := newTestOffsetState(nil, consumerRanges)
@@ -820,6 +848,39 @@ var _ = Describe("defaultOffsetState", func() {
 		})
 	})
 
+	Describe("RemoveGroup()", func() {
+		It("should remove the offsets of the group from memory and localdb", func() {
+			otherKey := OffsetStoreKey{Group: "g2", Topic: topic}
+			localDb := new(dbMocks.Client)
+			localDb.On("DeleteOffsets", group).Return(nil)
+			s := newTestOffsetState(map[OffsetStoreKey][]offsetRange{
+				key:                         {{value: valueC3_T0_1}},
+				{Group: group, Topic: "t2"}: {{value: valueC3_T0_1}},
+				otherKey:                    {{value: valueC3_T0_1}},
+			}, 4)
+			s.localDb = localDb
+
+			Expect(s.RemoveGroup(group)).NotTo(HaveOccurred())
+			Expect(s.Keys()).To(Equal([]OffsetStoreKey{otherKey}))
+			localDb.AssertCalled(GinkgoT(), "DeleteOffsets", group)
+		})
+	})
+
+	Describe("Values()", func() {
+		It("should return the distinct values in the order they were recorded", func() {
+			older := valueC3_T0_1
+			older.Source.Timestamp = 100
+			newer := valueC3_T2_3
+			newer.Source.Timestamp = 200
+			s := newTestOffsetState(map[OffsetStoreKey][]offsetRange{
+				key: {{value: newer}, {value: older}, {value: newer}},
+			}, 4)
+
+			Expect(s.Values(group, topic)).To(Equal([]Offset{older, newer}))
+			Expect(s.Values(group, "t2")).To(BeEmpty())
+		})
+	})
+
 	Describe("MaxProducedOffset()", func() {
 		It("should get the max produced offset from local", func() {
 			gen := Generation{Followers: []int{2, 0}}
@@ -0,0 +1,204 @@
+package consuming
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/polarstreams/polar/internal/data"
+)
+
+const (
+	filterConditionSeparator = "&&"
+	filterBodyPrefix         = "$."
+	filterHeaderPrefix       = "header."
+	filterKey                = "key"
+)
+
+// The filter operators, the ones that are a prefix of others must come last
+var filterOperators = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+// Represents the predicate that the records must match to be delivered to a consumer.
+//
+// The expression is composed by conditions joined by "&&", each condition compares a field of the JSON body
+// (e.g. `$.sensor.type`), a record header (e.g. `header.Region`) or the record key (`key`) with a JSON value
+// using one of the operators ==, !=, <, <=, > or >=.
+type recordFilter struct {
+	conditions []filterCondition
+	hasBody    bool // Determines whether any of the conditions requires the body to be parsed
+}
+
+type filterCondition struct {
+	path     []string // The path of the field in the JSON body, nil when comparing the key or a header
+	header   string
+	isKey    bool
+	operator string
+	value    interface{}
+}
+
+// Parses the filter expression, returning nil when the expression is empty
+func parseRecordFilter(expression string) (*recordFilter, error) {
+	if strings.TrimSpace(expression) == "" {
+		return nil, nil
+	}
+
+	filter := &recordFilter{}
+	for _, text := range strings.Split(expression, filterConditionSeparator) {
+		condition, err := parseFilterCondition(strings.TrimSpace(text))
+		if err != nil {
+			return nil, err
+		}
+		filter.conditions = append(filter.conditions, *condition)
+		filter.hasBody = filter.hasBody || condition.path != nil
+	}
+	return filter, nil
+}
+
+func parseFilterCondition(text string) (*filterCondition, error) {
+	index := -1
+	operator := ""
+	for _, op := range filterOperators {
+		if i := strings.Index(text, op); i > 0 && (index == -1 || i < index) {
+			index = i
+			operator = op
+		}
+	}
+	if index == -1 {
+		return nil, fmt.Errorf("Invalid filter condition '%s', expected an operator", text)
+	}
+
+	condition := &filterCondition{operator: operator}
+	field := strings.TrimSpace(text[:index])
+	switch {
+	case field == filterKey:
+		condition.isKey = true
+	case strings.HasPrefix(field, filterHeaderPrefix) && len(field) > len(filterHeaderPrefix):
+		condition.header = field[len(filterHeaderPrefix):]
+	case strings.HasPrefix(field, filterBodyPrefix) && len(field) > len(filterBodyPrefix):
+		condition.path = strings.Split(field[len(filterBodyPrefix):], ".")
+	default:
+		return nil, fmt.Errorf("Invalid filter field '%s', expected key, header.<name> or $.<path>", field)
+	}
+
+	value := strings.TrimSpace(text[index+len(operator):])
+	if err := json.Unmarshal([]byte(value), &condition.value); err != nil {
+		return nil, fmt.Errorf("Invalid filter value '%s', expected a JSON string, number, boolean or null", value)
+	}
+	switch condition.value.(type) {
+	case string, float64, bool, nil:
+	default:
+		return nil, fmt.Errorf("Invalid filter value '%s', expected a JSON string, number, boolean or null", value)
+	}
+	return condition, nil
+}
+
+// Determines whether the record matches all the conditions of the filter
+func (f *recordFilter) matches(header *data.RecordHeader, body []byte) bool {
+	var doc interface{}
+	if f.hasBody && len(body) > 0 {
+		if err := json.Unmarshal(body, &doc); err != nil {
+			// Conditions on the fields of a non-JSON body are evaluated as if the field was not found
+			doc = nil
+		}
+	}
+
+	for i := range f.conditions {
+		c := &f.conditions[i]
+		value, found := c.fieldValue(header, doc)
+		if !c.compare(value, found) {
+			return false
+		}
+	}
+	return true
+}
+
+// Gets the value of the field of the record, for headers and keys the value is a string
+func (c *filterCondition) fieldValue(header *data.RecordHeader, doc interface{}) (interface{}, bool) {
+	if c.isKey {
+		return string(header.Key), len(header.Key) > 0
+	}
+	if c.path == nil {
+		for _, e := range header.Headers {
+			if strings.EqualFold(e.Name, c.header) {
+				return e.Value, true
+			}
+		}
+		return nil, false
+	}
+
+	value := doc
+	for _, name := range c.path {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = m[name]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func (c *filterCondition) compare(value interface{}, found bool) bool {
+	if !found {
+		return c.operator == "!="
+	}
+
+	if s, ok := value.(string); ok && c.path == nil {
+		// Header values and keys are compared as numbers when the filter value is a number
+		if _, isNumber := c.value.(float64); isNumber {
+			n, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return c.operator == "!="
+			}
+			value = n
+		}
+	}
+
+	switch expected := c.value.(type) {
+	case float64:
+		if actual, ok := value.(float64); ok {
+			result := 0
+			if actual < expected {
+				result = -1
+			} else if actual > expected {
+				result = 1
+			}
+			return matchesOperator(result, c.operator)
+		}
+	case string:
+		if actual, ok := value.(string); ok {
+			return matchesOperator(strings.Compare(actual, expected), c.operator)
+		}
+	default:
+		// Booleans and null only support equality
+		switch c.operator {
+		case "==":
+			return value == expected
+		case "!=":
+			return value != expected
+		}
+		return false
+	}
+	return c.operator == "!="
+}
+
+// Determines whether the result of the comparison between the actual and the expected value satisfies the operator
+func matchesOperator(comparison int, operator string) bool {
+	switch operator {
+	case "==":
+		return comparison == 0
+	case "!=":
+		return comparison != 0
+	case "<":
+		return comparison < 0
+	case "<=":
+		return comparison <= 0
+	case ">":
+		return comparison > 0
+	case ">=":
+		return comparison >= 0
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package consuming
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/polarstreams/polar/internal/data"
+)
+
+var _ = Describe("parseRecordFilter()", func() {
+	It("should return nil when the expression is empty", func() {
+		Expect(parseRecordFilter("  ")).To(BeNil())
+	})
+
+	It("should return an error when the expression is not valid", func() {
+		expressions := []string{
+			"$.a",
+			"a == 1",
+			"$. == 1",
+			"header. == 1",
+			`$.a == abc`,
+			`$.a == {"b":1}`,
+			`$.a == 1 && `,
+		}
+		for _, expression := range expressions {
+			_, err := parseRecordFilter(expression)
+			Expect(err).To(HaveOccurred(), expression)
+		}
+	})
+})
+
+var _ = Describe("recordFilter", func() {
+	Describe("matches()", func() {
+		header := &data.RecordHeader{
+			Key:     []byte("k1"),
+			Headers: []data.RecordHeaderEntry{{Name: "Region", Value: "eu"}, {Name: "Priority", Value: "3"}},
+		}
+		body := []byte(`{"type": "alert", "value": 12.5, "sensor": {"id": "s1", "active": true}, "tag": null}`)
+
+		It("should evaluate the conditions on the body, headers and key", func() {
+			expressions := map[string]bool{
+				`$.type == "alert"`:                   true,
+				`$.type != "alert"`:                   false,
+				`$.value > 10`:                        true,
+				`$.value <= 12`:                       false,
+				`$.sensor.id == "s1"`:                 true,
+				`$.sensor.active == true`:             true,
+				`$.tag == null`:                       true,
+				`$.missing == 1`:                      false,
+				`$.missing != 1`:                      true,
+				`$.type == 1`:                         false,
+				`header.region == "eu"`:               true,
+				`header.Priority >= 3`:                true,
+				`header.Missing == "a"`:               false,
+				`key == "k1"`:                         true,
+				`$.type == "alert" && key == "k2"`:    false,
+				`$.value < 20 && header.Region=="eu"`: true,
+			}
+			for expression, expected := range expressions {
+				filter, err := parseRecordFilter(expression)
+				Expect(err).NotTo(HaveOccurred(), expression)
+				Expect(filter.matches(header, body)).To(Equal(expected), expression)
+			}
+		})
+
+		It("should consider the fields of non-JSON bodies as not found", func() {
+			filter, err := parseRecordFilter(`$.type == "alert"`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(filter.matches(header, []byte("plain text"))).To(BeFalse())
+		})
+	})
+})
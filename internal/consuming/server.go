@@ -1,21 +1,28 @@
 package consuming
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"reflect"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/polarstreams/polar/internal/auth"
 	"github.com/polarstreams/polar/internal/conf"
 	"github.com/polarstreams/polar/internal/data"
+	"github.com/polarstreams/polar/internal/data/acls"
+	"github.com/polarstreams/polar/internal/data/topics"
 	"github.com/polarstreams/polar/internal/discovery"
 	"github.com/polarstreams/polar/internal/interbroker"
 	"github.com/polarstreams/polar/internal/localdb"
 	"github.com/polarstreams/polar/internal/metrics"
+	"github.com/polarstreams/polar/internal/quotas"
 	"github.com/polarstreams/polar/internal/types"
 	. "github.com/polarstreams/polar/internal/types"
 	. "github.com/polarstreams/polar/internal/utils"
@@ -30,12 +37,14 @@ const (
 	consumerNoOwnedDataDelay    = 5 // Seconds to retry after
 	consumerNoDataDelay         = 1 // Seconds to retry after
 	halfOpenTimerResolution     = 5 * time.Second
+	streamHeartbeatInterval     = 15 * time.Second
 	consumerNotRegisteredStatus = http.StatusConflict
 )
 
 const (
-	defaultMimeType = "application/vnd.polar.consumermessage"
-	jsonMimeType    = "application/json"
+	defaultMimeType     = "application/vnd.polar.consumermessage"
+	jsonMimeType        = "application/json"
+	eventStreamMimeType = "text/event-stream"
 )
 
 const (
@@ -46,6 +55,15 @@ const (
 	groupQueryKey          = "group"
 	commitQueryKey         = "commit"
 	offsetResetKey         = "onNewGroup"
+	maxRecordsQueryKey     = "maxRecords"
+	maxBytesQueryKey       = "maxBytes"
+	waitQueryKey           = "waitMs"
+	commitIntervalQueryKey = "commitIntervalMs"
+	ackModeQueryKey        = "ackMode"
+	deadLetterQueryKey     = "deadLetterTopic"
+	filterQueryKey         = "filter"
+	assignQueryKey         = "assign"
+	recordFormatQueryKey   = "recordFormat"
 )
 
 const consumerGroupDefault = "default"
@@ -57,16 +75,44 @@ var logLegacyConsumerIdOnce sync.Once
 type Consumer interface {
 	Initializer
 	Closer
+	GroupAdmin
 
 	AcceptConnections() error
 }
 
+// GroupAdmin provides the operations to manage the consumer groups
+type GroupAdmin interface {
+	// Moves the position of the consumer group on the topic on all the brokers
+	SeekGroup(group string, topic string, target *SeekTarget) error
+
+	// Gets the lag of the consumer group per topic and token range from all the brokers
+	GroupLag(group string) ([]ConsumerLag, error)
+
+	// Gets the consumer groups known cluster-wide, with active members or stored offsets
+	ListGroups() ([]ConsumerGroupInfo, error)
+
+	// Gets the members of the consumer group with the topics and the token ranges assigned to each one
+	DescribeGroup(group string) (*ConsumerGroupInfo, error)
+
+	// Removes the stored offsets of a consumer group without active members on all the brokers
+	DeleteGroup(group string) error
+
+	// Copies the stored offsets of a consumer group into a new group on all the brokers, for a topic or all the
+	// topics when the topic is empty
+	CloneGroup(group string, target string, topic string) error
+}
+
 func NewConsumer(
 	config conf.ConsumerConfig,
 	localDb localdb.Client,
+	topicGetter topics.TopicGetter,
 	topologyGetter discovery.TopologyGetter,
 	datalog data.Datalog,
 	gossiper interbroker.Gossiper,
+	producer RecordProducer,
+	authenticator auth.Authenticator,
+	authorizer acls.Authorizer,
+	limiter quotas.Limiter,
 ) Consumer {
 	addDelay := config.ConsumerAddDelay()
 	if config.DevMode() {
@@ -75,6 +121,7 @@ func NewConsumer(
 
 	return &consumer{
 		config:         config,
+		topicGetter:    topicGetter,
 		topologyGetter: topologyGetter,
 		datalog:        datalog,
 		gossiper:       gossiper,
@@ -84,11 +131,16 @@ func NewConsumer(
 		offsetState:    newDefaultOffsetState(localDb, topologyGetter, datalog, gossiper, config),
 		readQueues:     NewCopyOnWriteMap(),
 		addDebouncer:   Debounce(addDelay, 0),
+		producer:       producer,
+		authenticator:  authenticator,
+		authorizer:     authorizer,
+		limiter:        limiter,
 	}
 }
 
 type consumer struct {
 	config         conf.ConsumerConfig
+	topicGetter    topics.TopicGetter
 	topologyGetter discovery.TopologyGetter
 	datalog        data.Datalog
 	gossiper       interbroker.Gossiper
@@ -99,6 +151,10 @@ type consumer struct {
 	readQueues     *CopyOnWriteMap
 	addDebouncer   Debouncer
 	listener       net.Listener
+	producer       RecordProducer
+	authenticator  auth.Authenticator
+	authorizer     acls.Authorizer
+	limiter        quotas.Limiter
 }
 
 func (c *consumer) Init() error {
@@ -109,6 +165,7 @@ func (c *consumer) Init() error {
 
 	// Send info in the background
 	go c.sendConsumerGroupsToPeers()
+	go c.updateLagMetrics()
 	return nil
 }
 
@@ -117,7 +174,13 @@ func (c *consumer) AcceptConnections() error {
 	port := c.config.ConsumerPort()
 	address := GetServiceAddress(port, c.topologyGetter.LocalInfo(), c.config)
 
-	listener, err := net.Listen("tcp", address)
+	// The connections are wrapped to be tracked, so the server can not identify TLS connections: HTTP/2 negotiated
+	// using ALPN is served by the h2c handler as prior knowledge
+	tlsConfig, err := NewServerTlsConfig(c.config, "h2", "http/1.1")
+	if err != nil {
+		return err
+	}
+	listener, err := Listen(address, tlsConfig)
 	if err != nil {
 		return err
 	}
@@ -153,14 +216,19 @@ func (c *consumer) AcceptConnections() error {
 			router.PUT(conf.ConsumerRegisterUrl, toTrackedHandler(tc, c.putRegister))
 			router.POST(conf.ConsumerRegisterUrl, toTrackedHandler(tc, c.putRegister)) // Backwards compatibility
 			router.POST(conf.ConsumerPollUrl, toTrackedHandler(tc, c.postPoll))
+			router.GET(conf.ConsumerStreamUrl, toTrackedHandler(tc, c.getStream))
+			router.POST(conf.ConsumerStreamUrl, toTrackedHandler(tc, c.getStream))
 			router.POST(conf.ConsumerManualCommitUrl, toTrackedHandler(tc, c.postManualCommit))
+			router.POST(conf.ConsumerAckUrl, toTrackedHandler(tc, c.postAck))
+			router.POST(conf.ConsumerNackUrl, toTrackedHandler(tc, c.postNack))
+			router.POST(conf.ConsumerOffsetsUrl, toTrackedHandler(tc, c.postOffsetCommit))
 			router.POST(conf.ConsumerGoodbye, toTrackedHandler(tc, c.postGoodbye))
 
 			// server.Serve() will block until the connection is not readable anymore
 			go func() {
 				server := &http.Server{
 					Addr:    address,
-					Handler: h2c.NewHandler(router, h2s),
+					Handler: h2c.NewHandler(auth.Handler(c.authenticator, auth.ConsumerServer, router), h2s),
 				}
 
 				metrics.ConsumerOpenConnections.Inc()
@@ -237,9 +305,51 @@ func (c *consumer) putRegister(
 			}
 		}
 
+		if ackModeValue := r.URL.Query().Get(ackModeQueryKey); ackModeValue != "" {
+			ackMode, err := strconv.ParseBool(ackModeValue)
+			if err != nil {
+				return types.NewHttpError(http.StatusBadRequest, "Invalid ack mode value")
+			}
+			info.AckMode = ackMode
+		}
+		info.DeadLetterTopic = r.URL.Query().Get(deadLetterQueryKey)
+		info.Filter = r.URL.Query().Get(filterQueryKey)
+		assignment, err := parseAssignment(r.URL.Query()[assignQueryKey])
+		if err != nil {
+			return types.NewHttpError(http.StatusBadRequest, err.Error())
+		}
+		info.Assignment = assignment
+		if value := r.URL.Query().Get(recordFormatQueryKey); value != "" {
+			recordFormat, err := strconv.ParseUint(value, 10, 8)
+			if err != nil {
+				return types.NewHttpError(http.StatusBadRequest, "Invalid record format value")
+			}
+			info.RecordFormat = byte(recordFormat)
+		}
+
+		if err := c.validateTopics(info.Topics); err != nil {
+			return err
+		}
+		if err := c.validateAckSettings(info.AckSettings); err != nil {
+			return err
+		}
+		if err := validateFilter(info.Filter); err != nil {
+			return err
+		}
+		if err := validateRecordFormat(info.RecordFormat); err != nil {
+			return err
+		}
+		if err := c.validateAssignment(info.Assignment); err != nil {
+			return err
+		}
+
 		if existingTc, existingInfo := c.state.TrackedConsumerById(statelessConsumerId); existingTc != nil {
 			if IfEmpty(info.Group, consumerGroupDefault) != existingInfo.Group ||
-				!reflect.DeepEqual(info.Topics, existingInfo.Topics) {
+				!reflect.DeepEqual(info.Topics, existingInfo.Topics) ||
+				info.Filter != existingInfo.Filter ||
+				!reflect.DeepEqual(info.Assignment, existingInfo.Assignment) ||
+				info.AckSettings != existingInfo.AckSettings ||
+				info.RecordFormat != existingInfo.RecordFormat {
 				return types.NewHttpError(
 					http.StatusBadRequest, "Consumer already registered with different parameters")
 			}
@@ -255,9 +365,31 @@ func (c *consumer) putRegister(
 		if err := json.NewDecoder(r.Body).Decode(&info); err != nil {
 			return types.NewHttpError(http.StatusBadRequest, "Invalid ConsumerInfo payload")
 		}
+		if err := c.validateTopics(info.Topics); err != nil {
+			return err
+		}
+		if err := c.validateAckSettings(info.AckSettings); err != nil {
+			return err
+		}
+		if err := validateFilter(info.Filter); err != nil {
+			return err
+		}
+		if err := validateRecordFormat(info.RecordFormat); err != nil {
+			return err
+		}
+		if err := c.validateAssignment(info.Assignment); err != nil {
+			return err
+		}
 		tc.TrackAsConnectionBound()
 	}
 
+	if err := c.validateGroupAssignment(&info); err != nil {
+		return err
+	}
+	if err := c.authorize(auth.Principal(r), &info); err != nil {
+		return err
+	}
+
 	// Default to "no rebalance delay" for stateless-less consumers
 	if err := c.addConnectionAndRebalance(tc, info, !statelessConsumer); err != nil {
 		return err
@@ -266,6 +398,10 @@ func (c *consumer) putRegister(
 	log.Info().
 		Strs("topics", info.Topics).
 		Bool("startFromLatest", info.OnNewGroup == StartFromLatest).
+		Bool("ackMode", info.AckMode).
+		Str("filter", info.Filter).
+		Bool("manualAssignment", info.Assignment != nil).
+		Str("principal", auth.Principal(r)).
 		Msgf("Registered new consumer with id %s and group %s", info.Id, info.Group)
 
 	if statelessConsumer {
@@ -275,7 +411,8 @@ func (c *consumer) putRegister(
 			// Ignore dev mode
 			err := AnyError(CollectErrors(InParallel(len(peers), func(i int) error {
 				return c.gossiper.SendConsumerRegister(
-					peers[i].Ordinal, info.Id, info.Group, info.Topics, info.OnNewGroup)
+					peers[i].Ordinal, info.Id, info.Group, info.Topics, info.OnNewGroup, info.Filter, info.Assignment,
+					info.AckSettings, info.RecordFormat)
 			})))
 
 			if err != nil {
@@ -290,6 +427,70 @@ func (c *consumer) putRegister(
 	return nil
 }
 
+// Validates that the topics exist, creating them when auto create is enabled
+func (c *consumer) validateTopics(topics []string) error {
+	for _, topic := range topics {
+		info, err := c.topicGetter.GetOrCreate(topic)
+		if err != nil {
+			return err
+		}
+		if info == nil {
+			return types.NewHttpErrorf(http.StatusNotFound, "Topic '%s' not found", topic)
+		}
+	}
+	return nil
+}
+
+// Verifies that the principal is allowed to consume the topics with the group of the consumer and, when set, to produce
+// to the dead-letter topic
+func (c *consumer) authorize(principal string, info *ConsumerInfo) error {
+	group := IfEmpty(info.Group, consumerGroupDefault)
+	for _, topic := range info.Topics {
+		if err := c.authorizer.Authorize(principal, AclOperationConsume, topic, group); err != nil {
+			return err
+		}
+	}
+	if info.DeadLetterTopic != "" {
+		return c.authorizer.Authorize(principal, AclOperationProduce, info.DeadLetterTopic, "")
+	}
+	return nil
+}
+
+// Verifies that the principal is allowed to read on behalf of the registered consumer, as the rules could have
+// changed since the consumer was registered
+func (c *consumer) authorizeRead(principal string, id string) error {
+	if _, info := c.state.TrackedConsumerById(id); info != nil {
+		return c.authorize(principal, info)
+	}
+	return nil
+}
+
+// Validates that the dead-letter topic is only set in ack mode and that it exists
+func (c *consumer) validateAckSettings(settings AckSettings) error {
+	if settings.DeadLetterTopic == "" {
+		return nil
+	}
+	if !settings.AckMode {
+		return types.NewHttpError(http.StatusBadRequest, "Dead-letter topic can only be set in ack mode")
+	}
+	return c.validateTopics([]string{settings.DeadLetterTopic})
+}
+
+// Validates that the filter expression can be parsed
+func validateFilter(expression string) error {
+	if _, err := parseRecordFilter(expression); err != nil {
+		return types.NewHttpError(http.StatusBadRequest, err.Error())
+	}
+	return nil
+}
+
+func validateRecordFormat(format byte) error {
+	if format > data.RecordFormatHeaders {
+		return types.NewHttpErrorf(http.StatusBadRequest, "Unsupported record format %d", format)
+	}
+	return nil
+}
+
 func (c *consumer) addConnectionAndRebalance(
 	tc *trackedConsumerHandler,
 	consumerInfo ConsumerInfo,
@@ -397,13 +598,26 @@ func (c *consumer) postPoll(
 	}
 	tc.SetAsRead()
 	id := tc.Id()
-	group, tokens, _ := logsToServe(c.state, c.topologyGetter, id)
+	if err := c.authorizeRead(auth.Principal(r), id); err != nil {
+		return err
+	}
+	group, tokens, topicNames := logsToServe(c.state, c.topologyGetter, id)
 	if len(tokens) == 0 {
 		log.Debug().Msgf("Received consumer client poll from connection '%s' with no assigned tokens", id)
 		NoContentResponse(w, consumerNoOwnedDataDelay)
 		return nil
 	}
 
+	options, err := c.parsePollOptions(r.URL.Query())
+	if err != nil {
+		return err
+	}
+
+	client := quotas.Client(auth.Principal(r), r.RemoteAddr)
+	if err := c.limiter.Charge(quotas.OperationConsume, client, 0, topicNames...); err != nil {
+		return err
+	}
+
 	log.Debug().
 		Interface("query", r.URL.Query()).
 		Msgf("Received consumer client poll from '%s'", id)
@@ -414,10 +628,176 @@ func (c *consumer) postPoll(
 		format = jsonFormat
 	}
 
-	groupReadQueue.readNext(id, format, w)
+	groupReadQueue.readNext(r.Context(), id, client, topicNames, format, options, w)
 	return nil
 }
 
+// Keeps the connection open, writing the events as they become available.
+//
+// The events of the following poll are read once the previous ones were written to the connection, so the
+// consumption adapts to the pace of the client.
+func (c *consumer) getStream(
+	tc *trackedConsumerHandler,
+	w http.ResponseWriter,
+	r *http.Request,
+	_ httprouter.Params,
+) error {
+	if err := c.validateRegistered(tc, r); err != nil {
+		return err
+	}
+	tc.SetAsRead()
+	if err := c.authorizeRead(auth.Principal(r), tc.Id()); err != nil {
+		return err
+	}
+
+	options, err := c.parsePollOptions(r.URL.Query())
+	if err != nil {
+		return err
+	}
+	// The readers commit to all the brokers every AutoCommitInterval, the commit interval of the stream is only
+	// effective when lower than that
+	commitInterval := time.Duration(0)
+	if value := r.URL.Query().Get(commitIntervalQueryKey); value != "" {
+		ms, err := strconv.Atoi(value)
+		if err != nil || ms < 0 {
+			return types.NewHttpErrorf(http.StatusBadRequest, "Invalid value for '%s': %s", commitIntervalQueryKey, value)
+		}
+		commitInterval = time.Duration(ms) * time.Millisecond
+	}
+
+	// The stream is charged as a single request, the reads are paced to the byte-rate quotas
+	client := quotas.Client(auth.Principal(r), r.RemoteAddr)
+	_, _, topicNames := logsToServe(c.state, c.topologyGetter, tc.Id())
+	if err := c.limiter.Charge(quotas.OperationConsume, client, 0, topicNames...); err != nil {
+		return err
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return types.NewHttpError(http.StatusInternalServerError, "Streaming is not supported by the connection")
+	}
+
+	format := ndjsonStreamFormat
+	w.Header().Set(ContentTypeHeaderKey, MIMETypeNDJSON)
+	if r.Header.Get("Accept") == eventStreamMimeType {
+		format = eventStreamFormat
+		w.Header().Set(ContentTypeHeaderKey, eventStreamMimeType)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	id := tc.Id()
+	log.Debug().Msgf("Start streaming to consumer '%s'", id)
+	buf := new(bytes.Buffer)
+	lastCommit := time.Now()
+	lastWrite := time.Now()
+	written := make(chan struct{}, 1)
+	stopWaiting := data.WaitForWrites(topicNames, written)
+	defer stopWaiting()
+	woken := false
+
+	for !tc.IsClosed() {
+		tc.SetAsRead()
+		hasData := false
+		group, tokens, topicNames := logsToServe(c.state, c.topologyGetter, id)
+		if len(tokens) > 0 {
+			groupReadQueue := c.getOrCreateReadQueue(group)
+			if commitInterval > 0 && time.Since(lastCommit) >= commitInterval {
+				groupReadQueue.commitStream(id)
+				lastCommit = time.Now()
+			}
+			if c.limiter.BytesDelay(quotas.OperationConsume, client, topicNames...) == 0 {
+				buf.Reset()
+				hasData = groupReadQueue.readStream(id, client, format, options, buf)
+			}
+		}
+
+		if hasData {
+			if _, err := w.Write(buf.Bytes()); err != nil {
+				log.Debug().Err(err).Msgf("Stream to consumer '%s' could not be written", id)
+				return nil
+			}
+			flusher.Flush()
+			lastWrite = time.Now()
+			continue
+		}
+
+		if woken {
+			// The readers start reading the new data from disk after responding with no data, try again right away
+			woken = false
+			continue
+		}
+
+		if format == eventStreamFormat && time.Since(lastWrite) >= streamHeartbeatInterval {
+			// Comment line to prevent intermediaries from closing the idle connection
+			if _, err := w.Write([]byte(": keep-alive\n\n")); err != nil {
+				return nil
+			}
+			flusher.Flush()
+			lastWrite = time.Now()
+		}
+
+		select {
+		case <-r.Context().Done():
+			log.Debug().Msgf("Stream to consumer '%s' closed", id)
+			if commitInterval > 0 {
+				// The events read were written to the connection, store the position as the client stopped reading
+				if group, tokens, _ := logsToServe(c.state, c.topologyGetter, id); len(tokens) > 0 {
+					c.getOrCreateReadQueue(group).commitStream(id)
+				}
+			}
+			return nil
+		case <-written:
+			woken = true
+		case <-time.After(pollWaitInterval):
+		}
+	}
+	return nil
+}
+
+// Gets the poll limits from the query string, using the defaults for the ones not provided.
+// The wait is capped to the max poll wait setting.
+func (c *consumer) parsePollOptions(query url.Values) (*pollOptions, error) {
+	options := &pollOptions{maxBytes: c.config.ConsumerReadThreshold()}
+
+	parse := func(key string) (int, error) {
+		value := query.Get(key)
+		if value == "" {
+			return 0, nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return 0, types.NewHttpErrorf(http.StatusBadRequest, "Invalid value for '%s': %s", key, value)
+		}
+		return n, nil
+	}
+
+	var err error
+	if options.maxRecords, err = parse(maxRecordsQueryKey); err != nil {
+		return nil, err
+	}
+
+	maxBytes, err := parse(maxBytesQueryKey)
+	if err != nil {
+		return nil, err
+	}
+	if maxBytes > 0 && maxBytes < options.maxBytes {
+		options.maxBytes = maxBytes
+	}
+
+	waitMs, err := parse(waitQueryKey)
+	if err != nil {
+		return nil, err
+	}
+	options.wait = time.Duration(waitMs) * time.Millisecond
+	if maxWait := c.config.ConsumerMaxPollWait(); options.wait > maxWait {
+		options.wait = maxWait
+	}
+
+	return options, nil
+}
+
 func (c *consumer) postManualCommit(
 	tc *trackedConsumerHandler,
 	w http.ResponseWriter,
@@ -445,6 +825,115 @@ func (c *consumer) postManualCommit(
 	return nil
 }
 
+// Acknowledges the records delivered to the consumer in ack mode
+func (c *consumer) postAck(
+	tc *trackedConsumerHandler,
+	w http.ResponseWriter,
+	r *http.Request,
+	_ httprouter.Params,
+) error {
+	return c.handleAck(tc, w, r, false)
+}
+
+// Negatively acknowledges the records delivered to the consumer in ack mode, to be delivered again
+func (c *consumer) postNack(
+	tc *trackedConsumerHandler,
+	w http.ResponseWriter,
+	r *http.Request,
+	_ httprouter.Params,
+) error {
+	return c.handleAck(tc, w, r, true)
+}
+
+func (c *consumer) handleAck(tc *trackedConsumerHandler, w http.ResponseWriter, r *http.Request, nack bool) error {
+	if err := c.validateRegistered(tc, r); err != nil {
+		return err
+	}
+	tc.SetAsRead()
+	id := tc.Id()
+
+	var acks []RecordAck
+	if err := json.NewDecoder(r.Body).Decode(&acks); err != nil {
+		return types.NewHttpError(http.StatusBadRequest, "Invalid ack payload")
+	}
+	if !c.state.AckSettings(id).AckMode {
+		return types.NewHttpError(http.StatusBadRequest, "Consumer was not registered in ack mode")
+	}
+
+	if tc.IsStateless() {
+		// The records could have been delivered by any of the brokers
+		peers := c.topologyGetter.Topology().Peers()
+		err := InParallelAnyError(len(peers), func(i int) error {
+			return c.gossiper.SendConsumerAck(peers[i].Ordinal, id, acks, nack)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	c.ackLocal(id, acks, nack)
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (c *consumer) ackLocal(id string, acks []RecordAck, nack bool) {
+	group, _, _ := c.state.CanConsume(id)
+	if group == "" {
+		return
+	}
+	c.getOrCreateReadQueue(group).ack(id, acks, nack)
+}
+
+// Commits the offsets explicitly provided by a consumer with manually assigned token ranges
+func (c *consumer) postOffsetCommit(
+	tc *trackedConsumerHandler,
+	w http.ResponseWriter,
+	r *http.Request,
+	_ httprouter.Params,
+) error {
+	if err := c.validateRegistered(tc, r); err != nil {
+		return err
+	}
+	tc.SetAsRead()
+	id := tc.Id()
+
+	var offsets []OffsetCommit
+	if err := json.NewDecoder(r.Body).Decode(&offsets); err != nil {
+		return types.NewHttpError(http.StatusBadRequest, "Invalid offset commit payload")
+	}
+	if c.state.Assignment(id) == nil {
+		return types.NewHttpError(http.StatusBadRequest, "Consumer was not registered with manually assigned token ranges")
+	}
+	for _, o := range offsets {
+		if o.Offset < 0 || int(o.RangeIndex) >= c.config.ConsumerRanges() {
+			return types.NewHttpErrorf(http.StatusBadRequest, "Invalid offset %d for range %d/%d", o.Offset, o.Token, o.RangeIndex)
+		}
+	}
+
+	if tc.IsStateless() {
+		// The records could have been delivered by any of the brokers
+		peers := c.topologyGetter.Topology().Peers()
+		err := InParallelAnyError(len(peers), func(i int) error {
+			return c.gossiper.SendConsumerOffsetCommit(peers[i].Ordinal, id, offsets)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	c.commitOffsetsLocal(id, offsets)
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (c *consumer) commitOffsetsLocal(id string, offsets []OffsetCommit) {
+	group, _, _ := c.state.CanConsume(id)
+	if group == "" {
+		return
+	}
+	c.getOrCreateReadQueue(group).commitOffsets(id, offsets)
+}
+
 func (c *consumer) postGoodbye(
 	tc *trackedConsumerHandler,
 	w http.ResponseWriter,
@@ -496,9 +985,19 @@ func (c *consumer) manualCommitLocal(id string, messageType string, w http.Respo
 	}
 }
 
+// Gets the read queue of the group, nil when it was not created
+func (c *consumer) readQueue(group string) *groupReadQueue {
+	if grq, ok := c.readQueues.Load(group); ok {
+		return grq.(*groupReadQueue)
+	}
+	return nil
+}
+
 func (c *consumer) getOrCreateReadQueue(group string) *groupReadQueue {
 	grq, _, _ := c.readQueues.LoadOrStore(group, func() (interface{}, error) {
-		return newGroupReadQueue(group, c.state, c.offsetState, c.topologyGetter, c.datalog, c.gossiper, c.rrFactory, c.config), nil
+		return newGroupReadQueue(
+			group, c.state, c.offsetState, c.topologyGetter, c.datalog, c.gossiper, c.topicGetter, c.rrFactory, c.config,
+			c.producer, c.limiter), nil
 	})
 
 	return grq.(*groupReadQueue)
@@ -549,6 +1048,7 @@ func adaptHttpErr(err error, w http.ResponseWriter) {
 		return
 	}
 
+	SetRetryAfter(w, err)
 	w.WriteHeader(httpErr.StatusCode())
 	// The message is supposed to be user friendly
 	fmt.Fprint(w, err.Error())
@@ -566,17 +1066,34 @@ func (c *consumer) OnOffsetFromPeer(kv *OffsetStoreKeyValue) {
 	c.offsetState.Set(kv.Key.Group, kv.Key.Topic, kv.Value, OffsetCommitLocal)
 }
 
-func (c *consumer) OnRegisterFromPeer(id string, group string, topics []string, onNewGroup OffsetResetPolicy) error {
+func (c *consumer) OnRegisterFromPeer(
+	id string,
+	group string,
+	topics []string,
+	onNewGroup OffsetResetPolicy,
+	filter string,
+	assignment *ManualAssignment,
+	ackSettings AckSettings,
+	recordFormat byte,
+) error {
 	consumerInfo := ConsumerInfo{
-		Id:         id,
-		Group:      group,
-		Topics:     topics,
-		OnNewGroup: onNewGroup,
+		Id:           id,
+		Group:        group,
+		Topics:       topics,
+		OnNewGroup:   onNewGroup,
+		Filter:       filter,
+		Assignment:   assignment,
+		AckSettings:  ackSettings,
+		RecordFormat: recordFormat,
 	}
 
 	if tc, existingInfo := c.state.TrackedConsumerById(id); tc != nil {
 		if IfEmpty(consumerInfo.Group, consumerGroupDefault) != existingInfo.Group ||
-			!reflect.DeepEqual(consumerInfo.Topics, existingInfo.Topics) {
+			!reflect.DeepEqual(consumerInfo.Topics, existingInfo.Topics) ||
+			consumerInfo.Filter != existingInfo.Filter ||
+			!reflect.DeepEqual(consumerInfo.Assignment, existingInfo.Assignment) ||
+			consumerInfo.AckSettings != existingInfo.AckSettings ||
+			consumerInfo.RecordFormat != existingInfo.RecordFormat {
 			return types.NewHttpError(
 				http.StatusBadRequest, "Consumer already registered with different parameters")
 		}
@@ -595,6 +1112,16 @@ func (c *consumer) OnUnregisterFromPeer(id string) error {
 	return nil
 }
 
+func (c *consumer) OnAckFromPeer(id string, acks []RecordAck, nack bool) error {
+	c.ackLocal(id, acks, nack)
+	return nil
+}
+
+func (c *consumer) OnOffsetCommitFromPeer(id string, offsets []OffsetCommit) error {
+	c.commitOffsetsLocal(id, offsets)
+	return nil
+}
+
 func (c *consumer) OnCommitFromPeer(id string) error {
 	c.manualCommitLocal(id, "gossip commit request", ignoreResponse{})
 	return nil
@@ -0,0 +1,322 @@
+package consuming
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/polarstreams/polar/internal/data"
+	cMocks "github.com/polarstreams/polar/internal/test/conf/mocks"
+	dMocks "github.com/polarstreams/polar/internal/test/discovery/mocks"
+	. "github.com/polarstreams/polar/internal/types"
+	"github.com/polarstreams/polar/internal/utils"
+	"github.com/stretchr/testify/mock"
+)
+
+var _ = Describe("consumer", func() {
+	Describe("parsePollOptions()", func() {
+		config := new(cMocks.Config)
+		config.On("ConsumerReadThreshold").Return(1000)
+		config.On("ConsumerMaxPollWait").Return(10 * time.Second)
+		c := &consumer{config: config}
+
+		It("should use the defaults when not set", func() {
+			options, err := c.parsePollOptions(url.Values{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*options).To(Equal(pollOptions{maxBytes: 1000}))
+		})
+
+		It("should parse the limits and cap them to the settings", func() {
+			options, err := c.parsePollOptions(url.Values{
+				"maxRecords": []string{"20"},
+				"maxBytes":   []string{"500"},
+				"waitMs":     []string{"60000"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*options).To(Equal(pollOptions{maxRecords: 20, maxBytes: 500, wait: 10 * time.Second}))
+
+			options, err = c.parsePollOptions(url.Values{"maxBytes": []string{"5000"}, "waitMs": []string{"200"}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*options).To(Equal(pollOptions{maxBytes: 1000, wait: 200 * time.Millisecond}))
+		})
+
+		It("should return a bad request error for invalid values", func() {
+			for _, key := range []string{"maxRecords", "maxBytes", "waitMs"} {
+				for _, value := range []string{"-1", "abc"} {
+					_, err := c.parsePollOptions(url.Values{key: []string{value}})
+					Expect(err).To(HaveOccurred())
+					Expect(err.(HttpError).StatusCode()).To(Equal(http.StatusBadRequest))
+				}
+			}
+		})
+	})
+
+	Describe("authorize()", func() {
+		c := &consumer{authorizer: testAuthorizer(func(principal, operation, topic, group string) bool {
+			return principal == "billing" && (topic == "orders" && group == "billing" || topic == "orders.dlq")
+		})}
+
+		It("should authorize all the topics with the group of the consumer", func() {
+			info := ConsumerInfo{Group: "billing", Topics: []string{"orders"}}
+			Expect(c.authorize("billing", &info)).To(Succeed())
+			info.DeadLetterTopic = "orders.dlq"
+			Expect(c.authorize("billing", &info)).To(Succeed())
+
+			for _, info := range []ConsumerInfo{
+				{Group: "billing", Topics: []string{"orders", "payments"}},
+				{Topics: []string{"orders"}},
+				{Group: "billing", Topics: []string{"orders"}, AckSettings: AckSettings{DeadLetterTopic: "other"}},
+			} {
+				err := c.authorize("billing", &info)
+				Expect(err).To(HaveOccurred())
+				Expect(err.(HttpError).StatusCode()).To(Equal(http.StatusForbidden))
+			}
+			Expect(c.authorize("other", &ConsumerInfo{Group: "billing", Topics: []string{"orders"}})).NotTo(Succeed())
+		})
+	})
+
+	Describe("getStream()", func() {
+		It("should write the events of each read and commit when the client disconnects", func() {
+			q := &groupReadQueue{items: make(chan readQueueItem)}
+			calls := serveTestStream(q, 3)
+			server, finished := newTestStreamServer(q)
+			defer server.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			req, err := http.NewRequestWithContext(
+				ctx, http.MethodGet, server.URL+"?consumerId=c1&commitIntervalMs=1", nil)
+			Expect(err).NotTo(HaveOccurred())
+			req.Header.Set("Accept", eventStreamMimeType)
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header.Get(ContentTypeHeaderKey)).To(Equal(eventStreamMimeType))
+
+			reader := bufio.NewReader(resp.Body)
+			for _, offset := range []string{"1", "2", "3"} {
+				Expect(reader.ReadString('\n')).To(Equal("data: " + testStreamEvent(offset) + "\n"))
+				Expect(reader.ReadString('\n')).To(Equal("\n"))
+			}
+
+			cancel()
+			resp.Body.Close()
+			Eventually(finished).Should(BeClosed())
+			close(q.items)
+
+			result := calls()
+			Expect(result[len(result)-1]).To(Equal("commit"), "should commit once disconnected")
+			// The reads take longer than the commit interval, the position is committed before the following reads
+			for _, n := range []int{2, 3} {
+				Expect(result[indexOfNth(result, "read", n)-1]).To(Equal("commit"))
+			}
+		})
+
+		It("should write newline delimited json and not commit when the interval is not set", func() {
+			q := &groupReadQueue{items: make(chan readQueueItem)}
+			calls := serveTestStream(q, 2)
+			server, finished := newTestStreamServer(q)
+			defer server.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"?consumerId=c1", nil)
+			Expect(err).NotTo(HaveOccurred())
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Header.Get(ContentTypeHeaderKey)).To(Equal(MIMETypeNDJSON))
+
+			reader := bufio.NewReader(resp.Body)
+			Expect(reader.ReadString('\n')).To(Equal(testStreamEvent("1") + "\n"))
+			Expect(reader.ReadString('\n')).To(Equal(testStreamEvent("2") + "\n"))
+
+			cancel()
+			resp.Body.Close()
+			Eventually(finished).Should(BeClosed())
+			close(q.items)
+			Expect(calls()).NotTo(ContainElement("commit"))
+		})
+
+		It("should not read the following events until the previous ones were written", func() {
+			q := &groupReadQueue{items: make(chan readQueueItem)}
+			calls := serveTestStream(q, 2)
+			c := newTestStreamConsumer(q)
+			tc := newTrackedConsumerHandler(nil)
+			tc.TrackAsStateless("c1")
+			w := &testBlockingWriter{header: http.Header{}, writes: make(chan string)}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			r := httptest.NewRequest(http.MethodGet, "/?consumerId=c1", nil).WithContext(ctx)
+
+			finished := make(chan error, 1)
+			go func() {
+				finished <- c.getStream(tc, w, r, nil)
+			}()
+
+			Eventually(calls).Should(Equal([]string{"read"}))
+			// The write of the first events is blocked
+			Consistently(calls, 50*time.Millisecond).Should(HaveLen(1))
+			Expect(<-w.writes).To(Equal(testStreamEvent("1") + "\n"))
+			Expect(<-w.writes).To(Equal(testStreamEvent("2") + "\n"))
+			Expect(calls()[:2]).To(Equal([]string{"read", "read"}))
+
+			cancel()
+			Eventually(finished, 2*time.Second).Should(Receive(BeNil()))
+			close(q.items)
+		})
+	})
+})
+
+// Creates a consumer that serves the stream of a registered stateless consumer "c1" from the provided read queue
+func newTestStreamConsumer(q *groupReadQueue) *consumer {
+	config := new(cMocks.Config)
+	config.On("ConsumerReadThreshold").Return(1000)
+	config.On("ConsumerMaxPollWait").Return(10 * time.Second)
+	decoder, err := zstd.NewReader(bytes.NewReader(make([]byte, 0)), zstd.WithDecoderConcurrency(1))
+	Expect(err).NotTo(HaveOccurred())
+	q.config = config
+	q.decoder = decoder
+	q.decoderBuffer = make([]byte, 16_384)
+	topology := newTestTopology(3, 0)
+	discoverer := new(dMocks.Discoverer)
+	discoverer.On("Topology").Return(&topology)
+	discoverer.On("Generation", mock.Anything).Return(&Generation{Leader: 0, ClusterSize: 3})
+
+	state := NewConsumerState(config, discoverer)
+	state.consumers.Store(map[string]ConsumerInfo{"c1": {
+		Id:             "c1",
+		Group:          "g1",
+		Topics:         []string{"t1"},
+		assignedTokens: []TokenRanges{{Token: topology.MyToken(), Indices: []RangeIndex{0}}},
+	}})
+	readQueues := utils.NewCopyOnWriteMap()
+	readQueues.LoadOrStore("g1", func() (interface{}, error) { return q, nil })
+
+	return &consumer{
+		config:         config,
+		state:          state,
+		topologyGetter: discoverer,
+		readQueues:     readQueues,
+		limiter:        testLimiter{},
+		authorizer:     testAuthorizer(func(principal, operation, topic, group string) bool { return true }),
+	}
+}
+
+// Starts a server with the stream endpoint, the returned channel is closed once the handler finished
+func newTestStreamServer(q *groupReadQueue) (*httptest.Server, chan bool) {
+	c := newTestStreamConsumer(q)
+	tc := newTrackedConsumerHandler(nil)
+	tc.TrackAsStateless("c1")
+	handler := toTrackedHandler(tc, c.getStream)
+	finished := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(finished)
+		handler(w, r, nil)
+	}))
+	return server, finished
+}
+
+// Serves the items of the read queue, responding with an item per read until the amount of batches is reached.
+// Returns a function to get the kind of items received so far.
+func serveTestStream(q *groupReadQueue, batches int) func() []string {
+	var mu sync.Mutex
+	calls := make([]string, 0)
+	reads := 0
+	go func() {
+		for item := range q.items {
+			mu.Lock()
+			if item.commitOnly {
+				calls = append(calls, "commit")
+				mu.Unlock()
+				item.done <- true
+				continue
+			}
+			calls = append(calls, "read")
+			mu.Unlock()
+
+			reads++
+			if reads > batches {
+				item.done <- false
+				continue
+			}
+			time.Sleep(5 * time.Millisecond)
+			topic := TopicDataId{Name: "t1", Token: -9223372036854775808}
+			responseItems := []consumerResponseItem{{chunk: data.NewEmptyChunk(int64(reads)), topic: topic}}
+			q.marshalStreamResponse(item.stream, item.format, responseItems)
+			item.done <- true
+		}
+	}()
+
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, calls...)
+	}
+}
+
+func testStreamEvent(offset string) string {
+	return `{"topic":"t1","token":"-9223372036854775808","rangeIndex":0,"version":0,"startOffset":"` + offset +
+		`","values":[]}`
+}
+
+func indexOfNth(values []string, value string, n int) int {
+	for i, v := range values {
+		if v == value {
+			if n--; n == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+type testBlockingWriter struct {
+	header http.Header
+	writes chan string
+}
+
+func (w *testBlockingWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *testBlockingWriter) Write(b []byte) (int, error) {
+	w.writes <- string(b)
+	return len(b), nil
+}
+
+func (w *testBlockingWriter) WriteHeader(statusCode int) {}
+
+func (w *testBlockingWriter) Flush() {}
+
+type testLimiter struct{}
+
+func (l testLimiter) Init() error {
+	return nil
+}
+
+func (l testLimiter) Charge(operation string, client string, bytes int64, topicNames ...string) error {
+	return nil
+}
+
+func (l testLimiter) ChargeBytes(operation string, client string, topic string, bytes int64) {}
+
+func (l testLimiter) BytesDelay(operation string, client string, topicNames ...string) time.Duration {
+	return 0
+}
+
+type testAuthorizer func(principal, operation, topic, group string) bool
+
+func (f testAuthorizer) Authorize(principal string, operation string, topic string, group string) error {
+	if !f(principal, operation, topic, group) {
+		return NewHttpError(http.StatusForbidden, "Forbidden")
+	}
+	return nil
+}
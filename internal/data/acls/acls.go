@@ -0,0 +1,281 @@
+package acls
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/polarstreams/polar/internal/conf"
+	"github.com/polarstreams/polar/internal/data/registry"
+	"github.com/polarstreams/polar/internal/discovery"
+	"github.com/polarstreams/polar/internal/interbroker"
+	"github.com/polarstreams/polar/internal/localdb"
+	. "github.com/polarstreams/polar/internal/types"
+	"github.com/rs/zerolog/log"
+)
+
+const aclsToPeersDelay = 30 * time.Second
+
+// A name or a prefix followed by the wildcard, e.g. "orders.*"
+var patternRegex = regexp.MustCompile(`^(\*|[\w\-.]+\*?)$`)
+
+// AclHandler maintains the registry of ACL rules, persisting it locally and replicating it to the peers
+type AclHandler interface {
+	Initializer
+	Authorizer
+
+	// Creates a new rule, returning an error when the rule is not valid or the same rule already exists
+	Create(rule AclRule) (*AclRule, error)
+
+	// Marks the rule as deleted, returning an error when the rule is not found
+	Delete(id string) error
+
+	// Gets a point-in-time snapshot of the existing rules, sorted by principal
+	List() []AclRule
+}
+
+type Authorizer interface {
+	// Returns a forbidden error when ACLs are enabled and no rule grants the principal the permission to perform the
+	// operation on the topic and consumer group (consume only).
+	Authorize(principal string, operation string, topic string, group string) error
+}
+
+func NewHandler(
+	config conf.AclConfig,
+	localDb localdb.Client,
+	topologyGetter discovery.TopologyGetter,
+	gossiper interbroker.Gossiper,
+) AclHandler {
+	return &aclHandler{
+		config:         config,
+		localDb:        localDb,
+		topologyGetter: topologyGetter,
+		gossiper:       gossiper,
+		mu:             sync.RWMutex{},
+		rules:          map[string]AclRule{},
+	}
+}
+
+type aclHandler struct {
+	config         conf.AclConfig
+	localDb        localdb.Client
+	topologyGetter discovery.TopologyGetter
+	gossiper       interbroker.Gossiper
+	mu             sync.RWMutex
+	rules          map[string]AclRule // Rules by id, including tombstones
+}
+
+func (h *aclHandler) Init() error {
+	stored, err := h.localDb.Acls()
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	for _, rule := range stored {
+		h.rules[rule.Id] = rule
+	}
+	h.mu.Unlock()
+
+	log.Info().Msgf("Loaded %d ACL rules from local db", len(stored))
+	if h.config.AuthAclEnabled() {
+		log.Info().Msgf("ACLs enabled")
+	}
+	h.gossiper.RegisterAclListener(h)
+
+	// Send info in the background
+	go h.sendAclsToPeers()
+	return nil
+}
+
+func (h *aclHandler) Authorize(principal string, operation string, topic string, group string) error {
+	if !h.config.AuthAclEnabled() || principal == SystemPrincipal {
+		return nil
+	}
+
+	h.mu.RLock()
+	for _, rule := range h.rules {
+		if rule.Allows(principal, operation, topic, group) {
+			h.mu.RUnlock()
+			return nil
+		}
+	}
+	h.mu.RUnlock()
+
+	log.Debug().Msgf("Principal '%s' is not allowed to %s topic '%s' (group '%s')", principal, operation, topic, group)
+	if operation == AclOperationConsume && group != "" {
+		return NewHttpErrorf(
+			http.StatusForbidden,
+			"Principal '%s' is not allowed to consume topic '%s' with group '%s'", principal, topic, group)
+	}
+	return NewHttpErrorf(http.StatusForbidden, "Principal '%s' is not allowed to %s topic '%s'", principal, operation, topic)
+}
+
+func (h *aclHandler) Create(rule AclRule) (*AclRule, error) {
+	if err := validateRule(&rule); err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	for _, existing := range h.rules {
+		if !existing.Deleted && existing.SameAs(&rule) {
+			h.mu.Unlock()
+			return nil, NewHttpErrorf(http.StatusConflict, "The same rule already exists with id '%s'", existing.Id)
+		}
+	}
+
+	rule.Id = uuid.New().String()
+	rule.Timestamp = time.Now().UnixMicro()
+	rule.Deleted = false
+	err := h.save(rule)
+	h.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info().Msgf("ACL rule %s created for principal '%s'", rule.Id, rule.Principal)
+	h.sendToAllPeers([]AclRule{rule})
+	return &rule, nil
+}
+
+func (h *aclHandler) Delete(id string) error {
+	h.mu.Lock()
+	existing, found := h.rules[id]
+	if !found || existing.Deleted {
+		h.mu.Unlock()
+		return NewHttpErrorf(http.StatusNotFound, "ACL rule '%s' not found", id)
+	}
+
+	rule := existing
+	rule.Timestamp = registry.NewTimestamp(existing.Timestamp)
+	rule.Deleted = true
+	err := h.save(rule)
+	h.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	log.Info().Msgf("ACL rule %s of principal '%s' deleted", id, rule.Principal)
+	h.sendToAllPeers([]AclRule{rule})
+	return nil
+}
+
+func (h *aclHandler) List() []AclRule {
+	h.mu.RLock()
+	result := make([]AclRule, 0, len(h.rules))
+	for _, rule := range h.rules {
+		if !rule.Deleted {
+			result = append(result, rule)
+		}
+	}
+	h.mu.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Principal != result[j].Principal {
+			return result[i].Principal < result[j].Principal
+		}
+		return result[i].Timestamp < result[j].Timestamp
+	})
+	return result
+}
+
+// Merges the rules provided by a peer, using the last modification timestamp to resolve conflicts
+func (h *aclHandler) OnAclsFromPeer(rules []AclRule) {
+	changed := make([]AclRule, 0)
+	h.mu.Lock()
+	for _, rule := range rules {
+		existing, found := h.rules[rule.Id]
+		if found && existing.Timestamp >= rule.Timestamp {
+			continue
+		}
+		h.rules[rule.Id] = rule
+		changed = append(changed, rule)
+	}
+	h.mu.Unlock()
+
+	for i := range changed {
+		if err := h.localDb.SaveAcl(&changed[i]); err != nil {
+			log.Err(err).Msgf("ACL rule %s received from peer could not be stored", changed[i].Id)
+		}
+	}
+}
+
+// Stores the rule and then sets it in memory, the caller must hold the lock
+func (h *aclHandler) save(rule AclRule) error {
+	if err := h.localDb.SaveAcl(&rule); err != nil {
+		return err
+	}
+	h.rules[rule.Id] = rule
+	return nil
+}
+
+// Gets all the rules, including tombstones
+func (h *aclHandler) snapshot() []AclRule {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	result := make([]AclRule, 0, len(h.rules))
+	for _, rule := range h.rules {
+		result = append(result, rule)
+	}
+	return result
+}
+
+func (h *aclHandler) sendToAllPeers(rules []AclRule) {
+	registry.SendToAllPeers(h.topologyGetter, "ACL rules", func(ordinal int) error {
+		return h.gossiper.SendAcls(ordinal, rules)
+	})
+}
+
+// Periodically sends the full rule snapshot to the next brokers, to converge in case of missed changes
+func (h *aclHandler) sendAclsToPeers() {
+	if h.config.DevMode() {
+		// There's never going to be a peer
+		return
+	}
+
+	registry.SendSnapshotsToPeers(h.topologyGetter, aclsToPeersDelay, "ACL rules", func() registry.PeerSender {
+		rules := h.snapshot()
+		if len(rules) == 0 {
+			return nil
+		}
+		return func(ordinal int) error {
+			return h.gossiper.SendAcls(ordinal, rules)
+		}
+	})
+}
+
+func validateRule(rule *AclRule) error {
+	if rule.Principal == "" || strings.HasPrefix(rule.Principal, "$") {
+		return NewHttpErrorf(http.StatusBadRequest, "Invalid principal '%s'", rule.Principal)
+	}
+	if rule.Operation != AclOperationProduce && rule.Operation != AclOperationConsume {
+		return NewHttpErrorf(
+			http.StatusBadRequest,
+			"Invalid operation '%s': it must be '%s' or '%s'",
+			rule.Operation,
+			AclOperationProduce,
+			AclOperationConsume)
+	}
+	if !patternRegex.MatchString(rule.Topic) {
+		return NewHttpErrorf(
+			http.StatusBadRequest,
+			"Invalid topic pattern '%s': it must be a topic name, optionally followed by '*'",
+			rule.Topic)
+	}
+	if rule.Group != "" {
+		if rule.Operation != AclOperationConsume {
+			return NewHttpError(http.StatusBadRequest, "The consumer group can only be set for consume rules")
+		}
+		if !patternRegex.MatchString(rule.Group) {
+			return NewHttpErrorf(
+				http.StatusBadRequest,
+				"Invalid group pattern '%s': it must be a group name, optionally followed by '*'",
+				rule.Group)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package acls
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	cMocks "github.com/polarstreams/polar/internal/test/conf/mocks"
+	dMocks "github.com/polarstreams/polar/internal/test/discovery/mocks"
+	iMocks "github.com/polarstreams/polar/internal/test/interbroker/mocks"
+	dbMocks "github.com/polarstreams/polar/internal/test/localdb/mocks"
+	. "github.com/polarstreams/polar/internal/types"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAcls(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Acls Suite")
+}
+
+var _ = Describe("aclHandler", func() {
+	Describe("Init()", func() {
+		It("should load the rules from the local db", func() {
+			stored := []AclRule{
+				{Id: "r1", Principal: "billing", Operation: AclOperationProduce, Topic: "orders", Timestamp: 10},
+				{Id: "r2", Principal: "billing", Operation: AclOperationConsume, Topic: "*", Timestamp: 20, Deleted: true},
+			}
+			h := newTestHandler(true, stored)
+
+			Expect(h.List()).To(Equal([]AclRule{stored[0]}))
+			Expect(h.Authorize("billing", AclOperationProduce, "orders", "")).To(Succeed())
+			Expect(h.Authorize("billing", AclOperationConsume, "orders", "g1")).NotTo(Succeed())
+		})
+	})
+
+	Describe("Authorize()", func() {
+		It("should allow any operation when ACLs are not enabled", func() {
+			h := newTestHandler(false, nil)
+
+			Expect(h.Authorize("billing", AclOperationProduce, "orders", "")).To(Succeed())
+			Expect(h.Authorize("", AclOperationConsume, "orders", "g1")).To(Succeed())
+		})
+
+		It("should match the principal, topic and group patterns", func() {
+			h := newTestHandler(true, []AclRule{
+				{Id: "r1", Principal: "billing", Operation: AclOperationProduce, Topic: "orders.*", Timestamp: 10},
+				{Id: "r2", Principal: "billing", Operation: AclOperationConsume, Topic: "orders", Group: "billing",
+					Timestamp: 10},
+				{Id: "r3", Principal: "*", Operation: AclOperationConsume, Topic: "public", Timestamp: 10},
+				{Id: "r4", Principal: "audit", Operation: AclOperationConsume, Topic: "*", Group: "audit-*",
+					Timestamp: 10},
+			})
+
+			Expect(h.Authorize("billing", AclOperationProduce, "orders.eu", "")).To(Succeed())
+			Expect(h.Authorize("billing", AclOperationProduce, "orders.", "")).To(Succeed())
+			Expect(h.Authorize("billing", AclOperationConsume, "orders", "billing")).To(Succeed())
+			Expect(h.Authorize("billing", AclOperationConsume, "public", "g1")).To(Succeed())
+			Expect(h.Authorize("other", AclOperationConsume, "public", "g2")).To(Succeed())
+			Expect(h.Authorize("audit", AclOperationConsume, "orders", "audit-1")).To(Succeed())
+			Expect(h.Authorize(SystemPrincipal, AclOperationProduce, "orders.dlq", "")).To(Succeed())
+
+			expectForbidden(h.Authorize("billing", AclOperationProduce, "orders", ""))
+			expectForbidden(h.Authorize("billing", AclOperationConsume, "orders.eu", "billing"))
+			expectForbidden(h.Authorize("billing", AclOperationConsume, "orders", "other"))
+			expectForbidden(h.Authorize("other", AclOperationProduce, "public", ""))
+			expectForbidden(h.Authorize("audit", AclOperationConsume, "orders", "billing"))
+			expectForbidden(h.Authorize("audit", AclOperationProduce, "orders", ""))
+		})
+	})
+
+	Describe("Create()", func() {
+		It("should store and authorize the new rule", func() {
+			h := newTestHandler(true, nil)
+			expectForbidden(h.Authorize("billing", AclOperationConsume, "orders", "billing"))
+
+			rule, err := h.Create(AclRule{
+				Principal: "billing", Operation: AclOperationConsume, Topic: "orders", Group: "billing"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rule.Id).NotTo(BeEmpty())
+			Expect(rule.Timestamp).To(BeNumerically(">", 0))
+			Expect(h.Authorize("billing", AclOperationConsume, "orders", "billing")).To(Succeed())
+			Expect(h.List()).To(Equal([]AclRule{*rule}))
+			h.localDb.(*dbMocks.Client).AssertCalled(GinkgoT(), "SaveAcl", rule)
+		})
+
+		It("should not create the rule when it can not be stored", func() {
+			h := newTestHandler(true, nil)
+			localDb := new(dbMocks.Client)
+			localDb.On("SaveAcl", mock.Anything).Return(fmt.Errorf("Test error"))
+			h.localDb = localDb
+
+			_, err := h.Create(AclRule{Principal: "billing", Operation: AclOperationProduce, Topic: "orders"})
+			Expect(err).To(MatchError("Test error"))
+			Expect(h.List()).To(BeEmpty())
+			expectForbidden(h.Authorize("billing", AclOperationProduce, "orders", ""))
+		})
+
+		It("should return a conflict error when the same rule exists", func() {
+			h := newTestHandler(true, []AclRule{
+				{Id: "r1", Principal: "billing", Operation: AclOperationProduce, Topic: "orders", Timestamp: 10}})
+
+			_, err := h.Create(AclRule{Principal: "billing", Operation: AclOperationProduce, Topic: "orders"})
+			Expect(err).To(HaveOccurred())
+			Expect(err.(HttpError).StatusCode()).To(Equal(http.StatusConflict))
+		})
+
+		It("should return an error when the rule is not valid", func() {
+			h := newTestHandler(true, nil)
+
+			invalid := []AclRule{
+				{Principal: "", Operation: AclOperationProduce, Topic: "orders"},
+				{Principal: "$system", Operation: AclOperationProduce, Topic: "orders"},
+				{Principal: "billing", Operation: "delete", Topic: "orders"},
+				{Principal: "billing", Operation: AclOperationProduce, Topic: ""},
+				{Principal: "billing", Operation: AclOperationProduce, Topic: "or*ders"},
+				{Principal: "billing", Operation: AclOperationProduce, Topic: "orders", Group: "billing"},
+				{Principal: "billing", Operation: AclOperationConsume, Topic: "orders", Group: "a/b"},
+			}
+			for _, rule := range invalid {
+				_, err := h.Create(rule)
+				Expect(err).To(HaveOccurred())
+				Expect(err.(HttpError).StatusCode()).To(Equal(http.StatusBadRequest))
+			}
+			Expect(h.List()).To(BeEmpty())
+		})
+	})
+
+	Describe("Delete()", func() {
+		It("should store a tombstone", func() {
+			h := newTestHandler(true, []AclRule{
+				{Id: "r1", Principal: "billing", Operation: AclOperationProduce, Topic: "orders", Timestamp: 10}})
+
+			Expect(h.Delete("r1")).To(Succeed())
+			Expect(h.List()).To(BeEmpty())
+			expectForbidden(h.Authorize("billing", AclOperationProduce, "orders", ""))
+			h.localDb.(*dbMocks.Client).AssertCalled(GinkgoT(), "SaveAcl", mock.MatchedBy(func(r *AclRule) bool {
+				return r.Id == "r1" && r.Deleted && r.Timestamp > 10
+			}))
+		})
+
+		It("should keep the rule when the tombstone can not be stored", func() {
+			h := newTestHandler(true, []AclRule{
+				{Id: "r1", Principal: "billing", Operation: AclOperationProduce, Topic: "orders", Timestamp: 10}})
+			localDb := new(dbMocks.Client)
+			localDb.On("SaveAcl", mock.Anything).Return(fmt.Errorf("Test error"))
+			h.localDb = localDb
+
+			Expect(h.Delete("r1")).To(MatchError("Test error"))
+			Expect(h.List()).To(HaveLen(1))
+			Expect(h.Authorize("billing", AclOperationProduce, "orders", "")).To(Succeed())
+		})
+
+		It("should return not found when the rule does not exist", func() {
+			h := newTestHandler(true, nil)
+
+			err := h.Delete("r1")
+			Expect(err).To(HaveOccurred())
+			Expect(err.(HttpError).StatusCode()).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("OnAclsFromPeer()", func() {
+		It("should only apply newer changes", func() {
+			r1 := AclRule{Id: "r1", Principal: "a", Operation: AclOperationProduce, Topic: "t1", Timestamp: 10}
+			r2 := AclRule{Id: "r2", Principal: "b", Operation: AclOperationProduce, Topic: "t1", Timestamp: 10}
+			r3 := AclRule{Id: "r3", Principal: "c", Operation: AclOperationProduce, Topic: "t1", Timestamp: 5}
+			h := newTestHandler(true, []AclRule{r1, r2})
+
+			deleted1, deleted2 := r1, r2
+			deleted1.Timestamp, deleted1.Deleted = 9, true
+			deleted2.Timestamp, deleted2.Deleted = 11, true
+			h.OnAclsFromPeer([]AclRule{deleted1, deleted2, r3})
+
+			Expect(h.List()).To(Equal([]AclRule{r1, r3}))
+			h.localDb.(*dbMocks.Client).AssertNumberOfCalls(GinkgoT(), "SaveAcl", 2)
+		})
+	})
+})
+
+func expectForbidden(err error) {
+	Expect(err).To(HaveOccurred())
+	Expect(err.(HttpError).StatusCode()).To(Equal(http.StatusForbidden))
+}
+
+func newTestHandler(enabled bool, stored []AclRule) *aclHandler {
+	config := new(cMocks.Config)
+	config.On("AuthAclEnabled").Return(enabled)
+	config.On("DevMode").Return(true)
+
+	localDb := new(dbMocks.Client)
+	localDb.On("Acls").Return(stored, nil)
+	localDb.On("SaveAcl", mock.Anything).Return(nil)
+
+	discoverer := new(dMocks.Discoverer)
+	discoverer.On("Topology").Return(newTestTopology())
+
+	gossiper := new(iMocks.Gossiper)
+	gossiper.On("RegisterAclListener", mock.Anything)
+	gossiper.On("SendAcls", mock.Anything, mock.Anything).Return(nil)
+
+	h := NewHandler(config, localDb, discoverer, gossiper).(*aclHandler)
+	Expect(h.Init()).NotTo(HaveOccurred())
+	return h
+}
+
+func newTestTopology() *TopologyInfo {
+	brokers := make([]BrokerInfo, 3)
+	for i := range brokers {
+		brokers[i] = BrokerInfo{IsSelf: i == 0, Ordinal: i, HostName: fmt.Sprintf("test-%d", i)}
+	}
+	topology := NewTopology(brokers, 0)
+	return &topology
+}
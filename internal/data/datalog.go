@@ -51,6 +51,14 @@ type Datalog interface {
 
 	// Gets a sorted list of offsets representing the name of the segment files, where the offset is less than maxOffset
 	SegmentFileList(topic *TopicDataId, maxOffset int64) ([]int64, error)
+
+	// Gets the offset of the first record with a timestamp (in unix micros) greater than or equal to the provided one.
+	// Returns a negative value when there's no local data or all the records are older.
+	OffsetByTimestamp(topic *TopicDataId, timestamp int64) (int64, error)
+
+	// Sets the provider of the topic settings, used to determine the retention per topic.
+	// It must be invoked before Init().
+	RegisterTopicGetter(getter TopicInfoGetter)
 }
 
 func NewDatalog(config conf.DatalogConfig) Datalog {
@@ -65,19 +73,26 @@ func NewDatalog(config conf.DatalogConfig) Datalog {
 		streamBufferChan: streamBufferChan,
 	}
 
-	if t := config.LogRetentionDuration(); t != nil {
-		go d.cleanUp(*t)
-	}
-
 	return d
 }
 
 type datalog struct {
 	config           conf.DatalogConfig
 	streamBufferChan chan []byte
+	topicGetter      TopicInfoGetter
+	compactedDirs    map[string]string // The signature of the segments by dir at the time of the last compaction
+}
+
+func (d *datalog) RegisterTopicGetter(getter TopicInfoGetter) {
+	if d.topicGetter != nil {
+		panic("Topic getter registered multiple times")
+	}
+	d.topicGetter = getter
 }
 
 func (d *datalog) Init() error {
+	// Retention can be defined per topic, even when there's no default retention
+	go d.cleanUp()
 	return nil
 }
 
@@ -124,14 +139,17 @@ func (d *datalog) ReadFileFrom(
 	topic *TopicDataId,
 ) ([]byte, error) {
 	basePath := d.config.DatalogPath(topic)
-	fileOffset := tryReadIndexFile(basePath, conf.SegmentFilePrefix(segmentId), startOffset)
 	fileName := conf.SegmentFileName(segmentId)
 
 	if maxSize < len(buf) {
 		buf = buf[:maxSize]
 	}
 
+	// Prevent the files from being swapped by the compactor between reading the index and opening the segment
+	segmentSwapLock.RLock()
+	fileOffset := tryReadIndexFile(basePath, conf.SegmentFilePrefix(segmentId), startOffset)
 	file, err := os.OpenFile(filepath.Join(basePath, fileName), conf.SegmentFileReadFlags, 0)
+	segmentSwapLock.RUnlock()
 	if err != nil {
 		log.Err(err).Msgf("Could not open file %s/%s", basePath, fileName)
 		return nil, err
@@ -205,8 +223,9 @@ func readChunksUntil(buf []byte, startOffset int64, maxRecords int) ([]byte, boo
 			return buf, false, nil
 		}
 
-		if startOffset >= header.Start && startOffset < header.Start+int64(header.RecordLength) {
-			// We found the starting chunk
+		if startOffset < header.Start+int64(header.RecordLength) &&
+			(startOffset >= header.Start || header.Flags&compactedFlag != 0) {
+			// We found the starting chunk or the following one when the offset was removed by compaction
 			end := 0
 			maxOffset := startOffset + int64(maxRecords) - 1
 			initialAlignment := alignment
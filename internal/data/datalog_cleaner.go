@@ -6,28 +6,29 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/polarstreams/polar/internal/conf"
+	"github.com/polarstreams/polar/internal/metrics"
+	. "github.com/polarstreams/polar/internal/types"
 	"github.com/rs/zerolog/log"
 )
 
-func (d *datalog) cleanUp(retention time.Duration) {
+func (d *datalog) cleanUp() {
 	delay := time.Duration(RetentionCheckMs) * time.Millisecond
 	for {
 		time.Sleep(delay)
 		log.Info().Msgf("Start looking for log files to clean up pass the retention time")
 
-		_, err := os.Open(d.config.DatalogSegmentsPath())
+		start := time.Now()
+		read, removed, err := d.cleanUpTopics(d.config.DatalogSegmentsPath())
 		if err != nil {
 			// Likely that we are starting cleaning before the first message arrived
 			log.Info().AnErr("open", err).Msgf("Segment path does not exist yet")
 			continue
 		}
-
-		start := time.Now()
-		read, removed := d.cleanUpDir(d.config.DatalogSegmentsPath(), retention)
 		diff := time.Since(start)
 		spent := fmt.Sprintf("%dms", diff.Milliseconds())
 
@@ -37,9 +38,64 @@ func (d *datalog) cleanUp(retention time.Duration) {
 
 		log.Info().Msgf(
 			"Log clean up took %s to visit %d files/folders. Removed %d segment files", spent, read, removed)
+
+		if compacted, err := d.compactTopics(d.config.DatalogSegmentsPath()); err != nil {
+			log.Err(err).Msgf("There was an error compacting the topics")
+		} else if compacted > 0 {
+			log.Info().Msgf("Log compaction removed %d records", compacted)
+		}
 	}
 }
 
+// Visits each topic dir under the segments path, using the retention of the topic or the broker default.
+// After the time-based retention, it removes the oldest segments of the topics and the broker exceeding the byte budget.
+func (d *datalog) cleanUpTopics(basePath string) (read int, removed int, err error) {
+	entries, err := os.ReadDir(basePath)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defaultRetention := d.config.LogRetentionDuration()
+	remaining := make([]segmentFileInfo, 0)
+	for _, entry := range entries {
+		read++
+		if !entry.IsDir() {
+			continue
+		}
+
+		topicDir := filepath.Join(basePath, entry.Name())
+		var topicInfo *TopicInfo
+		if d.topicGetter != nil {
+			topicInfo = d.topicGetter.Get(entry.Name())
+		}
+
+		if retention := topicInfo.Retention(defaultRetention); retention != nil {
+			subRead, subRemoved := d.cleanUpDir(topicDir, *retention)
+			read += subRead
+			removed += subRemoved
+		}
+
+		segments := segmentFiles(topicDir)
+		if budget := topicInfo.RetentionBytes(0); budget > 0 {
+			var subRemoved int
+			segments, subRemoved = removeOverBudget(segments, budget)
+			removed += subRemoved
+		}
+		remaining = append(remaining, segments...)
+	}
+
+	budget := d.config.LogRetentionBytes()
+	if budget > 0 {
+		var subRemoved int
+		remaining, subRemoved = removeOverBudget(remaining, budget)
+		removed += subRemoved
+	}
+
+	metrics.DatalogRetentionBudgetBytes.Set(float64(budget))
+	metrics.DatalogRetentionUsedBytes.Set(float64(totalSize(remaining)))
+	return read, removed, nil
+}
+
 func (d *datalog) cleanUpDir(dirPath string, retention time.Duration) (read int, removed int) {
 	log.Debug().Msgf("Log clean up reading dir %s", dirPath)
 	dir, err := os.Open(dirPath)
@@ -83,18 +139,134 @@ func (d *datalog) cleanUpFile(dirPath string, file fs.FileInfo, retention time.D
 		return 0
 	}
 
-	log.Debug().Msgf("Log clean up removing segment file %s/%s", dirPath, file.Name())
-
-	// Remove the index file
-	indexFile := strings.TrimSuffix(filepath.Base(file.Name()), conf.SegmentFileExtension) + conf.IndexFileExtension
-	if err := os.RemoveAll(filepath.Join(dirPath, indexFile)); err != nil {
-		log.Err(err).Msgf("Failed to remove index file %s on %s", dirPath, file.Name())
+	if openSegments.contains(filepath.Join(dirPath, file.Name())) {
+		// It's still being written
+		return 0
 	}
 
-	// Remove the actual segment
-	if err := os.Remove(filepath.Join(dirPath, file.Name())); err != nil {
-		log.Err(err).Msgf("Failed to remove segment file %s on %s", dirPath, file.Name())
+	if !removeSegmentFile(dirPath, file.Name(), file.Size()) {
 		return 0
 	}
 	return 1
 }
+
+// Removes the oldest segment files until the total size is within the budget, skipping the segments that are being
+// written. Returns the segments that were not removed.
+func removeOverBudget(segments []segmentFileInfo, budget int64) ([]segmentFileInfo, int) {
+	total := totalSize(segments)
+	if total <= budget {
+		return segments, 0
+	}
+
+	sort.SliceStable(segments, func(i, j int) bool {
+		return segments[i].modTime.Before(segments[j].modTime)
+	})
+
+	removed := 0
+	remaining := make([]segmentFileInfo, 0, len(segments))
+	for _, s := range segments {
+		if total <= budget || openSegments.contains(filepath.Join(s.dirPath, s.name)) {
+			remaining = append(remaining, s)
+			continue
+		}
+
+		log.Info().Msgf("Log clean up removing segment file %s/%s to honor the size retention", s.dirPath, s.name)
+		if !removeSegmentFile(s.dirPath, s.name, s.size) {
+			remaining = append(remaining, s)
+			continue
+		}
+		total -= s.size
+		removed++
+	}
+	return remaining, removed
+}
+
+// Removes the segment file and its index files, returning true when the segment file was removed
+func removeSegmentFile(dirPath string, name string, size int64) bool {
+	log.Debug().Msgf("Log clean up removing segment file %s/%s", dirPath, name)
+
+	// Remove the index files
+	indexPath := filepath.Join(dirPath, indexFileName(name))
+	timeIndexPath := filepath.Join(dirPath, timeIndexFileName(name))
+	for _, path := range []string{indexPath, timeIndexPath} {
+		if stat, err := os.Stat(path); err == nil {
+			size += stat.Size()
+		}
+		if err := os.RemoveAll(path); err != nil {
+			log.Err(err).Msgf("Failed to remove index file %s", path)
+		}
+	}
+
+	// Remove the files of an interrupted compaction or time index rebuild, if any
+	_ = os.RemoveAll(filepath.Join(dirPath, name+compactionFileSuffix))
+	_ = os.RemoveAll(indexPath + compactionFileSuffix)
+	_ = os.RemoveAll(timeIndexPath + compactionFileSuffix)
+
+	// Remove the actual segment
+	if err := os.Remove(filepath.Join(dirPath, name)); err != nil {
+		log.Err(err).Msgf("Failed to remove segment file %s on %s", dirPath, name)
+		return false
+	}
+
+	metrics.DatalogReclaimedBytes.Add(float64(size))
+	return true
+}
+
+// Gets the information of the segment files in the directory and its children, the size includes the index files
+func segmentFiles(dirPath string) []segmentFileInfo {
+	result := make([]segmentFileInfo, 0)
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		log.Err(err).Msgf("Log clean up could not read the dir %s", dirPath)
+		return result
+	}
+
+	segmentFileExtension := "." + conf.SegmentFileExtension
+	for _, entry := range entries {
+		if entry.IsDir() {
+			result = append(result, segmentFiles(filepath.Join(dirPath, entry.Name()))...)
+			continue
+		}
+
+		if filepath.Ext(entry.Name()) != segmentFileExtension {
+			continue
+		}
+
+		stat, err := entry.Info()
+		if err != nil {
+			// It was removed in the meantime
+			continue
+		}
+
+		size := stat.Size()
+		for _, indexName := range []string{indexFileName(entry.Name()), timeIndexFileName(entry.Name())} {
+			if indexStat, err := os.Stat(filepath.Join(dirPath, indexName)); err == nil {
+				size += indexStat.Size()
+			}
+		}
+
+		result = append(result, segmentFileInfo{
+			dirPath: dirPath,
+			name:    entry.Name(),
+			size:    size,
+			modTime: stat.ModTime(),
+		})
+	}
+	return result
+}
+
+func indexFileName(segmentFileName string) string {
+	return strings.TrimSuffix(filepath.Base(segmentFileName), conf.SegmentFileExtension) + conf.IndexFileExtension
+}
+
+func timeIndexFileName(segmentFileName string) string {
+	return strings.TrimSuffix(filepath.Base(segmentFileName), conf.SegmentFileExtension) + conf.TimeIndexFileExtension
+}
+
+func totalSize(segments []segmentFileInfo) int64 {
+	var total int64
+	for _, s := range segments {
+		total += s.size
+	}
+	return total
+}
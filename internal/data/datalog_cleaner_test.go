@@ -9,6 +9,7 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/polarstreams/polar/internal/test/conf/mocks"
+	. "github.com/polarstreams/polar/internal/types"
 )
 
 var _ = Describe("datalog", func() {
@@ -23,10 +24,126 @@ var _ = Describe("datalog", func() {
 			read, removed := d.cleanUpDir(dir, 7*24*time.Hour)
 			Expect(read).To(Equal(8))
 			Expect(removed).To(Equal(2))
+			Expect(listFiles(dir)).To(Equal([]string{"root_file2.dlog", "root_file2.index", "sub_dir"}))
+		})
+
+		It("should remove the time index along with the segment", func() {
+			dir, err := ioutil.TempDir("", "clean_up_time_index_test")
+			Expect(err).NotTo(HaveOccurred())
+			createSegmentFile(dir, "001", 100, 10)
+			createEmptyFile(dir, "001.timeindex")
+			createSegmentFile(dir, "002", 100, 1)
+			createEmptyFile(dir, "002.timeindex")
+			d := datalog{}
+			_, removed := d.cleanUpDir(dir, 7*24*time.Hour)
+			Expect(removed).To(Equal(1))
+			Expect(listFiles(dir)).To(Equal([]string{"002.dlog", "002.index", "002.timeindex"}))
+		})
+	})
+
+	Describe("cleanUpTopics()", func() {
+		It("should use the retention of each topic", func() {
+			dir, err := ioutil.TempDir("", "clean_up_topics_test")
+			Expect(err).NotTo(HaveOccurred())
+			for _, topic := range []string{"default", "forever", "long"} {
+				topicDir := filepath.Join(dir, topic)
+				Expect(os.Mkdir(topicDir, 0755)).NotTo(HaveOccurred())
+				createFilesToClean(topicDir)
+			}
+			defaultRetention := 7 * 24 * time.Hour
+			config := new(mocks.Config)
+			config.On("LogRetentionDuration").Return(&defaultRetention)
+			config.On("LogRetentionBytes").Return(int64(0))
+			d := datalog{config: config, topicGetter: fakeTopicGetter{
+				"forever": {Name: "forever", Settings: TopicSettings{Retention: "null"}},
+				"long":    {Name: "long", Settings: TopicSettings{Retention: "1000h"}},
+			}}
+
+			read, removed, err := d.cleanUpTopics(dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(read).To(Equal(3 + 8 + 8))
+			Expect(removed).To(Equal(2))
+		})
+
+		It("should remove the oldest segments of the topic exceeding the byte budget", func() {
+			dir, err := ioutil.TempDir("", "clean_up_topic_bytes_test")
+			Expect(err).NotTo(HaveOccurred())
+			topicDir := filepath.Join(dir, "t1", "sub_dir")
+			Expect(os.MkdirAll(topicDir, 0755)).NotTo(HaveOccurred())
+			createSegmentFile(topicDir, "001", 100, 4)
+			createSegmentFile(topicDir, "002", 100, 3)
+			createSegmentFile(topicDir, "003", 100, 2)
+			createSegmentFile(topicDir, "004", 100, 1)
+			config := new(mocks.Config)
+			config.On("LogRetentionDuration").Return(nil)
+			config.On("LogRetentionBytes").Return(int64(0))
+			d := datalog{config: config, topicGetter: fakeTopicGetter{
+				"t1": {Name: "t1", Settings: TopicSettings{RetentionBytes: 250}},
+			}}
+
+			_, removed, err := d.cleanUpTopics(dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(removed).To(Equal(2))
+			Expect(listFiles(topicDir)).To(Equal([]string{"003.dlog", "003.index", "004.dlog", "004.index"}))
+		})
+
+		It("should not remove the segments being written when exceeding the broker byte budget", func() {
+			dir, err := ioutil.TempDir("", "clean_up_broker_bytes_test")
+			Expect(err).NotTo(HaveOccurred())
+			t1Dir := filepath.Join(dir, "t1")
+			t2Dir := filepath.Join(dir, "t2")
+			Expect(os.Mkdir(t1Dir, 0755)).NotTo(HaveOccurred())
+			Expect(os.Mkdir(t2Dir, 0755)).NotTo(HaveOccurred())
+			createSegmentFile(t1Dir, "001", 100, 4)
+			createSegmentFile(t2Dir, "001", 100, 3)
+			createSegmentFile(t1Dir, "002", 100, 2)
+			createSegmentFile(t2Dir, "002", 100, 1)
+			openSegments.add(filepath.Join(t1Dir, "001.dlog"))
+			defer openSegments.remove(filepath.Join(t1Dir, "001.dlog"))
+			config := new(mocks.Config)
+			config.On("LogRetentionDuration").Return(nil)
+			config.On("LogRetentionBytes").Return(int64(200))
+			d := datalog{config: config}
+
+			_, removed, err := d.cleanUpTopics(dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(removed).To(Equal(2))
+			Expect(listFiles(t1Dir)).To(Equal([]string{"001.dlog", "001.index"}))
+			Expect(listFiles(t2Dir)).To(Equal([]string{"002.dlog", "002.index"}))
 		})
 	})
 })
 
+// Creates a segment file and its index file with a total size of the provided length, modified days ago
+func createSegmentFile(dir string, prefix string, length int, daysAgo int) {
+	segmentPath := filepath.Join(dir, prefix+".dlog")
+	indexPath := filepath.Join(dir, prefix+".index")
+	Expect(os.WriteFile(segmentPath, make([]byte, length-10), 0644)).NotTo(HaveOccurred())
+	Expect(os.WriteFile(indexPath, make([]byte, 10), 0644)).NotTo(HaveOccurred())
+	modifiedTime := time.Now().AddDate(0, 0, -daysAgo)
+	Expect(os.Chtimes(segmentPath, modifiedTime, modifiedTime)).NotTo(HaveOccurred())
+}
+
+func listFiles(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	Expect(err).NotTo(HaveOccurred())
+	result := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, entry.Name())
+	}
+	return result
+}
+
+type fakeTopicGetter map[string]TopicInfo
+
+func (g fakeTopicGetter) Get(topic string) *TopicInfo {
+	info, found := g[topic]
+	if !found {
+		return nil
+	}
+	return &info
+}
+
 func createFilesToClean(dir string) {
 	const permissions = 0755
 	subDir := filepath.Join(dir, "sub_dir")
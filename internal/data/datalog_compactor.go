@@ -0,0 +1,609 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/polarstreams/polar/internal/conf"
+	"github.com/polarstreams/polar/internal/metrics"
+	"github.com/polarstreams/polar/internal/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// The amount of time a tombstone is kept after being the latest record for a key, so that consumers can observe it
+const compactionTombstoneRetention = 24 * time.Hour
+
+const compactionFileSuffix = ".compacting"
+
+// Prevents segment and index files from being replaced while a reader is looking up the position in the files
+var segmentSwapLock = sync.RWMutex{}
+
+// Represents the latest record of a key in a token range
+type compactionKeyState struct {
+	position  int64 // The position of the record within the token range, in write order
+	tombstone bool
+	timestamp int64
+}
+
+// Represents a closed segment file to compact
+type compactionSegment struct {
+	dirPath string
+	name    string
+}
+
+// Represents a run of consecutive records of a chunk that were kept by the compaction
+type compactionRun struct {
+	start  int64
+	length uint32
+	body   []byte
+}
+
+// Visits the compacted topics under the segments path and compacts the closed segments of each token range
+func (d *datalog) compactTopics(basePath string) (removed int, err error) {
+	entries, err := os.ReadDir(basePath)
+	if err != nil {
+		return 0, err
+	}
+
+	if d.compactedDirs == nil {
+		d.compactedDirs = map[string]string{}
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || d.topicGetter == nil || !d.topicGetter.Get(entry.Name()).IsCompacted() {
+			continue
+		}
+
+		// Group the generation directories by token range: {topic}/{token}/{rangeIndex}/{genVersion}
+		rangePaths := make([]string, 0)
+		generationDirs := map[string][]string{}
+		for _, dirPath := range segmentDirs(filepath.Join(basePath, entry.Name())) {
+			rangePath := filepath.Dir(dirPath)
+			if _, found := generationDirs[rangePath]; !found {
+				rangePaths = append(rangePaths, rangePath)
+			}
+			generationDirs[rangePath] = append(generationDirs[rangePath], dirPath)
+		}
+
+		for _, rangePath := range rangePaths {
+			rangeRemoved, err := d.compactGenerations(rangePath, generationDirs[rangePath])
+			if err != nil {
+				log.Err(err).Msgf("Segment files in %s could not be compacted", rangePath)
+				continue
+			}
+			removed += rangeRemoved
+		}
+	}
+	return removed, nil
+}
+
+// Rewrites the closed segment files of the generations of a token range, keeping only the latest record per key
+// across the generations.
+//
+// The records that are kept maintain their offsets: the chunks are split into runs of consecutive records flagged as
+// compacted, so the readers skip the offsets that were removed. The last record of each segment is always kept, that
+// way the following segment and generation continue from the last offset of the previous one.
+func (d *datalog) compactGenerations(rangePath string, dirPaths []string) (int, error) {
+	segments := closedGenerationSegments(dirPaths)
+	if len(segments) == 0 {
+		return 0, nil
+	}
+
+	signature := segmentsSignature(segments)
+	if d.compactedDirs != nil && d.compactedDirs[rangePath] == signature {
+		// There were no changes since the last compaction
+		return 0, nil
+	}
+
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return 0, err
+	}
+	defer decoder.Close()
+
+	// Find the position of the latest record of each key and the last record of each segment
+	latest := map[string]compactionKeyState{}
+	segmentTails := map[int64]bool{}
+	position := int64(0)
+	for _, segment := range segments {
+		err := readSegmentChunks(filepath.Join(segment.dirPath, segment.name), func(header *chunkHeader, body []byte) error {
+			return readRecords(decoder, body, func(h *RecordHeader) {
+				if len(h.Key) > 0 {
+					latest[string(h.Key)] = compactionKeyState{
+						position:  position,
+						tombstone: h.IsTombstone(),
+						timestamp: h.Timestamp,
+					}
+				}
+				position++
+			})
+		})
+		if err != nil {
+			return 0, err
+		}
+		segmentTails[position-1] = true
+	}
+
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderCRC(true), zstd.WithEncoderLevel(zstd.SpeedDefault))
+	if err != nil {
+		return 0, err
+	}
+	defer encoder.Close()
+
+	tombstoneThreshold := time.Now().Add(-compactionTombstoneRetention).UnixMicro()
+	removed := 0
+	position = 0
+	for _, segment := range segments {
+		segmentRemoved, err := rewriteSegment(segment.dirPath, segment.name, decoder, encoder, func(h *RecordHeader) bool {
+			current := position
+			position++
+			if len(h.Key) == 0 || segmentTails[current] {
+				return true
+			}
+			state := latest[string(h.Key)]
+			if state.position != current {
+				// There's a newer record for the key
+				return false
+			}
+			return !state.tombstone || state.timestamp > tombstoneThreshold
+		})
+		if err != nil {
+			return removed, err
+		}
+		removed += segmentRemoved
+	}
+
+	if removed > 0 {
+		log.Info().Msgf("Compaction removed %d records in %s", removed, rangePath)
+		metrics.DatalogCompactionRemovedRecords.Add(float64(removed))
+	}
+
+	if d.compactedDirs != nil {
+		d.compactedDirs[rangePath] = segmentsSignature(segments)
+	}
+	return removed, nil
+}
+
+// Rewrites the segment file and its index file when there are records to remove.
+// The keep func is invoked for every record in order.
+func rewriteSegment(
+	dirPath string,
+	name string,
+	decoder *zstd.Decoder,
+	encoder *zstd.Encoder,
+	keep func(h *RecordHeader) bool,
+) (int, error) {
+	segmentPath := filepath.Join(dirPath, name)
+	stat, err := os.Stat(segmentPath)
+	if err != nil {
+		return 0, err
+	}
+
+	indexPath := filepath.Join(dirPath, indexFileName(name))
+	indexedOffsets := readIndexOffsets(indexPath)
+	tempSegmentPath := segmentPath + compactionFileSuffix
+	tempIndexPath := indexPath + compactionFileSuffix
+
+	tempFile, err := os.OpenFile(tempSegmentPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, FilePermissions)
+	if err != nil {
+		return 0, err
+	}
+	segmentWriter := &positionWriter{writer: bufio.NewWriter(tempFile)}
+	var indexBuffer bytes.Buffer
+	payloadBuffer := new(bytes.Buffer)
+	removed := 0
+
+	err = readSegmentChunks(segmentPath, func(header *chunkHeader, body []byte) error {
+		runs := make([]compactionRun, 0)
+		run := compactionRun{start: -1}
+		closeRun := func() error {
+			if run.length == 0 {
+				return nil
+			}
+			if err := encoder.Close(); err != nil {
+				return err
+			}
+			run.body = append([]byte(nil), payloadBuffer.Bytes()...)
+			runs = append(runs, run)
+			run = compactionRun{start: -1}
+			return nil
+		}
+
+		offset := header.Start
+		chunkRemoved := 0
+		err := readRecordsWithBody(decoder, body, func(format byte) error {
+			return nil
+		}, func(h *RecordHeader, recordBody []byte, format byte) error {
+			current := offset
+			offset++
+			if !keep(h) {
+				chunkRemoved++
+				return closeRun()
+			}
+			if run.length == 0 {
+				payloadBuffer.Reset()
+				encoder.Reset(payloadBuffer)
+				if err := WriteRecordFormat(encoder, format); err != nil {
+					return err
+				}
+				run.start = current
+			}
+			run.length++
+			if err := WriteRecordHeader(encoder, format, h); err != nil {
+				return err
+			}
+			return utils.WriteBytes(encoder, recordBody)
+		})
+		if err == nil {
+			err = closeRun()
+		}
+		if err != nil {
+			return err
+		}
+
+		if chunkRemoved == 0 {
+			runs = []compactionRun{{start: header.Start, length: header.RecordLength, body: body}}
+		}
+		removed += chunkRemoved
+
+		for i, r := range runs {
+			if i == 0 && indexedOffsets[header.Start] {
+				// The readers expect the indexed positions to be aligned
+				if err := segmentWriter.writeAlignment(); err != nil {
+					return err
+				}
+				appendIndexOffset(&indexBuffer, r.start, segmentWriter.position)
+			}
+			if err := segmentWriter.writeChunk(compactedFlag, r.start, r.length, r.body); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err == nil {
+		err = segmentWriter.writeAlignment()
+	}
+	if err == nil {
+		err = segmentWriter.writer.Flush()
+	}
+	if err == nil {
+		err = tempFile.Sync()
+	}
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil || removed == 0 {
+		_ = os.Remove(tempSegmentPath)
+		return 0, err
+	}
+
+	if err := writeFileSync(tempIndexPath, indexBuffer.Bytes()); err != nil {
+		_ = os.Remove(tempSegmentPath)
+		return 0, err
+	}
+
+	// Maintain the modification time for the time-based retention
+	if err := os.Chtimes(tempSegmentPath, stat.ModTime(), stat.ModTime()); err != nil {
+		log.Warn().Err(err).Msgf("Modification time of the compacted segment %s could not be set", segmentPath)
+	}
+
+	segmentSwapLock.Lock()
+	defer segmentSwapLock.Unlock()
+	if err := os.Rename(tempIndexPath, indexPath); err != nil {
+		_ = os.Remove(tempSegmentPath)
+		return 0, err
+	}
+	if err := os.Rename(tempSegmentPath, segmentPath); err != nil {
+		return 0, err
+	}
+
+	log.Debug().Msgf("Compacted segment file %s removing %d records", segmentPath, removed)
+	return removed, nil
+}
+
+// Writes the segment file chunks keeping track of the file position
+type positionWriter struct {
+	writer   *bufio.Writer
+	position int64
+}
+
+func (w *positionWriter) write(buf []byte) error {
+	n, err := w.writer.Write(buf)
+	w.position += int64(n)
+	return err
+}
+
+// Writes the chunk header and body
+func (w *positionWriter) writeChunk(flags byte, start int64, recordLength uint32, body []byte) error {
+	header := chunkHeader{
+		Flags:        flags,
+		BodyLength:   uint32(len(body)),
+		Start:        start,
+		RecordLength: recordLength,
+	}
+	buf := new(bytes.Buffer)
+	utils.PanicIfErr(binary.Write(buf, conf.Endianness, header), "Error writing chunk header")
+	headerBytes := buf.Bytes()
+	// The checksum is in the last position of the header
+	conf.Endianness.PutUint32(headerBytes[chunkHeaderSize-4:], crc32.ChecksumIEEE(headerBytes[:chunkHeaderSize-4]))
+
+	if err := w.write(headerBytes); err != nil {
+		return err
+	}
+	return w.write(body)
+}
+
+// Writes the alignment bytes when the position is not aligned
+func (w *positionWriter) writeAlignment() error {
+	if rem := w.position % alignmentSize; rem != 0 {
+		return w.write(alignmentBuffer[0 : alignmentSize-rem])
+	}
+	return nil
+}
+
+// Reads the records of the compressed chunk body, discarding the record bodies
+func readRecords(decoder *zstd.Decoder, body []byte, fn func(h *RecordHeader)) error {
+	if err := decoder.Reset(bytes.NewReader(body)); err != nil {
+		return err
+	}
+	reader := NewRecordReader(decoder)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := io.CopyN(io.Discard, decoder, int64(header.Length)); err != nil {
+			return err
+		}
+		fn(header)
+	}
+}
+
+// Reads the records of the compressed chunk body, invoking onFormat before the first record
+func readRecordsWithBody(
+	decoder *zstd.Decoder,
+	body []byte,
+	onFormat func(format byte) error,
+	fn func(h *RecordHeader, recordBody []byte, format byte) error,
+) error {
+	if err := decoder.Reset(bytes.NewReader(body)); err != nil {
+		return err
+	}
+	reader := NewRecordReader(decoder)
+	recordBody := make([]byte, 0)
+	for i := 0; ; i++ {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			if err := onFormat(reader.Format()); err != nil {
+				return err
+			}
+		}
+		if cap(recordBody) < int(header.Length) {
+			recordBody = make([]byte, header.Length)
+		}
+		recordBody = recordBody[:header.Length]
+		if _, err := io.ReadFull(decoder, recordBody); err != nil {
+			return err
+		}
+		if err := fn(header, recordBody, reader.Format()); err != nil {
+			return err
+		}
+	}
+}
+
+// Reads the chunks of a segment file in order
+func readSegmentChunks(segmentPath string, fn func(header *chunkHeader, body []byte) error) error {
+	return readSegmentChunksFrom(segmentPath, 0, fn)
+}
+
+// Reads the chunks of a segment file in order, starting at the provided file position of a chunk
+func readSegmentChunksFrom(
+	segmentPath string,
+	fileOffset int64,
+	fn func(header *chunkHeader, body []byte) error,
+) error {
+	file, err := os.Open(segmentPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if fileOffset > 0 {
+		if _, err := file.Seek(fileOffset, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	reader := bufio.NewReader(file)
+	headerBuf := make([]byte, chunkHeaderSize)
+	readBuf := make([]byte, chunkHeaderSize)
+	for {
+		flag, err := reader.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if flag == alignmentFlag {
+			continue
+		}
+		_ = reader.UnreadByte()
+
+		if _, err := io.ReadFull(reader, headerBuf); err != nil {
+			return fmt.Errorf("Incomplete chunk header in %s: %w", segmentPath, err)
+		}
+		header, err := readChunkHeader(bytes.NewReader(headerBuf), readBuf)
+		if err != nil {
+			return fmt.Errorf("Invalid chunk header in %s: %w", segmentPath, err)
+		}
+		body := make([]byte, header.BodyLength)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return fmt.Errorf("Incomplete chunk body in %s: %w", segmentPath, err)
+		}
+		if err := fn(header, body); err != nil {
+			return err
+		}
+	}
+}
+
+// Gets the set of message offsets contained in the index file
+func readIndexOffsets(indexPath string) map[int64]bool {
+	result := map[int64]bool{}
+	buf, err := os.ReadFile(indexPath)
+	if err != nil {
+		log.Warn().Msgf("Could not read index file at %s", indexPath)
+		return result
+	}
+
+	for len(buf) >= indexItemSize {
+		item := indexOffset{}
+		expectedChecksum := crc32.ChecksumIEEE(buf[:indexItemSize-4])
+		utils.PanicIfErr(binary.Read(bytes.NewReader(buf), conf.Endianness, &item), "Error reading index item")
+		if item.Checksum != expectedChecksum {
+			log.Warn().Msgf("Invalid index file checksum on %s (%d)", indexPath, item.Checksum)
+			break
+		}
+		result[item.Offset] = true
+		buf = buf[indexItemSize:]
+	}
+	return result
+}
+
+func appendIndexOffset(w *bytes.Buffer, offset int64, fileOffset int64) {
+	start := w.Len()
+	utils.PanicIfErr(binary.Write(w, conf.Endianness, offset), "Error writing offset")
+	utils.PanicIfErr(binary.Write(w, conf.Endianness, fileOffset), "Error writing file offset")
+	checksum := crc32.ChecksumIEEE(w.Bytes()[start:])
+	utils.PanicIfErr(binary.Write(w, conf.Endianness, checksum), "Error writing checksum")
+}
+
+func writeFileSync(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, FilePermissions)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Gets the segment files of the generation directories that are not being written, sorted by generation version
+// and segment id
+func closedGenerationSegments(dirPaths []string) []compactionSegment {
+	sorted := append([]string(nil), dirPaths...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return generationVersion(sorted[i]) < generationVersion(sorted[j])
+	})
+
+	result := make([]compactionSegment, 0)
+	for i, dirPath := range sorted {
+		// The generations with a newer version are closed
+		names, open := closedSegments(dirPath, i < len(sorted)-1)
+		for _, name := range names {
+			result = append(result, compactionSegment{dirPath: dirPath, name: name})
+		}
+		if open {
+			// The following records must not be compacted before the previous ones
+			break
+		}
+	}
+	return result
+}
+
+// Gets the names of the segment files in the directory that are not being written, sorted by segment id, and whether
+// there are segments open.
+// The last segment is considered open unless the generation is closed.
+func closedSegments(dirPath string, closedGeneration bool) ([]string, bool) {
+	entries, err := filepath.Glob(fmt.Sprintf("%s/*.%s", dirPath, conf.SegmentFileExtension))
+	if err != nil {
+		return nil, true
+	}
+
+	sort.Strings(entries)
+	if !closedGeneration && len(entries) > 0 {
+		entries = entries[:len(entries)-1]
+	}
+	result := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if openSegments.contains(entry) {
+			return result, true
+		}
+		result = append(result, filepath.Base(entry))
+	}
+	return result, !closedGeneration
+}
+
+// Gets the generation version from the directory name
+func generationVersion(dirPath string) int64 {
+	version, err := strconv.ParseInt(filepath.Base(dirPath), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return version
+}
+
+// Gets the directories containing segment files under the provided path
+func segmentDirs(dirPath string) []string {
+	result := make([]string, 0)
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return result
+	}
+
+	hasSegments := false
+	for _, entry := range entries {
+		if entry.IsDir() {
+			result = append(result, segmentDirs(filepath.Join(dirPath, entry.Name()))...)
+		} else if filepath.Ext(entry.Name()) == "."+conf.SegmentFileExtension {
+			hasSegments = true
+		}
+	}
+
+	if hasSegments {
+		result = append(result, dirPath)
+	}
+	return result
+}
+
+// Gets a value that changes when the segment files are modified
+func segmentsSignature(segments []compactionSegment) string {
+	parts := make([]string, 0, len(segments))
+	for _, segment := range segments {
+		size := int64(-1)
+		if stat, err := os.Stat(filepath.Join(segment.dirPath, segment.name)); err == nil {
+			size = stat.Size()
+		}
+		parts = append(parts, fmt.Sprintf("%s:%d", filepath.Join(segment.dirPath, segment.name), size))
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,237 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/polarstreams/polar/internal/conf"
+)
+
+type testRecord struct {
+	key       string
+	body      string
+	timestamp int64
+}
+
+var _ = Describe("datalog", func() {
+	Describe("compactGenerations()", func() {
+		It("should keep the latest record per key in the closed segments", func() {
+			dir, err := ioutil.TempDir("", "compact_dir_test")
+			Expect(err).NotTo(HaveOccurred())
+			now := time.Now().UnixMicro()
+			old := time.Now().Add(-48 * time.Hour).UnixMicro()
+
+			writeTestSegment(dir, 0, map[int64][]testRecord{
+				0: {{"k1", "a", now}, {"k2", "b", now}},
+				2: {{"k1", "c", now}, {"", "no key", now}},
+			}, []int64{0, 2})
+			writeTestSegment(dir, 4, map[int64][]testRecord{
+				4: {{"k2", "", old}, {"k3", "d", now}, {"k4", "", now}},
+			}, nil)
+			// The last segment is considered open
+			writeTestSegment(dir, 7, map[int64][]testRecord{
+				7: {{"k1", "e", now}},
+			}, nil)
+
+			d := datalog{}
+			removed, err := d.compactGenerations(dir, []string{dir})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(removed).To(Equal(3))
+
+			Expect(readTestSegment(dir, 0)).To(Equal(map[int64][]testRecord{
+				2: {{"k1", "c", now}, {"", "no key", now}},
+			}))
+			Expect(readTestSegment(dir, 4)).To(Equal(map[int64][]testRecord{
+				5: {{"k3", "d", now}, {"k4", "", now}},
+			}))
+			Expect(readTestSegment(dir, 7)).To(Equal(map[int64][]testRecord{
+				7: {{"k1", "e", now}},
+			}))
+
+			// The index file should point to the aligned position of the chunk
+			fileOffset := tryReadIndexFile(dir, conf.SegmentFilePrefix(0), 2)
+			Expect(fileOffset % alignmentSize).To(BeZero())
+			file, err := os.Open(filepath.Join(dir, conf.SegmentFileName(0)))
+			Expect(err).NotTo(HaveOccurred())
+			defer file.Close()
+			_, err = file.Seek(fileOffset, io.SeekStart)
+			Expect(err).NotTo(HaveOccurred())
+			headerBuf := make([]byte, chunkHeaderSize)
+			_, err = io.ReadFull(file, headerBuf)
+			Expect(err).NotTo(HaveOccurred())
+			header, err := readChunkHeader(bytes.NewReader(headerBuf), make([]byte, chunkHeaderSize))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(header.Start).To(Equal(int64(2)))
+			Expect(header.RecordLength).To(Equal(uint32(2)))
+			Expect(header.Flags).To(Equal(compactedFlag))
+
+			// Subsequent calls should not remove more records
+			removed, err = d.compactGenerations(dir, []string{dir})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(removed).To(Equal(0))
+		})
+
+		It("should split the chunks into runs of consecutive records", func() {
+			dir, err := ioutil.TempDir("", "compact_runs_test")
+			Expect(err).NotTo(HaveOccurred())
+			now := time.Now().UnixMicro()
+
+			writeTestSegment(dir, 0, map[int64][]testRecord{
+				0: {{"k1", "a", now}, {"k2", "b", now}, {"k3", "c", now}, {"k4", "d", now}, {"k5", "e", now}},
+				5: {{"k2", "f", now}, {"k4", "g", now}},
+			}, []int64{0})
+			writeTestSegment(dir, 7, map[int64][]testRecord{
+				7: {{"k1", "h", now}},
+			}, nil)
+
+			d := datalog{}
+			removed, err := d.compactGenerations(dir, []string{dir})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(removed).To(Equal(2))
+			Expect(readTestSegment(dir, 0)).To(Equal(map[int64][]testRecord{
+				0: {{"k1", "a", now}},
+				2: {{"k3", "c", now}},
+				4: {{"k5", "e", now}},
+				5: {{"k2", "f", now}, {"k4", "g", now}},
+			}))
+			Expect(tryReadIndexFile(dir, conf.SegmentFilePrefix(0), 0)).To(BeZero())
+		})
+
+		It("should compact across the generations of the token range", func() {
+			dir, err := ioutil.TempDir("", "compact_generations_test")
+			Expect(err).NotTo(HaveOccurred())
+			now := time.Now().UnixMicro()
+			gen1 := filepath.Join(dir, "1")
+			gen2 := filepath.Join(dir, "2")
+			Expect(os.MkdirAll(gen1, 0755)).To(Succeed())
+			Expect(os.MkdirAll(gen2, 0755)).To(Succeed())
+
+			// The last segment of the previous generation is closed
+			writeTestSegment(gen1, 0, map[int64][]testRecord{
+				0: {{"k1", "a", now}, {"k2", "b", now}},
+			}, nil)
+			writeTestSegment(gen1, 2, map[int64][]testRecord{
+				2: {{"k1", "c", now}, {"k3", "d", now}, {"k4", "e", now}},
+			}, nil)
+			writeTestSegment(gen2, 0, map[int64][]testRecord{
+				0: {{"k2", "f", now}, {"k3", "g", now}, {"k5", "h", now}},
+			}, nil)
+			writeTestSegment(gen2, 3, map[int64][]testRecord{
+				3: {{"k4", "i", now}},
+			}, nil)
+
+			d := datalog{}
+			removed, err := d.compactGenerations(dir, []string{gen2, gen1})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(removed).To(Equal(2))
+
+			Expect(readTestSegment(gen1, 0)).To(Equal(map[int64][]testRecord{
+				1: {{"k2", "b", now}},
+			}))
+			// The last record of the segment is kept
+			Expect(readTestSegment(gen1, 2)).To(Equal(map[int64][]testRecord{
+				2: {{"k1", "c", now}},
+				4: {{"k4", "e", now}},
+			}))
+			Expect(readTestSegment(gen2, 0)).To(Equal(map[int64][]testRecord{
+				0: {{"k2", "f", now}, {"k3", "g", now}, {"k5", "h", now}},
+			}))
+		})
+
+		It("should not rewrite legacy segments", func() {
+			dir, err := ioutil.TempDir("", "compact_dir_legacy_test")
+			Expect(err).NotTo(HaveOccurred())
+			createFilesToClean(dir)
+			createSegmentFile(dir, conf.SegmentFilePrefix(0), 100, 1)
+			createSegmentFile(dir, conf.SegmentFilePrefix(10), 100, 1)
+
+			d := datalog{}
+			_, err = d.compactGenerations(dir, []string{filepath.Join(dir, "sub_dir")})
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+})
+
+// Writes a segment file with keyed chunks and the index file with the provided offsets
+func writeTestSegment(dir string, segmentId int64, chunks map[int64][]testRecord, indexed []int64) {
+	encoder, err := zstd.NewWriter(nil)
+	Expect(err).NotTo(HaveOccurred())
+	file, err := os.Create(filepath.Join(dir, conf.SegmentFileName(segmentId)))
+	Expect(err).NotTo(HaveOccurred())
+	defer file.Close()
+	writer := &positionWriter{writer: bufio.NewWriter(file)}
+	index := new(bytes.Buffer)
+	isIndexed := map[int64]bool{}
+	for _, offset := range indexed {
+		isIndexed[offset] = true
+	}
+
+	for _, start := range sortedKeys(chunks) {
+		records := chunks[start]
+		payload := new(bytes.Buffer)
+		encoder.Reset(payload)
+		Expect(WriteRecordFormat(encoder, RecordFormatKeyed)).NotTo(HaveOccurred())
+		for _, r := range records {
+			key := []byte(r.key)
+			if r.key == "" {
+				key = nil
+			}
+			writeTestRecord(encoder, RecordFormatKeyed, r.timestamp, key, r.body)
+		}
+		Expect(encoder.Close()).NotTo(HaveOccurred())
+
+		if isIndexed[start] {
+			Expect(writer.writeAlignment()).NotTo(HaveOccurred())
+			appendIndexOffset(index, start, writer.position)
+		}
+		Expect(writer.writeChunk(0, start, uint32(len(records)), payload.Bytes())).NotTo(HaveOccurred())
+	}
+	Expect(writer.writeAlignment()).NotTo(HaveOccurred())
+	Expect(writer.writer.Flush()).NotTo(HaveOccurred())
+
+	indexPath := filepath.Join(dir, conf.SegmentFilePrefix(segmentId)+"."+conf.IndexFileExtension)
+	Expect(os.WriteFile(indexPath, index.Bytes(), 0644)).NotTo(HaveOccurred())
+}
+
+// Reads the records of a segment by chunk start offset
+func readTestSegment(dir string, segmentId int64) map[int64][]testRecord {
+	decoder, err := zstd.NewReader(nil)
+	Expect(err).NotTo(HaveOccurred())
+	result := map[int64][]testRecord{}
+	segmentPath := filepath.Join(dir, conf.SegmentFileName(segmentId))
+	err = readSegmentChunks(segmentPath, func(header *chunkHeader, body []byte) error {
+		records := make([]testRecord, 0)
+		err := readRecordsWithBody(decoder, body, func(format byte) error {
+			Expect(format).To(Equal(RecordFormatKeyed))
+			return nil
+		}, func(h *RecordHeader, recordBody []byte, format byte) error {
+			records = append(records, testRecord{string(h.Key), string(recordBody), h.Timestamp})
+			return nil
+		})
+		result[header.Start] = records
+		return err
+	})
+	Expect(err).NotTo(HaveOccurred())
+	return result
+}
+
+func sortedKeys(m map[int64][]testRecord) []int64 {
+	result := make([]int64, 0, len(m))
+	for k := range m {
+		result = append(result, k)
+	}
+	for i := 1; i < len(result); i++ {
+		for j := i; j > 0 && result[j] < result[j-1]; j-- {
+			result[j], result[j-1] = result[j-1], result[j]
+		}
+	}
+	return result
+}
@@ -73,6 +73,23 @@ var _ = Describe("datalog", func() {
 			Expect(obtainedBuf).To(Equal(chunk2))
 		})
 
+		It("should return the following compacted chunk when the offset was removed", func() {
+			chunk1 := createAlignedChunk(480, 100, 50)
+			chunk2 := createTestChunkWithFlags(compactedFlag, 200, 160, 40)
+			chunks := append(chunk1, chunk2...)
+			obtainedBuf, complete, err := readChunksUntil(chunks, 150, 300)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(complete).To(BeTrue())
+			Expect(obtainedBuf).To(Equal(chunk2))
+
+			// Gaps in chunks that were not compacted are not skipped
+			chunks = append(createAlignedChunk(480, 100, 50), createTestChunk(200, 160, 40)...)
+			obtainedBuf, complete, err = readChunksUntil(chunks, 150, 300)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(complete).To(BeFalse())
+			Expect(obtainedBuf).To(BeNil())
+		})
+
 		It("should return not completed when header is not contained", func() {
 			chunk2 := createTestChunk(200, 100, 50)
 			chunks := append(createTestChunk(200, 0, 100), chunk2[:chunkHeaderSize-2]...)
@@ -1,6 +1,7 @@
 package data
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"hash/crc32"
@@ -37,7 +38,9 @@ func newIndexFileWriter(basePath string, config conf.DatalogConfig) *indexFileWr
 func (w *indexFileWriter) writeLoop() {
 	var segmentId *int64
 	var file *os.File
+	var timeFile *os.File
 	lastStoredFileOffset := int64(0)
+	lastTimeOffset := int64(-1)
 	buffer := utils.NewBufferCap(16)
 	writeThreshold := int64(w.config.IndexFilePeriodBytes())
 	w.offsetWriter.create(w.basePath)
@@ -57,6 +60,17 @@ func (w *indexFileWriter) writeLoop() {
 				segmentId = nil
 				lastStoredFileOffset = 0
 			}
+			if timeFile != nil {
+				// Store the max timestamp of the whole segment
+				if item.tailOffset > lastTimeOffset {
+					w.writeTimeEntry(timeFile, buffer, item.maxTimestamp, item.tailOffset)
+				}
+				if err := timeFile.Close(); err != nil {
+					log.Err(err).Msgf("Time index file closed with error on path %s", w.basePath)
+				}
+				timeFile = nil
+				lastTimeOffset = -1
+			}
 			continue
 		}
 
@@ -72,6 +86,14 @@ func (w *indexFileWriter) writeLoop() {
 			file = f
 			id := item.segmentId
 			segmentId = &id
+
+			timeFileName := fmt.Sprintf("%020d.%s", item.segmentId, conf.TimeIndexFileExtension)
+			tf, err := os.OpenFile(filepath.Join(w.basePath, timeFileName), conf.IndexFileWriteFlags, FilePermissions)
+			if err != nil {
+				// The time index will be rebuilt on demand
+				log.Err(err).Msgf("Time index file %s could not be created on path %s", timeFileName, w.basePath)
+			}
+			timeFile = tf
 		}
 
 		if item.fileOffset-lastStoredFileOffset >= writeThreshold {
@@ -87,6 +109,11 @@ func (w *indexFileWriter) writeLoop() {
 				log.Debug().Msgf("Written to %d index file on path %s", *segmentId, w.basePath)
 			}
 			lastStoredFileOffset = item.fileOffset
+
+			if timeFile != nil {
+				w.writeTimeEntry(timeFile, buffer, item.maxTimestamp, item.tailOffset)
+				lastTimeOffset = item.tailOffset
+			}
 		}
 	}
 
@@ -94,22 +121,39 @@ func (w *indexFileWriter) writeLoop() {
 	w.closed <- true
 }
 
+// Adds a line to the time index file mapping the max timestamp with the message offset
+func (w *indexFileWriter) writeTimeEntry(file *os.File, buffer *bytes.Buffer, timestamp int64, offset int64) {
+	buffer.Reset()
+	writeTimeIndexEntry(buffer, timestamp, offset)
+	if _, err := file.Write(buffer.Bytes()); err != nil {
+		log.Err(err).Msgf("There was an error writing to the time index file on path %s", w.basePath)
+	}
+}
+
 // When conditions apply, it adds a line to the index file mapping file offset with message offset
 // in the background.
-func (w *indexFileWriter) append(segmentId int64, offset int64, fileOffset int64, tailOffset int64) {
+func (w *indexFileWriter) append(
+	segmentId int64,
+	offset int64,
+	fileOffset int64,
+	tailOffset int64,
+	maxTimestamp int64,
+) {
 	w.items <- indexFileItem{
-		segmentId:  segmentId,
-		offset:     offset,
-		fileOffset: fileOffset,
-		tailOffset: tailOffset,
+		segmentId:    segmentId,
+		offset:       offset,
+		fileOffset:   fileOffset,
+		tailOffset:   tailOffset,
+		maxTimestamp: maxTimestamp,
 	}
 }
 
 // Closes the current file in the background
-func (w *indexFileWriter) closeFile(segmentId int64, tailOffset int64) {
+func (w *indexFileWriter) closeFile(segmentId int64, tailOffset int64, maxTimestamp int64) {
 	w.items <- indexFileItem{
-		segmentId:  segmentId,
-		tailOffset: tailOffset,
-		toClose:    true,
+		segmentId:    segmentId,
+		tailOffset:   tailOffset,
+		maxTimestamp: maxTimestamp,
+		toClose:      true,
 	}
 }
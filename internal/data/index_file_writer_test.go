@@ -35,17 +35,17 @@ var _ = Describe("indexFileWriter", func() {
 		go w.writeLoop()
 
 		segmentId := int64(123)
-		w.append(segmentId, 0, 100, 0)
+		w.append(segmentId, 0, 100, 0, 0)
 		assertStored(dir, segmentId, []indexOffset{}) // Nothing stored
-		w.append(segmentId, 150, 200, 0)
+		w.append(segmentId, 150, 200, 0, 0)
 
 		expected := []indexOffset{{Offset: 150, FileOffset: 200}}
 
 		assertStored(dir, segmentId, expected)
 
-		w.append(segmentId, 180, 250, 0)
-		w.append(segmentId, 190, 350, 0)
-		w.append(segmentId, 191, 420, 0)
+		w.append(segmentId, 180, 250, 0, 0)
+		w.append(segmentId, 190, 350, 0, 0)
+		w.append(segmentId, 191, 420, 0, 0)
 
 		expected = append(expected, indexOffset{Offset: 191, FileOffset: 420})
 		assertStored(dir, segmentId, expected)
@@ -70,17 +70,17 @@ var _ = Describe("indexFileWriter", func() {
 		go w.writeLoop()
 
 		segmentId := int64(123)
-		w.append(segmentId, 0, 100, 0)
+		w.append(segmentId, 0, 100, 0, 0)
 		assertStored(dir, segmentId, []indexOffset{}) // Nothing stored
-		w.closeFile(segmentId, 0)
+		w.closeFile(segmentId, 0, 0)
 
 		// Noop
 		segmentId += 1
-		w.closeFile(segmentId, 0)
+		w.closeFile(segmentId, 0, 0)
 
 		segmentId += 1
-		w.append(segmentId, 352, 1001, 0)
-		w.closeFile(segmentId, 0)
+		w.append(segmentId, 352, 1001, 0, 0)
+		w.closeFile(segmentId, 0, 0)
 
 		assertStored(dir, segmentId, []indexOffset{{Offset: 352, FileOffset: 1001}})
 
@@ -104,17 +104,69 @@ var _ = Describe("indexFileWriter", func() {
 		go w.writeLoop()
 
 		segmentId := int64(123)
-		w.append(segmentId, 0, 100, 50)
+		w.append(segmentId, 0, 100, 50, 0)
 		assertProducerOffsetStored(dir, 50)
-		w.append(segmentId, 150, 200, 180)
+		w.append(segmentId, 150, 200, 180, 0)
 		assertProducerOffsetStored(dir, 180)
-		w.closeFile(segmentId, 190)
+		w.closeFile(segmentId, 190, 0)
 		assertProducerOffsetStored(dir, 190)
 
 		close(w.items)
 	})
+
+	It("should write the max timestamp to the time index file", func() {
+		config := new(mocks.Config)
+		config.On("IndexFilePeriodBytes").Return(200)
+
+		dir, err := ioutil.TempDir("", "test_time_index")
+		Expect(err).NotTo(HaveOccurred())
+
+		w := &indexFileWriter{
+			items:        make(chan indexFileItem),
+			basePath:     dir,
+			config:       config,
+			offsetWriter: newOffsetFileWriter(),
+		}
+
+		go w.writeLoop()
+
+		segmentId := int64(123)
+		w.append(segmentId, 123, 0, 149, 1000)
+		w.append(segmentId, 150, 100, 179, 1100)
+		w.append(segmentId, 180, 200, 189, 1200)
+		assertTimeIndexStored(dir, segmentId, []timeIndexEntry{{Timestamp: 1200, Offset: 189}})
+
+		// The last entry is stored when closing
+		w.append(segmentId, 190, 250, 199, 1300)
+		w.closeFile(segmentId, 199, 1300)
+		assertTimeIndexStored(dir, segmentId, []timeIndexEntry{
+			{Timestamp: 1200, Offset: 189},
+			{Timestamp: 1300, Offset: 199},
+		})
+
+		close(w.items)
+	})
 })
 
+func assertTimeIndexStored(basePath string, segmentId int64, values []timeIndexEntry) {
+	timeIndexPath := filepath.Join(basePath, fmt.Sprintf("%020d.%s", segmentId, conf.TimeIndexFileExtension))
+	var entries []timeIndexEntry
+	// Wait for the data to be stored in the file
+	for i := 0; i < 2500; i++ {
+		time.Sleep(20 * time.Millisecond)
+		if result, err := readTimeIndexFile(timeIndexPath); err == nil && len(result) == len(values) {
+			entries = result
+			break
+		}
+	}
+
+	Expect(entries).To(HaveLen(len(values)))
+	for i, value := range values {
+		Expect(entries[i].Timestamp).To(Equal(value.Timestamp))
+		Expect(entries[i].Offset).To(Equal(value.Offset))
+	}
+}
+
 func assertStored(basePath string, segmentId int64, values []indexOffset) {
 	expectedFileLength := utils.BinarySize(indexOffset{}) * len(values) // 8 + 8 + 4
 	var blob []byte
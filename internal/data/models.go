@@ -1,6 +1,9 @@
 package data
 
 import (
+	"sync"
+	"time"
+
 	. "github.com/polarstreams/polar/internal/types"
 	"github.com/polarstreams/polar/internal/utils"
 )
@@ -10,6 +13,95 @@ const alignmentSize = 512
 
 var emptyBuffer = make([]byte, 0)
 
+// Gets the information of a topic, including its settings.
+// Defined here to avoid depending on the topics package.
+type TopicInfoGetter interface {
+	Get(topic string) *TopicInfo
+}
+
+// Represents a segment file on disk, used to apply the retention policies
+type segmentFileInfo struct {
+	dirPath string
+	name    string
+	size    int64 // The size of the segment file and its index file
+	modTime time.Time
+}
+
+// Represents a thread-safe set of file paths
+type pathSet struct {
+	mu    sync.Mutex
+	paths map[string]bool
+}
+
+func newPathSet() *pathSet {
+	return &pathSet{paths: map[string]bool{}}
+}
+
+func (s *pathSet) add(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paths[path] = true
+}
+
+func (s *pathSet) remove(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.paths, path)
+}
+
+func (s *pathSet) contains(path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paths[path]
+}
+
+// Represents a thread-safe set of channels per topic that are signaled when data of the topic is written
+type topicWaiters struct {
+	mu      sync.Mutex
+	waiters map[string]map[chan<- struct{}]bool
+}
+
+func newTopicWaiters() *topicWaiters {
+	return &topicWaiters{waiters: map[string]map[chan<- struct{}]bool{}}
+}
+
+func (w *topicWaiters) add(topics []string, c chan<- struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, topic := range topics {
+		channels, found := w.waiters[topic]
+		if !found {
+			channels = map[chan<- struct{}]bool{}
+			w.waiters[topic] = channels
+		}
+		channels[c] = true
+	}
+}
+
+func (w *topicWaiters) remove(topics []string, c chan<- struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, topic := range topics {
+		channels := w.waiters[topic]
+		delete(channels, c)
+		if len(channels) == 0 {
+			delete(w.waiters, topic)
+		}
+	}
+}
+
+// Signals the channels of the topic without blocking, a channel that was already signaled is not signaled again
+func (w *topicWaiters) notify(topic string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for c := range w.waiters[topic] {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+}
+
 type LocalWriteItem interface {
 	SegmentChunk
 	Replication() ReplicationInfo
@@ -48,24 +140,27 @@ var chunkHeaderSize = utils.BinarySize(chunkHeader{})
 // Represents a queued message to read from a segment.
 // When the read is completed, `SetResult()` is invoked.
 type ReadItem interface {
-	Origin() string   // An identifier of the source of the poll used to determine whether the reader should use the last stored offset and not auto commit
-	CommitOnly() bool // Determines whether it should only commit and not read as part of this request
+	Origin() string     // An identifier of the source of the poll used to determine whether the reader should use the last stored offset and not auto commit
+	CommitOnly() bool   // Determines whether it should only commit and not read as part of this request
+	CommitLimit() int64 // The offset the commit can not move past, OffsetCompleted when the commit is not limited
 	SetResult(error, SegmentChunk)
 }
 
 // Represents a queued message to write to the index file.
 type indexFileItem struct {
-	segmentId  int64
-	offset     int64 // The message offset
-	fileOffset int64
-	toClose    bool
-	tailOffset int64
+	segmentId    int64
+	offset       int64 // The message offset
+	fileOffset   int64
+	toClose      bool
+	tailOffset   int64
+	maxTimestamp int64 // The max record timestamp of the segment up to the tail offset
 }
 
 type ReadSegmentChunk struct {
-	Buffer []byte
-	Start  int64  // The offset of the first message
-	Length uint32 // The amount of messages in the chunk
+	Buffer    []byte
+	Start     int64  // The offset of the first message
+	Length    uint32 // The amount of messages in the chunk
+	compacted bool   // Determines whether the records before the chunk might have been removed by compaction
 }
 
 func NewEmptyChunk(start int64) SegmentChunk {
@@ -88,6 +183,10 @@ func (s *ReadSegmentChunk) RecordLength() uint32 {
 	return s.Length
 }
 
+func (s *ReadSegmentChunk) MaxTimestamp() int64 {
+	return 0
+}
+
 type writerType string
 
 const (
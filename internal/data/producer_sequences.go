@@ -0,0 +1,117 @@
+package data
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/polarstreams/polar/internal/conf"
+	. "github.com/polarstreams/polar/internal/types"
+	"github.com/rs/zerolog/log"
+)
+
+// The amount of recent sequences tracked per idempotent producer
+const ProducerSequenceWindow = 32
+
+// Reads the sequences of the idempotent producers stored in the segment files of the generation that were modified
+// after the provided time, keeping the most recent ProducerSequenceWindow sequences of each producer along with the
+// offset of the first record of each sequence.
+//
+// Returns false when there are no segment files of the generation on local disk.
+func ReadProducerSequences(
+	config conf.DatalogConfig,
+	topic *TopicDataId,
+	since time.Time,
+) ([]ProducerSequenceInfo, bool, error) {
+	dirPath := config.DatalogPath(topic)
+	entries, err := filepath.Glob(fmt.Sprintf("%s/*.%s", dirPath, conf.SegmentFileExtension))
+	if err != nil || len(entries) == 0 {
+		return nil, false, err
+	}
+	sort.Strings(entries)
+
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, true, err
+	}
+	defer decoder.Close()
+
+	producers := make([]string, 0)
+	sequences := map[string][]ProducerSequenceInfo{}
+	for _, segmentPath := range entries {
+		if stat, err := os.Stat(segmentPath); err != nil || stat.ModTime().Before(since) {
+			// The records of the segment are older than the provided time
+			continue
+		}
+
+		err = readSegmentChunks(segmentPath, func(chunk *chunkHeader, body []byte) error {
+			offset := chunk.Start
+			return readRecords(decoder, body, func(h *RecordHeader) {
+				current := offset
+				offset++
+				producerId := h.HeaderValue(RecordHeaderProducerId)
+				if producerId == "" {
+					return
+				}
+				sequence, err := strconv.ParseInt(h.HeaderValue(RecordHeaderProducerSequence), 10, 64)
+				if err != nil {
+					return
+				}
+
+				list, found := sequences[producerId]
+				if !found {
+					producers = append(producers, producerId)
+				}
+				if len(list) > 0 && list[len(list)-1].Sequence == sequence {
+					// The records of a request share the sequence
+					return
+				}
+				list = append(list, ProducerSequenceInfo{ProducerId: producerId, Sequence: sequence, Offset: current})
+				if len(list) > ProducerSequenceWindow {
+					list = list[1:]
+				}
+				sequences[producerId] = list
+			})
+		})
+
+		if err != nil {
+			// The tail of the segment might not be complete, use the sequences read so far
+			log.Debug().Err(err).Msgf("Producer sequences could not be fully read from %s", segmentPath)
+		}
+	}
+
+	result := make([]ProducerSequenceInfo, 0)
+	for _, producerId := range producers {
+		result = append(result, sequences[producerId]...)
+	}
+	return result, true, nil
+}
+
+// Gets the greatest generation version that is lower than the one of the topic with data on local disk
+func PreviousLocalVersion(config conf.DatalogConfig, topic *TopicDataId) (GenVersion, bool) {
+	entries, err := os.ReadDir(filepath.Dir(config.DatalogPath(topic)))
+	if err != nil {
+		return 0, false
+	}
+
+	found := false
+	var result GenVersion
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		value, err := strconv.ParseUint(entry.Name(), 10, 32)
+		if err != nil {
+			continue
+		}
+		if v := GenVersion(value); v < topic.Version && (!found || v > result) {
+			result = v
+			found = true
+		}
+	}
+	return result, found
+}
@@ -0,0 +1,141 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/polarstreams/polar/internal/conf"
+	"github.com/polarstreams/polar/internal/test/conf/mocks"
+	. "github.com/polarstreams/polar/internal/types"
+	"github.com/stretchr/testify/mock"
+)
+
+var _ = Describe("ReadProducerSequences()", func() {
+	It("should read the sequences of every segment of the generation", func() {
+		dir, err := ioutil.TempDir("", "producer_sequences_test")
+		Expect(err).NotTo(HaveOccurred())
+		config := new(mocks.Config)
+		config.On("DatalogPath", mock.Anything).Return(func(t *TopicDataId) string {
+			return filepath.Join(dir, t.Version.String())
+		})
+
+		writeSequencesSegment(filepath.Join(dir, "3"), 0, "p1", 1, 1, 2)
+		writeSequencesSegment(filepath.Join(dir, "3"), 10, "p2", 1)
+		writeSequencesSegment(filepath.Join(dir, "3"), 20, "p1", 3, 4)
+
+		result, found, err := ReadProducerSequences(config, &TopicDataId{Name: "abc", Version: 3}, time.Time{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeTrue())
+		Expect(result).To(Equal([]ProducerSequenceInfo{
+			{ProducerId: "p1", Sequence: 1, Offset: 0},
+			{ProducerId: "p1", Sequence: 2, Offset: 2},
+			{ProducerId: "p1", Sequence: 3, Offset: 20},
+			{ProducerId: "p1", Sequence: 4, Offset: 21},
+			{ProducerId: "p2", Sequence: 1, Offset: 10},
+		}))
+	})
+
+	It("should keep the most recent sequences of each producer", func() {
+		dir, err := ioutil.TempDir("", "producer_sequences_window_test")
+		Expect(err).NotTo(HaveOccurred())
+		config := new(mocks.Config)
+		config.On("DatalogPath", mock.Anything).Return(dir)
+
+		sequences := make([]int64, 0)
+		for i := 0; i < ProducerSequenceWindow+10; i++ {
+			sequences = append(sequences, int64(i))
+		}
+		writeSequencesSegment(dir, 0, "p1", sequences...)
+
+		result, _, err := ReadProducerSequences(config, &TopicDataId{Version: 1}, time.Time{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(HaveLen(ProducerSequenceWindow))
+		Expect(result[0]).To(Equal(ProducerSequenceInfo{ProducerId: "p1", Sequence: 10, Offset: 10}))
+	})
+
+	It("should skip the segments modified before the provided time", func() {
+		dir, err := ioutil.TempDir("", "producer_sequences_since_test")
+		Expect(err).NotTo(HaveOccurred())
+		config := new(mocks.Config)
+		config.On("DatalogPath", mock.Anything).Return(dir)
+
+		writeSequencesSegment(dir, 0, "p1", 1)
+		writeSequencesSegment(dir, 10, "p1", 2)
+		old := time.Now().Add(-2 * time.Hour)
+		Expect(os.Chtimes(filepath.Join(dir, conf.SegmentFileName(0)), old, old)).To(Succeed())
+
+		result, found, err := ReadProducerSequences(config, &TopicDataId{Version: 1}, time.Now().Add(-time.Hour))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeTrue())
+		Expect(result).To(Equal([]ProducerSequenceInfo{{ProducerId: "p1", Sequence: 2, Offset: 10}}))
+	})
+
+	It("should return not found when there's no data of the generation", func() {
+		dir, err := ioutil.TempDir("", "producer_sequences_empty_test")
+		Expect(err).NotTo(HaveOccurred())
+		config := new(mocks.Config)
+		config.On("DatalogPath", mock.Anything).Return(filepath.Join(dir, "1"))
+
+		result, found, err := ReadProducerSequences(config, &TopicDataId{Version: 1}, time.Time{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeFalse())
+		Expect(result).To(BeEmpty())
+	})
+})
+
+var _ = Describe("PreviousLocalVersion()", func() {
+	It("should get the greatest lower version on disk", func() {
+		dir, err := ioutil.TempDir("", "producer_sequences_version_test")
+		Expect(err).NotTo(HaveOccurred())
+		config := new(mocks.Config)
+		config.On("DatalogPath", mock.Anything).Return(func(t *TopicDataId) string {
+			return filepath.Join(dir, t.Version.String())
+		})
+		for _, name := range []string{"1", "3", "5"} {
+			Expect(os.MkdirAll(filepath.Join(dir, name), 0755)).To(Succeed())
+		}
+
+		version, found := PreviousLocalVersion(config, &TopicDataId{Version: 5})
+		Expect(found).To(BeTrue())
+		Expect(version).To(Equal(GenVersion(3)))
+
+		_, found = PreviousLocalVersion(config, &TopicDataId{Version: 1})
+		Expect(found).To(BeFalse())
+	})
+})
+
+// Writes a segment file with a single chunk containing records with the producer sequences
+func writeSequencesSegment(dir string, segmentId int64, producerId string, sequences ...int64) {
+	Expect(os.MkdirAll(dir, 0755)).NotTo(HaveOccurred())
+	encoder, err := zstd.NewWriter(nil)
+	Expect(err).NotTo(HaveOccurred())
+	payload := new(bytes.Buffer)
+	encoder.Reset(payload)
+	Expect(WriteRecordFormat(encoder, RecordFormatHeaders)).NotTo(HaveOccurred())
+	for _, sequence := range sequences {
+		header := &RecordHeader{Length: 1, Headers: []RecordHeaderEntry{
+			{RecordHeaderProducerId, producerId},
+			{RecordHeaderProducerSequence, strconv.FormatInt(sequence, 10)},
+		}}
+		Expect(WriteRecordHeader(encoder, RecordFormatHeaders, header)).NotTo(HaveOccurred())
+		_, err = encoder.Write([]byte("a"))
+		Expect(err).NotTo(HaveOccurred())
+	}
+	Expect(encoder.Close()).NotTo(HaveOccurred())
+
+	file, err := os.Create(filepath.Join(dir, conf.SegmentFileName(segmentId)))
+	Expect(err).NotTo(HaveOccurred())
+	defer file.Close()
+	writer := &positionWriter{writer: bufio.NewWriter(file)}
+	Expect(writer.writeChunk(0, segmentId, uint32(len(sequences)), payload.Bytes())).NotTo(HaveOccurred())
+	Expect(writer.writeAlignment()).NotTo(HaveOccurred())
+	Expect(writer.writer.Flush()).NotTo(HaveOccurred())
+}
@@ -0,0 +1,288 @@
+package data
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/polarstreams/polar/internal/conf"
+	"github.com/polarstreams/polar/internal/utils"
+)
+
+// Record format versions, written as the first byte of the uncompressed chunk payload.
+//
+// Legacy payloads don't include the version, they start with the timestamp of the first record and the most
+// significant byte of a timestamp in micros is always zero.
+const (
+	RecordFormatLegacy  byte = 0 // timestamp, length and body
+	RecordFormatKeyed   byte = 1 // timestamp, length, key length, key and body
+	RecordFormatHeaders byte = 2 // timestamp, length, key length, key, headers and body
+)
+
+const (
+	MaxRecordKeyLength         = math.MaxUint16 // The maximum length in bytes of a record key
+	MaxRecordHeaders           = math.MaxUint8  // The maximum number of headers of a record
+	MaxRecordHeaderNameLength  = math.MaxUint8  // The maximum length in bytes of a record header name
+	MaxRecordHeaderValueLength = math.MaxUint16 // The maximum length in bytes of a record header value
+)
+
+// Names of the record headers reserved to store the sequence of idempotent producers
+const (
+	RecordHeaderProducerId       = "Polar-Producer-Id"
+	RecordHeaderProducerSequence = "Polar-Producer-Sequence"
+)
+
+// Name of the record header reserved to store the time to live of the record in milliseconds
+const RecordHeaderTtl = "Polar-Ttl"
+
+const recordHeaderSize = 8 + 4 // timestamp + length
+
+// Represents the information of a record preceding the body
+type RecordHeader struct {
+	Timestamp int64
+	Length    uint32 // The length of the body
+	Key       []byte // The optional key of the record
+	Headers   []RecordHeaderEntry
+}
+
+// Represents a name/value pair stored along with the record, like the content type
+type RecordHeaderEntry struct {
+	Name  string
+	Value string
+}
+
+// Gets the value of the first header entry with the provided name or empty string when not found
+func (h *RecordHeader) HeaderValue(name string) string {
+	for _, e := range h.Headers {
+		if e.Name == name {
+			return e.Value
+		}
+	}
+	return ""
+}
+
+// Determines whether the record should no longer be served, based on the time to live of the record or the provided
+// default when not set
+func (h *RecordHeader) IsExpired(defaultTtl time.Duration, now time.Time) bool {
+	ttl := defaultTtl
+	if value := h.HeaderValue(RecordHeaderTtl); value != "" {
+		if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+			ttl = time.Duration(ms) * time.Millisecond
+		}
+	}
+	return ttl > 0 && h.Timestamp+ttl.Microseconds() < now.UnixMicro()
+}
+
+// Determines whether the record marks the deletion of the previous records with the same key
+func (h *RecordHeader) IsTombstone() bool {
+	return len(h.Key) > 0 && h.Length == 0
+}
+
+// Reads the record headers from an uncompressed chunk payload, supporting all the record format versions.
+// After reading a header, the caller must read (or discard) the body from the underlying reader.
+type RecordReader struct {
+	reader  io.Reader
+	format  *byte
+	buf     []byte
+	keyBuf  []byte
+	pending []byte // The bytes of the header read while detecting the format
+}
+
+func NewRecordReader(reader io.Reader) *RecordReader {
+	return &RecordReader{
+		reader: reader,
+		buf:    make([]byte, recordHeaderSize+2),
+	}
+}
+
+// Gets the record format of the payload, only valid after reading the first header.
+func (r *RecordReader) Format() byte {
+	if r.format == nil {
+		return RecordFormatLegacy
+	}
+	return *r.format
+}
+
+// Reads the next record header, returning io.EOF when there are no more records.
+// The key slice is only valid until the next call.
+func (r *RecordReader) Next() (*RecordHeader, error) {
+	if r.format == nil {
+		if _, err := io.ReadFull(r.reader, r.buf[:1]); err != nil {
+			return nil, err
+		}
+		format := r.buf[0]
+		if format == RecordFormatLegacy {
+			// The byte is part of the timestamp
+			r.pending = r.buf[:1]
+		} else if format != RecordFormatKeyed && format != RecordFormatHeaders {
+			return nil, fmt.Errorf("Unsupported record format %d", format)
+		}
+		r.format = &format
+	}
+
+	headerLength := recordHeaderSize
+	if *r.format != RecordFormatLegacy {
+		headerLength += 2
+	}
+
+	buf := r.buf[:headerLength]
+	start := len(r.pending)
+	r.pending = nil
+	if _, err := io.ReadFull(r.reader, buf[start:]); err != nil {
+		// io.EOF when there are no more records
+		return nil, err
+	}
+
+	header := &RecordHeader{
+		Timestamp: int64(conf.Endianness.Uint64(buf)),
+		Length:    conf.Endianness.Uint32(buf[8:]),
+	}
+
+	if *r.format != RecordFormatLegacy {
+		keyLength := int(conf.Endianness.Uint16(buf[recordHeaderSize:]))
+		if keyLength > 0 {
+			if cap(r.keyBuf) < keyLength {
+				r.keyBuf = make([]byte, keyLength)
+			}
+			header.Key = r.keyBuf[:keyLength]
+			if _, err := io.ReadFull(r.reader, header.Key); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if *r.format == RecordFormatHeaders {
+		headers, err := r.readHeaders()
+		if err != nil {
+			return nil, err
+		}
+		header.Headers = headers
+	}
+
+	return header, nil
+}
+
+func (r *RecordReader) readHeaders() ([]RecordHeaderEntry, error) {
+	buf := r.buf[:2]
+	if _, err := io.ReadFull(r.reader, buf[:1]); err != nil {
+		return nil, noEOF(err)
+	}
+	length := int(buf[0])
+	if length == 0 {
+		return nil, nil
+	}
+
+	result := make([]RecordHeaderEntry, length)
+	for i := 0; i < length; i++ {
+		if _, err := io.ReadFull(r.reader, buf[:1]); err != nil {
+			return nil, noEOF(err)
+		}
+		name, err := r.readString(int(buf[0]))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(r.reader, buf); err != nil {
+			return nil, noEOF(err)
+		}
+		value, err := r.readString(int(conf.Endianness.Uint16(buf)))
+		if err != nil {
+			return nil, err
+		}
+		result[i] = RecordHeaderEntry{Name: name, Value: value}
+	}
+	return result, nil
+}
+
+func (r *RecordReader) readString(length int) (string, error) {
+	if length == 0 {
+		return "", nil
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r.reader, buf); err != nil {
+		return "", noEOF(err)
+	}
+	return string(buf), nil
+}
+
+// The record header was partially read
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Writes the record format at the beginning of the payload, legacy payloads don't include the format
+func WriteRecordFormat(w io.Writer, format byte) error {
+	if format == RecordFormatLegacy {
+		return nil
+	}
+	return utils.WriteBytes(w, []byte{format})
+}
+
+// Validates that the record headers can be stored
+func ValidateRecordHeaders(headers []RecordHeaderEntry) error {
+	if len(headers) > MaxRecordHeaders {
+		return fmt.Errorf("A record can not contain more than %d headers", MaxRecordHeaders)
+	}
+	for _, e := range headers {
+		if len(e.Name) == 0 || len(e.Name) > MaxRecordHeaderNameLength {
+			return fmt.Errorf("Record header names must not be empty and less than %d bytes", MaxRecordHeaderNameLength)
+		}
+		if len(e.Value) > MaxRecordHeaderValueLength {
+			return fmt.Errorf("Record header values can not be larger than %d bytes", MaxRecordHeaderValueLength)
+		}
+	}
+	return nil
+}
+
+// Writes the record header using the provided format.
+// The key is ignored for the legacy format and the headers are only written using the headers format.
+func WriteRecordHeader(w io.Writer, format byte, header *RecordHeader) error {
+	if err := binary.Write(w, conf.Endianness, header.Timestamp); err != nil {
+		return err
+	}
+	if err := binary.Write(w, conf.Endianness, header.Length); err != nil {
+		return err
+	}
+	if format == RecordFormatLegacy {
+		return nil
+	}
+	key := header.Key
+	if len(key) > MaxRecordKeyLength {
+		return fmt.Errorf("Record key can not be larger than %d bytes", MaxRecordKeyLength)
+	}
+	if err := binary.Write(w, conf.Endianness, uint16(len(key))); err != nil {
+		return err
+	}
+	if err := utils.WriteBytes(w, key); err != nil {
+		return err
+	}
+	if format != RecordFormatHeaders {
+		return nil
+	}
+	if err := ValidateRecordHeaders(header.Headers); err != nil {
+		return err
+	}
+	if err := binary.Write(w, conf.Endianness, uint8(len(header.Headers))); err != nil {
+		return err
+	}
+	for _, e := range header.Headers {
+		if err := binary.Write(w, conf.Endianness, uint8(len(e.Name))); err != nil {
+			return err
+		}
+		if err := utils.WriteBytes(w, []byte(e.Name)); err != nil {
+			return err
+		}
+		if err := binary.Write(w, conf.Endianness, uint16(len(e.Value))); err != nil {
+			return err
+		}
+		if err := utils.WriteBytes(w, []byte(e.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package data
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RecordReader", func() {
+	Describe("Next()", func() {
+		It("should read records in the legacy format", func() {
+			buf := new(bytes.Buffer)
+			Expect(WriteRecordFormat(buf, RecordFormatLegacy)).NotTo(HaveOccurred())
+			writeTestRecord(buf, RecordFormatLegacy, 1000, nil, "abc")
+			writeTestRecord(buf, RecordFormatLegacy, 1001, []byte("ignored"), "d")
+			reader := NewRecordReader(buf)
+
+			header, err := reader.Next()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reader.Format()).To(Equal(RecordFormatLegacy))
+			Expect(*header).To(Equal(RecordHeader{Timestamp: 1000, Length: 3}))
+			expectBody(buf, "abc")
+
+			header, err = reader.Next()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*header).To(Equal(RecordHeader{Timestamp: 1001, Length: 1}))
+			expectBody(buf, "d")
+
+			_, err = reader.Next()
+			Expect(err).To(Equal(io.EOF))
+		})
+
+		It("should read records in the keyed format", func() {
+			buf := new(bytes.Buffer)
+			Expect(WriteRecordFormat(buf, RecordFormatKeyed)).NotTo(HaveOccurred())
+			writeTestRecord(buf, RecordFormatKeyed, 1000, []byte("k1"), "abc")
+			writeTestRecord(buf, RecordFormatKeyed, 1001, nil, "d")
+			writeTestRecord(buf, RecordFormatKeyed, 1002, []byte("k1"), "")
+			reader := NewRecordReader(buf)
+
+			header, err := reader.Next()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reader.Format()).To(Equal(RecordFormatKeyed))
+			Expect(header.Key).To(Equal([]byte("k1")))
+			Expect(header.IsTombstone()).To(BeFalse())
+			expectBody(buf, "abc")
+
+			header, err = reader.Next()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(header.Key).To(BeNil())
+			Expect(header.Timestamp).To(Equal(int64(1001)))
+			expectBody(buf, "d")
+
+			header, err = reader.Next()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(header.IsTombstone()).To(BeTrue())
+
+			_, err = reader.Next()
+			Expect(err).To(Equal(io.EOF))
+		})
+
+		It("should read records in the headers format", func() {
+			buf := new(bytes.Buffer)
+			Expect(WriteRecordFormat(buf, RecordFormatHeaders)).NotTo(HaveOccurred())
+			headers := []RecordHeaderEntry{{"Content-Type", "text/plain"}, {"X-Polar-Header-Trace", ""}}
+			err := WriteRecordHeader(buf, RecordFormatHeaders, &RecordHeader{
+				Timestamp: 1000,
+				Length:    3,
+				Key:       []byte("k1"),
+				Headers:   headers,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			buf.WriteString("abc")
+			writeTestRecord(buf, RecordFormatHeaders, 1001, nil, "d")
+			reader := NewRecordReader(buf)
+
+			header, err := reader.Next()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reader.Format()).To(Equal(RecordFormatHeaders))
+			Expect(header.Key).To(Equal([]byte("k1")))
+			Expect(header.Headers).To(Equal(headers))
+			Expect(header.HeaderValue("Content-Type")).To(Equal("text/plain"))
+			expectBody(buf, "abc")
+
+			header, err = reader.Next()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*header).To(Equal(RecordHeader{Timestamp: 1001, Length: 1}))
+			expectBody(buf, "d")
+
+			_, err = reader.Next()
+			Expect(err).To(Equal(io.EOF))
+		})
+
+		It("should return EOF when the keyed payload has no records", func() {
+			buf := new(bytes.Buffer)
+			Expect(WriteRecordFormat(buf, RecordFormatKeyed)).NotTo(HaveOccurred())
+			_, err := NewRecordReader(buf).Next()
+			Expect(err).To(Equal(io.EOF))
+		})
+
+		It("should return an error for unsupported formats", func() {
+			_, err := NewRecordReader(bytes.NewReader([]byte{0x7f, 0, 0})).Next()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
+
+var _ = Describe("ValidateRecordHeaders()", func() {
+	It("should validate the number of headers and their length", func() {
+		Expect(ValidateRecordHeaders(nil)).NotTo(HaveOccurred())
+		Expect(ValidateRecordHeaders([]RecordHeaderEntry{{"a", "b"}})).NotTo(HaveOccurred())
+		Expect(ValidateRecordHeaders([]RecordHeaderEntry{{"", "b"}})).To(HaveOccurred())
+		Expect(ValidateRecordHeaders([]RecordHeaderEntry{{strings.Repeat("a", 256), "b"}})).To(HaveOccurred())
+		Expect(ValidateRecordHeaders([]RecordHeaderEntry{{"a", strings.Repeat("b", 65536)}})).To(HaveOccurred())
+		Expect(ValidateRecordHeaders(make([]RecordHeaderEntry, 256))).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("RecordHeader", func() {
+	Describe("IsExpired()", func() {
+		It("should use the record ttl or the default", func() {
+			now := time.Now()
+			timestamp := now.Add(-time.Minute).UnixMicro()
+			header := &RecordHeader{Timestamp: timestamp}
+			Expect(header.IsExpired(0, now)).To(BeFalse())
+			Expect(header.IsExpired(2*time.Minute, now)).To(BeFalse())
+			Expect(header.IsExpired(30*time.Second, now)).To(BeTrue())
+
+			header.Headers = []RecordHeaderEntry{{RecordHeaderTtl, "120000"}}
+			Expect(header.IsExpired(30*time.Second, now)).To(BeFalse())
+			header.Headers = []RecordHeaderEntry{{RecordHeaderTtl, "1000"}}
+			Expect(header.IsExpired(0, now)).To(BeTrue())
+		})
+	})
+})
+
+func writeTestRecord(w io.Writer, format byte, timestamp int64, key []byte, body string) {
+	header := &RecordHeader{Timestamp: timestamp, Length: uint32(len(body)), Key: key}
+	Expect(WriteRecordHeader(w, format, header)).NotTo(HaveOccurred())
+	_, err := w.Write([]byte(body))
+	Expect(err).NotTo(HaveOccurred())
+}
+
+func expectBody(r io.Reader, expected string) {
+	body := make([]byte, len(expected))
+	_, err := io.ReadFull(r, body)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(string(body)).To(Equal(expected))
+}
@@ -0,0 +1,59 @@
+package registry
+
+import (
+	"time"
+
+	"github.com/polarstreams/polar/internal/discovery"
+	"github.com/polarstreams/polar/internal/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// Sends the metadata changes to a peer
+type PeerSender func(ordinal int) error
+
+// Gets a timestamp in unix micros that is greater than the previous one, to be used as the last modification time of
+// the metadata entries when resolving conflicts
+func NewTimestamp(previous int64) int64 {
+	value := time.Now().UnixMicro()
+	if value <= previous {
+		value = previous + 1
+	}
+	return value
+}
+
+// Sends the changes to all the peers in the background, logging the failures using the name of the metadata
+func SendToAllPeers(topologyGetter discovery.TopologyGetter, name string, send PeerSender) {
+	for _, peer := range topologyGetter.Topology().Peers() {
+		ordinal := peer.Ordinal
+		go func() {
+			if err := send(ordinal); err != nil {
+				log.Warn().Err(err).Msgf("There was an error when sending %s to peer B%d", name, ordinal)
+			}
+		}()
+	}
+}
+
+// Periodically sends the full snapshot to the next brokers, to converge in case of missed changes.
+//
+// The snapshot function gets the sender of the current snapshot, nil when there's nothing to send.
+func SendSnapshotsToPeers(
+	topologyGetter discovery.TopologyGetter,
+	delay time.Duration,
+	name string,
+	snapshot func() PeerSender,
+) {
+	for {
+		time.Sleep(utils.Jitter(delay))
+		send := snapshot()
+		if send == nil {
+			continue
+		}
+
+		topology := topologyGetter.Topology()
+		for _, b := range topology.NextBrokers(topology.LocalIndex, 2) {
+			if err := send(b.Ordinal); err != nil {
+				log.Debug().Err(err).Msgf("There was an error when sending %s to peer B%d", name, b.Ordinal)
+			}
+		}
+	}
+}
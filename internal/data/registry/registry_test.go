@@ -0,0 +1,26 @@
+package registry
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestRegistry(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Registry Suite")
+}
+
+var _ = Describe("NewTimestamp()", func() {
+	It("should use the current time", func() {
+		previous := time.Now().Add(-time.Second).UnixMicro()
+		Expect(NewTimestamp(previous)).To(BeNumerically("~", time.Now().UnixMicro(), 1000*1000))
+	})
+
+	It("should be greater than the previous timestamp", func() {
+		previous := time.Now().Add(time.Hour).UnixMicro()
+		Expect(NewTimestamp(previous)).To(Equal(previous + 1))
+	})
+})
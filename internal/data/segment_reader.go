@@ -127,7 +127,7 @@ func (s *SegmentReader) read() {
 				break
 			}
 		} else {
-			s.storeOffset(lastCommit, item.CommitOnly())
+			s.storeOffset(lastCommit, item.CommitOnly(), item.CommitLimit())
 			if item.CommitOnly() {
 				item.SetResult(nil, NewEmptyChunk(s.messageOffset))
 				continue
@@ -253,21 +253,28 @@ func (s *SegmentReader) handleFileGap(offsetGap *int64, reader *bytes.Reader, bu
 	return false
 }
 
-func (s *SegmentReader) storeOffset(lastCommit *time.Time, manual bool) {
+// Stores the offset of the reader, up to the commit limit.
+//
+// The limit is used to keep the position of the records that were delivered but not acknowledged.
+func (s *SegmentReader) storeOffset(lastCommit *time.Time, manual bool, limit int64) {
 	commitType := OffsetCommitLocal
+	offset := s.messageOffset
+	if limit < offset {
+		offset = limit
+	}
 	value := Offset{
 		Token:       s.Topic.Token,
 		Index:       s.Topic.RangeIndex,
 		Version:     s.Topic.Version,
 		ClusterSize: s.TopicRangeClusterSize,
-		Offset:      s.messageOffset,
+		Offset:      offset,
 		Source:      NewOffsetSource(s.SourceVersion),
 	}
 
 	if time.Since(*lastCommit) >= s.config.AutoCommitInterval() || manual {
 		*lastCommit = time.Now()
 		commitType = OffsetCommitAll
-	} else if s.MaxProducedOffset != nil && s.messageOffset > *s.MaxProducedOffset {
+	} else if s.MaxProducedOffset != nil && value.Offset > *s.MaxProducedOffset {
 		if s.messageOffset != OffsetCompleted {
 			s.messageOffset = OffsetCompleted // Signal that it has been completed
 			commitType = OffsetCommitAll
@@ -315,16 +322,21 @@ func (s *SegmentReader) resetOffsetToLastCommitted() (bool, error) {
 // Tries open the initial file and seek the correct position, returning an error when there's an
 // I/O-related error
 func (s *SegmentReader) initRead(foreground bool) error {
+	// Prevent the files from being swapped by the compactor between reading the index and opening the segment
+	segmentSwapLock.RLock()
 	foundFileName, fileOffset, err := s.fullSeek(foreground)
 	if err != nil {
+		segmentSwapLock.RUnlock()
 		return err
 	}
 
 	if foundFileName == "" {
+		segmentSwapLock.RUnlock()
 		// No file found on folder, will attempt later
 		return nil
 	}
 	s.segmentFile, err = os.OpenFile(filepath.Join(s.basePath, foundFileName), conf.SegmentFileReadFlags, 0)
+	segmentSwapLock.RUnlock()
 	if err != nil {
 		log.Err(err).Msgf("File %s in %s could not be opened by reader", foundFileName, s.basePath)
 		return err
@@ -561,6 +573,10 @@ func (s *SegmentReader) readChunk(reader *bytes.Reader, offsetGap *int64) Segmen
 		}
 
 		if chunk.StartOffset() > s.messageOffset {
+			if chunk.(*ReadSegmentChunk).compacted {
+				// The records before the chunk were removed by compaction
+				break
+			}
 			// There's a gap in the file, set offsetGap to the last message offset missing
 			*offsetGap = chunk.StartOffset() - 1
 			return nil
@@ -617,9 +633,10 @@ func (s *SegmentReader) readSingleChunk(reader *bytes.Reader) (int, SegmentChunk
 	n += nBody
 
 	chunk := &ReadSegmentChunk{
-		Buffer: readBuffer,
-		Start:  header.Start,
-		Length: header.RecordLength,
+		Buffer:    readBuffer,
+		Start:     header.Start,
+		Length:    header.RecordLength,
+		compacted: header.Flags&compactedFlag != 0,
 	}
 	return n, chunk
 }
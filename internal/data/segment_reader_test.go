@@ -252,6 +252,37 @@ var _ = Describe("SegmentReader", func() {
 			pollChunk(s, item, 300)
 		})
 
+		It("should skip the offsets removed by compaction", func() {
+			dir, err := os.MkdirTemp("", "poll_stream_*")
+			Expect(err).NotTo(HaveOccurred())
+			config := newReaderConfig(dir)
+
+			file, err := os.Create(filepath.Join(dir, "00000000000000000000.dlog"))
+			Expect(err).NotTo(HaveOccurred())
+			defer file.Close()
+
+			file.Write(alignTestChunk(createTestChunkWithFlags(compactedFlag, 1200, 0, 20)))
+			file.Write(alignTestChunk(createTestChunkWithFlags(compactedFlag, 200, 35, 15)))
+			file.Write(alignTestChunk(createTestChunkWithFlags(compactedFlag, 300, 100, 120)))
+			file.Sync()
+
+			rr := &rrFake{}
+			s := newTestReader()
+			s.replicationReader = rr
+			s.config = config
+			s.basePath = dir
+			s.datalog = NewDatalog(config)
+
+			go s.read()
+			defer close(s.Items)
+
+			item := newTestReadItem()
+			pollChunk(s, item, 1200)
+			pollChunk(s, item, 200)
+			pollChunk(s, item, 300)
+			Expect(atomic.LoadInt64(&rr.streamCalled)).To(BeZero())
+		})
+
 		It("should recognize gaps from empty files", func() {
 			dir, err := os.MkdirTemp("", "poll_gap_empty_file_*")
 			Expect(err).NotTo(HaveOccurred())
@@ -360,6 +391,32 @@ var _ = Describe("SegmentReader", func() {
 		})
 	})
 
+	Describe("storeOffset()", func() {
+		It("should not move the offset past the commit limit", func() {
+			s := newTestReader()
+			s.group = "g1"
+			s.Topic = TopicDataId{Name: "t1", Version: 2}
+			s.messageOffset = 10
+			maxProduced := int64(9)
+			s.MaxProducedOffset = &maxProduced
+			offsetState := s.offsetState.(*tMocks.OffsetState)
+			lastCommit := time.Now()
+
+			s.storeOffset(&lastCommit, false, 5)
+			Expect(s.StoredOffsetAsCompleted()).To(BeFalse())
+			offsetState.AssertCalled(GinkgoT(), "Set", "g1", "t1", mock.MatchedBy(func(value Offset) bool {
+				return value.Offset == 5
+			}), OffsetCommitLocal)
+
+			// It should be marked as completed once there's no limit
+			s.storeOffset(&lastCommit, false, OffsetCompleted)
+			Expect(s.StoredOffsetAsCompleted()).To(BeTrue())
+			offsetState.AssertCalled(GinkgoT(), "Set", "g1", "t1", mock.MatchedBy(func(value Offset) bool {
+				return value.Offset == OffsetCompleted
+			}), OffsetCommitAll)
+		})
+	})
+
 	Describe("pollFile()", func() {
 		It("should align when remainingIndex is not zero", func() {
 			const bodyLength = 700
@@ -421,6 +478,10 @@ func (r *testReadItem) CommitOnly() bool {
 	return false
 }
 
+func (r *testReadItem) CommitLimit() int64 {
+	return OffsetCompleted
+}
+
 type rrFake struct {
 	streamBuf    []byte
 	streamCalled int64
@@ -436,8 +497,12 @@ func (f *rrFake) StreamFile(segmentId int64, topic *TopicDataId, startOffset int
 }
 
 func createTestChunk(bodyLength, start, recordLength int) []byte {
+	return createTestChunkWithFlags(0, bodyLength, start, recordLength)
+}
+
+func createTestChunkWithFlags(flags byte, bodyLength, start, recordLength int) []byte {
 	header := chunkHeader{
-		Flags:        0,
+		Flags:        flags,
 		BodyLength:   uint32(bodyLength),
 		Start:        int64(start),
 		RecordLength: uint32(recordLength),
@@ -462,7 +527,10 @@ func createTestChunk(bodyLength, start, recordLength int) []byte {
 }
 
 func createAlignedChunk(bodyLength, start, recordLength int) []byte {
-	chunk := createTestChunk(bodyLength, start, recordLength)
+	return alignTestChunk(createTestChunk(bodyLength, start, recordLength))
+}
+
+func alignTestChunk(chunk []byte) []byte {
 	rem := len(chunk) % alignmentSize
 
 	if rem == 0 {
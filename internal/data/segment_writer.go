@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/polarstreams/polar/internal/conf"
 	"github.com/polarstreams/polar/internal/metrics"
 	. "github.com/polarstreams/polar/internal/types"
@@ -19,8 +20,27 @@ import (
 const flushResolution = 200 * time.Millisecond
 const alignmentFlag = byte(1 << 7)
 
+// Set on the chunks rewritten by the compactor: the records between the previous chunk and this one were removed
+const compactedFlag = byte(1)
+
 var alignmentBuffer = createAlignmentBuffer()
 
+// Contains the path of the segment files that are open for writing, the cleaner must not remove them
+var openSegments = newPathSet()
+
+// Contains the channels of the readers waiting for data to be written per topic
+var writeWaiters = newTopicWaiters()
+
+// Signals the channel each time data of any of the topics is written to a segment file.
+//
+// The channel should be buffered, as the signals are not blocking. Returns the function to stop signaling it.
+func WaitForWrites(topics []string, c chan<- struct{}) func() {
+	writeWaiters.add(topics, c)
+	return func() {
+		writeWaiters.remove(topics, c)
+	}
+}
+
 // SegmentWriter contains the logic to write segments on disk and replicate them.
 //
 // There should be an instance per topic+token+generation. When the generation changes for
@@ -33,6 +53,8 @@ type SegmentWriter struct {
 	lastFlush      time.Time
 	bufferedOffset int64 // Stores the offset of the first message buffered since it was buffered
 	tailOffset     int64 // Value of the last written message
+	maxTimestamp   int64 // The max record timestamp of the current segment
+	decoder        *zstd.Decoder
 	config         conf.DatalogConfig
 	segmentFile    *os.File
 	indexFile      *indexFileWriter
@@ -165,6 +187,9 @@ func (s *SegmentWriter) close() {
 		s.flush("closing writer")
 	}
 	s.closeFile()
+	if s.decoder != nil {
+		s.decoder.Close()
+	}
 }
 
 // maybeFlush will write to the file when the next group doesn't fit in memory
@@ -197,9 +222,13 @@ func (s *SegmentWriter) createFile(segmentId int64) {
 	name := conf.SegmentFileName(segmentId)
 	log.Info().Str("type", string(s.writerType)).Msgf("Creating segment file %s on %s", name, s.basePath)
 
-	f, err := os.OpenFile(filepath.Join(s.basePath, name), conf.SegmentFileWriteFlags, FilePermissions)
+	segmentPath := filepath.Join(s.basePath, name)
+	// Track the segment before creating it, so the cleaner never sees it as a closed segment
+	openSegments.add(segmentPath)
+	f, err := os.OpenFile(segmentPath, conf.SegmentFileWriteFlags, FilePermissions)
 	if err != nil {
 		// Can't create segment
+		openSegments.remove(segmentPath)
 		log.Err(err).Msgf("Failed to create segment file at %s", s.basePath)
 		panic(err)
 	}
@@ -232,11 +261,12 @@ func (s *SegmentWriter) flush(reason string) {
 	}
 
 	// Store the index file and producer offset
-	s.indexFile.append(s.segmentId, s.bufferedOffset, s.segmentLength, s.tailOffset)
+	s.indexFile.append(s.segmentId, s.bufferedOffset, s.segmentLength, s.tailOffset, s.maxTimestamp)
 	s.segmentLength += length
 	s.buffer.Reset()
 	s.lastFlush = time.Now()
 	metrics.SegmentFlushBytes.Observe(float64(length))
+	writeWaiters.notify(s.Topic.Name)
 }
 
 // maybeCloseSegment determines whether the segment file should be closed.
@@ -255,14 +285,16 @@ func (s *SegmentWriter) closeFile() {
 	go func() {
 		err := previousFile.Close()
 		log.Err(err).Msgf("Closed segment file %s on %s", conf.SegmentFileName(previousSegmentId), s.basePath)
+		openSegments.remove(filepath.Join(s.basePath, conf.SegmentFileName(previousSegmentId)))
 	}()
 
 	// Close the index file
-	s.indexFile.closeFile(previousSegmentId, s.tailOffset)
+	s.indexFile.closeFile(previousSegmentId, s.tailOffset, s.maxTimestamp)
 
 	s.segmentFile = nil
 	s.segmentId = math.MaxInt64
 	s.segmentLength = 0
+	s.maxTimestamp = 0
 }
 
 func (s *SegmentWriter) writeToBuffer(item SegmentChunk) {
@@ -273,11 +305,12 @@ func (s *SegmentWriter) writeToBuffer(item SegmentChunk) {
 	}
 	headStartIndex := s.buffer.Len()
 	compressedBody := item.DataBlock()
-	const flags = byte(0) // Only valid flag is alignment 0x80 (10000000)
+	const flags = byte(0) // Only valid flags are alignment 0x80 (10000000) and compacted 0x01, set by the compactor
 
 	recordLength := item.RecordLength()
 	if recordLength > 0 {
 		s.tailOffset = item.StartOffset() + int64(recordLength) - 1
+		s.updateMaxTimestamp(item)
 	}
 
 	// Write head
@@ -295,6 +328,34 @@ func (s *SegmentWriter) writeToBuffer(item SegmentChunk) {
 	utils.PanicIfErr(err, "Unexpected error writing compressed body to buffer")
 }
 
+// Tracks the max timestamp of the segment, used to write the time index.
+//
+// The timestamps of the records are only read from the compressed body when the max timestamp of the chunk is not
+// known, like the chunks replicated by brokers running a previous version.
+func (s *SegmentWriter) updateMaxTimestamp(item SegmentChunk) {
+	if timestamp := item.MaxTimestamp(); timestamp > 0 {
+		if timestamp > s.maxTimestamp {
+			s.maxTimestamp = timestamp
+		}
+		return
+	}
+
+	if s.decoder == nil {
+		decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		utils.PanicIfErr(err, "Unexpected error creating zstd decoder")
+		s.decoder = decoder
+	}
+
+	err := readRecords(s.decoder, item.DataBlock(), func(h *RecordHeader) {
+		if h.Timestamp > s.maxTimestamp {
+			s.maxTimestamp = h.Timestamp
+		}
+	})
+	if err != nil {
+		log.Warn().Err(err).Msgf("Record timestamps could not be read for the time index on %s", s.basePath)
+	}
+}
+
 func (c *SegmentWriter) flushTimer() {
 	defer func() {
 		// Channel might be closed in the future, move on
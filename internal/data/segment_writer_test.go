@@ -95,6 +95,44 @@ var _ = Describe("SegmentWriter", func() {
 			close(s.Items)
 		})
 	})
+
+	Describe("WaitForWrites()", func() {
+		It("should signal the channels of the topic when flushing", func() {
+			config := new(mocks.Config)
+			config.On("IndexFilePeriodBytes").Return(5 * 1024 * 1024)
+
+			dir, err := ioutil.TempDir("", "test_wait_for_writes")
+			Expect(err).NotTo(HaveOccurred())
+			s := &SegmentWriter{
+				buffer:    createAlignedByteBuffer(alignmentSize * 2),
+				config:    config,
+				indexFile: newIndexFileWriter(dir, config),
+				basePath:  dir,
+				Topic:     TopicDataId{Name: "wait-topic1"},
+			}
+			s.createFile(0)
+			defer s.segmentFile.Close()
+
+			c1 := make(chan struct{}, 1)
+			c2 := make(chan struct{}, 1)
+			stop1 := WaitForWrites([]string{"wait-topic1", "wait-topic2"}, c1)
+			stop2 := WaitForWrites([]string{"wait-topic2"}, c2)
+			defer stop2()
+
+			s.buffer.Write(make([]byte, 10))
+			s.flush("test")
+			s.buffer.Write(make([]byte, 10))
+			s.flush("test")
+			Expect(c1).To(HaveLen(1))
+			Expect(c2).To(HaveLen(0))
+
+			<-c1
+			stop1()
+			s.buffer.Write(make([]byte, 10))
+			s.flush("test")
+			Expect(c1).To(HaveLen(0))
+		})
+	})
 })
 
 type testWriteItem struct {
@@ -123,6 +161,10 @@ func (d *testWriteItem) RecordLength() uint32 {
 	return 200
 }
 
+func (d *testWriteItem) MaxTimestamp() int64 {
+	return 0
+}
+
 func (d *testWriteItem) SetResult(err error) {
 	d.response <- err
 }
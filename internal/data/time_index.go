@@ -0,0 +1,223 @@
+package data
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/polarstreams/polar/internal/conf"
+	. "github.com/polarstreams/polar/internal/types"
+	"github.com/polarstreams/polar/internal/utils"
+	"github.com/rs/zerolog/log"
+)
+
+const offsetNotFound = -1
+
+var errStopReading = errors.New("Stop reading segment")
+
+// Represents a line of the time index file: all the records of the segment up to the offset (inclusive) have a
+// timestamp lower than or equal to the timestamp of the entry
+type timeIndexEntry struct {
+	// Strict ordering, serialized fields
+	Timestamp int64 // The max record timestamp in unix micros
+	Offset    int64
+	Checksum  uint32
+}
+
+var timeIndexItemSize = utils.BinarySize(timeIndexEntry{})
+
+func writeTimeIndexEntry(w *bytes.Buffer, timestamp int64, offset int64) {
+	start := w.Len()
+	utils.PanicIfErr(binary.Write(w, conf.Endianness, timestamp), "Error writing timestamp")
+	utils.PanicIfErr(binary.Write(w, conf.Endianness, offset), "Error writing offset")
+	checksum := crc32.ChecksumIEEE(w.Bytes()[start:])
+	utils.PanicIfErr(binary.Write(w, conf.Endianness, checksum), "Error writing checksum")
+}
+
+// Reads the valid entries of the time index file
+func readTimeIndexFile(timeIndexPath string) ([]timeIndexEntry, error) {
+	buf, err := os.ReadFile(timeIndexPath)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]timeIndexEntry, 0, len(buf)/timeIndexItemSize)
+	for len(buf) >= timeIndexItemSize {
+		item := timeIndexEntry{}
+		expectedChecksum := crc32.ChecksumIEEE(buf[:timeIndexItemSize-4])
+		utils.PanicIfErr(binary.Read(bytes.NewReader(buf), conf.Endianness, &item), "Error reading time index item")
+		if item.Checksum != expectedChecksum {
+			log.Warn().Msgf("Invalid time index file checksum on %s (%d)", timeIndexPath, item.Checksum)
+			break
+		}
+		result = append(result, item)
+		buf = buf[timeIndexItemSize:]
+	}
+	return result, nil
+}
+
+// Reads the chunks of the segment file to generate the entries of the time index
+func buildTimeIndex(decoder *zstd.Decoder, segmentPath string, periodBytes int) []timeIndexEntry {
+	result := make([]timeIndexEntry, 0)
+	maxTimestamp := int64(0)
+	tailOffset := int64(offsetNotFound)
+	readBytes := 0
+	lastStoredBytes := 0
+	err := readSegmentChunks(segmentPath, func(chunk *chunkHeader, body []byte) error {
+		offset := chunk.Start
+		err := readRecords(decoder, body, func(h *RecordHeader) {
+			if h.Timestamp > maxTimestamp {
+				maxTimestamp = h.Timestamp
+			}
+			tailOffset = offset
+			offset++
+		})
+		if err != nil {
+			return err
+		}
+
+		readBytes += chunkHeaderSize + len(body)
+		if readBytes-lastStoredBytes >= periodBytes && tailOffset != offsetNotFound {
+			result = append(result, timeIndexEntry{Timestamp: maxTimestamp, Offset: tailOffset})
+			lastStoredBytes = readBytes
+		}
+		return nil
+	})
+
+	if err != nil {
+		// The tail of the segment might not be complete, use the records read so far
+		log.Debug().Err(err).Msgf("Segment file %s could not be fully read", segmentPath)
+	}
+
+	if tailOffset != offsetNotFound && (len(result) == 0 || result[len(result)-1].Offset < tailOffset) {
+		result = append(result, timeIndexEntry{Timestamp: maxTimestamp, Offset: tailOffset})
+	}
+	return result
+}
+
+// Gets the entries of the time index of the segment.
+//
+// When the segment is closed and the time index doesn't exist (e.g. it was written by a previous version),
+// the time index is rebuilt and stored.
+func (d *datalog) timeIndex(decoder *zstd.Decoder, basePath string, segmentId int64, isOpen bool) []timeIndexEntry {
+	prefix := conf.SegmentFilePrefix(segmentId)
+	timeIndexPath := filepath.Join(basePath, prefix+"."+conf.TimeIndexFileExtension)
+	entries, err := readTimeIndexFile(timeIndexPath)
+	if err == nil {
+		return entries
+	}
+
+	if !os.IsNotExist(err) {
+		log.Warn().Err(err).Msgf("Could not read time index file at %s", timeIndexPath)
+		return nil
+	}
+
+	segmentPath := filepath.Join(basePath, conf.SegmentFileName(segmentId))
+	if isOpen || openSegments.contains(segmentPath) {
+		// The segment is being written
+		return nil
+	}
+
+	log.Info().Msgf("Rebuilding time index file for segment %s", segmentPath)
+	entries = buildTimeIndex(decoder, segmentPath, d.config.IndexFilePeriodBytes())
+	buf := new(bytes.Buffer)
+	for _, e := range entries {
+		writeTimeIndexEntry(buf, e.Timestamp, e.Offset)
+	}
+
+	tempPath := timeIndexPath + compactionFileSuffix
+	if err := writeFileSync(tempPath, buf.Bytes()); err != nil {
+		log.Err(err).Msgf("Time index file could not be written at %s", tempPath)
+		return entries
+	}
+	if err := os.Rename(tempPath, timeIndexPath); err != nil {
+		log.Err(err).Msgf("Time index file could not be renamed to %s", timeIndexPath)
+	}
+	return entries
+}
+
+func (d *datalog) OffsetByTimestamp(topic *TopicDataId, timestamp int64) (int64, error) {
+	segments, err := d.SegmentFileList(topic, math.MaxInt64)
+	if err != nil || len(segments) == 0 {
+		return offsetNotFound, err
+	}
+
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return offsetNotFound, err
+	}
+	defer decoder.Close()
+
+	basePath := d.config.DatalogPath(topic)
+	for i, segmentId := range segments {
+		isLast := i == len(segments)-1
+		entries := d.timeIndex(decoder, basePath, segmentId, isLast)
+
+		// The records up to the offset of the entries with a lower timestamp can be skipped
+		start := segmentId
+		for _, e := range entries {
+			if e.Timestamp >= timestamp {
+				break
+			}
+			start = e.Offset + 1
+		}
+
+		if !isLast && start >= segments[i+1] {
+			// All the records in the segment are older
+			continue
+		}
+
+		if offset := segmentOffsetByTimestamp(decoder, basePath, segmentId, start, timestamp); offset != offsetNotFound {
+			return offset, nil
+		}
+	}
+	return offsetNotFound, nil
+}
+
+// Gets the offset of the first record in the segment file, starting from the provided offset, with a timestamp
+// greater than or equal to the provided one
+func segmentOffsetByTimestamp(
+	decoder *zstd.Decoder,
+	basePath string,
+	segmentId int64,
+	start int64,
+	timestamp int64,
+) int64 {
+	segmentPath := filepath.Join(basePath, conf.SegmentFileName(segmentId))
+	fileOffset := int64(0)
+	if start > segmentId {
+		fileOffset = tryReadIndexFile(basePath, conf.SegmentFilePrefix(segmentId), start)
+	}
+
+	result := int64(offsetNotFound)
+	err := readSegmentChunksFrom(segmentPath, fileOffset, func(chunk *chunkHeader, body []byte) error {
+		if chunk.Start+int64(chunk.RecordLength) <= start {
+			// Skip the chunk without decompressing
+			return nil
+		}
+		offset := chunk.Start
+		if err := readRecords(decoder, body, func(h *RecordHeader) {
+			if result == offsetNotFound && offset >= start && h.Timestamp >= timestamp {
+				result = offset
+			}
+			offset++
+		}); err != nil {
+			return err
+		}
+		if result != offsetNotFound {
+			return errStopReading
+		}
+		return nil
+	})
+
+	if err != nil && err != errStopReading {
+		// The tail of the segment might not be complete, use the records read so far
+		log.Debug().Err(err).Msgf("Segment file %s could not be fully read", segmentPath)
+	}
+	return result
+}
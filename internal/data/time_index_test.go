@@ -0,0 +1,131 @@
+package data
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/polarstreams/polar/internal/conf"
+	"github.com/polarstreams/polar/internal/test/conf/mocks"
+	. "github.com/polarstreams/polar/internal/types"
+	"github.com/stretchr/testify/mock"
+)
+
+var _ = Describe("datalog", func() {
+	Describe("OffsetByTimestamp()", func() {
+		topic := &TopicDataId{Name: "abc"}
+
+		It("should return the offset of the first record with greater or equal timestamp", func() {
+			dir, err := ioutil.TempDir("", "offset_by_timestamp_test")
+			Expect(err).NotTo(HaveOccurred())
+			writeTestSegment(dir, 0, map[int64][]testRecord{
+				0: {{"", "a", 100}, {"", "b", 110}},
+				2: {{"", "c", 120}, {"", "d", 130}},
+			}, nil)
+			writeTestSegment(dir, 4, map[int64][]testRecord{
+				4: {{"", "e", 140}, {"", "f", 150}, {"", "g", 160}},
+			}, nil)
+
+			d := &datalog{config: newTimeIndexTestConfig(dir)}
+
+			expected := map[int64]int64{
+				0:   0,
+				100: 0,
+				105: 1,
+				120: 2,
+				131: 4,
+				150: 5,
+				160: 6,
+				161: offsetNotFound,
+			}
+
+			for timestamp, offset := range expected {
+				value, err := d.OffsetByTimestamp(topic, timestamp)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value).To(Equal(offset), "for timestamp %d", timestamp)
+			}
+
+			// The time index of the closed segment should be rebuilt
+			entries, err := readTimeIndexFile(filepath.Join(dir, conf.SegmentFilePrefix(0)+"."+conf.TimeIndexFileExtension))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].Timestamp).To(Equal(int64(130)))
+			Expect(entries[0].Offset).To(Equal(int64(3)))
+
+			// The last segment is considered open
+			_, err = os.Stat(filepath.Join(dir, conf.SegmentFilePrefix(4)+"."+conf.TimeIndexFileExtension))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("should skip the records based on the time index", func() {
+			dir, err := ioutil.TempDir("", "offset_by_timestamp_index_test")
+			Expect(err).NotTo(HaveOccurred())
+			writeTestSegment(dir, 0, map[int64][]testRecord{
+				0: {{"", "a", 100}, {"", "b", 110}},
+				2: {{"", "c", 120}, {"", "d", 130}},
+			}, []int64{0, 2})
+			writeTestSegment(dir, 4, map[int64][]testRecord{
+				4: {{"", "e", 140}},
+			}, nil)
+
+			// Use a time index that doesn't match the data to check that it's used
+			buf := new(bytes.Buffer)
+			writeTimeIndexEntry(buf, 115, 1)
+			writeTimeIndexEntry(buf, 300, 3)
+			timeIndexPath := filepath.Join(dir, conf.SegmentFilePrefix(0)+"."+conf.TimeIndexFileExtension)
+			Expect(os.WriteFile(timeIndexPath, buf.Bytes(), 0644)).NotTo(HaveOccurred())
+
+			d := &datalog{config: newTimeIndexTestConfig(dir)}
+
+			value, err := d.OffsetByTimestamp(topic, 105)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).To(Equal(int64(1)))
+
+			// Records 0 and 1 are skipped
+			value, err = d.OffsetByTimestamp(topic, 116)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).To(Equal(int64(2)))
+		})
+
+		It("should return not found when there's no data", func() {
+			dir, err := ioutil.TempDir("", "offset_by_timestamp_empty_test")
+			Expect(err).NotTo(HaveOccurred())
+			d := &datalog{config: newTimeIndexTestConfig(dir)}
+
+			value, err := d.OffsetByTimestamp(topic, 100)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).To(Equal(int64(offsetNotFound)))
+		})
+	})
+
+	Describe("buildTimeIndex()", func() {
+		It("should add entries based on the period and the tail", func() {
+			dir, err := ioutil.TempDir("", "build_time_index_test")
+			Expect(err).NotTo(HaveOccurred())
+			writeTestSegment(dir, 0, map[int64][]testRecord{
+				0: {{"", "a", 100}, {"", "b", 90}},
+				2: {{"", "c", 120}},
+				3: {{"", "d", 110}},
+			}, nil)
+
+			decoder, err := zstd.NewReader(nil)
+			Expect(err).NotTo(HaveOccurred())
+			entries := buildTimeIndex(decoder, filepath.Join(dir, conf.SegmentFileName(0)), 1)
+			Expect(entries).To(HaveLen(3))
+			Expect([]int64{entries[0].Timestamp, entries[0].Offset}).To(Equal([]int64{100, 1}))
+			Expect([]int64{entries[1].Timestamp, entries[1].Offset}).To(Equal([]int64{120, 2}))
+			Expect([]int64{entries[2].Timestamp, entries[2].Offset}).To(Equal([]int64{120, 3}))
+		})
+	})
+})
+
+func newTimeIndexTestConfig(dir string) *mocks.Config {
+	config := new(mocks.Config)
+	config.On("DatalogPath", mock.Anything).Return(dir)
+	config.On("IndexFilePeriodBytes").Return(conf.MiB)
+	return config
+}
@@ -1,36 +1,346 @@
 package topics
 
 import (
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
 	"github.com/polarstreams/polar/internal/conf"
-	"github.com/polarstreams/polar/internal/types"
+	"github.com/polarstreams/polar/internal/data/registry"
+	"github.com/polarstreams/polar/internal/discovery"
+	"github.com/polarstreams/polar/internal/interbroker"
+	"github.com/polarstreams/polar/internal/localdb"
+	. "github.com/polarstreams/polar/internal/types"
+	"github.com/rs/zerolog/log"
 )
 
+const topicsToPeersDelay = 30 * time.Second
+
+var topicNameRegex = regexp.MustCompile(`^[\w\-.]+$`)
+
+// TopicHandler maintains the registry of topics, persisting it locally and replicating it to the peers
 type TopicHandler interface {
-	types.Initializer
+	Initializer
 	TopicGetter
+
+	// Creates a new topic, returning an error when the name or settings are not valid or the topic already exists
+	Create(topic string, settings TopicSettings) (*TopicInfo, error)
+
+	// Replaces the settings of an existing topic
+	UpdateSettings(topic string, settings TopicSettings) (*TopicInfo, error)
+
+	// Marks the topic as deleted, returning an error when the topic is not found.
+	//
+	// The data files of the topic are not removed, they are subject to the retention policy.
+	Delete(topic string) error
+
+	// Gets a point-in-time snapshot of the existing topics, sorted by name
+	List() []TopicInfo
 }
 
 type TopicGetter interface {
-	Get(topic string) *types.TopicInfo
+	// Gets the topic information or nil when not found
+	Get(topic string) *TopicInfo
+
+	// Determines whether the topic exists
 	Exists(topic string) bool
+
+	// Gets the topic information, creating it when auto create is enabled.
+	// Returns nil when not found and it could not be created.
+	GetOrCreate(topic string) (*TopicInfo, error)
 }
 
-func NewHandler(config conf.Config) TopicHandler {
-	return &topicHandler{config}
+func NewHandler(
+	config conf.TopicsConfig,
+	localDb localdb.Client,
+	topologyGetter discovery.TopologyGetter,
+	gossiper interbroker.Gossiper,
+) TopicHandler {
+	return &topicHandler{
+		config:         config,
+		localDb:        localDb,
+		topologyGetter: topologyGetter,
+		gossiper:       gossiper,
+		mu:             sync.RWMutex{},
+		topics:         map[string]TopicInfo{},
+	}
 }
 
 type topicHandler struct {
-	config conf.Config
+	config         conf.TopicsConfig
+	localDb        localdb.Client
+	topologyGetter discovery.TopologyGetter
+	gossiper       interbroker.Gossiper
+	mu             sync.RWMutex
+	topics         map[string]TopicInfo // Topics by name, including tombstones
 }
 
 func (h *topicHandler) Init() error {
+	stored, err := h.localDb.Topics()
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	for _, topic := range stored {
+		h.topics[topic.Name] = topic
+	}
+	h.mu.Unlock()
+
+	log.Info().Msgf("Loaded %d topics from local db", len(stored))
+	h.gossiper.RegisterTopicInfoListener(h)
+
+	// Send info in the background
+	go h.sendTopicsToPeers()
 	return nil
 }
 
-func (h *topicHandler) Get(topic string) *types.TopicInfo {
-	return nil
+func (h *topicHandler) Get(topic string) *TopicInfo {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	info, found := h.topics[topic]
+	if !found || info.Deleted {
+		return nil
+	}
+	return &info
 }
 
 func (h *topicHandler) Exists(topic string) bool {
-	return true
+	return h.Get(topic) != nil
+}
+
+func (h *topicHandler) GetOrCreate(topic string) (*TopicInfo, error) {
+	if info := h.Get(topic); info != nil {
+		return info, nil
+	}
+
+	if !h.config.TopicAutoCreate() {
+		return nil, nil
+	}
+
+	info, err := h.Create(topic, TopicSettings{})
+	if err != nil {
+		if httpErr, ok := err.(HttpError); ok && httpErr.StatusCode() == http.StatusConflict {
+			// Created concurrently
+			return h.Get(topic), nil
+		}
+		return nil, err
+	}
+	log.Info().Msgf("Topic '%s' automatically created", topic)
+	return info, nil
+}
+
+// Creates the topic, storing it before it's visible.
+//
+// Automatically created topics use the current time as timestamp like the rest of the changes, so a topic in use is
+// not superseded by an older tombstone or topic from another broker when the changes are merged.
+func (h *topicHandler) Create(topic string, settings TopicSettings) (*TopicInfo, error) {
+	if err := validateName(topic); err != nil {
+		return nil, err
+	}
+	if err := h.validateSettings(settings); err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	existing, found := h.topics[topic]
+	if found && !existing.Deleted {
+		h.mu.Unlock()
+		return nil, NewHttpErrorf(http.StatusConflict, "Topic '%s' already exists", topic)
+	}
+
+	info := TopicInfo{Name: topic, Timestamp: registry.NewTimestamp(existing.Timestamp), Settings: settings}
+	err := h.save(info)
+	h.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	h.sendToAllPeers([]TopicInfo{info})
+	return &info, nil
+}
+
+func (h *topicHandler) UpdateSettings(topic string, settings TopicSettings) (*TopicInfo, error) {
+	if err := h.validateSettings(settings); err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	existing, found := h.topics[topic]
+	if !found || existing.Deleted {
+		h.mu.Unlock()
+		return nil, NewHttpErrorf(http.StatusNotFound, "Topic '%s' not found", topic)
+	}
+
+	if existing.Settings.Mode != settings.Mode {
+		h.mu.Unlock()
+		return nil, NewHttpErrorf(http.StatusBadRequest, "The mode of topic '%s' can not be changed", topic)
+	}
+
+	info := TopicInfo{Name: topic, Timestamp: registry.NewTimestamp(existing.Timestamp), Settings: settings}
+	err := h.save(info)
+	h.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info().Msgf("Settings of topic '%s' updated", topic)
+	h.sendToAllPeers([]TopicInfo{info})
+	return &info, nil
+}
+
+func (h *topicHandler) Delete(topic string) error {
+	h.mu.Lock()
+	existing, found := h.topics[topic]
+	if !found || existing.Deleted {
+		h.mu.Unlock()
+		return NewHttpErrorf(http.StatusNotFound, "Topic '%s' not found", topic)
+	}
+
+	info := TopicInfo{Name: topic, Timestamp: registry.NewTimestamp(existing.Timestamp), Deleted: true}
+	err := h.save(info)
+	h.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	h.sendToAllPeers([]TopicInfo{info})
+	return nil
+}
+
+func (h *topicHandler) List() []TopicInfo {
+	h.mu.RLock()
+	result := make([]TopicInfo, 0, len(h.topics))
+	for _, info := range h.topics {
+		if !info.Deleted {
+			result = append(result, info)
+		}
+	}
+	h.mu.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// Merges the topics provided by a peer, using the last modification timestamp to resolve conflicts
+func (h *topicHandler) OnTopicsFromPeer(topics []TopicInfo) {
+	changed := make([]TopicInfo, 0)
+	h.mu.Lock()
+	for _, info := range topics {
+		existing, found := h.topics[info.Name]
+		if found && existing.Timestamp >= info.Timestamp {
+			continue
+		}
+		h.topics[info.Name] = info
+		changed = append(changed, info)
+	}
+	h.mu.Unlock()
+
+	for i := range changed {
+		if err := h.localDb.SaveTopic(&changed[i]); err != nil {
+			log.Err(err).Msgf("Topic '%s' received from peer could not be stored", changed[i].Name)
+		}
+	}
+}
+
+// Stores the topic and then sets it in memory, the caller must hold the lock
+func (h *topicHandler) save(info TopicInfo) error {
+	if err := h.localDb.SaveTopic(&info); err != nil {
+		return err
+	}
+	h.topics[info.Name] = info
+	return nil
+}
+
+// Gets all the topics, including tombstones
+func (h *topicHandler) snapshot() []TopicInfo {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	result := make([]TopicInfo, 0, len(h.topics))
+	for _, info := range h.topics {
+		result = append(result, info)
+	}
+	return result
+}
+
+func (h *topicHandler) sendToAllPeers(topics []TopicInfo) {
+	registry.SendToAllPeers(h.topologyGetter, "topics", func(ordinal int) error {
+		return h.gossiper.SendTopics(ordinal, topics)
+	})
+}
+
+// Periodically sends the full topic snapshot to the next brokers, to converge in case of missed changes
+func (h *topicHandler) sendTopicsToPeers() {
+	if h.config.DevMode() {
+		// There's never going to be a peer
+		return
+	}
+
+	registry.SendSnapshotsToPeers(h.topologyGetter, topicsToPeersDelay, "topics", func() registry.PeerSender {
+		topics := h.snapshot()
+		if len(topics) == 0 {
+			return nil
+		}
+		return func(ordinal int) error {
+			return h.gossiper.SendTopics(ordinal, topics)
+		}
+	})
+}
+
+func validateName(topic string) error {
+	if topic == "" || len(topic) > conf.MaxTopicLength || !topicNameRegex.MatchString(topic) ||
+		topic == "." || topic == ".." {
+		return NewHttpErrorf(
+			http.StatusBadRequest,
+			"Invalid topic name '%s': it must contain only alphanumeric, '.', '_' and '-' characters (max length %d)",
+			topic,
+			conf.MaxTopicLength)
+	}
+	return nil
+}
+
+// Validates the topic settings against the broker limits
+func (h *topicHandler) validateSettings(settings TopicSettings) error {
+	if settings.Mode != TopicModeDefault && settings.Mode != TopicModeCompacted {
+		return NewHttpErrorf(http.StatusBadRequest, "Invalid topic mode '%s'", settings.Mode)
+	}
+
+	if settings.Retention != "" && settings.Retention != "null" {
+		if value, err := time.ParseDuration(settings.Retention); err != nil || value <= 0 {
+			return NewHttpErrorf(http.StatusBadRequest, "Invalid retention value '%s'", settings.Retention)
+		}
+	}
+
+	if settings.Ttl != "" {
+		if value, err := time.ParseDuration(settings.Ttl); err != nil || value <= 0 {
+			return NewHttpErrorf(http.StatusBadRequest, "Invalid ttl value '%s'", settings.Ttl)
+		}
+	}
+
+	if settings.RetentionBytes < 0 {
+		return NewHttpError(http.StatusBadRequest, "Retention bytes must be a positive number")
+	}
+
+	if settings.MaxGroupSize < 0 || settings.MaxGroupSize > h.config.MaxGroupSize() {
+		return NewHttpErrorf(
+			http.StatusBadRequest, "Max group size must be a positive number less than %d", h.config.MaxGroupSize())
+	}
+
+	info := TopicInfo{Settings: settings}
+	maxGroupSize := info.MaxGroupSize(h.config.MaxGroupSize())
+	if settings.MaxMessageSize < 0 || info.MaxMessageSize(h.config.MaxMessageSize()) > maxGroupSize {
+		return NewHttpErrorf(
+			http.StatusBadRequest, "Max message size must be a positive number less than %d", maxGroupSize)
+	}
+
+	for _, quota := range []*Quota{settings.ProduceQuota, settings.ConsumeQuota} {
+		if quota != nil && (quota.BytesPerSecond < 0 || quota.RequestsPerSecond < 0) {
+			return NewHttpError(http.StatusBadRequest, "Quota rates must be positive numbers")
+		}
+	}
+	return nil
 }
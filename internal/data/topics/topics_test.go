@@ -0,0 +1,267 @@
+package topics
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	cMocks "github.com/polarstreams/polar/internal/test/conf/mocks"
+	dMocks "github.com/polarstreams/polar/internal/test/discovery/mocks"
+	iMocks "github.com/polarstreams/polar/internal/test/interbroker/mocks"
+	dbMocks "github.com/polarstreams/polar/internal/test/localdb/mocks"
+	. "github.com/polarstreams/polar/internal/types"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestTopics(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Topics Suite")
+}
+
+var _ = Describe("topicHandler", func() {
+	Describe("Init()", func() {
+		It("should load the topics from the local db", func() {
+			stored := []TopicInfo{
+				{Name: "a", Timestamp: 10},
+				{Name: "b", Timestamp: 20, Deleted: true},
+			}
+			h := newTestHandler(true, stored)
+
+			Expect(h.Exists("a")).To(BeTrue())
+			Expect(h.Get("a")).To(Equal(&stored[0]))
+			Expect(h.Exists("b")).To(BeFalse())
+			Expect(h.Get("b")).To(BeNil())
+			Expect(h.Exists("c")).To(BeFalse())
+			Expect(h.List()).To(Equal([]TopicInfo{stored[0]}))
+		})
+	})
+
+	Describe("GetOrCreate()", func() {
+		It("should create the topic when auto create is enabled", func() {
+			h := newTestHandler(true, nil)
+
+			info, err := h.GetOrCreate("t1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info).NotTo(BeNil())
+			Expect(info.Name).To(Equal("t1"))
+			Expect(h.Exists("t1")).To(BeTrue())
+			h.localDb.(*dbMocks.Client).AssertCalled(GinkgoT(), "SaveTopic", info)
+		})
+
+		It("should use the current time as timestamp", func() {
+			h := newTestHandler(true, []TopicInfo{{Name: "t2", Timestamp: 10, Deleted: true}})
+
+			info, err := h.GetOrCreate("t1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Timestamp).To(BeNumerically("~", time.Now().UnixMicro(), 1000*1000))
+			info, err = h.GetOrCreate("t2")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Timestamp).To(BeNumerically("~", time.Now().UnixMicro(), 1000*1000))
+		})
+
+		It("should not be superseded by an older tombstone from a peer", func() {
+			h := newTestHandler(true, nil)
+			deletedAt := time.Now().UnixMicro()
+
+			info, err := h.GetOrCreate("t1")
+			Expect(err).NotTo(HaveOccurred())
+			h.OnTopicsFromPeer([]TopicInfo{{Name: "t1", Timestamp: deletedAt, Deleted: true}})
+			Expect(h.Get("t1")).To(Equal(info))
+
+			// A newer tombstone is applied
+			h.OnTopicsFromPeer([]TopicInfo{{Name: "t1", Timestamp: info.Timestamp + 1, Deleted: true}})
+			Expect(h.Exists("t1")).To(BeFalse())
+		})
+
+		It("should return nil when auto create is disabled", func() {
+			h := newTestHandler(false, nil)
+
+			info, err := h.GetOrCreate("t1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info).To(BeNil())
+			Expect(h.Exists("t1")).To(BeFalse())
+		})
+
+		It("should return an error when the name is not valid", func() {
+			h := newTestHandler(true, nil)
+
+			for _, name := range []string{"a/b", "..", "a b", ""} {
+				_, err := h.GetOrCreate(name)
+				Expect(err).To(HaveOccurred())
+				Expect(err.(HttpError).StatusCode()).To(Equal(http.StatusBadRequest))
+			}
+		})
+	})
+
+	Describe("Create()", func() {
+		It("should return a conflict error when the topic exists", func() {
+			h := newTestHandler(false, []TopicInfo{{Name: "a", Timestamp: 10}})
+
+			_, err := h.Create("a", TopicSettings{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.(HttpError).StatusCode()).To(Equal(http.StatusConflict))
+		})
+
+		It("should recreate a deleted topic with a newer timestamp", func() {
+			deleted := TopicInfo{Name: "a", Timestamp: 1 << 62, Deleted: true}
+			h := newTestHandler(false, []TopicInfo{deleted})
+
+			info, err := h.Create("a", TopicSettings{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Deleted).To(BeFalse())
+			Expect(info.Timestamp).To(BeNumerically(">", deleted.Timestamp))
+		})
+		It("should not create the topic when it can not be stored", func() {
+			h := newTestHandler(false, nil)
+			localDb := new(dbMocks.Client)
+			localDb.On("SaveTopic", mock.Anything).Return(fmt.Errorf("Test error"))
+			h.localDb = localDb
+
+			_, err := h.Create("a", TopicSettings{})
+			Expect(err).To(MatchError("Test error"))
+			Expect(h.Exists("a")).To(BeFalse())
+		})
+
+		It("should store the settings", func() {
+			h := newTestHandler(false, nil)
+			settings := TopicSettings{Retention: "72h", MaxMessageSize: 1024, MaxGroupSize: 2048}
+
+			info, err := h.Create("a", settings)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Settings).To(Equal(settings))
+			Expect(h.Get("a").Settings).To(Equal(settings))
+		})
+
+		It("should return an error when the settings are not valid", func() {
+			h := newTestHandler(false, nil)
+
+			invalid := []TopicSettings{
+				{Retention: "abc"},
+				{Retention: "-1h"},
+				{Ttl: "abc"},
+				{Ttl: "0s"},
+				{MaxGroupSize: 4 * 1024 * 1024},
+				{MaxMessageSize: 2048, MaxGroupSize: 1024},
+				{MaxMessageSize: -1},
+				{RetentionBytes: -1},
+				{Mode: "abc"},
+			}
+			for _, settings := range invalid {
+				_, err := h.Create("a", settings)
+				Expect(err).To(HaveOccurred())
+				Expect(err.(HttpError).StatusCode()).To(Equal(http.StatusBadRequest))
+			}
+			Expect(h.Exists("a")).To(BeFalse())
+		})
+	})
+
+	Describe("UpdateSettings()", func() {
+		It("should replace the settings with a newer timestamp", func() {
+			h := newTestHandler(false, []TopicInfo{{Name: "a", Timestamp: 10}})
+			settings := TopicSettings{Retention: "null"}
+
+			info, err := h.UpdateSettings("a", settings)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Settings).To(Equal(settings))
+			Expect(info.Timestamp).To(BeNumerically(">", 10))
+			h.localDb.(*dbMocks.Client).AssertCalled(GinkgoT(), "SaveTopic", info)
+		})
+
+		It("should return an error when the mode changes", func() {
+			h := newTestHandler(false, []TopicInfo{{Name: "a", Timestamp: 10}})
+
+			_, err := h.UpdateSettings("a", TopicSettings{Mode: TopicModeCompacted})
+			Expect(err).To(HaveOccurred())
+			Expect(err.(HttpError).StatusCode()).To(Equal(http.StatusBadRequest))
+			Expect(h.Get("a").IsCompacted()).To(BeFalse())
+		})
+
+		It("should return not found when the topic does not exist", func() {
+			h := newTestHandler(false, nil)
+
+			_, err := h.UpdateSettings("a", TopicSettings{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.(HttpError).StatusCode()).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("Delete()", func() {
+		It("should store a tombstone", func() {
+			h := newTestHandler(false, []TopicInfo{{Name: "a", Timestamp: 10}})
+
+			Expect(h.Delete("a")).NotTo(HaveOccurred())
+			Expect(h.Exists("a")).To(BeFalse())
+			h.localDb.(*dbMocks.Client).AssertCalled(GinkgoT(), "SaveTopic", mock.MatchedBy(func(t *TopicInfo) bool {
+				return t.Name == "a" && t.Deleted && t.Timestamp > 10
+			}))
+		})
+
+		It("should keep the topic when the tombstone can not be stored", func() {
+			h := newTestHandler(false, []TopicInfo{{Name: "a", Timestamp: 10}})
+			localDb := new(dbMocks.Client)
+			localDb.On("SaveTopic", mock.Anything).Return(fmt.Errorf("Test error"))
+			h.localDb = localDb
+
+			Expect(h.Delete("a")).To(MatchError("Test error"))
+			Expect(h.Exists("a")).To(BeTrue())
+		})
+
+		It("should return not found when the topic does not exist", func() {
+			h := newTestHandler(false, nil)
+
+			err := h.Delete("a")
+			Expect(err).To(HaveOccurred())
+			Expect(err.(HttpError).StatusCode()).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("OnTopicsFromPeer()", func() {
+		It("should only apply newer changes", func() {
+			h := newTestHandler(false, []TopicInfo{{Name: "a", Timestamp: 10}, {Name: "b", Timestamp: 10}})
+
+			h.OnTopicsFromPeer([]TopicInfo{
+				{Name: "a", Timestamp: 9, Deleted: true},
+				{Name: "b", Timestamp: 11, Deleted: true},
+				{Name: "c", Timestamp: 5},
+			})
+
+			Expect(h.List()).To(Equal([]TopicInfo{{Name: "a", Timestamp: 10}, {Name: "c", Timestamp: 5}}))
+			h.localDb.(*dbMocks.Client).AssertNumberOfCalls(GinkgoT(), "SaveTopic", 2)
+		})
+	})
+})
+
+func newTestHandler(autoCreate bool, stored []TopicInfo) *topicHandler {
+	config := new(cMocks.Config)
+	config.On("TopicAutoCreate").Return(autoCreate)
+	config.On("DevMode").Return(true)
+	config.On("MaxGroupSize").Return(2 * 1024 * 1024)
+	config.On("MaxMessageSize").Return(1024 * 1024)
+
+	localDb := new(dbMocks.Client)
+	localDb.On("Topics").Return(stored, nil)
+	localDb.On("SaveTopic", mock.Anything).Return(nil)
+
+	discoverer := new(dMocks.Discoverer)
+	discoverer.On("Topology").Return(newTestTopology())
+
+	gossiper := new(iMocks.Gossiper)
+	gossiper.On("RegisterTopicInfoListener", mock.Anything)
+	gossiper.On("SendTopics", mock.Anything, mock.Anything).Return(nil)
+
+	h := NewHandler(config, localDb, discoverer, gossiper).(*topicHandler)
+	Expect(h.Init()).NotTo(HaveOccurred())
+	return h
+}
+
+func newTestTopology() *TopologyInfo {
+	brokers := make([]BrokerInfo, 3)
+	for i := range brokers {
+		brokers[i] = BrokerInfo{IsSelf: i == 0, Ordinal: i, HostName: fmt.Sprintf("test-%d", i)}
+	}
+	topology := NewTopology(brokers, 0)
+	return &topology
+}
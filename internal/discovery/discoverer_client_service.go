@@ -45,20 +45,29 @@ func (d *discoverer) startClientDiscoveryServer() error {
 
 	router.GET(conf.ClientDiscoveryUrl, utils.ToHandle(d.getTopologyHandler))
 
+	tlsConfig, err := utils.NewServerTlsConfig(d.config)
+	if err != nil {
+		return err
+	}
 	h2s := &http2.Server{}
 	server := &http.Server{
-		Addr:    address,
-		Handler: h2c.NewHandler(router, h2s),
+		Addr:      address,
+		Handler:   h2c.NewHandler(router, h2s),
+		TLSConfig: tlsConfig,
 	}
 
 	if err := http2.ConfigureServer(server, h2s); err != nil {
 		return err
 	}
+	listener, err := utils.Listen(address, tlsConfig)
+	if err != nil {
+		return err
+	}
 
 	c := make(chan bool, 1)
 	go func() {
 		c <- true
-		if err := server.ListenAndServe(); err != nil {
+		if err := server.Serve(listener); err != nil {
 			if err == http.ErrServerClosed {
 				log.Info().Msgf("Client discovery server stopped")
 			} else {
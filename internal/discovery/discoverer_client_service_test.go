@@ -27,6 +27,7 @@ var _ = Describe("discoverer", func() {
 			config.On("ProducerPort").Return(8901)
 			config.On("ProducerBinaryPort").Return(8904)
 			config.On("ConsumerPort").Return(8902)
+			config.On("TlsCertFile").Return("")
 
 			d := &discoverer{
 				config:    config,
@@ -76,6 +77,7 @@ var _ = Describe("discoverer", func() {
 			config.On("ProducerPort").Return(8901)
 			config.On("ProducerBinaryPort").Return(8904)
 			config.On("ConsumerPort").Return(8902)
+			config.On("TlsCertFile").Return("")
 
 			d := &discoverer{
 				config:    config,
@@ -344,6 +344,22 @@ func (c *configFake) FixedTopologyFilePollDelay() time.Duration {
 	return 10 * time.Second
 }
 
+func (c *configFake) TlsCertFile() string {
+	return ""
+}
+
+func (c *configFake) TlsKeyFile() string {
+	return ""
+}
+
+func (c *configFake) TlsClientCaFile() string {
+	return ""
+}
+
+func (c *configFake) TlsReloadInterval() time.Duration {
+	return time.Minute
+}
+
 func newConfigFake(ordinal int) *configFake {
 	return &configFake{
 		ordinal:      ordinal,
@@ -22,16 +22,18 @@ type dataConnection struct {
 	cli       *clientInfo
 	conn      net.Conn
 	handlers  sync.Map
+	version   uint8 // The message version used with the peer
 }
 
-func newDataConnection(cli *clientInfo, config conf.GossipConfig) (*dataConnection, error) {
-	conn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", cli.hostName, config.GossipDataPort()))
+func newDataConnection(cli *clientInfo, config conf.GossipConfig, auth *peerAuth) (*dataConnection, error) {
+	conn, err := auth.dial("tcp", fmt.Sprintf("%s:%d", cli.hostName, config.GossipDataPort()), cli.hostName)
 	if err != nil {
 		return nil, err
 	}
 
 	log.Debug().Msgf("Sending startup data message to %s", conn.RemoteAddr())
-	if err = sendStartupMessage(conn); err != nil {
+	version, err := sendStartupMessage(conn)
+	if err != nil {
 		conn.Close()
 		log.Warn().Msgf("Startup message could not be sent to %s: %s", conn.RemoteAddr(), err.Error())
 		return nil, fmt.Errorf("Startup message could not be sent: %s", err.Error())
@@ -60,6 +62,7 @@ func newDataConnection(cli *clientInfo, config conf.GossipConfig) (*dataConnecti
 		cli:       cli,
 		conn:      conn,
 		handlers:  sync.Map{},
+		version:   version,
 	}
 
 	go c.readDataResponses(config, closeHandler)
@@ -68,39 +71,44 @@ func newDataConnection(cli *clientInfo, config conf.GossipConfig) (*dataConnecti
 	return c, nil
 }
 
-func sendStartupMessage(conn net.Conn) error {
+// Sends the startup message and returns the message version to use with the peer
+func sendStartupMessage(conn net.Conn) (uint8, error) {
 	buffer := &bytes.Buffer{}
 	header := &header{
-		Version:    1,
+		Version:    messageVersion,
 		StreamId:   0,
 		Op:         startupOp,
 		BodyLength: 0,
 	}
 	if err := writeHeader(buffer, header); err != nil {
-		return err
+		return 0, err
 	}
 
 	if n, err := conn.Write(buffer.Bytes()); err != nil {
-		return err
+		return 0, err
 	} else if n < buffer.Len() {
-		return fmt.Errorf("Write too short")
+		return 0, fmt.Errorf("Write too short")
 	}
 
 	responseHeaderBuffer := make([]byte, headerSize)
 	if _, err := io.ReadFull(conn, responseHeaderBuffer); err != nil {
-		return err
+		return 0, err
 	}
 	responseHeader, err := readHeader(responseHeaderBuffer)
 
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	if responseHeader.Op != readyOp {
-		return fmt.Errorf("Expected ready message, obtained op %d", responseHeader.Op)
+		return 0, fmt.Errorf("Expected ready message, obtained op %d", responseHeader.Op)
 	}
 
-	return nil
+	if responseHeader.Version < messageVersion {
+		// The peer is running a previous version
+		return responseHeader.Version, nil
+	}
+	return messageVersion, nil
 }
 
 func (c *dataConnection) readDataResponses(config conf.GossipConfig, closeHandler func(string)) {
@@ -156,13 +164,13 @@ func (c *dataConnection) writeDataRequests(config conf.GossipConfig, closeHandle
 	// Start a buffer without much capacity on purpose
 	// On larger clusters, no data should flow except for the neighboring brokers
 	w := new(bytes.Buffer)
-	header := header{Version: 1} // Reuse the header
+	header := header{Version: c.version} // Reuse the header
 
 	for message := range c.cli.dataMessages {
 		w.Reset()
 		streamId := <-c.streamIds
 		header.StreamId = streamId
-		header.BodyLength = message.BodyLength()
+		header.BodyLength = message.BodyLength(header.Version)
 
 		// We marshal it first and then check if it can be sent
 		if err := message.Marshal(w, &header); err != nil {
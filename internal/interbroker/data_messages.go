@@ -30,7 +30,11 @@ const (
 	fileStreamResponseOp       opcode = 7
 )
 
-const messageVersion = 1
+// The version of the data messages supported by this broker, the lowest version between the peers is used.
+// Version 2 includes the max record timestamp in the chunk replication requests.
+const messageVersion = 2
+
+const timestampMessageVersion = 2
 
 type dataRequest interface {
 	// In a CAS operation, tries to mark the request as written (for the send portion) by the connection.
@@ -39,7 +43,7 @@ type dataRequest interface {
 
 	Marshal(w types.BufferBackedWriter, header *header) error
 	SetResponse(res dataResponse) error
-	BodyLength() uint32
+	BodyLength(version uint8) uint32
 }
 
 type dataResponse interface {
@@ -78,14 +82,19 @@ type chunkReplicationRequest struct {
 	meta         dataRequestMeta
 	topic        string
 	data         []byte
+	maxTimestamp int64             // The max timestamp of the records, zero when unknown
 	response     chan dataResponse // response from replica
 	appendResult chan error        // result from append as a replica
 	wasWritten   atomic.Int32
 	writeWg      *sync.WaitGroup
 }
 
-func (r *chunkReplicationRequest) BodyLength() uint32 {
-	return uint32(dataRequestMetaSize) + uint32(r.meta.TopicLength) + uint32(len(r.data))
+func (r *chunkReplicationRequest) BodyLength(version uint8) uint32 {
+	length := uint32(dataRequestMetaSize) + uint32(r.meta.TopicLength) + uint32(len(r.data))
+	if version >= timestampMessageVersion {
+		length += 8 // int64 for max timestamp
+	}
+	return length
 }
 
 func (r *chunkReplicationRequest) DataBlock() []byte {
@@ -104,6 +113,10 @@ func (r *chunkReplicationRequest) RecordLength() uint32 {
 	return r.meta.RecordLength
 }
 
+func (r *chunkReplicationRequest) MaxTimestamp() int64 {
+	return r.maxTimestamp
+}
+
 func (r *chunkReplicationRequest) SetResult(err error) {
 	r.appendResult <- err
 }
@@ -125,6 +138,11 @@ func (r *chunkReplicationRequest) Marshal(w types.BufferBackedWriter, header *he
 	if _, err := w.WriteString(r.topic); err != nil {
 		return err
 	}
+	if header.Version >= timestampMessageVersion {
+		if err := binary.Write(w, conf.Endianness, r.maxTimestamp); err != nil {
+			return err
+		}
+	}
 	if _, err := w.Write(r.data); err != nil {
 		return err
 	}
@@ -184,7 +202,7 @@ func (r *fileStreamRequest) TrySetAsWritten() bool {
 	return true
 }
 
-func (r *fileStreamRequest) BodyLength() uint32 {
+func (r *fileStreamRequest) BodyLength(version uint8) uint32 {
 	return uint32(dataRequestMetaSize) +
 		uint32(r.meta.TopicLength) +
 		4 // uint32 for max size
@@ -361,7 +379,7 @@ func unmarshalFileStreamResponse(header *header, r *bufio.Reader) dataResponse {
 }
 
 // decodes into a data request (without response channel)
-func unmarshalDataRequest(body []byte) (*chunkReplicationRequest, error) {
+func unmarshalDataRequest(version uint8, body []byte) (*chunkReplicationRequest, error) {
 	meta := dataRequestMeta{}
 	reader := bytes.NewReader(body)
 	if err := binary.Read(reader, conf.Endianness, &meta); err != nil {
@@ -371,10 +389,16 @@ func unmarshalDataRequest(body []byte) (*chunkReplicationRequest, error) {
 	index := dataRequestMetaSize
 	topic := string(body[index : index+int(meta.TopicLength)])
 	index += int(meta.TopicLength)
+	maxTimestamp := int64(0)
+	if version >= timestampMessageVersion {
+		maxTimestamp = int64(conf.Endianness.Uint64(body[index:]))
+		index += 8
+	}
 	request := &chunkReplicationRequest{
-		meta:  meta,
-		topic: topic,
-		data:  body[index:],
+		meta:         meta,
+		topic:        topic,
+		data:         body[index:],
+		maxTimestamp: maxTimestamp,
 	}
 
 	return request, nil
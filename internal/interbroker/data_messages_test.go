@@ -44,3 +44,55 @@ var _ = Describe("fileStreamRequest", func() {
 		})
 	})
 })
+
+var _ = Describe("chunkReplicationRequest", func() {
+	Describe("Marshal() / unmarshal", func() {
+		topic := "abc"
+		newRequest := func() *chunkReplicationRequest {
+			return &chunkReplicationRequest{
+				meta: dataRequestMeta{
+					SegmentId:    1,
+					Token:        2,
+					RangeIndex:   3,
+					GenVersion:   4,
+					StartOffset:  5,
+					RecordLength: 6,
+					TopicLength:  uint8(len(topic)),
+				},
+				topic:        topic,
+				data:         []byte("hello"),
+				maxTimestamp: 1234,
+			}
+		}
+
+		It("should include the max timestamp", func() {
+			r := newRequest()
+			buf := new(bytes.Buffer)
+			header := header{Version: messageVersion, StreamId: 3, BodyLength: r.BodyLength(messageVersion)}
+			Expect(r.Marshal(buf, &header)).To(Succeed())
+			Expect(buf.Len()).To(Equal(headerSize + int(header.BodyLength)))
+
+			obtainedHeader, err := readHeader(buf.Bytes())
+			Expect(err).NotTo(HaveOccurred())
+			obtained, err := unmarshalDataRequest(obtainedHeader.Version, buf.Bytes()[headerSize:])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(obtained.meta).To(Equal(r.meta))
+			Expect(obtained.topic).To(Equal(topic))
+			Expect(obtained.data).To(Equal([]byte("hello")))
+			Expect(obtained.MaxTimestamp()).To(Equal(int64(1234)))
+		})
+
+		It("should support peers running a previous version", func() {
+			r := newRequest()
+			buf := new(bytes.Buffer)
+			header := header{Version: 1, StreamId: 3, BodyLength: r.BodyLength(1)}
+			Expect(r.Marshal(buf, &header)).To(Succeed())
+			Expect(buf.Len()).To(Equal(headerSize + int(header.BodyLength)))
+
+			obtained, err := unmarshalDataRequest(1, buf.Bytes()[headerSize:])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(obtained.data).To(Equal([]byte("hello")))
+			Expect(obtained.MaxTimestamp()).To(BeZero())
+		})
+	})
+})
@@ -18,7 +18,7 @@ func (g *gossiper) acceptDataConnections() error {
 	port := g.config.GossipDataPort()
 	address := utils.GetServiceAddress(port, g.discoverer.LocalInfo(), g.config)
 
-	listener, err := net.Listen("tcp", address)
+	listener, err := g.auth.listen(address)
 	if err != nil {
 		return err
 	}
@@ -37,7 +37,7 @@ func (g *gossiper) acceptDataConnections() error {
 			}
 
 			log.Debug().Msgf("Accepted new gossip data connection on %v", conn.LocalAddr())
-			g.handleData(conn)
+			go g.authenticateData(conn)
 		}
 	}()
 
@@ -49,6 +49,15 @@ func (g *gossiper) acceptDataConnections() error {
 	return nil
 }
 
+func (g *gossiper) authenticateData(conn net.Conn) {
+	if err := g.auth.accept(conn); err != nil {
+		log.Warn().Err(err).Msgf("Gossip data connection from %s could not be authenticated", conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+	g.handleData(conn)
+}
+
 func (g *gossiper) handleData(conn net.Conn) {
 	s := &peerDataServer{
 		conn:           conn,
@@ -135,7 +144,7 @@ func (s *peerDataServer) serve() {
 }
 
 func (s *peerDataServer) handleChunkReplication(header *header, bodyBuf []byte, done chan bool) {
-	request, err := unmarshalDataRequest(bodyBuf)
+	request, err := unmarshalDataRequest(header.Version, bodyBuf)
 
 	if err != nil {
 		s.responses <- newErrorResponse("Parsing error", header)
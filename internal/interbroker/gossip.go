@@ -2,6 +2,7 @@ package interbroker
 
 import (
 	"bytes"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,6 +11,7 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -30,6 +32,7 @@ import (
 const waitForUpDelay = 200 * time.Millisecond
 const waitForUpMaxWait = 10 * time.Minute
 const contentType = "application/json"
+const principalHeaderKey = "X-Polar-Principal" // The principal on behalf of which a message is rerouted
 
 // TODO: Pass Context
 
@@ -46,14 +49,17 @@ type Gossiper interface {
 	// Starts opening connections to known peers.
 	OpenConnections()
 
-	// Sends a message to be handled as a leader of a token
+	// Sends a message to be handled as a leader of a token on behalf of the principal, returning the location of the
+	// produced records
 	SendToLeader(
 		replicationInfo ReplicationInfo,
 		topic string,
+		principal string,
 		querystring url.Values,
 		contentLength int64,
 		contentType string,
-		body io.Reader) error
+		recordHeaders http.Header,
+		body io.Reader) (*ProduceResponse, error)
 
 	// Sends a request to get file part to one or more peers
 	StreamFile(
@@ -70,21 +76,69 @@ type Gossiper interface {
 	// Sends a message to the broker with the ordinal number containing the local snapshot of consumers
 	SendConsumerGroups(ordinal int, groups []ConsumerGroup) error
 
-	SendConsumerRegister(ordinal int, id string, group string, topics []string, onNewGroup OffsetResetPolicy) error
+	SendConsumerRegister(
+		ordinal int,
+		id string,
+		group string,
+		topics []string,
+		onNewGroup OffsetResetPolicy,
+		filter string,
+		assignment *ManualAssignment,
+		ackSettings AckSettings,
+		recordFormat byte) error
 
 	SendConsumerCommit(ordinal int, id string) error
 
 	SendConsumerUnregister(ordinal int, id string) error
 
+	// Sends a message to the broker to move the position of a consumer group on the tokens it leads
+	SendConsumerSeek(ordinal int, group string, topic string, target *SeekTarget) error
+
 	// Sends a message to the broker with the committed offset of a consumer group
 	SendCommittedOffset(ordinal int, offsetKv *OffsetStoreKeyValue) error
 
+	// Sends a message to the broker with the ordinal number containing topics metadata
+	SendTopics(ordinal int, topics []TopicInfo) error
+
+	// Sends a message to the broker with the ordinal number containing ACL rules
+	SendAcls(ordinal int, rules []AclRule) error
+
 	// Sends a message to the next broker stating the current broker is shutting down
 	SendGoobye()
 
 	// Reads the producer offset of a certain past topic generatoin
 	ReadProducerOffset(ordinal int, topic *TopicDataId) (int64, error)
 
+	// Reads the idempotent producer sequences of a topic generation stored by the broker after the provided time
+	ReadProducerSequences(ordinal int, topic *TopicDataId, since time.Time) ([]ProducerSequenceInfo, error)
+
+	// Reads the lag of the consumer group on the tokens led by the broker with the ordinal number
+	ReadConsumerLag(ordinal int, group string) ([]ConsumerLag, error)
+
+	// Reads the consumer groups known by the broker with the ordinal number
+	ReadConsumerGroups(ordinal int) ([]ConsumerGroupInfo, error)
+
+	// Sends a message to the broker to remove the stored offsets of a consumer group
+	SendConsumerGroupDelete(ordinal int, group string) error
+
+	// Sends a message to the broker to copy the stored offsets of a consumer group into a new group
+	SendConsumerGroupClone(ordinal int, group string, target string, topic string) error
+
+	// Sends the acks or nacks of records delivered to a consumer in ack mode
+	SendConsumerAck(ordinal int, id string, acks []RecordAck, nack bool) error
+
+	// Sends the offsets explicitly committed by a consumer with manually assigned token ranges
+	SendConsumerOffsetCommit(ordinal int, id string, offsets []OffsetCommit) error
+
+	// Sends a record scheduled for delivery to a follower to be stored as a replica
+	SendScheduledRecord(ordinal int, record *ScheduledRecord) error
+
+	// Sends a message to a follower to remove the replica of a scheduled record that was delivered
+	SendScheduledDelete(ordinal int, id string) error
+
+	// Reads the amount of records of the topic routed to the dead-letter topic by the broker with the ordinal number
+	ReadDeadLetterStats(ordinal int, topic string) (*DeadLetterStats, error)
+
 	// Retrieves the file structure from the peers and merge it with the local file structure
 	MergeTopicFiles(peers []int, topic *TopicDataId, offset int64) error
 
@@ -94,6 +148,18 @@ type Gossiper interface {
 	// Adds a listener for rerouted messages
 	RegisterReroutedMessageListener(listener ReroutingListener)
 
+	// Adds a listener for topics metadata
+	RegisterTopicInfoListener(listener TopicInfoListener)
+
+	// Adds a listener for ACL rules
+	RegisterAclListener(listener AclListener)
+
+	// Adds a listener for records scheduled for delivery
+	RegisterScheduledRecordListener(listener ScheduledRecordListener)
+
+	// Adds a listener for the stats of the records routed to dead-letter topics
+	RegisterDeadLetterStatsListener(listener DeadLetterStatsListener)
+
 	// WaitForPeersUp blocks until all peers are UP
 	WaitForPeersUp()
 
@@ -160,17 +226,37 @@ type gossiper struct {
 	genListener          GenListener
 	consumerInfoListener ConsumerInfoListener
 	reroutingListener    ReroutingListener
+	topicInfoListener    TopicInfoListener
+	aclListener          AclListener
+	scheduledListener    ScheduledRecordListener
+	deadLetterListener   DeadLetterStatsListener
 	hostUpDownListeners  []PeerStateListener
 	connectionsMutex     sync.Mutex
 	connections          atomic.Value          // Map of connections with copy-on-write semantics
 	replicaWriters       *utils.CopyOnWriteMap // Map of SegmentWriter to be use for replicating data as a replica
+	auth                 *peerAuth             // Authenticates the connections between brokers
 }
 
 func (g *gossiper) Init() error {
+	auth, err := newPeerAuth(g.config, g.verifyPeerCertificate)
+	if err != nil {
+		return fmt.Errorf("Gossip TLS could not be initialized: %s", err.Error())
+	}
+	g.auth = auth
 	g.discoverer.RegisterListener(g)
 	return nil
 }
 
+// Checks that the peer certificate was issued for one of the brokers in the topology
+func (g *gossiper) verifyPeerCertificate(cert *x509.Certificate) error {
+	for _, broker := range g.discoverer.Topology().Brokers {
+		if cert.VerifyHostname(broker.HostName) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("Peer certificate '%s' does not match any broker in the topology", cert.Subject.CommonName)
+}
+
 func (g *gossiper) OnTopologyChange(previousTopology *TopologyInfo, topology *TopologyInfo) {
 	if len(topology.Brokers) > len(previousTopology.Brokers) {
 		log.Info().Msgf("Scaling up detected, opening connections to new brokers")
@@ -254,19 +340,49 @@ func (g *gossiper) RegisterReroutedMessageListener(listener ReroutingListener) {
 	g.reroutingListener = listener
 }
 
+func (g *gossiper) RegisterTopicInfoListener(listener TopicInfoListener) {
+	if g.topicInfoListener != nil {
+		panic("Listener registered multiple times")
+	}
+	g.topicInfoListener = listener
+}
+
+func (g *gossiper) RegisterAclListener(listener AclListener) {
+	if g.aclListener != nil {
+		panic("Listener registered multiple times")
+	}
+	g.aclListener = listener
+}
+
+func (g *gossiper) RegisterScheduledRecordListener(listener ScheduledRecordListener) {
+	if g.scheduledListener != nil {
+		panic("Listener registered multiple times")
+	}
+	g.scheduledListener = listener
+}
+
+func (g *gossiper) RegisterDeadLetterStatsListener(listener DeadLetterStatsListener) {
+	if g.deadLetterListener != nil {
+		panic("Listener registered multiple times")
+	}
+	g.deadLetterListener = listener
+}
+
 func (g *gossiper) SendToLeader(
 	replicationInfo ReplicationInfo,
 	topic string,
+	principal string,
 	querystring url.Values,
 	contentLength int64,
 	contentType string,
+	recordHeaders http.Header,
 	body io.Reader,
-) error {
+) (*ProduceResponse, error) {
 	c := g.getClientInfo(replicationInfo.Leader.Ordinal)
 	if c == nil {
 		msg := fmt.Sprintf("No routing client found for peer with ordinal %d as leader", replicationInfo.Leader.Ordinal)
 		log.Error().Msg(msg)
-		return fmt.Errorf(msg)
+		return nil, fmt.Errorf(msg)
 	}
 
 	ordinal := replicationInfo.Leader.Ordinal
@@ -276,29 +392,45 @@ func (g *gossiper) SendToLeader(
 
 	if broker == nil {
 		log.Debug().Msgf("Broker with ordinal %d not found", ordinal)
-		return fmt.Errorf("Broker with ordinal %d not found", ordinal)
+		return nil, fmt.Errorf("Broker with ordinal %d not found", ordinal)
 	}
 
 	metrics.ReroutedSent.With(prometheus.Labels{"target": strconv.Itoa(ordinal)}).Inc()
 	req, err := http.NewRequest(http.MethodPost, g.getPeerUrl(broker, urlPath), body)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	req.ContentLength = contentLength
 	req.Header.Set(ContentTypeHeaderKey, contentType)
+	if principal != "" {
+		req.Header.Set(principalHeaderKey, principal)
+	}
+	for name, values := range recordHeaders {
+		req.Header[name] = values
+	}
 	resp, err := c.routingClient.Do(req)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return types.NewHttpError(resp.StatusCode, resp.Status)
+		return nil, types.NewHttpError(resp.StatusCode, resp.Status)
 	}
 
-	return nil
+	if !strings.HasPrefix(resp.Header.Get(ContentTypeHeaderKey), MIMETypeJSON) {
+		// Leaders running a previous version respond with a plain text body, without the location of the records
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return &ProduceResponse{Topic: topic}, nil
+	}
+
+	var result ProduceResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
 }
 
 func (g *gossiper) WaitForPeersUp() {
@@ -448,6 +580,76 @@ func (g *gossiper) ReadProducerOffset(ordinal int, topic *TopicDataId) (int64, e
 	return value, err
 }
 
+func (g *gossiper) ReadProducerSequences(
+	ordinal int,
+	topic *TopicDataId,
+	since time.Time,
+) ([]ProducerSequenceInfo, error) {
+	url := fmt.Sprintf(
+		conf.GossipProducerSequencesUrl,
+		topic.Name,
+		topic.Token.String(),
+		topic.RangeIndex.String(),
+		topic.Version.String(),
+		strconv.FormatInt(since.UnixMicro(), 10))
+	r, err := g.requestGet(ordinal, url)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode == http.StatusNoContent {
+		return nil, GossipGetNotFound
+	}
+	var value []ProducerSequenceInfo
+	if err = json.NewDecoder(r.Body).Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (g *gossiper) ReadConsumerLag(ordinal int, group string) ([]ConsumerLag, error) {
+	r, err := g.requestGet(ordinal, fmt.Sprintf(conf.GossipConsumerLagUrl, url.PathEscape(group)))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	var value []ConsumerLag
+	if err = json.NewDecoder(r.Body).Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (g *gossiper) ReadConsumerGroups(ordinal int) ([]ConsumerGroupInfo, error) {
+	r, err := g.requestGet(ordinal, conf.GossipConsumerGroupListUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	var value []ConsumerGroupInfo
+	if err = json.NewDecoder(r.Body).Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (g *gossiper) ReadDeadLetterStats(ordinal int, topic string) (*DeadLetterStats, error) {
+	r, err := g.requestGet(ordinal, fmt.Sprintf(conf.GossipDeadLetterStatsUrl, topic))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	var value DeadLetterStats
+	if err = json.NewDecoder(r.Body).Decode(&value); err != nil {
+		return nil, err
+	}
+	return &value, nil
+}
+
 func (g *gossiper) MergeTopicFiles(peers []int, topic *TopicDataId, offset int64) error {
 	url := fmt.Sprintf(
 		conf.GossipReadFileStructureUrl,
@@ -538,12 +740,26 @@ func (g *gossiper) SendConsumerGroups(ordinal int, groups []ConsumerGroup) error
 	return err
 }
 
-func (g *gossiper) SendConsumerRegister(ordinal int, id string, group string, topics []string, onNewGroup OffsetResetPolicy) error {
+func (g *gossiper) SendConsumerRegister(
+	ordinal int,
+	id string,
+	group string,
+	topics []string,
+	onNewGroup OffsetResetPolicy,
+	filter string,
+	assignment *ManualAssignment,
+	ackSettings AckSettings,
+	recordFormat byte,
+) error {
 	message := ConsumerRegisterMessage{
-		Id:         id,
-		Group:      group,
-		Topics:     topics,
-		OnNewGroup: onNewGroup,
+		Id:           id,
+		Group:        group,
+		Topics:       topics,
+		OnNewGroup:   onNewGroup,
+		Filter:       filter,
+		Assignment:   assignment,
+		AckSettings:  ackSettings,
+		RecordFormat: recordFormat,
 	}
 	jsonBody, err := json.Marshal(message)
 	if err != nil {
@@ -567,6 +783,92 @@ func (g *gossiper) SendConsumerUnregister(ordinal int, id string) error {
 	return err
 }
 
+func (g *gossiper) SendConsumerSeek(ordinal int, group string, topic string, target *SeekTarget) error {
+	message := ConsumerSeekMessage{
+		Group:  group,
+		Topic:  topic,
+		Target: *target,
+	}
+	jsonBody, err := json.Marshal(message)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("json marshalling failed when creating consumer seek message")
+	}
+
+	r, err := g.requestPost(ordinal, conf.GossipConsumerSeekUrl, jsonBody)
+	defer bodyClose(r)
+	return err
+}
+
+func (g *gossiper) SendConsumerGroupDelete(ordinal int, group string) error {
+	r, err := g.requestPost(ordinal, fmt.Sprintf(conf.GossipConsumerGroupDelete, url.PathEscape(group)), nil)
+	defer bodyClose(r)
+	return err
+}
+
+func (g *gossiper) SendConsumerGroupClone(ordinal int, group string, target string, topic string) error {
+	message := ConsumerGroupCloneMessage{
+		Group:  group,
+		Target: target,
+		Topic:  topic,
+	}
+	jsonBody, err := json.Marshal(message)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("json marshalling failed when creating consumer group clone message")
+	}
+
+	r, err := g.requestPost(ordinal, conf.GossipConsumerGroupClone, jsonBody)
+	defer bodyClose(r)
+	return err
+}
+
+func (g *gossiper) SendConsumerAck(ordinal int, id string, acks []RecordAck, nack bool) error {
+	message := ConsumerAckMessage{
+		Id:   id,
+		Acks: acks,
+		Nack: nack,
+	}
+	jsonBody, err := json.Marshal(message)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("json marshalling failed when creating consumer ack message")
+	}
+
+	r, err := g.requestPost(ordinal, conf.GossipConsumerAckUrl, jsonBody)
+	defer bodyClose(r)
+	return err
+}
+
+func (g *gossiper) SendConsumerOffsetCommit(ordinal int, id string, offsets []OffsetCommit) error {
+	message := ConsumerOffsetCommitMessage{
+		Id:      id,
+		Offsets: offsets,
+	}
+	jsonBody, err := json.Marshal(message)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("json marshalling failed when creating consumer offset commit message")
+	}
+
+	r, err := g.requestPost(ordinal, conf.GossipConsumerCommitOffsets, jsonBody)
+	defer bodyClose(r)
+	return err
+}
+
+func (g *gossiper) SendScheduledRecord(ordinal int, record *ScheduledRecord) error {
+	jsonBody, err := json.Marshal(record)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("json marshalling failed when sending scheduled record")
+	}
+
+	r, err := g.requestPost(ordinal, conf.GossipScheduledRecordUrl, jsonBody)
+	defer bodyClose(r)
+	return err
+}
+
+func (g *gossiper) SendScheduledDelete(ordinal int, id string) error {
+	r, err := g.requestPost(ordinal, fmt.Sprintf(conf.GossipScheduledDeleteUrl, id), nil)
+	defer bodyClose(r)
+	return err
+}
+
 func (g *gossiper) SendCommittedOffset(ordinal int, kv *OffsetStoreKeyValue) error {
 	jsonBody, err := json.Marshal(kv)
 	if err != nil {
@@ -578,6 +880,36 @@ func (g *gossiper) SendCommittedOffset(ordinal int, kv *OffsetStoreKeyValue) err
 	return err
 }
 
+func (g *gossiper) SendTopics(ordinal int, topics []TopicInfo) error {
+	message := TopicsMessage{
+		Topics: topics,
+		Origin: g.discoverer.Topology().MyOrdinal(),
+	}
+	jsonBody, err := json.Marshal(message)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("json marshalling failed when creating topics message")
+	}
+
+	r, err := g.requestPost(ordinal, conf.GossipTopicsUrl, jsonBody)
+	defer bodyClose(r)
+	return err
+}
+
+func (g *gossiper) SendAcls(ordinal int, rules []AclRule) error {
+	message := AclsMessage{
+		Rules:  rules,
+		Origin: g.discoverer.Topology().MyOrdinal(),
+	}
+	jsonBody, err := json.Marshal(message)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("json marshalling failed when creating acls message")
+	}
+
+	r, err := g.requestPost(ordinal, conf.GossipAclsUrl, jsonBody)
+	defer bodyClose(r)
+	return err
+}
+
 func (g *gossiper) SendGoobye() {
 	if g.config.DevMode() {
 		return
@@ -197,7 +197,7 @@ func (g *gossiper) createClient(broker BrokerInfo) *clientInfo {
 
 				// When in-flight streams is below max, there's a single open connection
 				log.Debug().Msgf("Creating gossip connection to %s", addr)
-				conn, err := net.Dial(network, addr)
+				conn, err := g.auth.dial(network, addr, broker.HostName)
 				if err != nil {
 					clientInfo.startReconnection(g, &broker)
 					return conn, err
@@ -228,7 +228,7 @@ func (g *gossiper) createClient(broker BrokerInfo) *clientInfo {
 				// Pretend we are dialing a TLS endpoint
 				// When in-flight streams is below max, there's a single open connection
 				log.Debug().Msgf("Creating peer connection to %s for re-routing", addr)
-				conn, err := net.Dial(network, addr)
+				conn, err := g.auth.dial(network, addr, broker.HostName)
 				if err != nil {
 					return nil, err
 				}
@@ -245,7 +245,7 @@ func (g *gossiper) createClient(broker BrokerInfo) *clientInfo {
 		},
 	}
 
-	go clientInfo.openDataConnection(g.config)
+	go clientInfo.openDataConnection(g.config, g.auth)
 
 	return clientInfo
 }
@@ -279,11 +279,11 @@ type clientInfo struct {
 	readyNewGossipConnection chan bool         // Gets a message when the peer is ready to accept gossip connections
 }
 
-func (c *clientInfo) openDataConnection(config conf.GossipConfig) {
+func (c *clientInfo) openDataConnection(config conf.GossipConfig, auth *peerAuth) {
 	r := newReconnectionPolicy()
 	shouldExit := false
 	for !shouldExit || !c.isHostLeaving() {
-		dataConn, err := newDataConnection(c, config)
+		dataConn, err := newDataConnection(c, config, auth)
 		if err != nil {
 			log.Info().Msgf("Client gossip data connection to %s could not be opened, retrying", c.hostName)
 			delay := utils.Jitter(r.next())
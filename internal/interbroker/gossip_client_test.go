@@ -62,6 +62,50 @@ var _ = Describe("Gossiper", func() {
 		})
 	})
 
+	Describe("SendToLeader()", func() {
+		newTestRoutingGossiper := func(ts *httptest.Server) *gossiper {
+			const ordinal = 0
+			port, _ := strconv.Atoi(strings.Split(ts.URL, ":")[2])
+			config := new(cMocks.Config)
+			config.On("GossipPort").Return(port)
+			discoverer := new(dMocks.Discoverer)
+			discoverer.On("Topology").Return(newTestTopology(3, 1))
+			g := &gossiper{discoverer: discoverer, config: config}
+			setTestGossipClient(g, ordinal, ts)
+			g.getClientInfo(ordinal).routingClient = ts.Client()
+			return g
+		}
+		replication := ReplicationInfo{Leader: &BrokerInfo{Ordinal: 0}}
+
+		It("should decode the location of the records", func() {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set(ContentTypeHeaderKey, contentType)
+				fmt.Fprintln(w, `{"topic":"abc","token":"1","rangeIndex":2,"version":3,"startOffset":"4"}`)
+			}))
+			defer ts.Close()
+
+			g := newTestRoutingGossiper(ts)
+			response, err := g.SendToLeader(
+				replication, "abc", "", url.Values{}, 1, "text/plain", nil, strings.NewReader("a"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response).To(Equal(&ProduceResponse{
+				Topic: "abc", Token: 1, RangeIndex: 2, Version: 3, StartOffset: 4}))
+		})
+
+		It("should support the plain text response of leaders running a previous version", func() {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, "OK")
+			}))
+			defer ts.Close()
+
+			g := newTestRoutingGossiper(ts)
+			response, err := g.SendToLeader(
+				replication, "abc", "", url.Values{}, 1, "text/plain", nil, strings.NewReader("a"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response).To(Equal(&ProduceResponse{Topic: "abc"}))
+		})
+	})
+
 	Describe("SendConsumerCommit()", func() {
 		It("should call the server", func() {
 			var mu sync.Mutex
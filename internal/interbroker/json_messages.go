@@ -28,11 +28,48 @@ type ConsumerGroupInfoMessage struct {
 	Origin int             `json:"origin"` // The ordinal of the sender
 }
 
+type TopicsMessage struct {
+	Topics []TopicInfo `json:"topics"`
+	Origin int         `json:"origin"` // The ordinal of the sender
+}
+
+type AclsMessage struct {
+	Rules  []AclRule `json:"rules"`
+	Origin int       `json:"origin"` // The ordinal of the sender
+}
+
 type ConsumerRegisterMessage struct {
 	Id         string            `json:"id"`
 	Group      string            `json:"group"`
 	Topics     []string          `json:"topics"`
 	OnNewGroup OffsetResetPolicy `json:"onNewGroup"`
+	Filter     string            `json:"filter,omitempty"`
+	Assignment *ManualAssignment `json:"assignment,omitempty"`
+	AckSettings
+	RecordFormat byte `json:"recordFormat,omitempty"`
+}
+
+type ConsumerAckMessage struct {
+	Id   string      `json:"id"`
+	Acks []RecordAck `json:"acks"`
+	Nack bool        `json:"nack,omitempty"`
+}
+
+type ConsumerOffsetCommitMessage struct {
+	Id      string         `json:"id"`
+	Offsets []OffsetCommit `json:"offsets"`
+}
+
+type ConsumerSeekMessage struct {
+	Group  string     `json:"group"`
+	Topic  string     `json:"topic"`
+	Target SeekTarget `json:"target"`
+}
+
+type ConsumerGroupCloneMessage struct {
+	Group  string `json:"group"`
+	Target string `json:"target"`
+	Topic  string `json:"topic,omitempty"` // When empty, the offsets of all the topics are cloned
 }
 
 type TopicFileStructureMessage struct {
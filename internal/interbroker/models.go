@@ -2,6 +2,7 @@ package interbroker
 
 import (
 	"io"
+	"net/http"
 	"net/url"
 
 	. "github.com/google/uuid"
@@ -26,21 +27,76 @@ type ConsumerInfoListener interface {
 	OnOffsetFromPeer(kv *OffsetStoreKeyValue)
 
 	// Invoked when a consumer should be registered as a result of a peer request
-	OnRegisterFromPeer(id string, group string, topics []string, onNewGroup OffsetResetPolicy) error
+	OnRegisterFromPeer(
+		id string,
+		group string,
+		topics []string,
+		onNewGroup OffsetResetPolicy,
+		filter string,
+		assignment *ManualAssignment,
+		ackSettings AckSettings,
+		recordFormat byte) error
 
 	// Invoked when a consumer offset should be committed locally as a result of a peer request
 	OnCommitFromPeer(id string) error
 
 	OnUnregisterFromPeer(id string) error
+
+	// Invoked when the position of a consumer group should be moved locally as a result of a peer request
+	OnSeekFromPeer(group string, topic string, target *SeekTarget) error
+
+	// Invoked when a peer requests the lag of a consumer group on the tokens led by this broker
+	OnLagFromPeer(group string) ([]ConsumerLag, error)
+
+	// Invoked when a peer requests the consumer groups known by this broker
+	OnGroupsFromPeer() []ConsumerGroupInfo
+
+	// Invoked when the stored offsets of a consumer group should be removed locally as a result of a peer request
+	OnGroupDeleteFromPeer(group string) error
+
+	// Invoked when the stored offsets of a consumer group should be copied locally as a result of a peer request
+	OnGroupCloneFromPeer(group string, target string, topic string) error
+
+	// Invoked when the acks or nacks of records delivered by this broker are sent to a peer
+	OnAckFromPeer(id string, acks []RecordAck, nack bool) error
+
+	// Invoked when the offsets explicitly committed by a consumer with manually assigned token ranges are sent to a peer
+	OnOffsetCommitFromPeer(id string, offsets []OffsetCommit) error
+}
+
+type TopicInfoListener interface {
+	// Invoked when a peer sends the topics metadata
+	OnTopicsFromPeer(topics []TopicInfo)
+}
+
+type AclListener interface {
+	// Invoked when a peer sends ACL rules
+	OnAclsFromPeer(rules []AclRule)
 }
 
 type ReroutingListener interface {
+	// Invoked when a peer routes a message to this broker as the leader, on behalf of the original principal
 	OnReroutedMessage(
 		topic string,
+		principal string,
 		querystring url.Values,
 		contentLength int64,
 		contentType string,
-		body io.ReadCloser) error
+		recordHeaders http.Header,
+		body io.ReadCloser) (*ProduceResponse, error)
+}
+
+type ScheduledRecordListener interface {
+	// Invoked when a leader sends a record scheduled for delivery to be stored as a replica
+	OnScheduledRecordFromPeer(record *ScheduledRecord) error
+
+	// Invoked when a leader delivered a scheduled record and the replica should be removed
+	OnScheduledDeleteFromPeer(id string) error
+}
+
+type DeadLetterStatsListener interface {
+	// Invoked when a peer reads the amount of records of the topic routed by this broker to the dead-letter topic
+	OnDeadLetterStatsFromPeer(topic string) *DeadLetterStats
 }
 
 type PeerStateListener interface {
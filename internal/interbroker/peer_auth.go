@@ -0,0 +1,163 @@
+package interbroker
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/polarstreams/polar/internal/conf"
+	"github.com/polarstreams/polar/internal/utils"
+)
+
+const peerHandshakeTimeout = 5 * time.Second
+const peerNonceLength = 32
+const peerAccepted byte = 1
+
+// The roles included in the signatures of the shared secret handshake
+const (
+	peerClientRole byte = 1
+	peerServerRole byte = 2
+)
+
+// peerAuth authenticates the connections between brokers on the gossip and data ports.
+//
+// When gossip TLS is enabled, brokers use mTLS and the client certificates must match a host name in the topology.
+// Otherwise, when a shared secret is set, the server and the client send random challenges that the other side must
+// sign using the secret. When none are set, connections are not authenticated.
+type peerAuth struct {
+	serverTls *tls.Config
+	clientTls *tls.Config
+	secret    []byte
+}
+
+func newPeerAuth(config conf.GossipTlsConfig, verifyClient func(*x509.Certificate) error) (*peerAuth, error) {
+	serverTls, clientTls, err := utils.NewPeerTlsConfigs(config, verifyClient)
+	if err != nil {
+		return nil, err
+	}
+	a := &peerAuth{serverTls: serverTls, clientTls: clientTls}
+	if config.GossipSecret() != "" {
+		a.secret = []byte(config.GossipSecret())
+	}
+	return a, nil
+}
+
+// Listens on the TCP address, accepting TLS connections when gossip TLS is enabled
+func (a *peerAuth) listen(address string) (net.Listener, error) {
+	return utils.Listen(address, a.serverTls)
+}
+
+// Connects to the peer and authenticates the connection
+func (a *peerAuth) dial(network string, address string, hostName string) (net.Conn, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.clientTls != nil {
+		config := a.clientTls.Clone()
+		config.ServerName = hostName
+		conn = tls.Client(conn, config)
+	}
+
+	if err := a.handshake(conn, a.clientSecretHandshake); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Peer %s authentication failed: %s", address, err.Error())
+	}
+	return conn, nil
+}
+
+// Authenticates a connection accepted from a peer, the connection should be closed when it fails
+func (a *peerAuth) accept(conn net.Conn) error {
+	return a.handshake(conn, a.serverSecretHandshake)
+}
+
+func (a *peerAuth) handshake(conn net.Conn, secretHandshake func(net.Conn) error) error {
+	if a.serverTls == nil && a.secret == nil {
+		return nil
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(peerHandshakeTimeout)); err != nil {
+		return err
+	}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsConn.Handshake(); err != nil {
+			return err
+		}
+	} else if err := secretHandshake(conn); err != nil {
+		return err
+	}
+	return conn.SetDeadline(time.Time{})
+}
+
+// The server sends a challenge and the client responds with its signature and its own challenge. When the signature
+// is valid, the server responds with its signature of both challenges, so both peers prove they know the secret.
+func (a *peerAuth) serverSecretHandshake(conn net.Conn) error {
+	serverNonce, err := newPeerNonce()
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(serverNonce); err != nil {
+		return err
+	}
+
+	response := make([]byte, sha256.Size+peerNonceLength)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return err
+	}
+	signature, clientNonce := response[:sha256.Size], response[sha256.Size:]
+	if !hmac.Equal(signature, a.sign(peerClientRole, serverNonce, clientNonce)) {
+		return fmt.Errorf("Invalid shared secret signature")
+	}
+
+	_, err = conn.Write(append([]byte{peerAccepted}, a.sign(peerServerRole, serverNonce, clientNonce)...))
+	return err
+}
+
+func (a *peerAuth) clientSecretHandshake(conn net.Conn) error {
+	serverNonce := make([]byte, peerNonceLength)
+	if _, err := io.ReadFull(conn, serverNonce); err != nil {
+		return err
+	}
+	clientNonce, err := newPeerNonce()
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(append(a.sign(peerClientRole, serverNonce, clientNonce), clientNonce...)); err != nil {
+		return err
+	}
+
+	// The server closes the connection when the signature is not valid
+	result := make([]byte, 1+sha256.Size)
+	if _, err := io.ReadFull(conn, result); err != nil {
+		return fmt.Errorf("Shared secret rejected by peer: %s", err.Error())
+	}
+	if result[0] != peerAccepted {
+		return fmt.Errorf("Unexpected shared secret handshake result %d", result[0])
+	}
+	if !hmac.Equal(result[1:], a.sign(peerServerRole, serverNonce, clientNonce)) {
+		return fmt.Errorf("Invalid shared secret signature from peer")
+	}
+	return nil
+}
+
+// Signs the challenges along with the role of the signer, so the signature of one side can not be used by the other
+func (a *peerAuth) sign(role byte, serverNonce []byte, clientNonce []byte) []byte {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte{role})
+	mac.Write(serverNonce)
+	mac.Write(clientNonce)
+	return mac.Sum(nil)
+}
+
+func newPeerNonce() ([]byte, error) {
+	nonce := make([]byte, peerNonceLength)
+	_, err := rand.Read(nonce)
+	return nonce, err
+}
@@ -0,0 +1,95 @@
+package interbroker
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	dMocks "github.com/polarstreams/polar/internal/test/discovery/mocks"
+)
+
+var _ = Describe("peerAuth", func() {
+	Describe("handshake()", func() {
+		It("should accept peers using the same shared secret", func() {
+			server := &peerAuth{secret: []byte("secret1")}
+			client := &peerAuth{secret: []byte("secret1")}
+			serverErr, clientErr := secretHandshake(server, client)
+			Expect(serverErr).NotTo(HaveOccurred())
+			Expect(clientErr).NotTo(HaveOccurred())
+		})
+
+		It("should reject peers using a different shared secret", func() {
+			server := &peerAuth{secret: []byte("secret1")}
+			client := &peerAuth{secret: []byte("secret2")}
+			serverErr, clientErr := secretHandshake(server, client)
+			Expect(serverErr).To(MatchError("Invalid shared secret signature"))
+			Expect(clientErr).To(HaveOccurred())
+		})
+
+		It("should reject servers that don't know the shared secret", func() {
+			serverConn, clientConn := net.Pipe()
+			defer serverConn.Close()
+			go func() {
+				// Accepts any client signature and responds with an invalid signature
+				_, _ = serverConn.Write(make([]byte, peerNonceLength))
+				_, _ = io.ReadFull(serverConn, make([]byte, sha256.Size+peerNonceLength))
+				_, _ = serverConn.Write(append([]byte{peerAccepted}, make([]byte, sha256.Size)...))
+			}()
+
+			client := &peerAuth{secret: []byte("secret1")}
+			err := client.handshake(clientConn, client.clientSecretHandshake)
+			Expect(err).To(MatchError("Invalid shared secret signature from peer"))
+		})
+
+		It("should not exchange messages when authentication is not enabled", func() {
+			serverConn, _ := net.Pipe()
+			defer serverConn.Close()
+			Expect((&peerAuth{}).accept(serverConn)).To(Succeed())
+		})
+	})
+})
+
+var _ = Describe("gossiper", func() {
+	Describe("verifyPeerCertificate()", func() {
+		discoverer := new(dMocks.Discoverer)
+		discoverer.On("Topology").Return(newTestTopology(3, 0))
+		g := &gossiper{discoverer: discoverer}
+
+		It("should accept certificates issued for a broker in the topology", func() {
+			cert := &x509.Certificate{IPAddresses: []net.IP{net.ParseIP("127.0.0.3")}}
+			Expect(g.verifyPeerCertificate(cert)).To(Succeed())
+		})
+
+		It("should reject certificates not matching the brokers in the topology", func() {
+			cert := &x509.Certificate{
+				Subject:     pkix.Name{CommonName: "other"},
+				IPAddresses: []net.IP{net.ParseIP("127.0.0.4")},
+			}
+			Expect(g.verifyPeerCertificate(cert)).To(
+				MatchError("Peer certificate 'other' does not match any broker in the topology"))
+		})
+	})
+})
+
+func secretHandshake(server *peerAuth, client *peerAuth) (serverErr error, clientErr error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	defer listener.Close()
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	Expect(err).NotTo(HaveOccurred())
+	serverConn, err := listener.Accept()
+	Expect(err).NotTo(HaveOccurred())
+
+	serverResult := make(chan error, 1)
+	go func() {
+		serverResult <- server.accept(serverConn)
+		serverConn.Close()
+	}()
+	clientErr = client.handshake(clientConn, client.clientSecretHandshake)
+	clientConn.Close()
+	return <-serverResult, clientErr
+}
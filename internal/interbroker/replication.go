@@ -54,11 +54,12 @@ func (g *gossiper) SendToFollowers(
 
 		wg.Add(1)
 		request := &chunkReplicationRequest{
-			meta:     meta,
-			topic:    topic.Name,
-			data:     chunk.DataBlock(),
-			writeWg:  &wg,
-			response: response,
+			meta:         meta,
+			topic:        topic.Name,
+			data:         chunk.DataBlock(),
+			maxTimestamp: chunk.MaxTimestamp(),
+			writeWg:      &wg,
+			response:     response,
 		}
 		sent = append(sent, request)
 
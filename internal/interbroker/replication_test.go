@@ -109,3 +109,7 @@ func (c *fakeChunk) StartOffset() int64 {
 func (c *fakeChunk) RecordLength() uint32 {
 	return 0
 }
+
+func (c *fakeChunk) MaxTimestamp() int64 {
+	return 0
+}
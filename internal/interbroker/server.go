@@ -3,11 +3,11 @@ package interbroker
 import (
 	"encoding/json"
 	"fmt"
-	"net"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/polarstreams/polar/internal/conf"
@@ -43,7 +43,7 @@ func (g *gossiper) acceptHttpConnections() error {
 	port := g.config.GossipPort()
 	address := GetServiceAddress(port, g.discoverer.LocalInfo(), g.config)
 
-	listener, err := net.Listen("tcp", address)
+	listener, err := g.auth.listen(address)
 	if err != nil {
 		return err
 	}
@@ -89,20 +89,44 @@ func (g *gossiper) acceptHttpConnections() error {
 				":rangeIndex",
 				":version",
 				":offset"), ToHandle(g.getFileStructure))
+			router.GET(fmt.Sprintf(
+				conf.GossipProducerSequencesUrl,
+				":topic",
+				":token",
+				":rangeIndex",
+				":version",
+				":since"), ToHandle(g.getProducerSequences))
 			router.GET(fmt.Sprintf(conf.GossipHostIsUpUrl, ":broker"), ToHandle(g.getBrokerIsUpHandler))
+			router.GET(fmt.Sprintf(conf.GossipConsumerLagUrl, ":group"), ToHandle(g.getConsumerLag))
+			router.GET(conf.GossipConsumerGroupListUrl, ToHandle(g.getConsumerGroups))
+			router.GET(fmt.Sprintf(conf.GossipDeadLetterStatsUrl, ":topic"), ToHandle(g.getDeadLetterStats))
 
 			router.POST(conf.GossipConsumerGroupsInfoUrl, ToPostHandle(g.postConsumerGroupInfoHandler))
 			router.POST(conf.GossipConsumerOffsetUrl, ToPostHandle(g.postConsumerOffsetHandler))
 			router.POST(conf.GossipConsumerRegisterUrl, ToPostHandle(g.postConsumerRegister))
 			router.POST(fmt.Sprintf(conf.GossipConsumerCommitUrl, ":id"), ToPostHandle(g.postConsumerCommit))
 			router.POST(fmt.Sprintf(conf.GossipConsumerUnregisterUrl, ":id"), ToPostHandle(g.postConsumerUnregister))
+			router.POST(conf.GossipConsumerSeekUrl, ToPostHandle(g.postConsumerSeek))
+			router.POST(fmt.Sprintf(conf.GossipConsumerGroupDelete, ":group"), ToPostHandle(g.postConsumerGroupDelete))
+			router.POST(conf.GossipConsumerGroupClone, ToPostHandle(g.postConsumerGroupClone))
+			router.POST(conf.GossipConsumerAckUrl, ToPostHandle(g.postConsumerAck))
+			router.POST(conf.GossipConsumerCommitOffsets, ToPostHandle(g.postConsumerOffsetCommit))
+			router.POST(conf.GossipTopicsUrl, ToPostHandle(g.postTopicsHandler))
+			router.POST(conf.GossipAclsUrl, ToPostHandle(g.postAclsHandler))
+			router.POST(conf.GossipScheduledRecordUrl, ToPostHandle(g.postScheduledRecord))
+			router.POST(fmt.Sprintf(conf.GossipScheduledDeleteUrl, ":id"), ToPostHandle(g.postScheduledDelete))
 
 			// Routing message is part of gossip but it's usually made using a different client connection
-			router.POST(fmt.Sprintf(conf.RoutingMessageUrl, ":topic"), ToPostHandle(g.postReroutingHandler))
+			router.POST(fmt.Sprintf(conf.RoutingMessageUrl, ":topic"), ToHandle(g.postReroutingHandler))
 
 			// server.ServeConn() will block until the connection is not readable anymore
 			// start it in the background
 			go func() {
+				if err := g.auth.accept(conn); err != nil {
+					log.Warn().Err(err).Msgf("Gossip connection from %s could not be authenticated", conn.RemoteAddr())
+					conn.Close()
+					return
+				}
 				server.ServeConn(conn, &http2.ServeConnOpts{
 					Handler: h2c.NewHandler(router, server),
 				})
@@ -354,6 +378,47 @@ func (g *gossiper) getFileStructure(w http.ResponseWriter, r *http.Request, ps h
 	return nil
 }
 
+func (g *gossiper) getProducerSequences(w http.ResponseWriter, r *http.Request, ps httprouter.Params) error {
+	topic := ps.ByName("topic")
+	if topic == "" {
+		return fmt.Errorf("Empty topic")
+	}
+	token, err := strconv.ParseInt(ps.ByName("token"), 10, 64)
+	if err != nil {
+		return err
+	}
+	rangeIndex, err := strconv.ParseUint(ps.ByName("rangeIndex"), 10, 8)
+	if err != nil {
+		return err
+	}
+	version, err := strconv.ParseUint(ps.ByName("version"), 10, 32)
+	if err != nil {
+		return err
+	}
+	topicId := TopicDataId{
+		Name:       topic,
+		Token:      Token(token),
+		RangeIndex: RangeIndex(rangeIndex),
+		Version:    GenVersion(version),
+	}
+	since, err := strconv.ParseInt(ps.ByName("since"), 10, 64)
+	if err != nil {
+		return err
+	}
+
+	sequences, found, err := data.ReadProducerSequences(g.config, &topicId, time.UnixMicro(since))
+	if err != nil {
+		return err
+	}
+	if !found {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set(ContentTypeHeaderKey, contentType)
+	PanicIfErr(json.NewEncoder(w).Encode(sequences), "Unexpected error when serializing producer sequences")
+	return nil
+}
+
 func (g *gossiper) postConsumerGroupInfoHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) error {
 	var message ConsumerGroupInfoMessage
 	if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
@@ -380,7 +445,64 @@ func (g *gossiper) postConsumerRegister(w http.ResponseWriter, r *http.Request,
 	if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
 		return err
 	}
-	return g.consumerInfoListener.OnRegisterFromPeer(message.Id, message.Group, message.Topics, message.OnNewGroup)
+	return g.consumerInfoListener.OnRegisterFromPeer(
+		message.Id, message.Group, message.Topics, message.OnNewGroup, message.Filter, message.Assignment,
+		message.AckSettings, message.RecordFormat)
+}
+
+func (g *gossiper) postConsumerSeek(w http.ResponseWriter, r *http.Request, _ httprouter.Params) error {
+	var message ConsumerSeekMessage
+	if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+		return err
+	}
+	return g.consumerInfoListener.OnSeekFromPeer(message.Group, message.Topic, &message.Target)
+}
+
+func (g *gossiper) getConsumerLag(w http.ResponseWriter, r *http.Request, ps httprouter.Params) error {
+	value, err := g.consumerInfoListener.OnLagFromPeer(ps.ByName("group"))
+	if err != nil {
+		return err
+	}
+	w.Header().Set(ContentTypeHeaderKey, contentType)
+	return json.NewEncoder(w).Encode(value)
+}
+
+func (g *gossiper) getConsumerGroups(w http.ResponseWriter, r *http.Request, _ httprouter.Params) error {
+	w.Header().Set(ContentTypeHeaderKey, contentType)
+	return json.NewEncoder(w).Encode(g.consumerInfoListener.OnGroupsFromPeer())
+}
+
+func (g *gossiper) getDeadLetterStats(w http.ResponseWriter, r *http.Request, ps httprouter.Params) error {
+	w.Header().Set(ContentTypeHeaderKey, contentType)
+	return json.NewEncoder(w).Encode(g.deadLetterListener.OnDeadLetterStatsFromPeer(ps.ByName("topic")))
+}
+
+func (g *gossiper) postConsumerGroupDelete(w http.ResponseWriter, r *http.Request, ps httprouter.Params) error {
+	return g.consumerInfoListener.OnGroupDeleteFromPeer(ps.ByName("group"))
+}
+
+func (g *gossiper) postConsumerGroupClone(w http.ResponseWriter, r *http.Request, _ httprouter.Params) error {
+	var message ConsumerGroupCloneMessage
+	if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+		return err
+	}
+	return g.consumerInfoListener.OnGroupCloneFromPeer(message.Group, message.Target, message.Topic)
+}
+
+func (g *gossiper) postConsumerAck(w http.ResponseWriter, r *http.Request, _ httprouter.Params) error {
+	var message ConsumerAckMessage
+	if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+		return err
+	}
+	return g.consumerInfoListener.OnAckFromPeer(message.Id, message.Acks, message.Nack)
+}
+
+func (g *gossiper) postConsumerOffsetCommit(w http.ResponseWriter, r *http.Request, _ httprouter.Params) error {
+	var message ConsumerOffsetCommitMessage
+	if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+		return err
+	}
+	return g.consumerInfoListener.OnOffsetCommitFromPeer(message.Id, message.Offsets)
 }
 
 func (g *gossiper) postConsumerCommit(w http.ResponseWriter, r *http.Request, ps httprouter.Params) error {
@@ -400,9 +522,54 @@ func (g *gossiper) postConsumerUnregister(w http.ResponseWriter, r *http.Request
 	return g.consumerInfoListener.OnUnregisterFromPeer(id)
 }
 
+func (g *gossiper) postTopicsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) error {
+	var message TopicsMessage
+	if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+		return err
+	}
+
+	// Use the registered listener
+	g.topicInfoListener.OnTopicsFromPeer(message.Topics)
+	return nil
+}
+
+func (g *gossiper) postAclsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) error {
+	var message AclsMessage
+	if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+		return err
+	}
+
+	g.aclListener.OnAclsFromPeer(message.Rules)
+	return nil
+}
+
+func (g *gossiper) postScheduledRecord(w http.ResponseWriter, r *http.Request, _ httprouter.Params) error {
+	var record ScheduledRecord
+	if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+		return err
+	}
+	return g.scheduledListener.OnScheduledRecordFromPeer(&record)
+}
+
+func (g *gossiper) postScheduledDelete(w http.ResponseWriter, r *http.Request, ps httprouter.Params) error {
+	return g.scheduledListener.OnScheduledDeleteFromPeer(ps.ByName("id"))
+}
+
 func (g *gossiper) postReroutingHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) error {
 	metrics.ReroutedReceived.Inc()
 	topic := ps.ByName("topic")
-	return g.reroutingListener.OnReroutedMessage(
-		topic, r.URL.Query(), r.ContentLength, r.Header.Get(ContentTypeHeaderKey), r.Body)
+	response, err := g.reroutingListener.OnReroutedMessage(
+		topic,
+		r.Header.Get(principalHeaderKey),
+		r.URL.Query(),
+		r.ContentLength,
+		r.Header.Get(ContentTypeHeaderKey),
+		RecordHeaders(r.Header),
+		r.Body)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set(ContentTypeHeaderKey, contentType)
+	return json.NewEncoder(w).Encode(response)
 }
@@ -29,6 +29,31 @@ type Client interface {
 	// Retrieves all the stored offsets
 	Offsets() ([]OffsetStoreKeyValue, error)
 
+	// Removes the stored offsets of the consumer group for all the topics
+	DeleteOffsets(group string) error
+
+	// Stores the topic metadata, replacing the existing one (if any)
+	SaveTopic(topic *TopicInfo) error
+
+	// Retrieves all the stored topics, including the deleted ones
+	Topics() ([]TopicInfo, error)
+
+	// Stores the ACL rule, replacing the existing one with the same id (if any)
+	SaveAcl(rule *AclRule) error
+
+	// Retrieves all the stored ACL rules, including the deleted ones
+	Acls() ([]AclRule, error)
+
+	// Stores a record until the delivery time, replacing the existing one with the same id (if any)
+	SaveScheduledRecord(record *ScheduledRecord) error
+
+	// Gets the stored records which delivery time is before or equal to the provided unix time in milliseconds,
+	// sorted by delivery time and id, starting after the provided record when set
+	DueScheduledRecords(deliverAt int64, after *ScheduledRecord, limit int) ([]ScheduledRecord, error)
+
+	// Removes a stored scheduled record
+	DeleteScheduledRecord(id string) error
+
 	// Gets latest generation stored per token
 	LatestGenerations() ([]Generation, error)
 
@@ -75,8 +100,8 @@ func (c *client) Init() error {
 
 	for _, q := range migrationQueries {
 		_, err := db.Exec(q)
-		// When the container restarts, the `ALTER TABLE ...` command generates the error: `duplicate column name: cluster_size`.
-		if err != nil && !strings.Contains(err.Error(), "duplicate column name: cluster_size") {
+		// When the container restarts, the `ALTER TABLE ...` command generates the error: `duplicate column name: <name>`.
+		if err != nil && !strings.Contains(err.Error(), "duplicate column name: ") {
 			return err
 		}
 	}
@@ -122,5 +147,13 @@ func (c *client) Close() {
 	_ = c.queries.insertTransaction.Close()
 	_ = c.queries.selectOffsets.Close()
 	_ = c.queries.insertOffset.Close()
+	_ = c.queries.deleteGroupOffsets.Close()
+	_ = c.queries.selectTopics.Close()
+	_ = c.queries.insertTopic.Close()
+	_ = c.queries.selectAcls.Close()
+	_ = c.queries.insertAcl.Close()
+	_ = c.queries.insertScheduledRecord.Close()
+	_ = c.queries.selectDueScheduledRecords.Close()
+	_ = c.queries.deleteScheduledRecord.Close()
 	log.Err(c.db.Close()).Msg("Local db closed")
 }
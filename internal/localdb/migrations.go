@@ -1,6 +1,6 @@
 package localdb
 
-var migrationQueries = []string{migration1, migration2}
+var migrationQueries = []string{migration1, migration2, migration3, migration4, migration5, migration6, migration7}
 
 const migration1 = `
 	CREATE TABLE IF NOT EXISTS local_info (
@@ -50,3 +50,50 @@ const migration2 = `
 ALTER TABLE generations ADD cluster_size int NOT NULL DEFAULT 3;
 ALTER TABLE offsets ADD cluster_size int NOT NULL DEFAULT 3;
 `
+
+const migration3 = `
+	-- Topic metadata, deleted topics are kept as tombstones
+	CREATE TABLE IF NOT EXISTS topics (
+		name TEXT PRIMARY KEY,
+		timestamp BIGINT NOT NULL,
+		deleted INT NOT NULL
+	);
+`
+
+const migration4 = `
+ALTER TABLE topics ADD settings TEXT NOT NULL DEFAULT '{}'; -- json of TopicSettings
+`
+
+const migration5 = `
+	-- Records stored until the delivery time, on the leader and the followers of the token
+	CREATE TABLE IF NOT EXISTS scheduled_records (
+		id TEXT PRIMARY KEY,
+		topic TEXT NOT NULL,
+		token BIGINT NOT NULL,
+		range_index INT NOT NULL,
+		partition_key TEXT NOT NULL,
+		deliver_at BIGINT NOT NULL,
+		content_type TEXT NOT NULL,
+		headers TEXT NOT NULL, -- json of the record headers
+		body BLOB
+	);
+
+	CREATE INDEX IF NOT EXISTS scheduled_records_deliver_at ON scheduled_records (deliver_at);
+`
+
+const migration6 = `
+ALTER TABLE scheduled_records ADD ttl BIGINT NOT NULL DEFAULT 0; -- time to live of the record in milliseconds
+`
+
+const migration7 = `
+	-- ACL rules, deleted rules are kept as tombstones
+	CREATE TABLE IF NOT EXISTS acls (
+		id TEXT PRIMARY KEY,
+		principal TEXT NOT NULL,
+		operation TEXT NOT NULL,
+		topic TEXT NOT NULL,
+		group_name TEXT NOT NULL,
+		timestamp BIGINT NOT NULL,
+		deleted INT NOT NULL
+	);
+`
@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"math"
+	"net/http"
 	"strconv"
 	"strings"
 
@@ -21,6 +23,14 @@ type queries struct {
 	insertTransaction         *sql.Stmt
 	selectOffsets             *sql.Stmt
 	insertOffset              *sql.Stmt
+	deleteGroupOffsets        *sql.Stmt
+	selectTopics              *sql.Stmt
+	insertTopic               *sql.Stmt
+	selectAcls                *sql.Stmt
+	insertAcl                 *sql.Stmt
+	insertScheduledRecord     *sql.Stmt
+	selectDueScheduledRecords *sql.Stmt
+	deleteScheduledRecord     *sql.Stmt
 }
 
 func (c *client) prepareQueries() {
@@ -57,6 +67,31 @@ func (c *client) prepareQueries() {
 
 	c.queries.selectOffsets = c.prepare(
 		`SELECT group_name, topic, token, range_index, cluster_size, version, offset, source FROM offsets`)
+
+	c.queries.deleteGroupOffsets = c.prepare(`DELETE FROM offsets WHERE group_name = ?`)
+
+	c.queries.insertTopic = c.prepare(
+		`REPLACE INTO topics (name, timestamp, deleted, settings) VALUES (?, ?, ?, ?)`)
+
+	c.queries.selectTopics = c.prepare(`SELECT name, timestamp, deleted, settings FROM topics`)
+
+	const aclColumns = "id, principal, operation, topic, group_name, timestamp, deleted"
+
+	c.queries.insertAcl = c.prepare(fmt.Sprintf(`REPLACE INTO acls (%s) VALUES (?, ?, ?, ?, ?, ?, ?)`, aclColumns))
+
+	c.queries.selectAcls = c.prepare(fmt.Sprintf(`SELECT %s FROM acls`, aclColumns))
+
+	const scheduledRecordColumns = "id, topic, token, range_index, partition_key, deliver_at, ttl, content_type, headers, body"
+
+	c.queries.insertScheduledRecord = c.prepare(fmt.Sprintf(
+		`REPLACE INTO scheduled_records (%s) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, scheduledRecordColumns))
+
+	c.queries.selectDueScheduledRecords = c.prepare(fmt.Sprintf(
+		`SELECT %s FROM scheduled_records
+		WHERE deliver_at <= ? AND (deliver_at > ? OR (deliver_at = ? AND id > ?))
+		ORDER BY deliver_at, id LIMIT ?`, scheduledRecordColumns))
+
+	c.queries.deleteScheduledRecord = c.prepare(`DELETE FROM scheduled_records WHERE id = ?`)
 }
 
 func (c *client) prepare(query string) *sql.Stmt {
@@ -269,6 +304,109 @@ func (c *client) Offsets() ([]OffsetStoreKeyValue, error) {
 	return result, nil
 }
 
+func (c *client) DeleteOffsets(group string) error {
+	_, err := c.queries.deleteGroupOffsets.Exec(group)
+	return err
+}
+
+func (c *client) SaveTopic(topic *TopicInfo) error {
+	_, err := c.queries.insertTopic.Exec(
+		topic.Name, topic.Timestamp, topic.Deleted, topicSettingsToString(topic.Settings))
+	return err
+}
+
+func (c *client) Topics() ([]TopicInfo, error) {
+	rows, err := c.queries.selectTopics.Query()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]TopicInfo, 0)
+	defer rows.Close()
+
+	var settingsString string
+
+	for rows.Next() {
+		topic := TopicInfo{}
+		if err = rows.Scan(&topic.Name, &topic.Timestamp, &topic.Deleted, &settingsString); err != nil {
+			return result, err
+		}
+		topic.Settings = topicSettingsFromString(settingsString)
+		result = append(result, topic)
+	}
+	return result, nil
+}
+
+func (c *client) SaveAcl(rule *AclRule) error {
+	_, err := c.queries.insertAcl.Exec(
+		rule.Id, rule.Principal, rule.Operation, rule.Topic, rule.Group, rule.Timestamp, rule.Deleted)
+	return err
+}
+
+func (c *client) Acls() ([]AclRule, error) {
+	rows, err := c.queries.selectAcls.Query()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]AclRule, 0)
+	defer rows.Close()
+
+	for rows.Next() {
+		rule := AclRule{}
+		err = rows.Scan(
+			&rule.Id, &rule.Principal, &rule.Operation, &rule.Topic, &rule.Group, &rule.Timestamp, &rule.Deleted)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, rule)
+	}
+	return result, nil
+}
+
+func (c *client) SaveScheduledRecord(r *ScheduledRecord) error {
+	_, err := c.queries.insertScheduledRecord.Exec(
+		r.Id, r.Topic, r.Token, r.RangeIndex, r.PartitionKey, r.DeliverAt, r.Ttl, r.ContentType,
+		headersToString(r.Headers), r.Body)
+	return err
+}
+
+func (c *client) DueScheduledRecords(deliverAt int64, after *ScheduledRecord, limit int) ([]ScheduledRecord, error) {
+	afterDeliverAt := int64(math.MinInt64)
+	afterId := ""
+	if after != nil {
+		afterDeliverAt = after.DeliverAt
+		afterId = after.Id
+	}
+	rows, err := c.queries.selectDueScheduledRecords.Query(deliverAt, afterDeliverAt, afterDeliverAt, afterId, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]ScheduledRecord, 0)
+	defer rows.Close()
+
+	var headersString string
+
+	for rows.Next() {
+		r := ScheduledRecord{}
+		err = rows.Scan(
+			&r.Id, &r.Topic, &r.Token, &r.RangeIndex, &r.PartitionKey, &r.DeliverAt, &r.Ttl, &r.ContentType,
+			&headersString, &r.Body)
+		if err != nil {
+			return result, err
+		}
+		r.Headers = headersFromString(headersString)
+		result = append(result, r)
+	}
+	return result, nil
+}
+
+func (c *client) DeleteScheduledRecord(id string) error {
+	_, err := c.queries.deleteScheduledRecord.Exec(id)
+	return err
+}
+
 func parentsFromString(stringValue string) []GenId {
 	var result []GenId
 	utils.PanicIfErr(json.Unmarshal([]byte(stringValue), &result), "Unexpected error when deserializing parents")
@@ -301,3 +439,33 @@ func offsetSourceToString(s OffsetSource) string {
 	utils.PanicIfErr(err, "Unexpected error when serializing OffsetSource")
 	return string(bytes)
 }
+
+func headersFromString(stringValue string) http.Header {
+	var result http.Header
+	utils.PanicIfErr(json.Unmarshal([]byte(stringValue), &result), "Unexpected error when deserializing headers")
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
+
+func headersToString(h http.Header) string {
+	if len(h) == 0 {
+		return "{}"
+	}
+	bytes, err := json.Marshal(h)
+	utils.PanicIfErr(err, "Unexpected error when serializing headers")
+	return string(bytes)
+}
+
+func topicSettingsFromString(stringValue string) TopicSettings {
+	var result TopicSettings
+	utils.PanicIfErr(json.Unmarshal([]byte(stringValue), &result), "Unexpected error when deserializing TopicSettings")
+	return result
+}
+
+func topicSettingsToString(s TopicSettings) string {
+	bytes, err := json.Marshal(s)
+	utils.PanicIfErr(err, "Unexpected error when serializing TopicSettings")
+	return string(bytes)
+}
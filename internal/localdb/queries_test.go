@@ -417,6 +417,148 @@ var _ = Describe("Client", func() {
 			Expect(client.Offsets()).To(ContainElement(kv))
 		})
 	})
+
+	Describe("DeleteOffsets()", func() {
+		It("should remove the offsets of the group for all the topics", func() {
+			client := newTestClient()
+			value := Offset{Version: 1, ClusterSize: 3, Offset: 10, Token: -123}
+			kvs := []OffsetStoreKeyValue{
+				{Key: OffsetStoreKey{Group: "group_delete1", Topic: "topic1"}, Value: value},
+				{Key: OffsetStoreKey{Group: "group_delete1", Topic: "topic2"}, Value: value},
+				{Key: OffsetStoreKey{Group: "group_delete2", Topic: "topic1"}, Value: value},
+			}
+			for i := range kvs {
+				Expect(client.SaveOffset(&kvs[i])).NotTo(HaveOccurred())
+			}
+
+			Expect(client.DeleteOffsets("group_delete1")).NotTo(HaveOccurred())
+
+			offsets, err := client.Offsets()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(offsets).NotTo(ContainElement(kvs[0]))
+			Expect(offsets).NotTo(ContainElement(kvs[1]))
+			Expect(offsets).To(ContainElement(kvs[2]))
+		})
+	})
+
+	Describe("SaveTopic()", func() {
+		It("should insert and replace a topic", func() {
+			client := newTestClient()
+			defer client.Close()
+
+			topic := TopicInfo{
+				Name:      "topic1",
+				Timestamp: time.Now().UnixMicro(),
+				Settings:  TopicSettings{Retention: "2160h", MaxMessageSize: 1024},
+			}
+			Expect(client.SaveTopic(&topic)).NotTo(HaveOccurred())
+
+			result, err := client.Topics()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal([]TopicInfo{topic}))
+
+			// Upsert as a tombstone
+			deleted := TopicInfo{Name: "topic1", Timestamp: topic.Timestamp + 1, Deleted: true}
+			Expect(client.SaveTopic(&deleted)).NotTo(HaveOccurred())
+
+			result, err = client.Topics()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal([]TopicInfo{deleted}))
+		})
+	})
+
+	Describe("SaveAcl()", func() {
+		It("should insert and replace a rule", func() {
+			client := newTestClient()
+			defer client.Close()
+
+			rule := AclRule{
+				Id:        "rule1",
+				Principal: "billing",
+				Operation: AclOperationConsume,
+				Topic:     "orders.*",
+				Group:     "billing",
+				Timestamp: time.Now().UnixMicro(),
+			}
+			Expect(client.SaveAcl(&rule)).NotTo(HaveOccurred())
+
+			result, err := client.Acls()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal([]AclRule{rule}))
+
+			// Upsert as a tombstone
+			deleted := rule
+			deleted.Timestamp++
+			deleted.Deleted = true
+			Expect(client.SaveAcl(&deleted)).NotTo(HaveOccurred())
+
+			result, err = client.Acls()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal([]AclRule{deleted}))
+		})
+	})
+
+	Describe("DueScheduledRecords()", func() {
+		It("should return the records due sorted by delivery time", func() {
+			client := newTestClient()
+			defer client.Close()
+
+			records := []ScheduledRecord{
+				{Id: "r1", Topic: "topic1", Token: math.MinInt64, RangeIndex: 1, DeliverAt: 3000, Body: []byte("a")},
+				{
+					Id:           "r2",
+					Topic:        "topic1",
+					Token:        -123,
+					PartitionKey: "key1",
+					DeliverAt:    1000,
+					Ttl:          60000,
+					ContentType:  "text/plain",
+					Headers:      map[string][]string{"X-Polar-Header-A": {"1"}},
+					Body:         []byte("b"),
+				},
+				{Id: "r3", Topic: "topic2", Token: -123, DeliverAt: 5000, Body: []byte("c")},
+			}
+			for i := range records {
+				Expect(client.SaveScheduledRecord(&records[i])).NotTo(HaveOccurred())
+			}
+
+			result, err := client.DueScheduledRecords(3000, nil, 10)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal([]ScheduledRecord{records[1], records[0]}))
+
+			result, err = client.DueScheduledRecords(5000, nil, 1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal([]ScheduledRecord{records[1]}))
+
+			// Continue after the record
+			result, err = client.DueScheduledRecords(5000, &records[1], 1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal([]ScheduledRecord{records[0]}))
+
+			Expect(client.DeleteScheduledRecord("r2")).NotTo(HaveOccurred())
+			result, err = client.DueScheduledRecords(5000, nil, 10)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal([]ScheduledRecord{records[0], records[2]}))
+		})
+
+		It("should continue after the records with the same delivery time sorted by id", func() {
+			client := newTestClient()
+			defer client.Close()
+
+			records := []ScheduledRecord{
+				{Id: "r1", Topic: "topic1", DeliverAt: 1000, Body: []byte("a")},
+				{Id: "r2", Topic: "topic1", DeliverAt: 1000, Body: []byte("b")},
+				{Id: "r3", Topic: "topic1", DeliverAt: 1000, Body: []byte("c")},
+			}
+			for i := range records {
+				Expect(client.SaveScheduledRecord(&records[i])).NotTo(HaveOccurred())
+			}
+
+			result, err := client.DueScheduledRecords(1000, &records[0], 10)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal([]ScheduledRecord{records[1], records[2]}))
+		})
+	})
 })
 
 func newTestClient() *client {
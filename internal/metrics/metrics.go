@@ -20,6 +20,46 @@ var (
 		Help: "The total number of bytes for all the request bodies received by the producer server",
 	})
 
+	ProducerDuplicateRequests = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "polar_producer_duplicate_requests_total",
+		Help: "The total number of retried requests from idempotent producers that were not written again",
+	})
+
+	ProducerDeadLetterRecords = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "polar_producer_dead_letter_records_total",
+		Help: "The total number of records rejected by the producer that were routed to the dead-letter topic",
+	}, []string{"topic", "reason"})
+
+	ProducerDeadLetterFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "polar_producer_dead_letter_failures_total",
+		Help: "The total number of records rejected by the producer that could not be routed to the dead-letter topic",
+	}, []string{"topic"})
+
+	ClientAuthenticated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "polar_client_authenticated_total",
+		Help: "The total number of requests and binary connections of clients authenticated, by server",
+	}, []string{"server"})
+
+	ClientAuthenticationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "polar_client_authentication_failures_total",
+		Help: "The total number of requests and binary connections of clients that could not be authenticated",
+	}, []string{"server"})
+
+	QuotaRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "polar_quota_requests_total",
+		Help: "The total number of produce requests and consumer polls charged to the quotas, by client and by topic",
+	}, []string{"operation", "kind", "name"})
+
+	QuotaBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "polar_quota_bytes_total",
+		Help: "The total number of bytes produced and consumed charged to the quotas, by client and by topic",
+	}, []string{"operation", "kind", "name"})
+
+	QuotaThrottled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "polar_quota_throttled_total",
+		Help: "The total number of requests rejected as the client or the topic exceeded the quota",
+	}, []string{"operation", "kind", "name"})
+
 	CoalescerMessagesProcessed = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "polar_coalescer_messages_total",
 		Help: "The total number of processed messages by the coalescer (producer)",
@@ -71,6 +111,36 @@ var (
 		Name: "polar_consumer_open_connections",
 		Help: "The number of open connections to consumers that are being served",
 	})
+
+	ConsumerGroupLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "polar_consumer_group_lag",
+		Help: "The number of records not yet consumed by the group on the token ranges led by this broker",
+	}, []string{"group", "topic", "token", "range", "version"})
+
+	ConsumerExpiredRecords = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "polar_consumer_expired_records_total",
+		Help: "The total number of records skipped when serving consumers as their time-to-live expired",
+	}, []string{"topic"})
+
+	DatalogReclaimedBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "polar_datalog_reclaimed_bytes_total",
+		Help: "The total number of bytes of segment and index files removed by the retention policies",
+	})
+
+	DatalogRetentionUsedBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "polar_datalog_retention_used_bytes",
+		Help: "The number of bytes of segment and index files stored in this broker after the last clean up",
+	})
+
+	DatalogRetentionBudgetBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "polar_datalog_retention_budget_bytes",
+		Help: "The maximum number of bytes of segment and index files to store in this broker, zero when unlimited",
+	})
+
+	DatalogCompactionRemovedRecords = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "polar_datalog_compaction_removed_records_total",
+		Help: "The total number of records removed by the compaction of topics",
+	})
 )
 
 // Serve starts the metrics endpoint
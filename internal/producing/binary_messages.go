@@ -4,6 +4,8 @@ import (
 	"encoding/binary"
 	"fmt"
 	"hash/crc32"
+	"strconv"
+	"time"
 
 	"github.com/polarstreams/polar/internal/conf"
 	. "github.com/polarstreams/polar/internal/types"
@@ -31,13 +33,17 @@ const (
 // Flags.
 // Use fixed numbers (not iota) to make it harder to break the protocol by moving stuff around.
 const (
-	withTimestamp flags = 0b00000001
+	withTimestamp        flags = 0b00000001
+	withProducerSequence flags = 0b00000010
 )
 
 const (
 	serverError         errorCode = 0
 	routingError        errorCode = 1
 	leaderNotFoundError errorCode = 2
+	unauthorizedError   errorCode = 3
+	forbiddenError      errorCode = 4
+	throttledError      errorCode = 5
 )
 
 // Header for producer messages. Order of fields defines the serialization format.
@@ -105,6 +111,46 @@ func (r *errorResponse) BodyLength() int {
 	return len(r.message) + 1
 }
 
+// Contains the location in the log of the produced records
+type produceResponse struct {
+	streamId streamId
+	response *ProduceResponse
+}
+
+func (r *produceResponse) Marshal(w BufferBackedWriter) error {
+	if err := writeHeader(w, &binaryHeader{
+		Version:    messageVersion,
+		StreamId:   r.streamId,
+		Op:         produceResponseOp,
+		BodyLength: uint32(r.BodyLength()),
+	}); err != nil {
+		return err
+	}
+
+	// | topic length (uint8) | topic | token (int64) | range index (uint8) | version (uint32) | start offset (int64) |
+	if _, err := w.Write([]byte{byte(len(r.response.Topic))}); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(r.response.Topic)); err != nil {
+		return err
+	}
+	return binary.Write(w, conf.Endianness, struct {
+		Token       int64
+		RangeIndex  uint8
+		Version     uint32
+		StartOffset int64
+	}{
+		int64(r.response.Token),
+		uint8(r.response.RangeIndex),
+		uint32(r.response.Version),
+		r.response.StartOffset,
+	})
+}
+
+func (r *produceResponse) BodyLength() int {
+	return 1 + len(r.response.Topic) + 8 + 1 + 4 + 8
+}
+
 func writeHeader(w BufferBackedWriter, header *binaryHeader) error {
 	if err := binary.Write(w, conf.Endianness, header); err != nil {
 		return err
@@ -142,3 +188,33 @@ func newLeaderNotFoundErrorResponse(token Token, requestHeader *binaryHeader) bi
 		code:     leaderNotFoundError,
 	}
 }
+
+func newAuthenticationErrorResponse(err error, requestHeader *binaryHeader) binaryResponse {
+	return &errorResponse{
+		message:  err.Error(),
+		streamId: requestHeader.StreamId,
+		code:     unauthorizedError,
+	}
+}
+
+func newForbiddenErrorResponse(message string, requestHeader *binaryHeader) binaryResponse {
+	return &errorResponse{
+		message:  message,
+		streamId: requestHeader.StreamId,
+		code:     forbiddenError,
+	}
+}
+
+// Creates an error response with the amount of milliseconds the client should wait before sending more requests as
+// the message
+func newThrottledErrorResponse(err error, requestHeader *binaryHeader) binaryResponse {
+	retryAfter := time.Second
+	if throttledErr, ok := err.(ThrottledError); ok {
+		retryAfter = throttledErr.RetryAfter()
+	}
+	return &errorResponse{
+		message:  strconv.FormatInt(retryAfter.Milliseconds(), 10),
+		streamId: requestHeader.StreamId,
+		code:     throttledError,
+	}
+}
@@ -0,0 +1,39 @@
+package producing
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/polarstreams/polar/internal/conf"
+	. "github.com/polarstreams/polar/internal/types"
+)
+
+var _ = Describe("produceResponse", func() {
+	Describe("Marshal()", func() {
+		It("should write the location of the records", func() {
+			r := &produceResponse{streamId: 3, response: &ProduceResponse{
+				Topic:       "abc",
+				Token:       -1234,
+				RangeIndex:  1,
+				Version:     7,
+				StartOffset: 1001,
+			}}
+			w := new(bytes.Buffer)
+			Expect(r.Marshal(w)).NotTo(HaveOccurred())
+			Expect(w.Len()).To(Equal(binaryHeaderSize + r.BodyLength()))
+
+			buf := w.Bytes()
+			Expect(opcode(buf[4])).To(Equal(produceResponseOp))
+			Expect(conf.Endianness.Uint32(buf[5:])).To(Equal(uint32(r.BodyLength())))
+
+			body := buf[binaryHeaderSize:]
+			Expect(body[0]).To(Equal(byte(3)))
+			Expect(string(body[1:4])).To(Equal("abc"))
+			Expect(int64(conf.Endianness.Uint64(body[4:]))).To(Equal(int64(-1234)))
+			Expect(body[12]).To(Equal(byte(1)))
+			Expect(conf.Endianness.Uint32(body[13:])).To(Equal(uint32(7)))
+			Expect(int64(conf.Endianness.Uint64(body[17:]))).To(Equal(int64(1001)))
+		})
+	})
+})
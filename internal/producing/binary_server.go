@@ -1,28 +1,35 @@
 package producing
 
 import (
+	"crypto/tls"
 	"encoding/binary"
+	"fmt"
 	"io"
 	"net"
 	"net/url"
 	"time"
 
+	"github.com/polarstreams/polar/internal/auth"
 	"github.com/polarstreams/polar/internal/conf"
+	"github.com/polarstreams/polar/internal/data/acls"
+	"github.com/polarstreams/polar/internal/data/topics"
 	"github.com/polarstreams/polar/internal/discovery"
 	"github.com/polarstreams/polar/internal/interbroker"
 	"github.com/polarstreams/polar/internal/producing/pooling"
+	"github.com/polarstreams/polar/internal/quotas"
 	. "github.com/polarstreams/polar/internal/types"
 	"github.com/polarstreams/polar/internal/utils"
 	"github.com/rs/zerolog/log"
 )
 
 const maxResponseGroupSize = 16 * 1024
+const maxStartupBodyLength = 16 * 1024
 
-func (p *producer) acceptBinaryConnections() error {
+func (p *producer) acceptBinaryConnections(tlsConfig *tls.Config) error {
 	port := p.config.ProducerBinaryPort()
 	address := utils.GetServiceAddress(port, p.leaderGetter.LocalInfo(), p.config)
 
-	listener, err := net.Listen("tcp", address)
+	listener, err := utils.Listen(address, tlsConfig)
 	if err != nil {
 		return err
 	}
@@ -52,10 +59,16 @@ func (p *producer) acceptBinaryConnections() error {
 func (p *producer) handleBinaryConnection(conn net.Conn) {
 	s := binaryServer{
 		bufferPool:      p.bufferPool,
+		topicGetter:     p.topicGetter,
+		maxMessageSize:  p.config.MaxMessageSize(),
 		gossiper:        p.gossiper,
 		leaderGetter:    p.leaderGetter,
 		coalescerGetter: p,
+		authenticator:   p.authenticator,
+		authorizer:      p.authorizer,
+		limiter:         p.limiter,
 		conn:            conn,
+		remoteAddr:      conn.RemoteAddr().String(),
 		responses:       make(chan binaryResponse, 128),
 	}
 
@@ -65,10 +78,17 @@ func (p *producer) handleBinaryConnection(conn net.Conn) {
 
 type binaryServer struct {
 	bufferPool      pooling.BufferPool
+	topicGetter     topics.TopicGetter
+	maxMessageSize  int
 	gossiper        interbroker.Gossiper
 	leaderGetter    discovery.TopologyGetter
 	coalescerGetter coalescerGetter
+	authenticator   auth.Authenticator
+	authorizer      acls.Authorizer
+	limiter         quotas.Limiter
 	conn            io.ReadWriteCloser
+	remoteAddr      string
+	principal       string // The authenticated principal, empty when authentication is not enabled
 	initialized     bool
 	responses       chan binaryResponse
 }
@@ -87,13 +107,11 @@ func (s *binaryServer) serve() {
 		if !s.initialized {
 			s.initialized = true
 			// It's the first message
-			if header.Op != startupOp {
-				log.Error().Msgf("Invalid first message %v", header.Op)
-				s.responses <- newErrorResponse("Invalid first message", header)
-				break
+			if err := s.startup(header); err != nil {
+				// Let the writer send the error response before closing the connection
+				close(s.responses)
+				return
 			}
-			s.responses <- &emptyResponse{streamId: header.StreamId, op: readyOp}
-
 			continue
 		}
 
@@ -113,10 +131,42 @@ func (s *binaryServer) serve() {
 		s.responses <- newErrorResponse("Only producer operations are supported", header)
 
 	}
-	log.Debug().Msg("Closing producer client connection")
+	log.Debug().Str("principal", s.principal).Msg("Closing producer client connection")
 	_ = s.conn.Close()
 }
 
+// Handles the first message of the connection, authenticating the client when authentication is enabled
+func (s *binaryServer) startup(header *binaryHeader) error {
+	if header.Op != startupOp {
+		log.Error().Msgf("Invalid first message %v", header.Op)
+		s.responses <- newErrorResponse("Invalid first message", header)
+		return fmt.Errorf("Invalid first message")
+	}
+	if header.BodyLength > maxStartupBodyLength {
+		s.responses <- newErrorResponse("Startup message body too large", header)
+		return fmt.Errorf("Startup message body too large")
+	}
+
+	// The body contains the credentials: the API key or the JWT
+	body := make([]byte, header.BodyLength)
+	if _, err := io.ReadFull(s.conn, body); err != nil {
+		log.Warn().Err(err).Msg("Invalid startup message from producer client, closing connection")
+		return err
+	}
+
+	if s.authenticator.IsEnabled() {
+		principal, err := auth.Authenticated(s.authenticator, auth.ProducerBinaryServer, string(body), s.remoteAddr)
+		if err != nil {
+			s.responses <- newAuthenticationErrorResponse(err, header)
+			return err
+		}
+		s.principal = principal
+	}
+
+	s.responses <- &emptyResponse{streamId: header.StreamId, op: readyOp}
+	return nil
+}
+
 func (s *binaryServer) writeResponses() {
 	w := utils.NewBufferCap(maxResponseGroupSize)
 
@@ -190,6 +240,17 @@ func totalResponseSize(r binaryResponse) int {
 
 // Handles the message in the background and it returns an error when it's not safe to continue
 func (s *binaryServer) handleProduceMessage(header *binaryHeader) error {
+	client := quotas.Client(s.principal, s.remoteAddr)
+	if err := s.limiter.Charge(quotas.OperationProduce, client, int64(header.BodyLength)); err != nil {
+		// Skip the body without using the buffer pool
+		if _, err := io.CopyN(io.Discard, s.conn, int64(header.BodyLength)); err != nil {
+			log.Warn().Err(err).Msgf("Error reading from producer client")
+			return err
+		}
+		s.responses <- newThrottledErrorResponse(err, header)
+		return nil
+	}
+
 	bodyBuffers := s.bufferPool.Get(int(header.BodyLength))
 	if err := utils.ReadIntoBuffers(s.conn, bodyBuffers, int(header.BodyLength)); err != nil {
 		s.bufferPool.Free(bodyBuffers)
@@ -217,6 +278,21 @@ func (s *binaryServer) processProduceMessage(header *binaryHeader, bodyBuffers [
 		timestampMicros = int64(ts)
 	}
 
+	var producer *producerSequence
+	if header.Flags&withProducerSequence > 0 {
+		producerId, err := body.ReadStringBytes()
+		if err != nil {
+			return newErrorResponse(err.Error(), header)
+		}
+		sequence, err := body.ReadUint64()
+		if err != nil {
+			return newErrorResponse(err.Error(), header)
+		}
+		if producer, err = newProducerSequence(producerId, int64(sequence)); err != nil {
+			return newErrorResponse(err.Error(), header)
+		}
+	}
+
 	partitionKey, err := body.ReadStringBytes()
 	if err != nil {
 		return newErrorResponse(err.Error(), header)
@@ -227,6 +303,23 @@ func (s *binaryServer) processProduceMessage(header *binaryHeader, bodyBuffers [
 		return newErrorResponse(err.Error(), header)
 	}
 
+	if err := s.authorizer.Authorize(s.principal, AclOperationProduce, topic, ""); err != nil {
+		return newForbiddenErrorResponse(err.Error(), header)
+	}
+
+	// The client was charged before reading the body
+	if err := s.limiter.Charge(quotas.OperationProduce, "", int64(header.BodyLength), topic); err != nil {
+		return newThrottledErrorResponse(err, header)
+	}
+
+	topicInfo, err := s.topicGetter.GetOrCreate(topic)
+	if err != nil {
+		return newErrorResponse(err.Error(), header)
+	}
+	if topicInfo == nil {
+		return newErrorResponse(fmt.Sprintf("Topic '%s' not found", topic), header)
+	}
+
 	replication := s.leaderGetter.Leader(partitionKey)
 	leader := replication.Leader
 
@@ -235,23 +328,38 @@ func (s *binaryServer) processProduceMessage(header *binaryHeader, bodyBuffers [
 	}
 
 	payloadBuffers, payloadLength := body.Bytes()
+	if maxMessageSize := topicInfo.MaxMessageSize(s.maxMessageSize); payloadLength > maxMessageSize {
+		return newErrorResponse(fmt.Sprintf("Message size must be less than %d bytes", maxMessageSize), header)
+	}
+
 	if !leader.IsSelf {
 		// Route the message as-is
 		key := url.Values{}
 		if partitionKey != "" {
 			key.Set("partitionKey", partitionKey)
 		}
-		err := s.gossiper.SendToLeader(replication, topic, key, int64(payloadLength), MIMETypeProducerBinary, body)
+		producer.setQuery(key)
+		response, err := s.gossiper.SendToLeader(
+			replication, topic, s.principal, key, int64(payloadLength), MIMETypeProducerBinary, nil, body)
 		if err != nil {
 			return newRoutingErrorResponse(err, header)
 		}
-		return &emptyResponse{streamId: header.StreamId, op: produceResponseOp}
+		return &produceResponse{streamId: header.StreamId, response: response}
 	}
 
 	coalescer := s.coalescerGetter.Coalescer(topic, replication.Token, replication.RangeIndex)
-	if err := coalescer.append(replication, uint32(payloadLength), timestampMicros, MIMETypeProducerBinary, payloadBuffers); err != nil {
+	response, err := coalescer.append(
+		replication,
+		uint32(payloadLength),
+		timestampMicros,
+		MIMETypeProducerBinary,
+		partitionKey,
+		producer.appendHeaders(nil),
+		producer,
+		payloadBuffers)
+	if err != nil {
 		return newErrorResponse(err.Error(), header)
 	}
 
-	return &emptyResponse{streamId: header.StreamId, op: produceResponseOp}
+	return &produceResponse{streamId: header.StreamId, response: response}
 }
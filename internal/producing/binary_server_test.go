@@ -0,0 +1,146 @@
+package producing
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/polarstreams/polar/internal/auth"
+	"github.com/polarstreams/polar/internal/producing/pooling"
+	"github.com/polarstreams/polar/internal/test/conf/mocks"
+	. "github.com/polarstreams/polar/internal/types"
+)
+
+var _ = Describe("binaryServer", func() {
+	Describe("startup()", func() {
+		var authenticator auth.Authenticator
+
+		BeforeEach(func() {
+			keysFile := filepath.Join(newTestDir(), "keys.json")
+			Expect(os.WriteFile(keysFile, []byte(`[{"principal":"billing","key":"key1"}]`), 0600)).To(Succeed())
+			config := new(mocks.Config)
+			config.On("AuthApiKeysFile").Return(keysFile)
+			config.On("AuthJwksFile").Return("")
+			config.On("AuthReloadInterval").Return(time.Minute)
+			authenticator = auth.NewAuthenticator(config)
+			Expect(authenticator.Init()).To(Succeed())
+		})
+
+		It("should set the principal of the connection", func() {
+			s := newTestBinaryServer(authenticator, "key1")
+			Expect(s.startup(&binaryHeader{Op: startupOp, StreamId: 1, BodyLength: 4})).To(Succeed())
+			Expect(s.principal).To(Equal("billing"))
+			Expect(<-s.responses).To(Equal(&emptyResponse{streamId: 1, op: readyOp}))
+		})
+
+		It("should respond with an unauthorized error when the credentials are not valid", func() {
+			s := newTestBinaryServer(authenticator, "key2")
+			Expect(s.startup(&binaryHeader{Op: startupOp, StreamId: 1, BodyLength: 4})).NotTo(Succeed())
+			Expect(s.principal).To(Equal(""))
+			Expect(<-s.responses).To(Equal(&errorResponse{streamId: 1, code: unauthorizedError, message: "Unauthorized"}))
+		})
+	})
+
+	Describe("processProduceMessage()", func() {
+		It("should respond with a forbidden error when the principal is not allowed to produce to the topic", func() {
+			var authorized []string
+			s := &binaryServer{
+				bufferPool: pooling.NewBufferPool(2 * 8192),
+				authorizer: testAuthorizer(func(principal, operation, topic, group string) bool {
+					authorized = append(authorized, principal, operation, topic)
+					return false
+				}),
+				principal: "billing",
+			}
+			// Empty partition key, topic name and payload
+			body := append([]byte{0, 6}, []byte("orders")...)
+			body = append(body, []byte("abc")...)
+			buffers := s.bufferPool.Get(len(body))
+			copy(buffers[0], body)
+
+			header := &binaryHeader{Op: produceOp, StreamId: 2, BodyLength: uint32(len(body))}
+			response := s.processProduceMessage(header, buffers)
+			Expect(response).To(Equal(&errorResponse{streamId: 2, code: forbiddenError, message: "Forbidden"}))
+			Expect(authorized).To(Equal([]string{"billing", AclOperationProduce, "orders"}))
+		})
+	})
+
+	Describe("handleProduceMessage()", func() {
+		It("should skip the body and respond with a throttled error when the client exceeded the quota", func() {
+			var charged []string
+			s := newTestBinaryServer(nil, "abcdef")
+			s.principal = "billing"
+			s.limiter = testLimiter(func(client string, topics []string) error {
+				charged = append(charged, client)
+				return NewThrottledError(1500*time.Millisecond, "Throttled")
+			})
+
+			header := &binaryHeader{Op: produceOp, StreamId: 3, BodyLength: 4}
+			Expect(s.handleProduceMessage(header)).To(Succeed())
+			Expect(<-s.responses).To(Equal(&errorResponse{streamId: 3, code: throttledError, message: "1500"}))
+			Expect(charged).To(Equal([]string{"billing"}))
+
+			// The rest of the data can be read
+			remaining, err := io.ReadAll(s.conn)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(remaining)).To(Equal("ef"))
+		})
+	})
+})
+
+func newTestBinaryServer(authenticator auth.Authenticator, body string) *binaryServer {
+	return &binaryServer{
+		authenticator: authenticator,
+		conn:          readWriteNopCloser{bytes.NewReader([]byte(body))},
+		responses:     make(chan binaryResponse, 1),
+	}
+}
+
+type readWriteNopCloser struct {
+	io.Reader
+}
+
+func (readWriteNopCloser) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (readWriteNopCloser) Close() error {
+	return nil
+}
+
+func newTestDir() string {
+	dir, err := ioutil.TempDir("", "binary_server_test")
+	Expect(err).NotTo(HaveOccurred())
+	return dir
+}
+
+type testLimiter func(client string, topics []string) error
+
+func (f testLimiter) Init() error {
+	return nil
+}
+
+func (f testLimiter) Charge(operation string, client string, bytes int64, topics ...string) error {
+	return f(client, topics)
+}
+
+func (f testLimiter) ChargeBytes(operation string, client string, topic string, bytes int64) {}
+
+func (f testLimiter) BytesDelay(operation string, client string, topics ...string) time.Duration {
+	return 0
+}
+
+type testAuthorizer func(principal, operation, topic, group string) bool
+
+func (f testAuthorizer) Authorize(principal string, operation string, topic string, group string) error {
+	if !f(principal, operation, topic, group) {
+		return NewHttpError(http.StatusForbidden, "Forbidden")
+	}
+	return nil
+}
@@ -2,12 +2,15 @@ package producing
 
 import (
 	"bytes"
+	"net/http"
 	"time"
 
 	"github.com/klauspost/compress/zstd"
 	"github.com/polarstreams/polar/internal/conf"
 	"github.com/polarstreams/polar/internal/data"
+	"github.com/polarstreams/polar/internal/data/topics"
 	"github.com/polarstreams/polar/internal/discovery"
+	"github.com/polarstreams/polar/internal/interbroker"
 	"github.com/polarstreams/polar/internal/metrics"
 	"github.com/polarstreams/polar/internal/types"
 	"github.com/polarstreams/polar/internal/utils"
@@ -18,6 +21,9 @@ import (
 // at any time (in an orderly manner)
 const writeConcurrencyLevel = 2
 
+// The maximum time the requests of idempotent producers wait for the producer sequences to be loaded
+const sequencesLoadTimeout = 5 * time.Second
+
 // Groups records into compressed chunks and dispatches them in order
 // to the segment writers.
 //
@@ -29,12 +35,27 @@ type coalescer struct {
 	topicName       string
 	token           types.Token
 	rangeIndex      types.RangeIndex
+	topicGetter     topics.TopicGetter
 	generationState discovery.TopologyGetter
 	replicator      types.Replicator
+	gossiper        interbroker.Gossiper
 	config          conf.ProducerConfig
 	offset          int64
 	buffers         coalescerBuffers
 	writer          *data.SegmentWriter
+	sequences       *producerSequences // The sequences of the idempotent producers
+	sequencesLoaded bool               // Determines whether the sequences of the previous generations were loaded
+	loading         bool               // Determines whether the sequences are being loaded in the background
+	loadResults     chan *loadedSequences
+	parked          []*recordItem    // The items of idempotent producers waiting for the sequences to be loaded
+	parkedTimeout   <-chan time.Time // Fires when the parked items waited too long, nil when there are none
+}
+
+// The sequences of the idempotent producers read from the previous generations of a token range
+type loadedSequences struct {
+	topic       types.TopicDataId // The topic of the writer the sequences were loaded for
+	versions    []types.GenVersion
+	generations [][]types.ProducerSequenceInfo
 }
 
 func newBuffers(config conf.ProducerConfig) coalescerBuffers {
@@ -57,8 +78,10 @@ func newCoalescer(
 	topicName string,
 	token types.Token,
 	rangeIndex types.RangeIndex,
+	topicGetter topics.TopicGetter,
 	generationState discovery.TopologyGetter,
 	replicator types.Replicator,
+	gossiper interbroker.Gossiper,
 	config conf.ProducerConfig,
 ) *coalescer {
 	c := &coalescer{
@@ -66,12 +89,16 @@ func newCoalescer(
 		topicName:       topicName,
 		token:           token,
 		rangeIndex:      rangeIndex,
+		topicGetter:     topicGetter,
 		generationState: generationState,
 		replicator:      replicator,
+		gossiper:        gossiper,
 		config:          config,
 		offset:          0,
 		buffers:         newBuffers(config),
 		writer:          nil,
+		sequences:       newProducerSequences(),
+		loadResults:     make(chan *loadedSequences),
 	}
 	// Start processing in the background
 	go c.process()
@@ -82,12 +109,16 @@ func (c *coalescer) process() {
 	var item *recordItem = nil
 	var bufferIndex uint8
 	for {
-		group := newCoalescerGroup(c.offset, c.config.MaxGroupSize())
+		// The topic settings can change over time
+		maxGroupSize := c.topicGetter.Get(c.topicName).MaxGroupSize(c.config.MaxGroupSize())
+		group := newCoalescerGroup(c.offset, maxGroupSize)
 		var err error
 
 		// Block receiving the first item or when there isn't a buffered item
 		if item == nil {
-			item = <-c.items
+			if item = c.nextItem(); item == nil {
+				continue
+			}
 		}
 
 		gen := c.generationState.Generation(c.token)
@@ -119,6 +150,8 @@ func (c *coalescer) process() {
 				item = nil
 				continue
 			}
+			// The previous generation might have been led by another broker
+			c.sequencesLoaded = false
 		}
 
 		if c.writer.Topic.Version != types.GenVersion(gen.Version) {
@@ -135,6 +168,10 @@ func (c *coalescer) process() {
 		}
 
 		// Either there was a buffered item or we just received it
+		if !c.accept(item) {
+			item = nil
+			continue
+		}
 		item = group.tryAdd(item)
 
 		canAddNext := true
@@ -144,6 +181,10 @@ func (c *coalescer) process() {
 			// or the max length for a group was reached
 			select {
 			case item = <-c.items:
+				if !c.accept(item) {
+					item = nil
+					continue
+				}
 				item = group.tryAdd(item)
 				if item != nil {
 					// The group can't contain the new item
@@ -182,7 +223,16 @@ func (c *coalescer) compress(index *uint8, group *coalescerGroup) ([]byte, int,
 	compressor.Reset(buf)
 	totalRecordLength := 0
 
+	format := group.format()
+	if err := data.WriteRecordFormat(compressor, format); err != nil {
+		return nil, 0, err
+	}
+
 	for _, item := range group.items {
+		// All the records in the chunk use the same format
+		item.format = format
+		item.version = c.writer.Topic.Version
+		item.startOffset = group.offset + int64(totalRecordLength)
 		recordLength, err := item.marshal(compressor)
 		if err != nil {
 			return nil, 0, err
@@ -197,23 +247,258 @@ func (c *coalescer) compress(index *uint8, group *coalescerGroup) ([]byte, int,
 	return buf.Bytes(), totalRecordLength, nil
 }
 
+// Gets the next item to process: a parked item once the sequences were loaded or an item from the channel.
+// Returns nil when the loading of the sequences finished or timed out instead.
+func (c *coalescer) nextItem() *recordItem {
+	if c.sequencesLoaded && len(c.parked) > 0 {
+		item := c.parked[0]
+		c.parked = c.parked[1:]
+		return item
+	}
+
+	select {
+	case item := <-c.items:
+		return item
+	case result := <-c.loadResults:
+		c.onSequencesLoaded(result)
+	case <-c.parkedTimeout:
+		c.rejectParked()
+	}
+	return nil
+}
+
+// Checks the sequence of the idempotent producer requests, responding to the retried ones.
+// Returns true when the item should be written.
+//
+// The items are parked while the sequences of the previous generations are loaded in the background.
+func (c *coalescer) accept(item *recordItem) bool {
+	if item.producer == nil || item.sequencePending {
+		return true
+	}
+
+	if !c.sequencesLoaded {
+		c.park(item)
+		return false
+	}
+
+	state, written := c.sequences.check(item.producer)
+	switch state {
+	case sequenceDuplicate:
+		metrics.ProducerDuplicateRequests.Inc()
+		// Respond with the location of the records that were already written
+		item.version = written.Version
+		item.startOffset = written.StartOffset
+		item.response <- nil
+		return false
+	case sequenceInProgress:
+		item.response <- newSequenceInProgressError()
+		return false
+	case sequenceTooOld:
+		item.response <- newSequenceTooOldError(item.producer)
+		return false
+	}
+
+	item.sequencePending = true
+	return true
+}
+
+// Holds the item until the sequences are loaded, starting to load them when needed
+func (c *coalescer) park(item *recordItem) {
+	if len(c.parked) == 0 {
+		c.parkedTimeout = time.After(sequencesLoadTimeout)
+	}
+	c.parked = append(c.parked, item)
+	if !c.loading {
+		c.startLoading()
+	}
+}
+
+// Loads the sequences for the current writer in the background, the result is received in the processing loop
+func (c *coalescer) startLoading() {
+	c.loading = true
+	topic := c.writer.Topic
+	go func() {
+		c.loadResults <- c.loadSequences(topic)
+	}()
+}
+
+func (c *coalescer) onSequencesLoaded(result *loadedSequences) {
+	c.loading = false
+	if c.writer == nil || c.writer.Topic != result.topic {
+		// There was a generational change while loading, load the sequences for the current writer
+		log.Debug().Msgf("Discarding producer sequences loaded for %s", &result.topic)
+		if len(c.parked) > 0 && c.writer != nil {
+			c.startLoading()
+		}
+		return
+	}
+
+	total := 0
+	for i := len(result.generations) - 1; i >= 0; i-- {
+		// Add them in order
+		for _, s := range result.generations[i] {
+			c.sequences.add(s.ProducerId, s.Sequence, c.produceResponse(result.versions[i], s.Offset))
+			total++
+		}
+	}
+	c.sequencesLoaded = true
+	c.parkedTimeout = nil
+	log.Debug().Msgf(
+		"Loaded %d producer sequences from %d previous generations of %s", total, len(result.generations), &result.topic)
+}
+
+// Responds to the parked items with an error, only the idempotent producers are affected while the sequences can not
+// be loaded
+func (c *coalescer) rejectParked() {
+	log.Warn().Msgf("Producer sequences of topic '%s' and token %d could not be loaded in time", c.topicName, c.token)
+	for _, item := range c.parked {
+		// Not using a no write attempted error, as the records of idempotent producers should not be rerouted
+		item.response <- types.NewHttpError(
+			http.StatusServiceUnavailable, "The sequences of the idempotent producers could not be loaded in time")
+	}
+	c.parked = nil
+	c.parkedTimeout = nil
+}
+
+// Loads the sequences of the idempotent producers from the data of the previous generations of the token range,
+// covering the time window in which the sequences of a producer are tracked.
+//
+// It's invoked in the background as it can read from the peers, it must not modify the state of the coalescer.
+func (c *coalescer) loadSequences(writerTopic types.TopicDataId) *loadedSequences {
+	result := &loadedSequences{
+		topic:       writerTopic,
+		versions:    make([]types.GenVersion, 0),
+		generations: make([][]types.ProducerSequenceInfo, 0),
+	}
+	since := time.Now().Add(-producerSequenceExpiration)
+	topic := writerTopic
+	successor := c.generationState.GenerationInfo(topic.GenId())
+	for successor == nil || !successor.Time().Before(since) {
+		// The records of the previous generation were written before the successor was created
+		version, found := c.previousVersion(&topic)
+		if !found {
+			break
+		}
+		topic.Version = version
+		successor = c.generationState.GenerationInfo(topic.GenId())
+		sequences, found := c.readSequences(&topic, successor, since)
+		if !found && successor == nil {
+			// There's no more information of the past generations
+			break
+		}
+		result.versions = append(result.versions, version)
+		result.generations = append(result.generations, sequences)
+	}
+	return result
+}
+
+// Gets the version of the generation that preceded the one of the topic
+func (c *coalescer) previousVersion(topic *types.TopicDataId) (types.GenVersion, bool) {
+	if topic.Version > 1 {
+		previous := types.GenId{Start: topic.Token, Version: topic.Version - 1}
+		if c.generationState.GenerationInfo(previous) != nil {
+			return previous.Version, true
+		}
+	}
+	// Fallback to the data on local disk
+	return data.PreviousLocalVersion(c.config, topic)
+}
+
+// Reads the producer sequences of a past generation from the local disk and the peers that were replicas of it.
+// Returns false when the data of the generation was not found.
+func (c *coalescer) readSequences(
+	topic *types.TopicDataId,
+	gen *types.Generation,
+	since time.Time,
+) ([]types.ProducerSequenceInfo, bool) {
+	myOrdinal := c.generationState.Topology().MyOrdinal()
+	replicas := []int{myOrdinal}
+	if gen != nil {
+		replicas = append([]int{gen.Leader}, gen.Followers...)
+	}
+
+	// A replica might not have received the last chunks, use the union of the replicas
+	result := make([]types.ProducerSequenceInfo, 0)
+	found := false
+	for _, ordinal := range replicas {
+		var sequences []types.ProducerSequenceInfo
+		var err error
+		if ordinal == myOrdinal {
+			var localFound bool
+			sequences, localFound, err = data.ReadProducerSequences(c.config, topic, since)
+			if err == nil && !localFound {
+				err = types.GossipGetNotFound
+			}
+		} else {
+			sequences, err = c.gossiper.ReadProducerSequences(ordinal, topic, since)
+		}
+
+		if err != nil {
+			if err != types.GossipGetNotFound {
+				log.Warn().Err(err).Msgf("Producer sequences of %s could not be read from B%d", topic, ordinal)
+			}
+			continue
+		}
+
+		found = true
+		result = append(result, sequences...)
+	}
+	return result, found
+}
+
 func (c *coalescer) append(
 	replication types.ReplicationInfo,
 	length uint32,
 	timestampMicros int64,
 	contentType string,
+	partitionKey string,
+	headers []data.RecordHeaderEntry,
+	producer *producerSequence,
 	buffers [][]byte,
-) error {
+) (*types.ProduceResponse, error) {
 	record := &recordItem{
 		replication: replication,
 		length:      length,
 		timestamp:   timestampMicros,
 		contentType: contentType,
+		format:      data.RecordFormatLegacy,
 		buffers:     buffers,
 		response:    make(chan error, 1),
 	}
+
+	if c.topicGetter.Get(c.topicName).IsCompacted() {
+		// The key is needed to compact the topic
+		record.format = data.RecordFormatKeyed
+		record.key = []byte(partitionKey)
+	}
+	record.producer = producer
+	if len(headers) > 0 {
+		record.format = data.RecordFormatHeaders
+		record.headers = headers
+	}
 	c.items <- record
-	return <-record.response
+	if err := <-record.response; err != nil {
+		if record.sequencePending {
+			c.sequences.complete(producer, nil)
+		}
+		return nil, err
+	}
+
+	response := c.produceResponse(record.version, record.startOffset)
+	if record.sequencePending {
+		c.sequences.complete(producer, response)
+	}
+	return response, nil
+}
+
+func (c *coalescer) produceResponse(version types.GenVersion, startOffset int64) *types.ProduceResponse {
+	return &types.ProduceResponse{
+		Topic:       c.topicName,
+		Token:       c.token,
+		RangeIndex:  c.rangeIndex,
+		Version:     version,
+		StartOffset: startOffset,
+	}
 }
 
 type localDataItem struct {
@@ -251,6 +536,10 @@ func (d *localDataItem) RecordLength() uint32 {
 	return uint32(d.recordLength)
 }
 
+func (d *localDataItem) MaxTimestamp() int64 {
+	return d.group.maxTimestamp()
+}
+
 func (d *localDataItem) SetResult(err error) {
 	d.group.sendResponse(err)
 }
@@ -0,0 +1,104 @@
+package producing
+
+import (
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/polarstreams/polar/internal/data"
+	dMocks "github.com/polarstreams/polar/internal/test/discovery/mocks"
+	iMocks "github.com/polarstreams/polar/internal/test/interbroker/mocks"
+	. "github.com/polarstreams/polar/internal/types"
+	"github.com/stretchr/testify/mock"
+)
+
+var _ = Describe("coalescer", func() {
+	topology := newTestTopology(3, 0)
+	topic := TopicDataId{Name: "t1", Token: topology.MyToken(), Version: 2}
+
+	Describe("accept()", func() {
+		It("should park the items of idempotent producers while the sequences are loaded", func() {
+			release := make(chan time.Time)
+			gossiper := new(iMocks.Gossiper)
+			gossiper.On("ReadProducerSequences", 1, mock.Anything, mock.Anything).
+				WaitUntil(release).
+				Return([]ProducerSequenceInfo{{ProducerId: "p1", Sequence: 5, Offset: 100}}, nil)
+			gossiper.On("ReadProducerSequences", 2, mock.Anything, mock.Anything).Return(nil, GossipGetNotFound)
+			c := newTestCoalescer(&topology, topic, gossiper)
+
+			retried := newTestRecordItem(&producerSequence{"p1", 5})
+			Expect(c.accept(retried)).To(BeFalse())
+			Expect(c.parked).To(Equal([]*recordItem{retried}))
+
+			// The rest of the producers are not blocked by the load
+			Expect(c.accept(newTestRecordItem(nil))).To(BeTrue())
+
+			close(release)
+			Expect(c.nextItem()).To(BeNil())
+			Expect(c.sequencesLoaded).To(BeTrue())
+			Expect(c.nextItem()).To(BeIdenticalTo(retried))
+			Expect(c.accept(retried)).To(BeFalse())
+			Expect(<-retried.response).NotTo(HaveOccurred())
+			Expect(retried.version).To(Equal(GenVersion(1)))
+			Expect(retried.startOffset).To(Equal(int64(100)))
+
+			Expect(c.accept(newTestRecordItem(&producerSequence{"p1", 6}))).To(BeTrue())
+		})
+
+		It("should reject only the parked items when the sequences can not be loaded in time", func() {
+			gossiper := new(iMocks.Gossiper)
+			gossiper.On("ReadProducerSequences", mock.Anything, mock.Anything, mock.Anything).
+				WaitUntil(time.After(time.Hour)).
+				Return(nil, GossipGetNotFound)
+			c := newTestCoalescer(&topology, topic, gossiper)
+
+			item := newTestRecordItem(&producerSequence{"p1", 1})
+			Expect(c.accept(item)).To(BeFalse())
+			Expect(c.parkedTimeout).NotTo(BeNil())
+
+			c.rejectParked()
+			err := <-item.response
+			Expect(err).To(HaveOccurred())
+			Expect(err.(HttpError).StatusCode()).To(Equal(http.StatusServiceUnavailable))
+			Expect(c.parked).To(BeEmpty())
+			Expect(c.accept(newTestRecordItem(nil))).To(BeTrue())
+		})
+	})
+
+	Describe("onSequencesLoaded()", func() {
+		It("should discard the sequences loaded for a previous generation", func() {
+			gossiper := new(iMocks.Gossiper)
+			gossiper.On("ReadProducerSequences", mock.Anything, mock.Anything, mock.Anything).
+				Return(nil, GossipGetNotFound)
+			c := newTestCoalescer(&topology, topic, gossiper)
+
+			previous := topic
+			previous.Version = 1
+			c.onSequencesLoaded(&loadedSequences{topic: previous})
+			Expect(c.sequencesLoaded).To(BeFalse())
+		})
+	})
+})
+
+func newTestCoalescer(topology *TopologyInfo, topic TopicDataId, gossiper *iMocks.Gossiper) *coalescer {
+	previous := &Generation{Start: topic.Token, Version: topic.Version - 1, Leader: 1, Followers: []int{2}}
+	discoverer := new(dMocks.Discoverer)
+	discoverer.On("Topology").Return(topology)
+	discoverer.On("GenerationInfo", previous.Id()).Return(previous)
+	discoverer.On("GenerationInfo", mock.Anything).Return(nil)
+
+	return &coalescer{
+		topicName:       topic.Name,
+		token:           topic.Token,
+		generationState: discoverer,
+		gossiper:        gossiper,
+		writer:          &data.SegmentWriter{Topic: topic},
+		sequences:       newProducerSequences(),
+		loadResults:     make(chan *loadedSequences),
+	}
+}
+
+func newTestRecordItem(producer *producerSequence) *recordItem {
+	return &recordItem{producer: producer, response: make(chan error, 1)}
+}
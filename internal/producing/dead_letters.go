@@ -0,0 +1,196 @@
+package producing
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/polarstreams/polar/internal/metrics"
+	"github.com/polarstreams/polar/internal/types"
+	"github.com/polarstreams/polar/internal/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// The reasons of the records routed to the dead-letter topic
+const (
+	deadLetterReasonMessageSize  = "messageSize"  // The message was larger than the max message size of the topic
+	deadLetterReasonWriteFailure = "writeFailure" // The message could not be written by the coalescer
+)
+
+// The record headers added to the records routed to the dead-letter topic
+const (
+	deadLetterSourceTopicHeader = types.RecordHeaderPrefix + "Source-Topic"
+	deadLetterReasonHeader      = types.RecordHeaderPrefix + "Dead-Letter-Reason"
+	deadLetterErrorHeader       = types.RecordHeaderPrefix + "Dead-Letter-Error"
+)
+
+// Tracks the amount of records routed by this broker to the dead-letter topics, per source topic
+type deadLetterCounter struct {
+	mu     sync.Mutex
+	values map[string]*types.DeadLetterStats
+}
+
+func newDeadLetterCounter() *deadLetterCounter {
+	return &deadLetterCounter{values: make(map[string]*types.DeadLetterStats)}
+}
+
+func (c *deadLetterCounter) routed(topic string, reason string, length int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.getOrCreate(topic)
+	stats.Records++
+	stats.Bytes += int64(length)
+	stats.Reasons[reason]++
+}
+
+func (c *deadLetterCounter) failed(topic string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.getOrCreate(topic).Failed++
+}
+
+// Gets a copy of the stats of the topic
+func (c *deadLetterCounter) get(topic string) *types.DeadLetterStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result := &types.DeadLetterStats{Topic: topic, DeadLetterTopic: topic + types.DeadLetterTopicSuffix}
+	if stats, found := c.values[topic]; found {
+		result.Add(stats)
+	}
+	return result
+}
+
+// Must be called while holding the lock
+func (c *deadLetterCounter) getOrCreate(topic string) *types.DeadLetterStats {
+	stats, found := c.values[topic]
+	if !found {
+		stats = &types.DeadLetterStats{Topic: topic, Reasons: make(map[string]int64)}
+		c.values[topic] = stats
+	}
+	return stats
+}
+
+// Reads the body of the rejected message and routes it to the dead-letter topic, when the topic defines a dead-letter
+// policy and the body can be stored in the dead-letter topic.
+//
+// Otherwise, it returns the rejection error.
+func (p *producer) deadLetterFromReader(
+	topicInfo *types.TopicInfo,
+	reason string,
+	rejection error,
+	partitionKey string,
+	contentType string,
+	recordHeaders http.Header,
+	contentLength int64,
+	body io.Reader,
+) (*types.ProduceResponse, error) {
+	deadLetterTopic := topicInfo.DeadLetterTopic()
+	if deadLetterTopic == "" || contentLength <= 0 {
+		return nil, rejection
+	}
+
+	info, err := p.topicGetter.GetOrCreate(deadLetterTopic)
+	if err != nil || info == nil || contentLength > int64(info.MaxMessageSize(p.config.MaxMessageSize())) {
+		log.Warn().Err(err).Msgf("Rejected message for topic '%s' can not be stored in '%s'", topicInfo.Name, deadLetterTopic)
+		p.deadLetterFailed(topicInfo.Name)
+		return nil, rejection
+	}
+
+	buf := make([]byte, contentLength)
+	if _, err := io.ReadFull(body, buf); err != nil {
+		log.Err(err).Msgf("Producer server could not read body of expected length %d", contentLength)
+		return nil, fmt.Errorf("Producer server could not read body of expected length %d", contentLength)
+	}
+	return p.deadLetter(topicInfo, reason, rejection, partitionKey, contentType, recordHeaders, buf)
+}
+
+// Routes the rejected message to the dead-letter topic along with the error information, when the topic defines a
+// dead-letter policy.
+//
+// Otherwise or when the message can not be routed, it returns the rejection error.
+func (p *producer) deadLetter(
+	topicInfo *types.TopicInfo,
+	reason string,
+	rejection error,
+	partitionKey string,
+	contentType string,
+	recordHeaders http.Header,
+	body []byte,
+) (*types.ProduceResponse, error) {
+	deadLetterTopic := topicInfo.DeadLetterTopic()
+	if deadLetterTopic == "" || len(body) == 0 {
+		return nil, rejection
+	}
+
+	headers := recordHeaders.Clone()
+	if headers == nil {
+		headers = http.Header{}
+	}
+	headers.Set(deadLetterSourceTopicHeader, topicInfo.Name)
+	headers.Set(deadLetterReasonHeader, reason)
+	headers.Set(deadLetterErrorHeader, rejection.Error())
+
+	response, err := p.ProduceRecord(deadLetterTopic, partitionKey, contentType, headers, body)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Rejected message for topic '%s' could not be routed to '%s'", topicInfo.Name, deadLetterTopic)
+		p.deadLetterFailed(topicInfo.Name)
+		return nil, rejection
+	}
+
+	log.Debug().Msgf("Rejected message for topic '%s' routed to '%s' (%s)", topicInfo.Name, deadLetterTopic, reason)
+	p.deadLetters.routed(topicInfo.Name, reason, len(body))
+	metrics.ProducerDeadLetterRecords.WithLabelValues(topicInfo.Name, reason).Inc()
+	response.DeadLetterReason = reason
+	return response, nil
+}
+
+func (p *producer) deadLetterFailed(topic string) {
+	p.deadLetters.failed(topic)
+	metrics.ProducerDeadLetterFailures.WithLabelValues(topic).Inc()
+}
+
+func (p *producer) DeadLetterStats(topic string) (*types.DeadLetterStats, error) {
+	if p.topicGetter.Get(topic) == nil {
+		return nil, types.NewHttpErrorf(http.StatusNotFound, "Topic '%s' not found", topic)
+	}
+
+	// Each broker tracks the records it routed
+	result := p.deadLetters.get(topic)
+	peers := p.leaderGetter.Topology().Peers()
+	peerStats := make([]*types.DeadLetterStats, len(peers))
+	utils.CollectErrors(utils.InParallel(len(peers), func(i int) error {
+		value, err := p.gossiper.ReadDeadLetterStats(peers[i].Ordinal, topic)
+		if err != nil {
+			log.Warn().Err(err).Msgf(
+				"Dead-letter stats of topic '%s' could not be retrieved from peer B%d", topic, peers[i].Ordinal)
+			return err
+		}
+		peerStats[i] = value
+		return nil
+	}))
+
+	for _, value := range peerStats {
+		if value != nil {
+			result.Add(value)
+		}
+	}
+	return result, nil
+}
+
+func (p *producer) OnDeadLetterStatsFromPeer(topic string) *types.DeadLetterStats {
+	return p.deadLetters.get(topic)
+}
+
+// Gets the body stored in the buffers as a single slice
+func joinBuffers(buffers [][]byte, length int) []byte {
+	result := make([]byte, 0, length)
+	for _, b := range buffers {
+		remaining := length - len(result)
+		if remaining <= 0 {
+			break
+		}
+		result = append(result, b[:utils.Min(remaining, len(b))]...)
+	}
+	return result
+}
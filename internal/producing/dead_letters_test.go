@@ -0,0 +1,119 @@
+package producing
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	dMocks "github.com/polarstreams/polar/internal/test/discovery/mocks"
+	iMocks "github.com/polarstreams/polar/internal/test/interbroker/mocks"
+	. "github.com/polarstreams/polar/internal/types"
+)
+
+var _ = Describe("deadLetterCounter", func() {
+	It("should track the routed and failed records per topic", func() {
+		c := newDeadLetterCounter()
+		c.routed("t1", deadLetterReasonMessageSize, 10)
+		c.routed("t1", deadLetterReasonWriteFailure, 5)
+		c.routed("t1", deadLetterReasonMessageSize, 20)
+		c.failed("t1")
+		c.failed("t2")
+
+		Expect(c.get("t1")).To(Equal(&DeadLetterStats{
+			Topic:           "t1",
+			DeadLetterTopic: "t1.dlq",
+			Records:         3,
+			Bytes:           35,
+			Reasons:         map[string]int64{deadLetterReasonMessageSize: 2, deadLetterReasonWriteFailure: 1},
+			Failed:          1,
+		}))
+		Expect(c.get("t2").Failed).To(Equal(int64(1)))
+		Expect(c.get("t3")).To(Equal(&DeadLetterStats{Topic: "t3", DeadLetterTopic: "t3.dlq"}))
+	})
+})
+
+var _ = Describe("producer", func() {
+	Describe("deadLetterFromReader()", func() {
+		It("should return the rejection when the topic does not define a dead-letter policy", func() {
+			p := &producer{deadLetters: newDeadLetterCounter()}
+			rejection := NewHttpError(http.StatusBadRequest, "Test error")
+			_, err := p.deadLetterFromReader(
+				&TopicInfo{Name: "t1"}, deadLetterReasonMessageSize, rejection, "", "", nil, 3, strings.NewReader("abc"))
+			Expect(err).To(Equal(rejection))
+			Expect(p.deadLetters.get("t1").Failed).To(BeZero())
+		})
+	})
+
+	Describe("OnDeadLetterStatsFromPeer()", func() {
+		It("should return the local stats", func() {
+			p := &producer{deadLetters: newDeadLetterCounter()}
+			p.deadLetters.routed("t1", deadLetterReasonWriteFailure, 1)
+			Expect(p.OnDeadLetterStatsFromPeer("t1").Records).To(Equal(int64(1)))
+		})
+	})
+
+	Describe("DeadLetterStats()", func() {
+		It("should aggregate the stats of all the brokers", func() {
+			topology := newTestTopology(3, 0)
+			discoverer := new(dMocks.Discoverer)
+			discoverer.On("Topology").Return(&topology)
+			gossiper := new(iMocks.Gossiper)
+			gossiper.On("ReadDeadLetterStats", 1, "t1").Return(&DeadLetterStats{
+				Topic:   "t1",
+				Records: 2,
+				Bytes:   20,
+				Reasons: map[string]int64{deadLetterReasonMessageSize: 2},
+			}, nil)
+			gossiper.On("ReadDeadLetterStats", 2, "t1").Return(nil, fmt.Errorf("Test error"))
+			p := &producer{
+				topicGetter:  &testTopicGetter{topics: map[string]*TopicInfo{"t1": {Name: "t1"}}},
+				leaderGetter: discoverer,
+				gossiper:     gossiper,
+				deadLetters:  newDeadLetterCounter(),
+			}
+			p.deadLetters.routed("t1", deadLetterReasonWriteFailure, 5)
+			p.deadLetters.failed("t1")
+
+			stats, err := p.DeadLetterStats("t1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stats).To(Equal(&DeadLetterStats{
+				Topic:           "t1",
+				DeadLetterTopic: "t1.dlq",
+				Records:         3,
+				Bytes:           25,
+				Reasons:         map[string]int64{deadLetterReasonMessageSize: 2, deadLetterReasonWriteFailure: 1},
+				Failed:          1,
+			}))
+
+			_, err = p.DeadLetterStats("t2")
+			Expect(err).To(HaveOccurred())
+			Expect(err.(HttpError).StatusCode()).To(Equal(http.StatusNotFound))
+		})
+	})
+})
+
+var _ = Describe("joinBuffers()", func() {
+	It("should copy the body up to the length", func() {
+		buffers := [][]byte{[]byte("abc"), []byte("def"), []byte("gh")}
+		Expect(string(joinBuffers(buffers, 5))).To(Equal("abcde"))
+		Expect(string(joinBuffers(buffers, 8))).To(Equal("abcdefgh"))
+	})
+})
+
+type testTopicGetter struct {
+	topics map[string]*TopicInfo
+}
+
+func (g *testTopicGetter) Get(topic string) *TopicInfo {
+	return g.topics[topic]
+}
+
+func (g *testTopicGetter) GetOrCreate(topic string) (*TopicInfo, error) {
+	return g.topics[topic], nil
+}
+
+func (g *testTopicGetter) Exists(topic string) bool {
+	return g.topics[topic] != nil
+}
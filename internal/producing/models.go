@@ -3,11 +3,17 @@ package producing
 import (
 	"bufio"
 	"bytes"
-	"encoding/binary"
+	"fmt"
 	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/klauspost/compress/zstd"
 	"github.com/polarstreams/polar/internal/conf"
+	"github.com/polarstreams/polar/internal/data"
 	"github.com/polarstreams/polar/internal/metrics"
 	. "github.com/polarstreams/polar/internal/types"
 	"github.com/polarstreams/polar/internal/utils"
@@ -25,11 +31,25 @@ type recordItem struct {
 	length      uint32 // Body length
 	timestamp   int64  // Timestamp in micros
 	contentType string // The content type detailing the format of the
+	key         []byte // The partition key, only stored using the keyed record format
+	headers     []data.RecordHeaderEntry
+	format      byte // The record format, as defined by the topic mode and the headers
 	buffers     [][]byte
 	response    chan error
+
+	producer        *producerSequence // The sequence of the idempotent producer request
+	sequencePending bool              // Determines whether the producer sequence was accepted and it's pending
+
+	version     GenVersion // The generation version of the chunk containing the records
+	startOffset int64      // The offset of the first record
 }
 
 func (r *recordItem) marshal(w io.Writer) (totalRecords int, err error) {
+	if r.length == 0 {
+		// Tombstone
+		return 1, r.marshalRecord(w, 0, nil)
+	}
+
 	if r.contentType == MIMETypeNDJSON {
 		return r.marshalRecordsByLine(w, r.length)
 	}
@@ -38,7 +58,7 @@ func (r *recordItem) marshal(w io.Writer) (totalRecords int, err error) {
 		return r.marshalFramedRecords(w, r.length)
 	}
 
-	return 1, marshalRecord(w, r.timestamp, r.length, r.buffers)
+	return 1, r.marshalRecord(w, r.length, r.buffers)
 }
 
 func (r *recordItem) marshalRecordsByLine(w io.Writer, length uint32) (totalRecords int, err error) {
@@ -67,7 +87,7 @@ func (r *recordItem) marshalRecordsByLine(w io.Writer, length uint32) (totalReco
 				recordBodyLength += len(token)
 			}
 			if index < len(buf) && recordBodyLength > 0 {
-				if err := marshalRecord(w, r.timestamp, uint32(recordBodyLength), recordBody); err != nil {
+				if err := r.marshalRecord(w, uint32(recordBodyLength), recordBody); err != nil {
 					return totalRecords, err
 				}
 				totalRecords++
@@ -79,7 +99,7 @@ func (r *recordItem) marshalRecordsByLine(w io.Writer, length uint32) (totalReco
 
 	if recordBodyLength > 0 {
 		totalRecords++
-		if err := marshalRecord(w, r.timestamp, uint32(recordBodyLength), recordBody); err != nil {
+		if err := r.marshalRecord(w, uint32(recordBodyLength), recordBody); err != nil {
 			return totalRecords, err
 		}
 	}
@@ -93,7 +113,7 @@ func (r *recordItem) marshalFramedRecords(w io.Writer, length uint32) (totalReco
 	for totalRead < int(length) {
 		recordLength := readUint32(r.buffers, &bufferIndex, &index)
 		totalRead += 4
-		if err := marshalRecordProps(r.timestamp, recordLength, w); err != nil {
+		if err := data.WriteRecordHeader(w, r.format, r.recordHeader(recordLength)); err != nil {
 			return totalRecords, err
 		}
 		totalRecords++
@@ -121,8 +141,8 @@ func (r *recordItem) marshalFramedRecords(w io.Writer, length uint32) (totalReco
 	return totalRecords, nil
 }
 
-func marshalRecord(w io.Writer, timestamp int64, length uint32, buffers [][]byte) error {
-	if err := marshalRecordProps(timestamp, length, w); err != nil {
+func (r *recordItem) marshalRecord(w io.Writer, length uint32, buffers [][]byte) error {
+	if err := data.WriteRecordHeader(w, r.format, r.recordHeader(length)); err != nil {
 		return err
 	}
 
@@ -143,14 +163,63 @@ func marshalRecord(w io.Writer, timestamp int64, length uint32, buffers [][]byte
 	return nil
 }
 
-func marshalRecordProps(timestamp int64, length uint32, w io.Writer) error {
-	if err := binary.Write(w, conf.Endianness, timestamp); err != nil {
-		return err
+func (r *recordItem) recordHeader(length uint32) *data.RecordHeader {
+	return &data.RecordHeader{
+		Timestamp: r.timestamp,
+		Length:    length,
+		Key:       r.key,
+		Headers:   r.headers,
 	}
-	if err := binary.Write(w, conf.Endianness, length); err != nil {
-		return err
+}
+
+// Gets the header entries to store along with the records, sorted by name.
+// The content type is only stored when it's not the default one.
+func recordHeaderEntries(contentType string, recordHeaders http.Header) []data.RecordHeaderEntry {
+	var result []data.RecordHeaderEntry
+	if !isDefaultContentType(contentType) {
+		result = append(result, data.RecordHeaderEntry{Name: ContentTypeHeaderKey, Value: contentType})
 	}
-	return nil
+	for name, values := range recordHeaders {
+		result = append(result, data.RecordHeaderEntry{Name: name, Value: strings.Join(values, ", ")})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+const ttlKey = "ttl"
+
+// Parses the time to live of the records in milliseconds from the query string value, zero when not set
+func parseTtl(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	ttl, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("Invalid ttl value '%s'", value)
+	}
+	return ttl, nil
+}
+
+// Appends the header to store the time to live along with the record
+func appendTtlHeader(headers []data.RecordHeaderEntry, ttl int64) []data.RecordHeaderEntry {
+	if ttl == 0 {
+		return headers
+	}
+	return append(headers, data.RecordHeaderEntry{Name: data.RecordHeaderTtl, Value: strconv.FormatInt(ttl, 10)})
+}
+
+// Determines whether the content type is JSON, the default for records produced using HTTP, or binary frames,
+// which don't carry the content type of each record.
+func isDefaultContentType(contentType string) bool {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		contentType = mediaType
+	}
+	return contentType == "" ||
+		contentType == MIMETypeJSON ||
+		contentType == MIMETypeNDJSON ||
+		contentType == MIMETypeProducerBinary
 }
 
 func readUint32(buffers [][]byte, bufferIndex *int, index *int) uint32 {
@@ -170,7 +239,7 @@ func readUint32(buffers [][]byte, bufferIndex *int, index *int) uint32 {
 
 // Represents a group of `recordItem` that get compressed and written into a single chunk
 type coalescerGroup struct {
-	items        []recordItem
+	items        []*recordItem
 	offset       int64 // The start offset of the group
 	byteSize     int64 // The total size in bytes of the group
 	maxGroupSize int
@@ -178,7 +247,7 @@ type coalescerGroup struct {
 
 func newCoalescerGroup(offset int64, maxGroupSize int) *coalescerGroup {
 	return &coalescerGroup{
-		items:        make([]recordItem, 0, 4),
+		items:        make([]*recordItem, 0, 4),
 		offset:       offset,
 		byteSize:     0,
 		maxGroupSize: maxGroupSize,
@@ -191,6 +260,29 @@ func (g *coalescerGroup) sendResponse(err error) {
 	}
 }
 
+// Gets the record format to use for the items in the group.
+// Each record format can represent the records of the previous formats.
+func (g *coalescerGroup) format() byte {
+	result := data.RecordFormatLegacy
+	for _, item := range g.items {
+		if item.format > result {
+			result = item.format
+		}
+	}
+	return result
+}
+
+// Gets the max timestamp of the records in the group
+func (g *coalescerGroup) maxTimestamp() int64 {
+	result := int64(0)
+	for _, item := range g.items {
+		if item.timestamp > result {
+			result = item.timestamp
+		}
+	}
+	return result
+}
+
 // Attempts to add a new item to the group and returns nil when it was appended.
 func (g *coalescerGroup) tryAdd(item *recordItem) *recordItem {
 	itemSize := int64(item.length)
@@ -199,7 +291,7 @@ func (g *coalescerGroup) tryAdd(item *recordItem) *recordItem {
 		return item
 	}
 	g.byteSize += itemSize
-	g.items = append(g.items, *item)
+	g.items = append(g.items, item)
 	metrics.CoalescerMessagesProcessed.Inc()
 	return nil
 }
@@ -4,12 +4,14 @@ import (
 	"bytes"
 	"encoding/binary"
 	"io"
+	"net/http"
 	"strings"
 	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/polarstreams/polar/internal/conf"
+	datalog "github.com/polarstreams/polar/internal/data"
 	. "github.com/polarstreams/polar/internal/types"
 )
 
@@ -200,6 +202,76 @@ var _ = Describe("recordItem", func() {
 				}
 			})
 		})
+
+		Context("Headers", func() {
+			It("should write the key and the headers of a record", func() {
+				const body = "hello world"
+				headers := []datalog.RecordHeaderEntry{
+					{Name: "Content-Type", Value: "text/plain"},
+					{Name: "X-Polar-Header-A", Value: "b"},
+				}
+				item := recordItem{
+					length:      uint32(len(body)),
+					timestamp:   time.Now().UnixMicro(),
+					contentType: "text/plain",
+					key:         []byte("k1"),
+					headers:     headers,
+					format:      datalog.RecordFormatHeaders,
+					buffers:     [][]byte{[]byte(body)},
+				}
+
+				writer := new(bytes.Buffer)
+				Expect(datalog.WriteRecordFormat(writer, item.format)).NotTo(HaveOccurred())
+				totalRecords, err := item.marshal(writer)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(totalRecords).To(Equal(1))
+
+				reader := datalog.NewRecordReader(writer)
+				header, err := reader.Next()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(*header).To(Equal(datalog.RecordHeader{
+					Timestamp: item.timestamp,
+					Length:    uint32(len(body)),
+					Key:       []byte("k1"),
+					Headers:   headers,
+				}))
+				Expect(writer.String()).To(Equal(body))
+			})
+		})
+	})
+})
+
+var _ = Describe("recordHeaderEntries()", func() {
+	It("should only include the content type when it's not the default", func() {
+		for _, contentType := range []string{"", MIMETypeJSON, MIMETypeNDJSON, "application/json; charset=utf-8"} {
+			Expect(recordHeaderEntries(contentType, nil)).To(BeNil())
+		}
+		Expect(recordHeaderEntries("text/plain", nil)).To(Equal([]datalog.RecordHeaderEntry{
+			{Name: ContentTypeHeaderKey, Value: "text/plain"},
+		}))
+	})
+
+	It("should include the record headers sorted by name", func() {
+		recordHeaders := http.Header{"X-Polar-Header-B": {"1", "2"}, "X-Polar-Header-A": {"3"}}
+		Expect(recordHeaderEntries("application/octet-stream", recordHeaders)).To(Equal([]datalog.RecordHeaderEntry{
+			{Name: ContentTypeHeaderKey, Value: "application/octet-stream"},
+			{Name: "X-Polar-Header-A", Value: "3"},
+			{Name: "X-Polar-Header-B", Value: "1, 2"},
+		}))
+	})
+})
+
+var _ = Describe("coalescerGroup", func() {
+	Describe("format()", func() {
+		It("should use the format that can represent all the records", func() {
+			group := newCoalescerGroup(0, 1024)
+			Expect(group.format()).To(Equal(datalog.RecordFormatLegacy))
+			Expect(group.tryAdd(&recordItem{length: 1, format: datalog.RecordFormatKeyed})).To(BeNil())
+			Expect(group.format()).To(Equal(datalog.RecordFormatKeyed))
+			Expect(group.tryAdd(&recordItem{length: 1, format: datalog.RecordFormatHeaders})).To(BeNil())
+			Expect(group.tryAdd(&recordItem{length: 1, format: datalog.RecordFormatKeyed})).To(BeNil())
+			Expect(group.format()).To(Equal(datalog.RecordFormatHeaders))
+		})
 	})
 })
 
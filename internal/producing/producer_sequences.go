@@ -0,0 +1,217 @@
+package producing
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/polarstreams/polar/internal/data"
+	"github.com/polarstreams/polar/internal/types"
+)
+
+const (
+	producerIdKey = "producerId"
+	sequenceKey   = "sequence"
+)
+
+const (
+	maxProducerIdLength        = math.MaxUint8
+	producerSequenceWindow     = data.ProducerSequenceWindow // The amount of recent sequences to track per producer
+	producerSequenceExpiration = time.Hour                   // The time after which the sequences of an inactive producer are discarded
+)
+
+type sequenceState int
+
+const (
+	sequenceNew sequenceState = iota
+	sequenceDuplicate
+	sequenceInProgress
+	sequenceTooOld
+)
+
+// Identifies a request from an idempotent producer
+type producerSequence struct {
+	producerId string
+	sequence   int64
+}
+
+// Tracks the recent sequences of the idempotent producers of a token range to detect retried requests.
+//
+// A sequence is considered a duplicate when it was already written, in which case the location of the records
+// is returned.
+type producerSequences struct {
+	mu        sync.Mutex
+	producers map[string]*producerState
+	lastPurge time.Time
+}
+
+type producerState struct {
+	written  []writtenSequence // The most recent written sequences, sorted
+	pending  map[int64]bool
+	lastUsed time.Time
+}
+
+type writtenSequence struct {
+	sequence int64
+	response *types.ProduceResponse // The location of the records
+}
+
+func newProducerSequences() *producerSequences {
+	return &producerSequences{
+		producers: map[string]*producerState{},
+		lastPurge: time.Now(),
+	}
+}
+
+// Checks whether the sequence was already seen and marks it as pending when it's new.
+// It returns the location of the records when it was already written.
+func (s *producerSequences) check(p *producerSequence) (sequenceState, *types.ProduceResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.purge(now)
+
+	state := s.producers[p.producerId]
+	if state == nil {
+		state = &producerState{pending: map[int64]bool{}}
+		s.producers[p.producerId] = state
+	}
+	state.lastUsed = now
+
+	if state.pending[p.sequence] {
+		return sequenceInProgress, nil
+	}
+	if len(state.written) == producerSequenceWindow && p.sequence < state.written[0].sequence {
+		return sequenceTooOld, nil
+	}
+	if response := state.get(p.sequence); response != nil {
+		return sequenceDuplicate, response
+	}
+	state.pending[p.sequence] = true
+	return sequenceNew, nil
+}
+
+// Marks the pending sequence as completed.
+// When written, the location of the records is tracked to detect future retries.
+func (s *producerSequences) complete(p *producerSequence, response *types.ProduceResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := s.producers[p.producerId]
+	if state == nil {
+		return
+	}
+	delete(state.pending, p.sequence)
+	if response != nil {
+		state.add(p.sequence, response)
+	}
+}
+
+// Tracks a sequence that was previously written, i.e. by the leader of the previous generation
+func (s *producerSequences) add(producerId string, sequence int64, response *types.ProduceResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := s.producers[producerId]
+	if state == nil {
+		state = &producerState{pending: map[int64]bool{}, lastUsed: time.Now()}
+		s.producers[producerId] = state
+	}
+	state.add(sequence, response)
+}
+
+// Removes the state of the producers that were not used recently, it must be called holding the lock
+func (s *producerSequences) purge(now time.Time) {
+	if now.Sub(s.lastPurge) < time.Minute {
+		return
+	}
+	s.lastPurge = now
+	for id, state := range s.producers {
+		if len(state.pending) == 0 && now.Sub(state.lastUsed) > producerSequenceExpiration {
+			delete(s.producers, id)
+		}
+	}
+}
+
+func (p *producerState) search(sequence int64) int {
+	return sort.Search(len(p.written), func(i int) bool { return p.written[i].sequence >= sequence })
+}
+
+func (p *producerState) get(sequence int64) *types.ProduceResponse {
+	i := p.search(sequence)
+	if i < len(p.written) && p.written[i].sequence == sequence {
+		return p.written[i].response
+	}
+	return nil
+}
+
+func (p *producerState) add(sequence int64, response *types.ProduceResponse) {
+	i := p.search(sequence)
+	if i < len(p.written) && p.written[i].sequence == sequence {
+		return
+	}
+	p.written = append(p.written, writtenSequence{})
+	copy(p.written[i+1:], p.written[i:])
+	p.written[i] = writtenSequence{sequence: sequence, response: response}
+	if len(p.written) > producerSequenceWindow {
+		// Discard the oldest
+		p.written = p.written[1:]
+	}
+}
+
+// Parses the producer id and sequence, returning nil when the producer is not idempotent
+func parseProducerSequence(producerId string, sequence string) (*producerSequence, error) {
+	if producerId == "" && sequence == "" {
+		return nil, nil
+	}
+	value, err := strconv.ParseInt(sequence, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid sequence '%s' for producer '%s'", sequence, producerId)
+	}
+	return newProducerSequence(producerId, value)
+}
+
+func newProducerSequence(producerId string, sequence int64) (*producerSequence, error) {
+	if producerId == "" || len(producerId) > maxProducerIdLength {
+		return nil, fmt.Errorf("Producer id must be defined and less than %d bytes", maxProducerIdLength)
+	}
+	if sequence < 0 {
+		return nil, fmt.Errorf("Invalid sequence %d for producer '%s'", sequence, producerId)
+	}
+	return &producerSequence{producerId: producerId, sequence: sequence}, nil
+}
+
+// Appends the headers to store the sequence along with the record, allowing the next leaders to detect retries
+func (p *producerSequence) appendHeaders(headers []data.RecordHeaderEntry) []data.RecordHeaderEntry {
+	if p == nil {
+		return headers
+	}
+	return append(headers,
+		data.RecordHeaderEntry{Name: data.RecordHeaderProducerId, Value: p.producerId},
+		data.RecordHeaderEntry{Name: data.RecordHeaderProducerSequence, Value: strconv.FormatInt(p.sequence, 10)})
+}
+
+// Sets the producer id and sequence in the query string, used when rerouting the request
+func (p *producerSequence) setQuery(querystring url.Values) {
+	if p == nil {
+		return
+	}
+	querystring.Set(producerIdKey, p.producerId)
+	querystring.Set(sequenceKey, strconv.FormatInt(p.sequence, 10))
+}
+
+func newSequenceInProgressError() error {
+	return types.NewHttpError(
+		http.StatusConflict, "A request with the same producer id and sequence is still being processed")
+}
+
+func newSequenceTooOldError(p *producerSequence) error {
+	return types.NewHttpErrorf(
+		http.StatusConflict,
+		"Sequence %d is older than the tracked sequences of producer '%s'",
+		p.sequence,
+		p.producerId)
+}
@@ -0,0 +1,95 @@
+package producing
+
+import (
+	"net/url"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	datalog "github.com/polarstreams/polar/internal/data"
+	"github.com/polarstreams/polar/internal/types"
+)
+
+var _ = Describe("producerSequences", func() {
+	Describe("check()", func() {
+		It("should detect retried sequences once written", func() {
+			s := newProducerSequences()
+			p1 := &producerSequence{"p1", 10}
+			expectState(s, p1, sequenceNew)
+			expectState(s, p1, sequenceInProgress)
+			response := &types.ProduceResponse{Topic: "abc", Version: 2, StartOffset: 100}
+			s.complete(p1, response)
+			state, previous := s.check(p1)
+			Expect(state).To(Equal(sequenceDuplicate))
+			Expect(previous).To(Equal(response))
+
+			// Other producers are not affected
+			expectState(s, &producerSequence{"p2", 10}, sequenceNew)
+		})
+
+		It("should allow retrying sequences that were not written", func() {
+			s := newProducerSequences()
+			p1 := &producerSequence{"p1", 10}
+			expectState(s, p1, sequenceNew)
+			s.complete(p1, nil)
+			expectState(s, p1, sequenceNew)
+		})
+
+		It("should accept out of order sequences within the window", func() {
+			s := newProducerSequences()
+			for _, sequence := range []int64{5, 3, 4} {
+				p := &producerSequence{"p1", sequence}
+				expectState(s, p, sequenceNew)
+				s.complete(p, &types.ProduceResponse{StartOffset: sequence})
+			}
+			expectState(s, &producerSequence{"p1", 2}, sequenceNew)
+			_, response := s.check(&producerSequence{"p1", 4})
+			Expect(response.StartOffset).To(Equal(int64(4)))
+		})
+
+		It("should reject sequences older than the window", func() {
+			s := newProducerSequences()
+			for i := 0; i < producerSequenceWindow+10; i++ {
+				s.add("p1", int64(i+100), &types.ProduceResponse{StartOffset: int64(i)})
+			}
+			expectState(s, &producerSequence{"p1", 10}, sequenceTooOld)
+			expectState(s, &producerSequence{"p1", 109}, sequenceTooOld)
+			expectState(s, &producerSequence{"p1", 110}, sequenceDuplicate)
+			expectState(s, &producerSequence{"p1", 200}, sequenceNew)
+		})
+	})
+})
+
+func expectState(s *producerSequences, p *producerSequence, expected sequenceState) {
+	state, _ := s.check(p)
+	ExpectWithOffset(1, state).To(Equal(expected))
+}
+
+var _ = Describe("parseProducerSequence()", func() {
+	It("should return nil when not provided", func() {
+		p, err := parseProducerSequence("", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p).To(BeNil())
+	})
+
+	It("should parse the producer id and sequence", func() {
+		p, err := parseProducerSequence("abc", "123")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*p).To(Equal(producerSequence{"abc", 123}))
+
+		query := url.Values{}
+		p.setQuery(query)
+		Expect(query.Encode()).To(Equal("producerId=abc&sequence=123"))
+
+		Expect(p.appendHeaders(nil)).To(Equal([]datalog.RecordHeaderEntry{
+			{Name: datalog.RecordHeaderProducerId, Value: "abc"},
+			{Name: datalog.RecordHeaderProducerSequence, Value: "123"},
+		}))
+	})
+
+	It("should return an error when not valid", func() {
+		for _, values := range [][]string{{"", "1"}, {"abc", ""}, {"abc", "-1"}, {"abc", "z"}} {
+			_, err := parseProducerSequence(values[0], values[1])
+			Expect(err).To(HaveOccurred())
+		}
+	})
+})
@@ -0,0 +1,258 @@
+package producing
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/polarstreams/polar/internal/types"
+	"github.com/polarstreams/polar/internal/utils"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	deliverAtKey = "deliverAt"
+	delayKey     = "delay"
+)
+
+const scheduledRecordsInterval = 1 * time.Second
+const scheduledRecordsBatchSize = 1000
+
+// The time after which a due record that is not led by this broker is discarded, it's expected to be delivered by the
+// leader of the token and the removal message was not received.
+const scheduledRecordMaxOverdue = 1 * time.Hour
+
+// Gets the unix time in milliseconds of the scheduled delivery from the query string, returning zero when the record
+// should be delivered right away
+func parseDeliverAt(querystring url.Values, now time.Time, maxDelay time.Duration) (int64, error) {
+	deliverAt := int64(0)
+	if value := querystring.Get(deliverAtKey); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("Invalid deliverAt value '%s'", value)
+		}
+		deliverAt = n
+	}
+
+	if value := querystring.Get(delayKey); value != "" {
+		if deliverAt > 0 {
+			return 0, fmt.Errorf("deliverAt and delay can not be set at the same time")
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("Invalid delay value '%s'", value)
+		}
+		deliverAt = now.UnixMilli() + n
+	}
+
+	if deliverAt <= now.UnixMilli() {
+		return 0, nil
+	}
+	if deliverAt-now.UnixMilli() > maxDelay.Milliseconds() {
+		return 0, fmt.Errorf("Delivery can not be scheduled more than %s ahead", maxDelay)
+	}
+	return deliverAt, nil
+}
+
+// Stores the record locally and in the followers of the token until the delivery time
+func (p *producer) schedule(
+	topic string,
+	replication types.ReplicationInfo,
+	partitionKey string,
+	deliverAt int64,
+	ttl int64,
+	contentLength int64,
+	contentType string,
+	recordHeaders http.Header,
+	body io.Reader,
+) (*types.ProduceResponse, error) {
+	record := &types.ScheduledRecord{
+		Id:           uuid.NewString(),
+		Topic:        topic,
+		Token:        replication.Token,
+		RangeIndex:   replication.RangeIndex,
+		PartitionKey: partitionKey,
+		DeliverAt:    deliverAt,
+		Ttl:          ttl,
+		ContentType:  contentType,
+		Headers:      recordHeaders,
+		Body:         make([]byte, contentLength),
+	}
+	if _, err := io.ReadFull(body, record.Body); err != nil {
+		log.Err(err).Msgf("Producer server could not read body of expected length %d", contentLength)
+		return nil, fmt.Errorf("Producer server could not read body of expected length %d", contentLength)
+	}
+
+	if err := p.localDb.SaveScheduledRecord(record); err != nil {
+		return nil, err
+	}
+
+	// Similar to the data, consider it as stored when at least one replica acknowledged it
+	followers := replication.Followers
+	errs := utils.CollectErrors(utils.InParallel(len(followers), func(i int) error {
+		return p.gossiper.SendScheduledRecord(followers[i].Ordinal, record)
+	}))
+	if len(followers) > 0 && countErrors(errs) == len(followers) {
+		log.Err(errs[0]).Msgf("Scheduled record for topic '%s' could not be stored in the followers", topic)
+		if err := p.localDb.DeleteScheduledRecord(record.Id); err != nil {
+			log.Err(err).Msgf("Scheduled record %s could not be removed", record.Id)
+		}
+		return nil, types.NewHttpError(
+			http.StatusServiceUnavailable, "Scheduled record could not be stored in the followers")
+	}
+
+	log.Debug().Msgf("Scheduled record %s for topic '%s' to be delivered at %d", record.Id, topic, deliverAt)
+	return &types.ProduceResponse{
+		Topic:       topic,
+		Token:       replication.Token,
+		RangeIndex:  replication.RangeIndex,
+		ScheduledId: record.Id,
+		DeliverAt:   deliverAt,
+	}, nil
+}
+
+// Periodically appends the due scheduled records of the tokens led by this broker
+func (p *producer) deliverScheduledRecords() {
+	for !p.localDb.IsShuttingDown() {
+		time.Sleep(scheduledRecordsInterval)
+		p.deliverDue(time.Now())
+	}
+}
+
+func (p *producer) deliverDue(now time.Time) {
+	var after *types.ScheduledRecord
+	for {
+		records, err := p.localDb.DueScheduledRecords(now.UnixMilli(), after, scheduledRecordsBatchSize)
+		if err != nil {
+			log.Err(err).Msgf("Scheduled records could not be retrieved")
+			return
+		}
+
+		topology := p.leaderGetter.Topology()
+		for i := range records {
+			p.deliverRecord(topology, &records[i], now)
+		}
+
+		if len(records) < scheduledRecordsBatchSize {
+			return
+		}
+		// Continue after the last record, the records that were not delivered remain stored
+		after = &records[len(records)-1]
+	}
+}
+
+// Delivers the due record when this broker is responsible for it: when it leads the token the record was stored for
+// or, when the token no longer exists after scaling down, when it's one of the brokers that stored it
+func (p *producer) deliverRecord(topology *types.TopologyInfo, r *types.ScheduledRecord, now time.Time) {
+	var followers []int
+	gen := p.leaderGetter.Generation(r.Token)
+	if gen != nil {
+		followers = gen.Followers
+	}
+	if (gen == nil && hasToken(topology, r.Token)) || (gen != nil && gen.Leader != topology.MyOrdinal()) {
+		// The leader of the token is responsible for delivering it
+		if now.Sub(time.UnixMilli(r.DeliverAt)) > scheduledRecordMaxOverdue {
+			log.Warn().Msgf("Discarding scheduled record %s for topic '%s' as it was not delivered", r.Id, r.Topic)
+			p.removeScheduledRecord(r.Id, nil)
+		}
+		return
+	}
+
+	if p.topicGetter.Get(r.Topic) == nil {
+		log.Warn().Msgf("Discarding scheduled record %s as topic '%s' was not found", r.Id, r.Topic)
+		p.removeScheduledRecord(r.Id, followers)
+		return
+	}
+
+	if err := p.deliver(r); err != nil {
+		// It will be retried in the next interval
+		log.Warn().Err(err).Msgf("Scheduled record %s for topic '%s' could not be delivered", r.Id, r.Topic)
+		return
+	}
+	p.removeScheduledRecord(r.Id, followers)
+}
+
+// Appends the scheduled record to the token range of its partition key at the time of the delivery, routing it to
+// the leader of the token when it's not this broker
+func (p *producer) deliver(r *types.ScheduledRecord) error {
+	replication := p.leaderGetter.Leader(r.PartitionKey)
+	if replication.Leader == nil {
+		return fmt.Errorf("Leader for token %d could not be found", replication.Token)
+	}
+
+	if !replication.Leader.IsSelf {
+		querystring := url.Values{}
+		if r.PartitionKey != "" {
+			querystring.Set("partitionKey", r.PartitionKey)
+		}
+		if r.Ttl > 0 {
+			querystring.Set(ttlKey, strconv.FormatInt(r.Ttl, 10))
+		}
+		_, err := p.gossiper.SendToLeader(
+			replication,
+			r.Topic,
+			types.SystemPrincipal,
+			querystring,
+			int64(len(r.Body)),
+			r.ContentType,
+			r.Headers,
+			bytes.NewReader(r.Body))
+		return err
+	}
+
+	var buffers [][]byte
+	if len(r.Body) > 0 {
+		buffers = [][]byte{r.Body}
+	}
+	headers := appendTtlHeader(recordHeaderEntries(r.ContentType, r.Headers), r.Ttl)
+
+	coalescer := p.Coalescer(r.Topic, replication.Token, replication.RangeIndex)
+	_, err := coalescer.append(
+		replication, uint32(len(r.Body)), time.Now().UnixMicro(), r.ContentType, r.PartitionKey, headers, nil, buffers)
+	return err
+}
+
+// Removes the scheduled record locally and in the followers, on a best-effort basis
+func (p *producer) removeScheduledRecord(id string, followers []int) {
+	if err := p.localDb.DeleteScheduledRecord(id); err != nil {
+		log.Err(err).Msgf("Scheduled record %s could not be removed", id)
+	}
+	for _, ordinal := range followers {
+		if err := p.gossiper.SendScheduledDelete(ordinal, id); err != nil {
+			log.Warn().Err(err).Msgf("Scheduled record %s could not be removed from B%d", id, ordinal)
+		}
+	}
+}
+
+func (p *producer) OnScheduledRecordFromPeer(record *types.ScheduledRecord) error {
+	return p.localDb.SaveScheduledRecord(record)
+}
+
+func (p *producer) OnScheduledDeleteFromPeer(id string) error {
+	return p.localDb.DeleteScheduledRecord(id)
+}
+
+// Determines whether the token is the start of the range of a broker in the topology
+func hasToken(topology *types.TopologyInfo, token types.Token) bool {
+	for i := 0; i < topology.TotalBrokers(); i++ {
+		if topology.GetToken(types.BrokerIndex(i)) == token {
+			return true
+		}
+	}
+	return false
+}
+
+func countErrors(errs []error) int {
+	result := 0
+	for _, err := range errs {
+		if err != nil {
+			result++
+		}
+	}
+	return result
+}
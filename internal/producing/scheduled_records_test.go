@@ -0,0 +1,212 @@
+package producing
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	dMocks "github.com/polarstreams/polar/internal/test/discovery/mocks"
+	iMocks "github.com/polarstreams/polar/internal/test/interbroker/mocks"
+	lMocks "github.com/polarstreams/polar/internal/test/localdb/mocks"
+	. "github.com/polarstreams/polar/internal/types"
+	"github.com/stretchr/testify/mock"
+)
+
+var _ = Describe("parseDeliverAt()", func() {
+	now := time.UnixMilli(1_000_000)
+	maxDelay := time.Hour
+
+	It("should return zero when not scheduled or in the past", func() {
+		for _, q := range []string{"", "deliverAt=1000", "delay=0", "deliverAt=1000000"} {
+			querystring, _ := url.ParseQuery(q)
+			Expect(parseDeliverAt(querystring, now, maxDelay)).To(Equal(int64(0)), q)
+		}
+	})
+
+	It("should return the delivery time", func() {
+		querystring := url.Values{"deliverAt": {"1000500"}}
+		Expect(parseDeliverAt(querystring, now, maxDelay)).To(Equal(int64(1_000_500)))
+		querystring = url.Values{"delay": {"2000"}}
+		Expect(parseDeliverAt(querystring, now, maxDelay)).To(Equal(int64(1_002_000)))
+	})
+
+	It("should return an error when the values are not valid", func() {
+		for _, q := range []string{"deliverAt=abc", "delay=-1", "deliverAt=1000500&delay=10", "delay=3600001"} {
+			querystring, _ := url.ParseQuery(q)
+			_, err := parseDeliverAt(querystring, now, maxDelay)
+			Expect(err).To(HaveOccurred(), q)
+		}
+	})
+})
+
+var _ = Describe("producer", func() {
+	topology := newTestTopology(3, 0)
+	replication := NewReplicationInfo(&topology, topology.MyToken(), 0, []int{1, 2}, 1)
+
+	Describe("schedule()", func() {
+		It("should store the record locally and in the followers", func() {
+			localDb := new(lMocks.Client)
+			localDb.On("SaveScheduledRecord", mock.Anything).Return(nil)
+			gossiper := new(iMocks.Gossiper)
+			gossiper.On("SendScheduledRecord", 1, mock.Anything).Return(nil)
+			gossiper.On("SendScheduledRecord", 2, mock.Anything).Return(fmt.Errorf("Test error"))
+			p := &producer{localDb: localDb, gossiper: gossiper}
+
+			response, err := p.schedule(
+				"t1", replication, "k1", 2000, 0, 3, "text/plain", http.Header{}, strings.NewReader("abc"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.ScheduledId).NotTo(BeEmpty())
+			Expect(response.DeliverAt).To(Equal(int64(2000)))
+			Expect(response.RangeIndex).To(Equal(RangeIndex(1)))
+
+			record := localDb.Calls[0].Arguments.Get(0).(*ScheduledRecord)
+			Expect(record.Id).To(Equal(response.ScheduledId))
+			Expect(record.Token).To(Equal(topology.MyToken()))
+			Expect(record.PartitionKey).To(Equal("k1"))
+			Expect(string(record.Body)).To(Equal("abc"))
+			gossiper.AssertCalled(GinkgoT(), "SendScheduledRecord", 1, record)
+		})
+
+		It("should remove the record when it could not be stored in the followers", func() {
+			localDb := new(lMocks.Client)
+			localDb.On("SaveScheduledRecord", mock.Anything).Return(nil)
+			localDb.On("DeleteScheduledRecord", mock.Anything).Return(nil)
+			gossiper := new(iMocks.Gossiper)
+			gossiper.On("SendScheduledRecord", mock.Anything, mock.Anything).Return(fmt.Errorf("Test error"))
+			p := &producer{localDb: localDb, gossiper: gossiper}
+
+			_, err := p.schedule("t1", replication, "", 2000, 0, 3, "", nil, strings.NewReader("abc"))
+			Expect(err).To(HaveOccurred())
+			Expect(err.(HttpError).StatusCode()).To(Equal(http.StatusServiceUnavailable))
+			localDb.AssertNumberOfCalls(GinkgoT(), "DeleteScheduledRecord", 1)
+		})
+	})
+
+	Describe("deliverDue()", func() {
+		It("should discard the records of tokens led by other brokers once overdue", func() {
+			now := time.Now()
+			records := []ScheduledRecord{
+				{Id: "r1", Topic: "t1", Token: topology.GetToken(1), DeliverAt: now.Add(-2 * time.Hour).UnixMilli()},
+				{Id: "r2", Topic: "t1", Token: topology.GetToken(1), DeliverAt: now.UnixMilli()},
+			}
+			localDb := new(lMocks.Client)
+			localDb.On("DueScheduledRecords", now.UnixMilli(), (*ScheduledRecord)(nil), scheduledRecordsBatchSize).
+				Return(records, nil)
+			localDb.On("DeleteScheduledRecord", mock.Anything).Return(nil)
+			discoverer := new(dMocks.Discoverer)
+			discoverer.On("Topology").Return(&topology)
+			discoverer.On("Generation", topology.GetToken(1)).Return(&Generation{Leader: 1, Followers: []int{2, 0}})
+			p := &producer{localDb: localDb, leaderGetter: discoverer}
+
+			p.deliverDue(now)
+			localDb.AssertCalled(GinkgoT(), "DeleteScheduledRecord", "r1")
+			localDb.AssertNotCalled(GinkgoT(), "DeleteScheduledRecord", "r2")
+		})
+
+		It("should route the records to the leader of the partition key at the time of the delivery", func() {
+			now := time.Now()
+			records := []ScheduledRecord{{
+				Id:           "r1",
+				Topic:        "t1",
+				Token:        topology.MyToken(),
+				PartitionKey: "k1",
+				DeliverAt:    now.UnixMilli(),
+				Ttl:          1000,
+				Body:         []byte("abc"),
+			}}
+			localDb := new(lMocks.Client)
+			localDb.On("DueScheduledRecords", now.UnixMilli(), (*ScheduledRecord)(nil), scheduledRecordsBatchSize).
+				Return(records, nil)
+			localDb.On("DeleteScheduledRecord", mock.Anything).Return(nil)
+			discoverer := new(dMocks.Discoverer)
+			discoverer.On("Topology").Return(&topology)
+			discoverer.On("Generation", topology.MyToken()).Return(&Generation{Leader: 0, Followers: []int{1, 2}})
+			// The range of the key moved to another broker after scaling up
+			leaderReplication := NewReplicationInfo(&topology, topology.GetToken(1), 1, []int{2, 0}, 3)
+			discoverer.On("Leader", "k1").Return(leaderReplication)
+			gossiper := new(iMocks.Gossiper)
+			gossiper.On("SendToLeader", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything,
+				mock.Anything, mock.Anything, mock.Anything).Return(&ProduceResponse{}, nil)
+			gossiper.On("SendScheduledDelete", mock.Anything, mock.Anything).Return(nil)
+			p := &producer{
+				localDb:      localDb,
+				leaderGetter: discoverer,
+				gossiper:     gossiper,
+				topicGetter:  &testTopicGetter{topics: map[string]*TopicInfo{"t1": {Name: "t1"}}},
+			}
+
+			p.deliverDue(now)
+			gossiper.AssertCalled(GinkgoT(), "SendToLeader", leaderReplication, "t1", SystemPrincipal,
+				url.Values{"partitionKey": {"k1"}, "ttl": {"1000"}}, int64(3), "", http.Header(nil), mock.Anything)
+			localDb.AssertCalled(GinkgoT(), "DeleteScheduledRecord", "r1")
+			gossiper.AssertCalled(GinkgoT(), "SendScheduledDelete", 1, "r1")
+			gossiper.AssertCalled(GinkgoT(), "SendScheduledDelete", 2, "r1")
+		})
+
+		It("should deliver the records of tokens that no longer exist after scaling down", func() {
+			now := time.Now()
+			removedToken := GetTokenAtIndex(6, 1)
+			records := []ScheduledRecord{{Id: "r1", Topic: "t1", Token: removedToken, PartitionKey: "k1", DeliverAt: 1}}
+			localDb := new(lMocks.Client)
+			localDb.On("DueScheduledRecords", now.UnixMilli(), (*ScheduledRecord)(nil), scheduledRecordsBatchSize).
+				Return(records, nil)
+			localDb.On("DeleteScheduledRecord", mock.Anything).Return(nil)
+			discoverer := new(dMocks.Discoverer)
+			discoverer.On("Topology").Return(&topology)
+			discoverer.On("Generation", removedToken).Return(nil)
+			discoverer.On("Leader", "k1").Return(NewReplicationInfo(&topology, topology.GetToken(1), 1, []int{2, 0}, 0))
+			gossiper := new(iMocks.Gossiper)
+			gossiper.On("SendToLeader", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything,
+				mock.Anything, mock.Anything, mock.Anything).Return(&ProduceResponse{}, nil)
+			p := &producer{
+				localDb:      localDb,
+				leaderGetter: discoverer,
+				gossiper:     gossiper,
+				topicGetter:  &testTopicGetter{topics: map[string]*TopicInfo{"t1": {Name: "t1"}}},
+			}
+
+			p.deliverDue(now)
+			gossiper.AssertNumberOfCalls(GinkgoT(), "SendToLeader", 1)
+			localDb.AssertCalled(GinkgoT(), "DeleteScheduledRecord", "r1")
+		})
+
+		It("should continue after the records that were not delivered", func() {
+			now := time.Now()
+			records := make([]ScheduledRecord, scheduledRecordsBatchSize)
+			for i := range records {
+				records[i] = ScheduledRecord{
+					Id: fmt.Sprintf("r%d", i), Topic: "t1", Token: topology.GetToken(1), DeliverAt: now.UnixMilli()}
+			}
+			last := &records[len(records)-1]
+			localDb := new(lMocks.Client)
+			localDb.On("DueScheduledRecords", now.UnixMilli(), (*ScheduledRecord)(nil), scheduledRecordsBatchSize).
+				Return(records, nil)
+			localDb.On("DueScheduledRecords", now.UnixMilli(), last, scheduledRecordsBatchSize).
+				Return([]ScheduledRecord{}, nil)
+			discoverer := new(dMocks.Discoverer)
+			discoverer.On("Topology").Return(&topology)
+			discoverer.On("Generation", topology.GetToken(1)).Return(&Generation{Leader: 1, Followers: []int{2, 0}})
+			p := &producer{localDb: localDb, leaderGetter: discoverer}
+
+			p.deliverDue(now)
+			localDb.AssertNumberOfCalls(GinkgoT(), "DueScheduledRecords", 2)
+		})
+	})
+})
+
+func newTestTopology(length int, ordinal int) TopologyInfo {
+	brokers := make([]BrokerInfo, length)
+	for i := 0; i < length; i++ {
+		brokers[i] = BrokerInfo{
+			IsSelf:   i == ordinal,
+			Ordinal:  i,
+			HostName: fmt.Sprintf("test-%d", i),
+		}
+	}
+
+	return NewTopology(brokers, ordinal)
+}
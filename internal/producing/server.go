@@ -1,7 +1,9 @@
 package producing
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,13 +12,17 @@ import (
 	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/polarstreams/polar/internal/auth"
 	"github.com/polarstreams/polar/internal/conf"
 	"github.com/polarstreams/polar/internal/data"
+	"github.com/polarstreams/polar/internal/data/acls"
 	"github.com/polarstreams/polar/internal/data/topics"
 	"github.com/polarstreams/polar/internal/discovery"
 	"github.com/polarstreams/polar/internal/interbroker"
+	"github.com/polarstreams/polar/internal/localdb"
 	"github.com/polarstreams/polar/internal/metrics"
 	"github.com/polarstreams/polar/internal/producing/pooling"
+	"github.com/polarstreams/polar/internal/quotas"
 	"github.com/polarstreams/polar/internal/types"
 	"github.com/polarstreams/polar/internal/utils"
 	"github.com/rs/zerolog/log"
@@ -25,10 +31,17 @@ import (
 type Producer interface {
 	types.Initializer
 	types.Closer
+	types.RecordProducer
+	DeadLetterAdmin
 
 	AcceptConnections() error
 }
 
+type DeadLetterAdmin interface {
+	// Gets the amount of records of the topic routed to the dead-letter topic by all the brokers
+	DeadLetterStats(topic string) (*types.DeadLetterStats, error)
+}
+
 type coalescerGetter interface {
 	Coalescer(topicName string, token types.Token, rangeIndex types.RangeIndex) *coalescer
 }
@@ -39,34 +52,52 @@ func NewProducer(
 	leaderGetter discovery.TopologyGetter,
 	datalog data.Datalog,
 	gossiper interbroker.Gossiper,
+	localDb localdb.Client,
+	authenticator auth.Authenticator,
+	authorizer acls.Authorizer,
+	limiter quotas.Limiter,
 ) Producer {
 	coalescerMap := utils.NewCopyOnWriteMap()
 
 	return &producer{
-		config:       config,
-		topicGetter:  topicGetter,
-		datalog:      datalog,
-		gossiper:     gossiper,
-		leaderGetter: leaderGetter,
-		coalescerMap: coalescerMap,
-		bufferPool:   pooling.NewBufferPool(config.ProducerBufferPoolSize()),
+		config:        config,
+		topicGetter:   topicGetter,
+		datalog:       datalog,
+		gossiper:      gossiper,
+		localDb:       localDb,
+		leaderGetter:  leaderGetter,
+		coalescerMap:  coalescerMap,
+		bufferPool:    pooling.NewBufferPool(config.ProducerBufferPoolSize()),
+		deadLetters:   newDeadLetterCounter(),
+		authenticator: authenticator,
+		authorizer:    authorizer,
+		limiter:       limiter,
 	}
 }
 
 type producer struct {
-	config       conf.ProducerConfig
-	topicGetter  topics.TopicGetter
-	datalog      data.Datalog
-	gossiper     interbroker.Gossiper
-	leaderGetter discovery.TopologyGetter
-	coalescerMap *utils.CopyOnWriteMap
-	server       *http.Server
-	bufferPool   pooling.BufferPool
+	config        conf.ProducerConfig
+	topicGetter   topics.TopicGetter
+	datalog       data.Datalog
+	gossiper      interbroker.Gossiper
+	localDb       localdb.Client
+	leaderGetter  discovery.TopologyGetter
+	coalescerMap  *utils.CopyOnWriteMap
+	server        *http.Server
+	bufferPool    pooling.BufferPool
+	deadLetters   *deadLetterCounter
+	authenticator auth.Authenticator
+	authorizer    acls.Authorizer
+	limiter       quotas.Limiter
 }
 
 func (p *producer) Init() error {
 	// Listen to rerouted messages from other peers
 	p.gossiper.RegisterReroutedMessageListener(p)
+	p.gossiper.RegisterScheduledRecordListener(p)
+	p.gossiper.RegisterDeadLetterStatsListener(p)
+
+	go p.deliverScheduledRecords()
 	return nil
 }
 
@@ -75,7 +106,7 @@ func (p *producer) AcceptConnections() error {
 	address := utils.GetServiceAddress(port, p.leaderGetter.LocalInfo(), p.config)
 	router := httprouter.New()
 
-	router.POST(conf.TopicMessageUrl, utils.ToPostHandle(p.postMessage))
+	router.POST(conf.TopicMessageUrl, utils.ToHandle(p.postMessage))
 	router.GET(conf.StatusUrl, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		fmt.Fprintf(w, "Producer server listening on %d\n", port)
 	})
@@ -84,15 +115,24 @@ func (p *producer) AcceptConnections() error {
 		fmt.Fprint(w, "Producer server doesn't allow getting topic messages\n")
 	})
 
+	tlsConfig, err := utils.NewServerTlsConfig(p.config)
+	if err != nil {
+		return err
+	}
 	server := &http.Server{
-		Addr:    address,
-		Handler: router,
+		Addr:      address,
+		Handler:   auth.Handler(p.authenticator, auth.ProducerServer, router),
+		TLSConfig: tlsConfig,
+	}
+	listener, err := utils.Listen(address, tlsConfig)
+	if err != nil {
+		return err
 	}
 
 	c := make(chan bool, 1)
 	go func() {
 		c <- true
-		if err := server.ListenAndServe(); err != nil {
+		if err := server.Serve(listener); err != nil {
 			if err == http.ErrServerClosed {
 				log.Info().Msgf("Producer server stopped")
 			} else {
@@ -104,7 +144,7 @@ func (p *producer) AcceptConnections() error {
 	<-c
 	p.server = server
 	log.Info().Msgf("Start listening to producers on %s", address)
-	return p.acceptBinaryConnections()
+	return p.acceptBinaryConnections(tlsConfig)
 }
 
 func (p *producer) Close() {
@@ -115,64 +155,182 @@ func (p *producer) Close() {
 
 func (p *producer) OnReroutedMessage(
 	topic string,
+	principal string,
 	querystring url.Values,
 	contentLength int64,
 	contentType string,
+	recordHeaders http.Header,
 	body io.ReadCloser,
-) error {
-	return p.handleMessage(topic, querystring, contentLength, contentType, body)
+) (*types.ProduceResponse, error) {
+	// The quotas were enforced by the broker that received the request
+	return p.handleMessage(topic, principal, "", querystring, contentLength, contentType, recordHeaders, body)
+}
+
+// Produces a record on behalf of the broker, like the records routed to dead-letter topics
+func (p *producer) ProduceRecord(
+	topic string,
+	partitionKey string,
+	contentType string,
+	recordHeaders http.Header,
+	body []byte,
+) (*types.ProduceResponse, error) {
+	querystring := url.Values{}
+	if partitionKey != "" {
+		querystring.Set("partitionKey", partitionKey)
+	}
+	return p.handleMessage(
+		topic,
+		types.SystemPrincipal,
+		"",
+		querystring,
+		int64(len(body)),
+		contentType,
+		recordHeaders,
+		io.NopCloser(bytes.NewReader(body)))
 }
 
 func (p *producer) postMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) error {
 	metrics.ProducerMessagesReceived.Inc()
 	metrics.ProducerMessagesBodyBytes.Add(float64(r.ContentLength))
 
-	return p.handleMessage(
-		ps.ByName("topic"), r.URL.Query(), r.ContentLength, r.Header.Get(types.ContentTypeHeaderKey), r.Body)
+	response, err := p.handleMessage(
+		ps.ByName("topic"),
+		auth.Principal(r),
+		quotas.Client(auth.Principal(r), r.RemoteAddr),
+		r.URL.Query(),
+		r.ContentLength,
+		r.Header.Get(types.ContentTypeHeaderKey),
+		utils.RecordHeaders(r.Header),
+		r.Body)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set(types.ContentTypeHeaderKey, types.MIMETypeJSON)
+	if response.ScheduledId != "" {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	return json.NewEncoder(w).Encode(response)
 }
 
-// Produces or re-routes the message request
+// Produces or re-routes the message request on behalf of the principal, returning the location of the produced records.
+// The request is charged to the quotas of the client and the topic, except when the client is empty.
 func (p *producer) handleMessage(
 	topic string,
+	principal string,
+	client string,
 	querystring url.Values,
 	contentLength int64,
 	contentType string,
+	recordHeaders http.Header,
 	body io.ReadCloser,
-) error {
-	if topic == "" || !p.topicGetter.Exists(topic) {
-		return types.NewHttpError(http.StatusBadRequest, "Invalid topic")
+) (*types.ProduceResponse, error) {
+	if topic == "" {
+		return nil, types.NewHttpError(http.StatusBadRequest, "Invalid topic")
+	}
+
+	if err := p.authorizer.Authorize(principal, types.AclOperationProduce, topic, ""); err != nil {
+		return nil, err
+	}
+
+	topicInfo, err := p.topicGetter.GetOrCreate(topic)
+	if err != nil {
+		return nil, err
+	}
+	if topicInfo == nil {
+		return nil, types.NewHttpErrorf(http.StatusNotFound, "Topic '%s' not found", topic)
 	}
 
-	if contentLength <= 0 || contentLength > int64(p.config.MaxMessageSize()) {
+	if client != "" {
+		if err := p.limiter.Charge(quotas.OperationProduce, client, contentLength, topic); err != nil {
+			return nil, err
+		}
+	}
+
+	partitionKey := querystring.Get("partitionKey")
+	isTombstone := contentLength == 0 && partitionKey != "" && topicInfo.IsCompacted()
+	maxMessageSize := topicInfo.MaxMessageSize(p.config.MaxMessageSize())
+	if (contentLength <= 0 && !isTombstone) || contentLength > int64(maxMessageSize) {
 		log.Debug().Msgf("Invalid content length (%d) when handling message", contentLength)
-		return types.NewHttpErrorf(
+		rejection := types.NewHttpErrorf(
 			http.StatusBadRequest,
 			"Content length must be defined (HTTP/1.1 chunked not supported), greater than 0 and less than %d bytes",
-			p.config.MaxMessageSize())
+			maxMessageSize)
+		return p.deadLetterFromReader(
+			topicInfo,
+			deadLetterReasonMessageSize,
+			rejection,
+			partitionKey,
+			contentType,
+			recordHeaders,
+			contentLength,
+			body)
+	}
+
+	if topicInfo.IsCompacted() && len(partitionKey) > data.MaxRecordKeyLength {
+		return nil, types.NewHttpErrorf(
+			http.StatusBadRequest, "Partition key can not be larger than %d bytes", data.MaxRecordKeyLength)
+	}
+
+	producer, err := parseProducerSequence(querystring.Get(producerIdKey), querystring.Get(sequenceKey))
+	if err != nil {
+		return nil, types.NewHttpError(http.StatusBadRequest, err.Error())
+	}
+
+	ttl, err := parseTtl(querystring.Get(ttlKey))
+	if err != nil {
+		return nil, types.NewHttpError(http.StatusBadRequest, err.Error())
+	}
+
+	headers := appendTtlHeader(producer.appendHeaders(recordHeaderEntries(contentType, recordHeaders)), ttl)
+	if err := data.ValidateRecordHeaders(headers); err != nil {
+		return nil, types.NewHttpError(http.StatusBadRequest, err.Error())
+	}
+
+	deliverAt, err := parseDeliverAt(querystring, time.Now(), p.config.ProducerMaxDeliveryDelay())
+	if err != nil {
+		return nil, types.NewHttpError(http.StatusBadRequest, err.Error())
+	}
+	if deliverAt > 0 && producer != nil {
+		return nil, types.NewHttpError(
+			http.StatusBadRequest, "Scheduled delivery is not supported for idempotent producers")
 	}
 
-	partitionKey := querystring.Get("partitionKey")
 	replication := p.leaderGetter.Leader(partitionKey)
 	leader := replication.Leader
 
 	if leader == nil {
-		return types.NewHttpError(
+		return nil, types.NewHttpError(
 			http.StatusMisdirectedRequest,
 			fmt.Sprintf("Leader for token %d could not be found", replication.Token))
 	}
 
 	if !leader.IsSelf {
+		if deliverAt > 0 {
+			// The delay is relative to the time it was received
+			querystring.Del(delayKey)
+			querystring.Set(deliverAtKey, strconv.FormatInt(deliverAt, 10))
+		}
 		// Route the message as-is
-		return p.gossiper.SendToLeader(replication, topic, querystring, contentLength, contentType, body)
+		return p.gossiper.SendToLeader(
+			replication, topic, principal, querystring, contentLength, contentType, recordHeaders, body)
 	}
 
-	// Use a buffer from the pool (may block when there isn't free space)
-	buffers := p.bufferPool.Get(int(contentLength))
-	defer p.bufferPool.Free(buffers)
-	bodyLength, err := readBody(buffers, body)
-	if err != nil {
-		log.Err(err).Msgf("Producer server could not read body of expected length %d", contentLength)
-		return fmt.Errorf("Producer server could not read body of expected length %d", contentLength)
+	if deliverAt > 0 {
+		return p.schedule(
+			topic, replication, partitionKey, deliverAt, ttl, contentLength, contentType, recordHeaders, body)
+	}
+
+	var buffers [][]byte
+	bodyLength := 0
+	if !isTombstone {
+		// Use a buffer from the pool (may block when there isn't free space)
+		buffers = p.bufferPool.Get(int(contentLength))
+		defer p.bufferPool.Free(buffers)
+		if bodyLength, err = readBody(buffers, body); err != nil {
+			log.Err(err).Msgf("Producer server could not read body of expected length %d", contentLength)
+			return nil, fmt.Errorf("Producer server could not read body of expected length %d", contentLength)
+		}
 	}
 
 	timestampMicros := time.Now().UnixMicro()
@@ -183,15 +341,33 @@ func (p *producer) handleMessage(
 	}
 
 	coalescer := p.Coalescer(topic, replication.Token, replication.RangeIndex)
-	if err := coalescer.append(replication, uint32(bodyLength), timestampMicros, contentType, buffers); err != nil {
-		return p.adaptCoalescerError(err)
+	response, err := coalescer.append(
+		replication, uint32(bodyLength), timestampMicros, contentType, partitionKey, headers, producer, buffers)
+	if err != nil {
+		if !wasWriteAttempted(err) && bodyLength > 0 {
+			// The records can be routed as they were not written
+			return p.deadLetter(
+				topicInfo,
+				deadLetterReasonWriteFailure,
+				p.adaptCoalescerError(err),
+				partitionKey,
+				contentType,
+				recordHeaders,
+				joinBuffers(buffers, bodyLength))
+		}
+		return nil, p.adaptCoalescerError(err)
 	}
-	return nil
+	return response, nil
 }
 
-func (p *producer) adaptCoalescerError(err error) error {
+// Determines whether the coalescer tried to write the records, returning true when it's not known
+func wasWriteAttempted(err error) bool {
 	inner, ok := err.(types.ProducingError)
-	if ok && !inner.WasWriteAttempted() {
+	return !ok || inner.WasWriteAttempted()
+}
+
+func (p *producer) adaptCoalescerError(err error) error {
+	if !wasWriteAttempted(err) {
 		return types.NewHttpError(
 			http.StatusMisdirectedRequest,
 			fmt.Sprintf("Producer request could not be handled at the moment: %s", err.Error()))
@@ -222,7 +398,7 @@ func readBody(buffers [][]byte, body io.Reader) (int, error) {
 func (p *producer) Coalescer(topicName string, token types.Token, rangeIndex types.RangeIndex) *coalescer {
 	key := coalescerKey{topicName, token, rangeIndex}
 	c, loaded, _ := p.coalescerMap.LoadOrStore(key, func() (interface{}, error) {
-		return newCoalescer(topicName, token, rangeIndex, p.leaderGetter, p.gossiper, p.config), nil
+		return newCoalescer(topicName, token, rangeIndex, p.topicGetter, p.leaderGetter, p.gossiper, p.gossiper, p.config), nil
 	})
 
 	if !loaded {
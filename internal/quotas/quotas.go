@@ -0,0 +1,290 @@
+package quotas
+
+import (
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/polarstreams/polar/internal/auth"
+	"github.com/polarstreams/polar/internal/conf"
+	"github.com/polarstreams/polar/internal/data/topics"
+	"github.com/polarstreams/polar/internal/metrics"
+	"github.com/polarstreams/polar/internal/types"
+	"github.com/rs/zerolog/log"
+)
+
+// The operations limited by the quotas
+const (
+	OperationProduce = "produce"
+	OperationConsume = "consume"
+)
+
+// The kinds of the entities the quotas are applied to, used in metrics
+const (
+	kindClient = "client"
+	kindTopic  = "topic"
+)
+
+// The amount of time after which the usage and the metrics of an idle client or topic are removed
+const idleUsageExpiration = time.Minute
+
+// Limiter enforces the byte-rate and request-rate quotas of the clients and the topics on this broker.
+//
+// The rates allow bursts of up to one second, the bytes of a request exceeding the rate are allowed and the following
+// requests are throttled until the usage is within the quota again.
+type Limiter interface {
+	types.Initializer
+
+	// Charges a request and its bytes to the quotas of the client and each one of the topics.
+	// It returns a types.ThrottledError, without charging, when the client or a topic exceeded the quota.
+	// An empty client is not charged.
+	Charge(operation string, client string, bytes int64, topicNames ...string) error
+
+	// Charges bytes transferred after the request was allowed, like the records served to a consumer.
+	// An empty client or topic is not charged.
+	ChargeBytes(operation string, client string, topic string, bytes int64)
+
+	// Gets the amount of time to wait until the client and the topics are within the byte-rate quota, zero when
+	// they are not exceeding it
+	BytesDelay(operation string, client string, topicNames ...string) time.Duration
+}
+
+func NewLimiter(config conf.QuotaConfig, topicGetter topics.TopicGetter, authenticator auth.Authenticator) Limiter {
+	return &limiter{
+		config:        config,
+		topicGetter:   topicGetter,
+		authenticator: authenticator,
+	}
+}
+
+// Gets the identity of the client the quotas are applied to: the authenticated principal or the remote host when
+// authentication is not enabled
+func Client(principal string, remoteAddr string) string {
+	if principal != "" {
+		return principal
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+type limiter struct {
+	config        conf.QuotaConfig
+	topicGetter   topics.TopicGetter
+	authenticator auth.Authenticator
+	usages        sync.Map // *usage by usageKey, including the ones that are not limited but have metrics
+}
+
+type usageKey struct {
+	operation string
+	kind      string
+	name      string
+}
+
+func (l *limiter) Init() error {
+	go l.removeIdleUsages()
+	return nil
+}
+
+func (l *limiter) Charge(operation string, client string, bytes int64, topicNames ...string) error {
+	now := time.Now()
+	if client != "" {
+		if err := l.throttled(usageKey{operation, kindClient, client}, false, now); err != nil {
+			return err
+		}
+	}
+	for _, topic := range topicNames {
+		if err := l.throttled(usageKey{operation, kindTopic, topic}, false, now); err != nil {
+			return err
+		}
+	}
+
+	if client != "" {
+		l.charge(usageKey{operation, kindClient, client}, 1, bytes, now)
+	}
+	for _, topic := range topicNames {
+		l.charge(usageKey{operation, kindTopic, topic}, 1, bytes, now)
+	}
+	return nil
+}
+
+func (l *limiter) ChargeBytes(operation string, client string, topic string, bytes int64) {
+	now := time.Now()
+	if client != "" {
+		l.charge(usageKey{operation, kindClient, client}, 0, bytes, now)
+	}
+	if topic != "" {
+		l.charge(usageKey{operation, kindTopic, topic}, 0, bytes, now)
+	}
+}
+
+func (l *limiter) BytesDelay(operation string, client string, topicNames ...string) time.Duration {
+	now := time.Now()
+	delay := time.Duration(0)
+	if client != "" {
+		delay = l.delay(usageKey{operation, kindClient, client}, true, now)
+	}
+	for _, topic := range topicNames {
+		if d := l.delay(usageKey{operation, kindTopic, topic}, true, now); d > delay {
+			delay = d
+		}
+	}
+	return delay
+}
+
+// Returns a throttled error when the client or topic exceeded the quota
+func (l *limiter) throttled(key usageKey, bytesOnly bool, now time.Time) error {
+	delay := l.delay(key, bytesOnly, now)
+	if delay == 0 {
+		return nil
+	}
+	// Round up to milliseconds
+	delay = (delay + time.Millisecond - 1).Truncate(time.Millisecond)
+
+	metrics.QuotaThrottled.WithLabelValues(key.operation, key.kind, key.name).Inc()
+	log.Debug().Msgf("Throttling %s requests of %s '%s' for %s", key.operation, key.kind, key.name, delay)
+	return types.NewThrottledError(
+		delay, "The %s quota of the %s '%s' was exceeded, retry after %s", key.operation, key.kind, key.name, delay)
+}
+
+func (l *limiter) delay(key usageKey, bytesOnly bool, now time.Time) time.Duration {
+	quota := l.quota(key)
+	if !quota.IsLimited() {
+		return 0
+	}
+	return l.usage(key).delay(quota, bytesOnly, now)
+}
+
+func (l *limiter) charge(key usageKey, requests int64, bytes int64, now time.Time) {
+	quota := l.quota(key)
+	hasMetrics := l.hasMetrics(key, quota)
+	if hasMetrics {
+		metrics.QuotaRequests.WithLabelValues(key.operation, key.kind, key.name).Add(float64(requests))
+		metrics.QuotaBytes.WithLabelValues(key.operation, key.kind, key.name).Add(float64(bytes))
+	}
+
+	if !quota.IsLimited() && !hasMetrics {
+		return
+	}
+	// The usage of the clients and topics with metrics is tracked to remove the series once idle
+	l.usage(key).charge(quota, requests, bytes, now)
+}
+
+// Determines whether the usage of the client or the topic is exposed in the metrics.
+//
+// The remote hosts are unbounded, so the clients are only included when they are authenticated principals or a client
+// quota is configured.
+func (l *limiter) hasMetrics(key usageKey, quota types.Quota) bool {
+	return key.kind == kindTopic || l.authenticator.IsEnabled() || quota.IsLimited()
+}
+
+// Gets the quota of the client or the topic, using the topic settings when defined
+func (l *limiter) quota(key usageKey) types.Quota {
+	if key.kind == kindClient {
+		if key.operation == OperationProduce {
+			return l.config.ClientProduceQuota()
+		}
+		return l.config.ClientConsumeQuota()
+	}
+
+	topic := l.topicGetter.Get(key.name)
+	if key.operation == OperationProduce {
+		return topic.ProduceQuota(l.config.TopicProduceQuota())
+	}
+	return topic.ConsumeQuota(l.config.TopicConsumeQuota())
+}
+
+func (l *limiter) usage(key usageKey) *usage {
+	if value, found := l.usages.Load(key); found {
+		return value.(*usage)
+	}
+	value, _ := l.usages.LoadOrStore(key, &usage{})
+	return value.(*usage)
+}
+
+func (l *limiter) removeIdleUsages() {
+	for {
+		time.Sleep(idleUsageExpiration)
+		l.removeIdle(time.Now())
+	}
+}
+
+func (l *limiter) removeIdle(now time.Time) {
+	l.usages.Range(func(key, value interface{}) bool {
+		if value.(*usage).isIdle(now) {
+			l.usages.Delete(key)
+			k := key.(usageKey)
+			metrics.QuotaRequests.DeleteLabelValues(k.operation, k.kind, k.name)
+			metrics.QuotaBytes.DeleteLabelValues(k.operation, k.kind, k.name)
+			metrics.QuotaThrottled.DeleteLabelValues(k.operation, k.kind, k.name)
+		}
+		return true
+	})
+}
+
+// Represents the tokens available of the rates of a client or a topic, negative when the usage exceeded the quota
+type usage struct {
+	mu       sync.Mutex
+	requests float64
+	bytes    float64
+	updated  time.Time
+}
+
+// Gets the amount of time until a request can be charged, zero when it can be charged now
+func (u *usage) delay(quota types.Quota, bytesOnly bool, now time.Time) time.Duration {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.refill(quota, now)
+
+	delay := deficit(u.bytes, 0, quota.BytesPerSecond)
+	if !bytesOnly {
+		if d := deficit(u.requests, 1, quota.RequestsPerSecond); d > delay {
+			delay = d
+		}
+	}
+	return delay
+}
+
+func (u *usage) charge(quota types.Quota, requests int64, bytes int64, now time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.refill(quota, now)
+	if quota.RequestsPerSecond > 0 {
+		u.requests -= float64(requests)
+	}
+	if quota.BytesPerSecond > 0 {
+		u.bytes -= float64(bytes)
+	}
+}
+
+func (u *usage) isIdle(now time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return now.Sub(u.updated) > idleUsageExpiration
+}
+
+// Adds the tokens for the time elapsed since the last update, up to one second of the rates
+func (u *usage) refill(quota types.Quota, now time.Time) {
+	elapsed := now.Sub(u.updated).Seconds()
+	if u.updated.IsZero() {
+		elapsed = 1
+	}
+	u.updated = now
+	u.requests = refill(u.requests, quota.RequestsPerSecond, elapsed)
+	u.bytes = refill(u.bytes, quota.BytesPerSecond, elapsed)
+}
+
+func refill(tokens float64, rate int64, elapsed float64) float64 {
+	return math.Min(tokens+float64(rate)*elapsed, float64(rate))
+}
+
+// Gets the amount of time until the tokens reach the needed amount at the given rate, zero when not limited
+func deficit(tokens float64, needed float64, rate int64) time.Duration {
+	if rate <= 0 || tokens >= needed {
+		return 0
+	}
+	return time.Duration((needed - tokens) / float64(rate) * float64(time.Second))
+}
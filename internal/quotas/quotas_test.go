@@ -0,0 +1,176 @@
+package quotas
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/polarstreams/polar/internal/metrics"
+	"github.com/polarstreams/polar/internal/test/conf/mocks"
+	. "github.com/polarstreams/polar/internal/types"
+)
+
+func TestQuotas(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Quotas Suite")
+}
+
+var _ = Describe("limiter", func() {
+	Describe("Charge()", func() {
+		It("should throttle the client when the request rate is exceeded", func() {
+			l := newTestLimiter(Quota{RequestsPerSecond: 2}, Quota{}, nil)
+			Expect(l.Charge(OperationProduce, "billing", 10, "orders")).To(Succeed())
+			Expect(l.Charge(OperationProduce, "billing", 10, "orders")).To(Succeed())
+
+			err := l.Charge(OperationProduce, "billing", 10, "orders")
+			Expect(err).To(HaveOccurred())
+			throttled, ok := err.(ThrottledError)
+			Expect(ok).To(BeTrue())
+			Expect(throttled.StatusCode()).To(Equal(429))
+			Expect(throttled.RetryAfter()).To(BeNumerically(">", 0))
+			Expect(throttled.RetryAfter()).To(BeNumerically("<=", 500*time.Millisecond))
+
+			// Other clients and operations are not affected
+			Expect(l.Charge(OperationProduce, "shipping", 10, "orders")).To(Succeed())
+			Expect(l.Charge(OperationConsume, "billing", 0, "orders")).To(Succeed())
+		})
+
+		It("should allow a request exceeding the byte rate and throttle the following ones", func() {
+			l := newTestLimiter(Quota{BytesPerSecond: 100}, Quota{}, nil)
+			Expect(l.Charge(OperationProduce, "billing", 150, "orders")).To(Succeed())
+
+			err := l.Charge(OperationProduce, "billing", 1, "orders")
+			Expect(err).To(HaveOccurred())
+			Expect(err.(ThrottledError).RetryAfter()).To(BeNumerically("~", 500*time.Millisecond, 50*time.Millisecond))
+			Expect(err).To(MatchError(ContainSubstring("The produce quota of the client 'billing' was exceeded")))
+		})
+
+		It("should use the quota of the topic settings", func() {
+			topics := map[string]*TopicInfo{
+				"orders": {Name: "orders", Settings: TopicSettings{ProduceQuota: &Quota{RequestsPerSecond: 1}}},
+			}
+			l := newTestLimiter(Quota{}, Quota{RequestsPerSecond: 100}, topics)
+			Expect(l.Charge(OperationProduce, "billing", 10, "orders")).To(Succeed())
+			err := l.Charge(OperationProduce, "shipping", 10, "orders")
+			Expect(err).To(MatchError(ContainSubstring("The produce quota of the topic 'orders' was exceeded")))
+
+			// Falls back to the broker default
+			Expect(l.Charge(OperationProduce, "billing", 10, "events")).To(Succeed())
+			Expect(l.Charge(OperationProduce, "billing", 10, "events")).To(Succeed())
+		})
+
+		It("should not charge the client when a topic is throttled", func() {
+			topics := map[string]*TopicInfo{
+				"orders": {Name: "orders", Settings: TopicSettings{ProduceQuota: &Quota{RequestsPerSecond: 1}}},
+			}
+			l := newTestLimiter(Quota{RequestsPerSecond: 2}, Quota{}, topics)
+			Expect(l.Charge(OperationProduce, "billing", 10, "orders")).NotTo(HaveOccurred())
+			Expect(l.Charge(OperationProduce, "billing", 10, "orders")).To(HaveOccurred())
+			Expect(l.Charge(OperationProduce, "billing", 10, "events")).To(Succeed())
+		})
+
+		It("should not throttle when the quotas are not set", func() {
+			l := newTestLimiter(Quota{}, Quota{}, nil)
+			for i := 0; i < 100; i++ {
+				Expect(l.Charge(OperationProduce, "billing", 1000, "orders")).To(Succeed())
+			}
+		})
+	})
+
+	Describe("ChargeBytes()", func() {
+		It("should charge the bytes without throttling", func() {
+			l := newTestLimiter(Quota{BytesPerSecond: 100}, Quota{}, nil)
+			Expect(l.BytesDelay(OperationConsume, "billing", "orders")).To(BeZero())
+			Expect(l.Charge(OperationConsume, "billing", 0, "orders")).To(Succeed())
+			l.ChargeBytes(OperationConsume, "billing", "orders", 200)
+			l.ChargeBytes(OperationConsume, "billing", "orders", 200)
+
+			Expect(l.BytesDelay(OperationConsume, "billing", "orders")).To(
+				BeNumerically("~", 3*time.Second, 100*time.Millisecond))
+			Expect(l.Charge(OperationConsume, "billing", 0, "orders")).To(HaveOccurred())
+		})
+	})
+
+	Describe("removeIdle()", func() {
+		It("should remove the usages not updated recently", func() {
+			l := newTestLimiter(Quota{RequestsPerSecond: 1}, Quota{}, nil).(*limiter)
+			Expect(l.Charge(OperationProduce, "billing", 10)).To(Succeed())
+			l.removeIdle(time.Now())
+			Expect(l.Charge(OperationProduce, "billing", 10)).To(HaveOccurred())
+
+			l.removeIdle(time.Now().Add(2 * idleUsageExpiration))
+			Expect(l.Charge(OperationProduce, "billing", 10)).To(Succeed())
+		})
+
+		It("should remove the metrics of the idle clients and topics", func() {
+			l := newTestLimiter(Quota{}, Quota{}, nil).(*limiter)
+			l.authenticator = testAuthenticator(true)
+			Expect(l.Charge(OperationProduce, "idle-client", 10, "idle-topic")).To(Succeed())
+			l.removeIdle(time.Now().Add(2 * idleUsageExpiration))
+
+			Expect(metrics.QuotaRequests.DeleteLabelValues(OperationProduce, kindClient, "idle-client")).To(BeFalse())
+			Expect(metrics.QuotaBytes.DeleteLabelValues(OperationProduce, kindTopic, "idle-topic")).To(BeFalse())
+		})
+	})
+
+	Describe("hasMetrics()", func() {
+		It("should include the clients when they are authenticated or a client quota is set", func() {
+			l := newTestLimiter(Quota{}, Quota{}, nil).(*limiter)
+			Expect(l.Charge(OperationProduce, "10.0.0.1", 10, "orders")).To(Succeed())
+			Expect(metrics.QuotaRequests.DeleteLabelValues(OperationProduce, kindClient, "10.0.0.1")).To(BeFalse())
+			Expect(metrics.QuotaRequests.DeleteLabelValues(OperationProduce, kindTopic, "orders")).To(BeTrue())
+
+			Expect(l.hasMetrics(usageKey{OperationProduce, kindClient, "a"}, Quota{})).To(BeFalse())
+			Expect(l.hasMetrics(usageKey{OperationProduce, kindClient, "a"}, Quota{BytesPerSecond: 1})).To(BeTrue())
+			l.authenticator = testAuthenticator(true)
+			Expect(l.hasMetrics(usageKey{OperationProduce, kindClient, "a"}, Quota{})).To(BeTrue())
+		})
+	})
+})
+
+var _ = Describe("Client()", func() {
+	It("should use the principal or the remote host", func() {
+		Expect(Client("billing", "10.0.0.1:1234")).To(Equal("billing"))
+		Expect(Client("", "10.0.0.1:1234")).To(Equal("10.0.0.1"))
+		Expect(Client("", "[::1]:1234")).To(Equal("::1"))
+	})
+})
+
+func newTestLimiter(clientQuota Quota, topicQuota Quota, topics map[string]*TopicInfo) Limiter {
+	config := new(mocks.Config)
+	config.On("ClientProduceQuota").Return(clientQuota)
+	config.On("ClientConsumeQuota").Return(clientQuota)
+	config.On("TopicProduceQuota").Return(topicQuota)
+	config.On("TopicConsumeQuota").Return(topicQuota)
+	return NewLimiter(config, testTopicGetter(topics), testAuthenticator(false))
+}
+
+// Represents an authenticator that is enabled or not
+type testAuthenticator bool
+
+func (a testAuthenticator) Init() error {
+	return nil
+}
+
+func (a testAuthenticator) IsEnabled() bool {
+	return bool(a)
+}
+
+func (a testAuthenticator) Authenticate(token string) (string, error) {
+	return token, nil
+}
+
+type testTopicGetter map[string]*TopicInfo
+
+func (g testTopicGetter) Get(topic string) *TopicInfo {
+	return g[topic]
+}
+
+func (g testTopicGetter) GetOrCreate(topic string) (*TopicInfo, error) {
+	return g[topic], nil
+}
+
+func (g testTopicGetter) Exists(topic string) bool {
+	return g[topic] != nil
+}
@@ -15,6 +15,120 @@ type Config struct {
 	mock.Mock
 }
 
+// AdminPort provides a mock function with given fields:
+func (_m *Config) AdminPort() int {
+	ret := _m.Called()
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func() int); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	return r0
+}
+
+// AdminPrincipals provides a mock function with given fields:
+func (_m *Config) AdminPrincipals() []string {
+	ret := _m.Called()
+
+	var r0 []string
+	if rf, ok := ret.Get(0).(func() []string); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	return r0
+}
+
+// AuthAclEnabled provides a mock function with given fields:
+func (_m *Config) AuthAclEnabled() bool {
+	ret := _m.Called()
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func() bool); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	return r0
+}
+
+// AuthApiKeysFile provides a mock function with given fields:
+func (_m *Config) AuthApiKeysFile() string {
+	ret := _m.Called()
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func() string); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
+
+// AuthJwksFile provides a mock function with given fields:
+func (_m *Config) AuthJwksFile() string {
+	ret := _m.Called()
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func() string); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
+
+// AuthJwtAudience provides a mock function with given fields:
+func (_m *Config) AuthJwtAudience() string {
+	ret := _m.Called()
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func() string); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
+
+// AuthJwtIssuer provides a mock function with given fields:
+func (_m *Config) AuthJwtIssuer() string {
+	ret := _m.Called()
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func() string); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
+
+// AuthReloadInterval provides a mock function with given fields:
+func (_m *Config) AuthReloadInterval() time.Duration {
+	ret := _m.Called()
+
+	var r0 time.Duration
+	if rf, ok := ret.Get(0).(func() time.Duration); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(time.Duration)
+	}
+
+	return r0
+}
+
 // AutoCommitInterval provides a mock function with given fields:
 func (_m *Config) AutoCommitInterval() time.Duration {
 	ret := _m.Called()
@@ -43,6 +157,20 @@ func (_m *Config) BaseHostName() string {
 	return r0
 }
 
+// ClientConsumeQuota provides a mock function with given fields:
+func (_m *Config) ClientConsumeQuota() types.Quota {
+	ret := _m.Called()
+
+	var r0 types.Quota
+	if rf, ok := ret.Get(0).(func() types.Quota); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(types.Quota)
+	}
+
+	return r0
+}
+
 // ClientDiscoveryPort provides a mock function with given fields:
 func (_m *Config) ClientDiscoveryPort() int {
 	ret := _m.Called()
@@ -57,6 +185,20 @@ func (_m *Config) ClientDiscoveryPort() int {
 	return r0
 }
 
+// ClientProduceQuota provides a mock function with given fields:
+func (_m *Config) ClientProduceQuota() types.Quota {
+	ret := _m.Called()
+
+	var r0 types.Quota
+	if rf, ok := ret.Get(0).(func() types.Quota); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(types.Quota)
+	}
+
+	return r0
+}
+
 // ConsumerAddDelay provides a mock function with given fields:
 func (_m *Config) ConsumerAddDelay() time.Duration {
 	ret := _m.Called()
@@ -99,6 +241,48 @@ func (_m *Config) ConsumerRanges() int {
 	return r0
 }
 
+// ConsumerAckTimeout provides a mock function with given fields:
+func (_m *Config) ConsumerAckTimeout() time.Duration {
+	ret := _m.Called()
+
+	var r0 time.Duration
+	if rf, ok := ret.Get(0).(func() time.Duration); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(time.Duration)
+	}
+
+	return r0
+}
+
+// ConsumerMaxNacks provides a mock function with given fields:
+func (_m *Config) ConsumerMaxNacks() int {
+	ret := _m.Called()
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func() int); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	return r0
+}
+
+// ConsumerMaxPollWait provides a mock function with given fields:
+func (_m *Config) ConsumerMaxPollWait() time.Duration {
+	ret := _m.Called()
+
+	var r0 time.Duration
+	if rf, ok := ret.Get(0).(func() time.Duration); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(time.Duration)
+	}
+
+	return r0
+}
+
 // ConsumerReadThreshold provides a mock function with given fields:
 func (_m *Config) ConsumerReadThreshold() int {
 	ret := _m.Called()
@@ -225,6 +409,62 @@ func (_m *Config) GossipPort() int {
 	return r0
 }
 
+// GossipSecret provides a mock function with given fields:
+func (_m *Config) GossipSecret() string {
+	ret := _m.Called()
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func() string); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
+
+// GossipTlsCaFile provides a mock function with given fields:
+func (_m *Config) GossipTlsCaFile() string {
+	ret := _m.Called()
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func() string); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
+
+// GossipTlsCertFile provides a mock function with given fields:
+func (_m *Config) GossipTlsCertFile() string {
+	ret := _m.Called()
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func() string); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
+
+// GossipTlsKeyFile provides a mock function with given fields:
+func (_m *Config) GossipTlsKeyFile() string {
+	ret := _m.Called()
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func() string); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
+
 // HomePath provides a mock function with given fields:
 func (_m *Config) HomePath() string {
 	ret := _m.Called()
@@ -295,6 +535,20 @@ func (_m *Config) LocalDbPath() string {
 	return r0
 }
 
+// LogRetentionBytes provides a mock function with given fields:
+func (_m *Config) LogRetentionBytes() int64 {
+	ret := _m.Called()
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func() int64); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	return r0
+}
+
 // LogRetentionDuration provides a mock function with given fields:
 func (_m *Config) LogRetentionDuration() *time.Duration {
 	ret := _m.Called()
@@ -451,6 +705,20 @@ func (_m *Config) ProducerBufferPoolSize() int {
 	return r0
 }
 
+// ProducerMaxDeliveryDelay provides a mock function with given fields:
+func (_m *Config) ProducerMaxDeliveryDelay() time.Duration {
+	ret := _m.Called()
+
+	var r0 time.Duration
+	if rf, ok := ret.Get(0).(func() time.Duration); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(time.Duration)
+	}
+
+	return r0
+}
+
 // ProducerPort provides a mock function with given fields:
 func (_m *Config) ProducerPort() int {
 	ret := _m.Called()
@@ -577,6 +845,104 @@ func (_m *Config) StreamBufferSize() int {
 	return r0
 }
 
+// TlsCertFile provides a mock function with given fields:
+func (_m *Config) TlsCertFile() string {
+	ret := _m.Called()
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func() string); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
+
+// TlsClientCaFile provides a mock function with given fields:
+func (_m *Config) TlsClientCaFile() string {
+	ret := _m.Called()
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func() string); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
+
+// TlsKeyFile provides a mock function with given fields:
+func (_m *Config) TlsKeyFile() string {
+	ret := _m.Called()
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func() string); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
+
+// TlsReloadInterval provides a mock function with given fields:
+func (_m *Config) TlsReloadInterval() time.Duration {
+	ret := _m.Called()
+
+	var r0 time.Duration
+	if rf, ok := ret.Get(0).(func() time.Duration); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(time.Duration)
+	}
+
+	return r0
+}
+
+// TopicAutoCreate provides a mock function with given fields:
+func (_m *Config) TopicAutoCreate() bool {
+	ret := _m.Called()
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func() bool); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	return r0
+}
+
+// TopicConsumeQuota provides a mock function with given fields:
+func (_m *Config) TopicConsumeQuota() types.Quota {
+	ret := _m.Called()
+
+	var r0 types.Quota
+	if rf, ok := ret.Get(0).(func() types.Quota); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(types.Quota)
+	}
+
+	return r0
+}
+
+// TopicProduceQuota provides a mock function with given fields:
+func (_m *Config) TopicProduceQuota() types.Quota {
+	ret := _m.Called()
+
+	var r0 types.Quota
+	if rf, ok := ret.Get(0).(func() types.Quota); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(types.Quota)
+	}
+
+	return r0
+}
+
 type mockConstructorTestingTNewConfig interface {
 	mock.TestingT
 	Cleanup(func())
@@ -3,6 +3,7 @@
 package mocks
 
 import (
+	data "github.com/polarstreams/polar/internal/data"
 	types "github.com/polarstreams/polar/internal/types"
 	mock "github.com/stretchr/testify/mock"
 )
@@ -70,11 +71,37 @@ func (_m *Datalog) ReadProducerOffset(topicId *types.TopicDataId) (int64, error)
 	return r0, r1
 }
 
+// RegisterTopicGetter provides a mock function with given fields: getter
+func (_m *Datalog) RegisterTopicGetter(getter data.TopicInfoGetter) {
+	_m.Called(getter)
+}
+
 // ReleaseStreamBuffer provides a mock function with given fields: buf
 func (_m *Datalog) ReleaseStreamBuffer(buf []byte) {
 	_m.Called(buf)
 }
 
+// OffsetByTimestamp provides a mock function with given fields: topic, timestamp
+func (_m *Datalog) OffsetByTimestamp(topic *types.TopicDataId, timestamp int64) (int64, error) {
+	ret := _m.Called(topic, timestamp)
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(*types.TopicDataId, int64) int64); ok {
+		r0 = rf(topic, timestamp)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*types.TopicDataId, int64) error); ok {
+		r1 = rf(topic, timestamp)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // SegmentFileList provides a mock function with given fields: topic, maxOffset
 func (_m *Datalog) SegmentFileList(topic *types.TopicDataId, maxOffset int64) ([]int64, error) {
 	ret := _m.Called(topic, maxOffset)
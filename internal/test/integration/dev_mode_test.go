@@ -32,7 +32,7 @@ var _ = Describe("Dev mode", func() {
 
 		client := NewTestClient(nil)
 		message := `{"hello": "world"}`
-		expectOk(client.ProduceJson(0, "abc", message, ""), "should produce json")
+		expectProduced(client.ProduceJson(0, "abc", message, ""), "should produce json")
 		client.RegisterAsConsumer(1, `{"id": "c1", "group": "g1", "topics": ["abc"]}`)
 
 		// Wait for the consumer to be considered
@@ -57,7 +57,7 @@ var _ = Describe("Dev mode", func() {
 		time.Sleep(50 * time.Millisecond)
 
 		client := NewTestClient(nil)
-		expectOk(client.ProduceJson(0, "abc", `{"hello": "world"}`, ""), "should produce json")
+		expectProduced(client.ProduceJson(0, "abc", `{"hello": "world"}`, ""), "should produce json")
 		time.Sleep(200 * time.Millisecond)
 		b0.LookForErrors(30)
 	})
@@ -73,7 +73,7 @@ var _ = Describe("Dev mode", func() {
 
 		// Produce a few messages initially
 		for i := 0; i < messagesByGroup; i++ {
-			expectOk(pClient.ProduceJson(0, topic, fmt.Sprintf(message, i), ""))
+			expectProduced(pClient.ProduceJson(0, topic, fmt.Sprintf(message, i), ""))
 		}
 
 		time.Sleep(SegmentFlushInterval * 2)
@@ -92,7 +92,7 @@ var _ = Describe("Dev mode", func() {
 
 		// Produce some more messages
 		for i := messagesByGroup; i < messagesByGroup*2; i++ {
-			expectOk(pClient.ProduceJson(0, topic, fmt.Sprintf(message, i), ""))
+			expectProduced(pClient.ProduceJson(0, topic, fmt.Sprintf(message, i), ""))
 		}
 		time.Sleep(SegmentFlushInterval * 2)
 
@@ -82,12 +82,12 @@ var _ = Describe("A 3 node cluster", func() {
 			// Test with HTTP
 			client := NewTestClient(nil)
 			resp := client.ProduceJson(0, "abc", message, "")
-			expectOk(resp)
+			expectProduced(resp)
 
 			// Use different partition keys
 			// expectResponseOk(client.ProduceJson(0, "abc", message, partitionKeyT0Range)) // B0
-			expectOk(client.ProduceJson(0, "abc", message, partitionKeyT1Range)) // Re-routed to B1
-			expectOk(client.ProduceJson(0, "abc", message, partitionKeyT2Range)) // Re-routed to B2
+			expectProduced(client.ProduceJson(0, "abc", message, partitionKeyT1Range)) // Re-routed to B1
+			expectProduced(client.ProduceJson(0, "abc", message, partitionKeyT2Range)) // Re-routed to B2
 
 			client.RegisterAsConsumer(3, `{"id": "c1", "group": "g1", "topics": ["abc"]}`)
 			log.Debug().Msgf("Registered as consumer")
@@ -131,9 +131,9 @@ var _ = Describe("A 3 node cluster", func() {
 
 			client := NewTestClient(nil)
 			// Send messages to all brokers
-			expectOk(client.ProduceJson(0, "abc", `{"hello": "world0"}`, ""))
-			expectOk(client.ProduceJson(1, "abc", `{"hello": "world1_1"}`, ""))
-			expectOk(client.ProduceJson(2, "abc", `{"hello": "world2_1"}`, ""))
+			expectProduced(client.ProduceJson(0, "abc", `{"hello": "world0"}`, ""))
+			expectProduced(client.ProduceJson(1, "abc", `{"hello": "world1_1"}`, ""))
+			expectProduced(client.ProduceJson(2, "abc", `{"hello": "world2_1"}`, ""))
 
 			log.Debug().Msgf("Consuming from B2")
 			client.RegisterAsConsumer(3, `{"id": "c1", "group": "g1", "topics": ["abc"], "onNewGroup": 1}`)
@@ -153,7 +153,7 @@ var _ = Describe("A 3 node cluster", func() {
 			time.Sleep(1 * time.Second)
 
 			// B2 should ingest data in T1-T2 range
-			expectOk(client.ProduceJson(2, "abc", `{"hello": "world1_2"}`, partitionKeyT1Range))
+			expectProduced(client.ProduceJson(2, "abc", `{"hello": "world1_2"}`, partitionKeyT1Range))
 			time.Sleep(1 * time.Second)
 
 			// Wait for the consumer to be considered
@@ -196,7 +196,7 @@ var _ = Describe("A 3 node cluster", func() {
 
 			// There should be a version 3 of T1
 			b1.WaitOutput("Committing \\[-3074457345618259968, 3074457345618255872\\] v3 with B1 as leader")
-			expectOk(client.ProduceJson(1, "abc", `{"hello": "world1_2"}`, partitionKeyT1Range))
+			expectProduced(client.ProduceJson(1, "abc", `{"hello": "world1_2"}`, partitionKeyT1Range))
 
 			for httpResult := range c {
 				if httpResult.err != nil {
@@ -222,7 +222,7 @@ var _ = Describe("A 3 node cluster", func() {
 				// Generate a message each time to make it harder to compress
 				message := fmt.Sprintf(`{"long_message": "%s", "id": %d}`, generateString(500*1024), i)
 				resp := client.ProduceJson(0, "abc", message, partitionKeyT0Range)
-				expectOk(resp)
+				expectProduced(resp)
 			}
 
 			client.RegisterAsConsumer(3, `{"id": "c1", "group": "g1", "topics": ["abc"], "onNewGroup": 1}`)
@@ -270,11 +270,11 @@ var _ = Describe("A 3 node cluster", func() {
 
 {"id": %d}`
 			// Produce messages 0..6
-			expectOk(client.ProduceNDJson(0, "abc", fmt.Sprintf(message, 0, 1, 2), partitionKeyT0Range))
-			expectOk(client.ProduceNDJson(0, "abc", fmt.Sprintf(message, 3, 4, 5), partitionKeyT0Range))
+			expectProduced(client.ProduceNDJson(0, "abc", fmt.Sprintf(message, 0, 1, 2), partitionKeyT0Range))
+			expectProduced(client.ProduceNDJson(0, "abc", fmt.Sprintf(message, 3, 4, 5), partitionKeyT0Range))
 
 			// Produce messages 6..9 via another broker into the same partition
-			expectOk(client.ProduceNDJson(1, "abc", fmt.Sprintf(message, 6, 7, 8), partitionKeyT0Range))
+			expectProduced(client.ProduceNDJson(1, "abc", fmt.Sprintf(message, 6, 7, 8), partitionKeyT0Range))
 
 			time.Sleep(SegmentFlushInterval)
 
@@ -320,8 +320,8 @@ var _ = Describe("A 3 node cluster", func() {
 				wg.Add(1)
 				go func(i int) {
 					defer wg.Done()
-					expectOk(client.ProduceNDJson(0, "topic1", fmt.Sprintf(message, i, i+1), partitionKeyT0Range))
-					expectOk(client.ProduceNDJson(0, "topic2", fmt.Sprintf(message, i, i+1), partitionKeyT0Range))
+					expectProduced(client.ProduceNDJson(0, "topic1", fmt.Sprintf(message, i, i+1), partitionKeyT0Range))
+					expectProduced(client.ProduceNDJson(0, "topic2", fmt.Sprintf(message, i, i+1), partitionKeyT0Range))
 				}(i)
 			}
 			wg.Wait()
@@ -334,7 +334,7 @@ var _ = Describe("A 3 node cluster", func() {
 			}
 
 			// Make sure is not grouped to validate single
-			expectOk(client.ProduceNDJson(0, "topic3", fmt.Sprintf(message, 100, 101), partitionKeyT0Range))
+			expectProduced(client.ProduceNDJson(0, "topic3", fmt.Sprintf(message, 100, 101), partitionKeyT0Range))
 			time.Sleep(SegmentFlushInterval * 2)
 			for i := 0; i < 5; i++ {
 				responseBodies = append(responseBodies, ReadBody(client.ConsumerPollJson(0)))
@@ -609,9 +609,9 @@ func produceOrderedJson(client *TestClient, topic string, startIndex int, totalM
 		wg.Add(1)
 		go func(i int) {
 			defer wg.Done()
-			expectOk(client.ProduceJson(0, topic, fmt.Sprintf(message, i), partitionKeyT0Range))
-			expectOk(client.ProduceJson(1, topic, fmt.Sprintf(message, i+1), partitionKeyT1Range))
-			expectOk(client.ProduceJson(2, topic, fmt.Sprintf(message, i+2), partitionKeyT2Range))
+			expectProduced(client.ProduceJson(0, topic, fmt.Sprintf(message, i), partitionKeyT0Range))
+			expectProduced(client.ProduceJson(1, topic, fmt.Sprintf(message, i+1), partitionKeyT1Range))
+			expectProduced(client.ProduceJson(2, topic, fmt.Sprintf(message, i+2), partitionKeyT2Range))
 		}(i)
 	}
 	wg.Wait()
@@ -631,6 +631,16 @@ func expectOk(resp *http.Response, description ...interface{}) {
 	Expect(ReadBody(resp)).To(Equal("OK"))
 }
 
+// Checks that the producer request succeeded and returns the location of the records
+func expectProduced(resp *http.Response, description ...interface{}) *ProduceResponse {
+	defer resp.Body.Close()
+	Expect(resp.StatusCode).To(Equal(http.StatusOK), description...)
+	var result ProduceResponse
+	Expect(json.NewDecoder(resp.Body).Decode(&result)).NotTo(HaveOccurred())
+	Expect(result.Topic).NotTo(BeEmpty())
+	return &result
+}
+
 func expectStatusOk(resp *http.Response) {
 	defer resp.Body.Close()
 	Expect(resp.StatusCode).To(Equal(http.StatusOK))
@@ -54,8 +54,8 @@ var _ = Describe("Scale down with a non-reusable cluster", func() {
 		client.RegisterAsConsumer(6, `{"id": "c1", "group": "g1", "topics": ["abc"], "onNewGroup": 1}`)
 
 		// Produced a message in gen v1
-		expectOk(client.ProduceJson(0, "abc", `{"hello": "world_before_0_0"}`, partitionKeyT0Range))
-		expectOk(client.ProduceJson(3, "abc", `{"hello": "world_before_3_0"}`, ""))
+		expectProduced(client.ProduceJson(0, "abc", `{"hello": "world_before_0_0"}`, partitionKeyT0Range))
+		expectProduced(client.ProduceJson(3, "abc", `{"hello": "world_before_3_0"}`, ""))
 
 		time.Sleep(1 * time.Second)
 		fmt.Println("------------------Updating the topology")
@@ -115,11 +115,11 @@ var _ = Describe("Scale down with a non-reusable cluster", func() {
 		b2.WaitOutput("Gossip now contains 2 clients for 2 peers")
 
 		// Produce a new message in the new generation
-		expectOk(client.ProduceJson(0, "abc", `{"hello": "world_after_0_0"}`, partitionKeyT0Range))
+		expectProduced(client.ProduceJson(0, "abc", `{"hello": "world_after_0_0"}`, partitionKeyT0Range))
 
 		// Produce more messages with different ranges on B0
 		for i := 1; i < 8; i++ {
-			expectOk(client.ProduceJson(0, "abc", fmt.Sprintf(`{"hello": "world_after_0_%d"}`, i), ""))
+			expectProduced(client.ProduceJson(0, "abc", fmt.Sprintf(`{"hello": "world_after_0_%d"}`, i), ""))
 		}
 
 		allMessages := make([]consumerResponseItem, 0)
@@ -254,9 +254,31 @@ func unmarshalResponse(header *binaryHeader, body []byte) (binaryResponse, error
 			message: message,
 		}, nil
 	}
+	if header.Op == 5 {
+		// | topic length | topic | token | range index | version | start offset |
+		topicLength := int(body[0])
+		rest := body[1+topicLength:]
+		return &binaryProduceResponse{
+			response: ProduceResponse{
+				Topic:       string(body[1 : 1+topicLength]),
+				Token:       Token(conf.Endianness.Uint64(rest)),
+				RangeIndex:  RangeIndex(rest[8]),
+				Version:     GenVersion(conf.Endianness.Uint32(rest[9:])),
+				StartOffset: int64(conf.Endianness.Uint64(rest[13:])),
+			},
+		}, nil
+	}
 	return nil, fmt.Errorf("Unsupported op %d", header.Op)
 }
 
+type binaryProduceResponse struct {
+	response ProduceResponse
+}
+
+func (r *binaryProduceResponse) Op() uint8 {
+	return 5 // produceResponseOp
+}
+
 type binaryEmptyResponse struct {
 	op uint8
 }
@@ -5,12 +5,16 @@ package mocks
 import (
 	io "io"
 
+	http "net/http"
+
 	interbroker "github.com/polarstreams/polar/internal/interbroker"
 
 	mock "github.com/stretchr/testify/mock"
 
 	types "github.com/polarstreams/polar/internal/types"
 
+	time "time"
+
 	url "net/url"
 
 	uuid "github.com/google/uuid"
@@ -199,6 +203,98 @@ func (_m *Gossiper) ReadProducerOffset(ordinal int, topic *types.TopicDataId) (i
 	return r0, r1
 }
 
+// ReadProducerSequences provides a mock function with given fields: ordinal, topic, since
+func (_m *Gossiper) ReadProducerSequences(ordinal int, topic *types.TopicDataId, since time.Time) ([]types.ProducerSequenceInfo, error) {
+	ret := _m.Called(ordinal, topic, since)
+
+	var r0 []types.ProducerSequenceInfo
+	if rf, ok := ret.Get(0).(func(int, *types.TopicDataId, time.Time) []types.ProducerSequenceInfo); ok {
+		r0 = rf(ordinal, topic, since)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]types.ProducerSequenceInfo)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(int, *types.TopicDataId, time.Time) error); ok {
+		r1 = rf(ordinal, topic, since)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ReadConsumerLag provides a mock function with given fields: ordinal, group
+func (_m *Gossiper) ReadConsumerLag(ordinal int, group string) ([]types.ConsumerLag, error) {
+	ret := _m.Called(ordinal, group)
+
+	var r0 []types.ConsumerLag
+	if rf, ok := ret.Get(0).(func(int, string) []types.ConsumerLag); ok {
+		r0 = rf(ordinal, group)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]types.ConsumerLag)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(int, string) error); ok {
+		r1 = rf(ordinal, group)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ReadConsumerGroups provides a mock function with given fields: ordinal
+func (_m *Gossiper) ReadConsumerGroups(ordinal int) ([]types.ConsumerGroupInfo, error) {
+	ret := _m.Called(ordinal)
+
+	var r0 []types.ConsumerGroupInfo
+	if rf, ok := ret.Get(0).(func(int) []types.ConsumerGroupInfo); ok {
+		r0 = rf(ordinal)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]types.ConsumerGroupInfo)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(int) error); ok {
+		r1 = rf(ordinal)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ReadDeadLetterStats provides a mock function with given fields: ordinal, topic
+func (_m *Gossiper) ReadDeadLetterStats(ordinal int, topic string) (*types.DeadLetterStats, error) {
+	ret := _m.Called(ordinal, topic)
+
+	var r0 *types.DeadLetterStats
+	if rf, ok := ret.Get(0).(func(int, string) *types.DeadLetterStats); ok {
+		r0 = rf(ordinal, topic)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*types.DeadLetterStats)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(int, string) error); ok {
+		r1 = rf(ordinal, topic)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // ReadTokenHistory provides a mock function with given fields: ordinal, token, clusterSize
 func (_m *Gossiper) ReadTokenHistory(ordinal int, token types.Token, clusterSize int) (*types.Generation, error) {
 	ret := _m.Called(ordinal, token, clusterSize)
@@ -222,6 +318,11 @@ func (_m *Gossiper) ReadTokenHistory(ordinal int, token types.Token, clusterSize
 	return r0, r1
 }
 
+// RegisterAclListener provides a mock function with given fields: listener
+func (_m *Gossiper) RegisterAclListener(listener interbroker.AclListener) {
+	_m.Called(listener)
+}
+
 // RegisterConsumerInfoListener provides a mock function with given fields: listener
 func (_m *Gossiper) RegisterConsumerInfoListener(listener interbroker.ConsumerInfoListener) {
 	_m.Called(listener)
@@ -242,6 +343,35 @@ func (_m *Gossiper) RegisterReroutedMessageListener(listener interbroker.Rerouti
 	_m.Called(listener)
 }
 
+// RegisterTopicInfoListener provides a mock function with given fields: listener
+func (_m *Gossiper) RegisterTopicInfoListener(listener interbroker.TopicInfoListener) {
+	_m.Called(listener)
+}
+
+// RegisterDeadLetterStatsListener provides a mock function with given fields: listener
+func (_m *Gossiper) RegisterDeadLetterStatsListener(listener interbroker.DeadLetterStatsListener) {
+	_m.Called(listener)
+}
+
+// RegisterScheduledRecordListener provides a mock function with given fields: listener
+func (_m *Gossiper) RegisterScheduledRecordListener(listener interbroker.ScheduledRecordListener) {
+	_m.Called(listener)
+}
+
+// SendAcls provides a mock function with given fields: ordinal, rules
+func (_m *Gossiper) SendAcls(ordinal int, rules []types.AclRule) error {
+	ret := _m.Called(ordinal, rules)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(int, []types.AclRule) error); ok {
+		r0 = rf(ordinal, rules)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // SendCommittedOffset provides a mock function with given fields: ordinal, offsetKv
 func (_m *Gossiper) SendCommittedOffset(ordinal int, offsetKv *types.OffsetStoreKeyValue) error {
 	ret := _m.Called(ordinal, offsetKv)
@@ -284,13 +414,83 @@ func (_m *Gossiper) SendConsumerGroups(ordinal int, groups []types.ConsumerGroup
 	return r0
 }
 
-// SendConsumerRegister provides a mock function with given fields: ordinal, id, group, topics, onNewGroup
-func (_m *Gossiper) SendConsumerRegister(ordinal int, id string, group string, topics []string, onNewGroup types.OffsetResetPolicy) error {
-	ret := _m.Called(ordinal, id, group, topics, onNewGroup)
+// SendConsumerAck provides a mock function with given fields: ordinal, id, acks, nack
+func (_m *Gossiper) SendConsumerAck(ordinal int, id string, acks []types.RecordAck, nack bool) error {
+	ret := _m.Called(ordinal, id, acks, nack)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(int, string, []types.RecordAck, bool) error); ok {
+		r0 = rf(ordinal, id, acks, nack)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SendConsumerGroupClone provides a mock function with given fields: ordinal, group, target, topic
+func (_m *Gossiper) SendConsumerGroupClone(ordinal int, group string, target string, topic string) error {
+	ret := _m.Called(ordinal, group, target, topic)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(int, string, string, string) error); ok {
+		r0 = rf(ordinal, group, target, topic)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SendConsumerGroupDelete provides a mock function with given fields: ordinal, group
+func (_m *Gossiper) SendConsumerGroupDelete(ordinal int, group string) error {
+	ret := _m.Called(ordinal, group)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(int, string) error); ok {
+		r0 = rf(ordinal, group)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SendConsumerOffsetCommit provides a mock function with given fields: ordinal, id, offsets
+func (_m *Gossiper) SendConsumerOffsetCommit(ordinal int, id string, offsets []types.OffsetCommit) error {
+	ret := _m.Called(ordinal, id, offsets)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(int, string, []types.OffsetCommit) error); ok {
+		r0 = rf(ordinal, id, offsets)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SendConsumerRegister provides a mock function with given fields: ordinal, id, group, topics, onNewGroup, filter, assignment, ackSettings, recordFormat
+func (_m *Gossiper) SendConsumerRegister(ordinal int, id string, group string, topics []string, onNewGroup types.OffsetResetPolicy, filter string, assignment *types.ManualAssignment, ackSettings types.AckSettings, recordFormat byte) error {
+	ret := _m.Called(ordinal, id, group, topics, onNewGroup, filter, assignment, ackSettings, recordFormat)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(int, string, string, []string, types.OffsetResetPolicy, string, *types.ManualAssignment, types.AckSettings, byte) error); ok {
+		r0 = rf(ordinal, id, group, topics, onNewGroup, filter, assignment, ackSettings, recordFormat)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SendConsumerSeek provides a mock function with given fields: ordinal, group, topic, target
+func (_m *Gossiper) SendConsumerSeek(ordinal int, group string, topic string, target *types.SeekTarget) error {
+	ret := _m.Called(ordinal, group, topic, target)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(int, string, string, []string, types.OffsetResetPolicy) error); ok {
-		r0 = rf(ordinal, id, group, topics, onNewGroup)
+	if rf, ok := ret.Get(0).(func(int, string, string, *types.SeekTarget) error); ok {
+		r0 = rf(ordinal, group, topic, target)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -317,6 +517,34 @@ func (_m *Gossiper) SendGoobye() {
 	_m.Called()
 }
 
+// SendScheduledDelete provides a mock function with given fields: ordinal, id
+func (_m *Gossiper) SendScheduledDelete(ordinal int, id string) error {
+	ret := _m.Called(ordinal, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(int, string) error); ok {
+		r0 = rf(ordinal, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SendScheduledRecord provides a mock function with given fields: ordinal, record
+func (_m *Gossiper) SendScheduledRecord(ordinal int, record *types.ScheduledRecord) error {
+	ret := _m.Called(ordinal, record)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(int, *types.ScheduledRecord) error); ok {
+		r0 = rf(ordinal, record)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // SendToFollowers provides a mock function with given fields: replicationInfo, topic, segmentId, chunk
 func (_m *Gossiper) SendToFollowers(replicationInfo types.ReplicationInfo, topic types.TopicDataId, segmentId int64, chunk types.SegmentChunk) error {
 	ret := _m.Called(replicationInfo, topic, segmentId, chunk)
@@ -331,13 +559,36 @@ func (_m *Gossiper) SendToFollowers(replicationInfo types.ReplicationInfo, topic
 	return r0
 }
 
-// SendToLeader provides a mock function with given fields: replicationInfo, topic, querystring, contentLength, contentType, body
-func (_m *Gossiper) SendToLeader(replicationInfo types.ReplicationInfo, topic string, querystring url.Values, contentLength int64, contentType string, body io.Reader) error {
-	ret := _m.Called(replicationInfo, topic, querystring, contentLength, contentType, body)
+// SendToLeader provides a mock function with given fields: replicationInfo, topic, principal, querystring, contentLength, contentType, recordHeaders, body
+func (_m *Gossiper) SendToLeader(replicationInfo types.ReplicationInfo, topic string, principal string, querystring url.Values, contentLength int64, contentType string, recordHeaders http.Header, body io.Reader) (*types.ProduceResponse, error) {
+	ret := _m.Called(replicationInfo, topic, principal, querystring, contentLength, contentType, recordHeaders, body)
+
+	var r0 *types.ProduceResponse
+	if rf, ok := ret.Get(0).(func(types.ReplicationInfo, string, string, url.Values, int64, string, http.Header, io.Reader) *types.ProduceResponse); ok {
+		r0 = rf(replicationInfo, topic, principal, querystring, contentLength, contentType, recordHeaders, body)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*types.ProduceResponse)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(types.ReplicationInfo, string, string, url.Values, int64, string, http.Header, io.Reader) error); ok {
+		r1 = rf(replicationInfo, topic, principal, querystring, contentLength, contentType, recordHeaders, body)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SendTopics provides a mock function with given fields: ordinal, topics
+func (_m *Gossiper) SendTopics(ordinal int, topics []types.TopicInfo) error {
+	ret := _m.Called(ordinal, topics)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(types.ReplicationInfo, string, url.Values, int64, string, io.Reader) error); ok {
-		r0 = rf(replicationInfo, topic, querystring, contentLength, contentType, body)
+	if rf, ok := ret.Get(0).(func(int, []types.TopicInfo) error); ok {
+		r0 = rf(ordinal, topics)
 	} else {
 		r0 = ret.Error(0)
 	}
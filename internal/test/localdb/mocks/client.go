@@ -12,6 +12,29 @@ type Client struct {
 	mock.Mock
 }
 
+// Acls provides a mock function with given fields:
+func (_m *Client) Acls() ([]types.AclRule, error) {
+	ret := _m.Called()
+
+	var r0 []types.AclRule
+	if rf, ok := ret.Get(0).(func() []types.AclRule); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]types.AclRule)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Close provides a mock function with given fields:
 func (_m *Client) Close() {
 	_m.Called()
@@ -170,6 +193,57 @@ func (_m *Client) MarkAsShuttingDown() {
 	_m.Called()
 }
 
+// DeleteOffsets provides a mock function with given fields: group
+func (_m *Client) DeleteOffsets(group string) error {
+	ret := _m.Called(group)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(group)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteScheduledRecord provides a mock function with given fields: id
+func (_m *Client) DeleteScheduledRecord(id string) error {
+	ret := _m.Called(id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DueScheduledRecords provides a mock function with given fields: deliverAt, after, limit
+func (_m *Client) DueScheduledRecords(deliverAt int64, after *types.ScheduledRecord, limit int) ([]types.ScheduledRecord, error) {
+	ret := _m.Called(deliverAt, after, limit)
+
+	var r0 []types.ScheduledRecord
+	if rf, ok := ret.Get(0).(func(int64, *types.ScheduledRecord, int) []types.ScheduledRecord); ok {
+		r0 = rf(deliverAt, after, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]types.ScheduledRecord)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(int64, *types.ScheduledRecord, int) error); ok {
+		r1 = rf(deliverAt, after, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Offsets provides a mock function with given fields:
 func (_m *Client) Offsets() ([]types.OffsetStoreKeyValue, error) {
 	ret := _m.Called()
@@ -193,6 +267,20 @@ func (_m *Client) Offsets() ([]types.OffsetStoreKeyValue, error) {
 	return r0, r1
 }
 
+// SaveAcl provides a mock function with given fields: rule
+func (_m *Client) SaveAcl(rule *types.AclRule) error {
+	ret := _m.Called(rule)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*types.AclRule) error); ok {
+		r0 = rf(rule)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // SaveOffset provides a mock function with given fields: offsetKv
 func (_m *Client) SaveOffset(offsetKv *types.OffsetStoreKeyValue) error {
 	ret := _m.Called(offsetKv)
@@ -207,6 +295,57 @@ func (_m *Client) SaveOffset(offsetKv *types.OffsetStoreKeyValue) error {
 	return r0
 }
 
+// SaveScheduledRecord provides a mock function with given fields: record
+func (_m *Client) SaveScheduledRecord(record *types.ScheduledRecord) error {
+	ret := _m.Called(record)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*types.ScheduledRecord) error); ok {
+		r0 = rf(record)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SaveTopic provides a mock function with given fields: topic
+func (_m *Client) SaveTopic(topic *types.TopicInfo) error {
+	ret := _m.Called(topic)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*types.TopicInfo) error); ok {
+		r0 = rf(topic)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Topics provides a mock function with given fields:
+func (_m *Client) Topics() ([]types.TopicInfo, error) {
+	ret := _m.Called()
+
+	var r0 []types.TopicInfo
+	if rf, ok := ret.Get(0).(func() []types.TopicInfo); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]types.TopicInfo)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 type mockConstructorTestingTNewClient interface {
 	mock.TestingT
 	Cleanup(func())
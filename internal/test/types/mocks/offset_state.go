@@ -51,6 +51,38 @@ func (_m *OffsetState) GetAllWithDefaults(group string, topic string, token type
 	return r0
 }
 
+// GetDefaults provides a mock function with given fields: topic, token, rangeIndex, clusterSize, policy
+func (_m *OffsetState) GetDefaults(topic string, token types.Token, rangeIndex types.RangeIndex, clusterSize int, policy types.OffsetResetPolicy) []types.Offset {
+	ret := _m.Called(topic, token, rangeIndex, clusterSize, policy)
+
+	var r0 []types.Offset
+	if rf, ok := ret.Get(0).(func(string, types.Token, types.RangeIndex, int, types.OffsetResetPolicy) []types.Offset); ok {
+		r0 = rf(topic, token, rangeIndex, clusterSize, policy)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]types.Offset)
+		}
+	}
+
+	return r0
+}
+
+// Keys provides a mock function with given fields:
+func (_m *OffsetState) Keys() []types.OffsetStoreKey {
+	ret := _m.Called()
+
+	var r0 []types.OffsetStoreKey
+	if rf, ok := ret.Get(0).(func() []types.OffsetStoreKey); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]types.OffsetStoreKey)
+		}
+	}
+
+	return r0
+}
+
 // Init provides a mock function with given fields:
 func (_m *OffsetState) Init() error {
 	ret := _m.Called()
@@ -86,6 +118,20 @@ func (_m *OffsetState) MaxProducedOffset(topicId *types.TopicDataId) (int64, err
 	return r0, r1
 }
 
+// RemoveGroup provides a mock function with given fields: group
+func (_m *OffsetState) RemoveGroup(group string) error {
+	ret := _m.Called(group)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(group)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Set provides a mock function with given fields: group, topic, value, commit
 func (_m *OffsetState) Set(group string, topic string, value types.Offset, commit types.OffsetCommitType) bool {
 	ret := _m.Called(group, topic, value, commit)
@@ -100,6 +146,22 @@ func (_m *OffsetState) Set(group string, topic string, value types.Offset, commi
 	return r0
 }
 
+// Values provides a mock function with given fields: group, topic
+func (_m *OffsetState) Values(group string, topic string) []types.Offset {
+	ret := _m.Called(group, topic)
+
+	var r0 []types.Offset
+	if rf, ok := ret.Get(0).(func(string, string) []types.Offset); ok {
+		r0 = rf(group, topic)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]types.Offset)
+		}
+	}
+
+	return r0
+}
+
 // String provides a mock function with given fields:
 func (_m *OffsetState) String() string {
 	ret := _m.Called()
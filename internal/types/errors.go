@@ -1,6 +1,10 @@
 package types
 
-import "fmt"
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
 
 // Peer processed the GET request, but didn't found the information that was looking for
 var GossipGetNotFound = fmt.Errorf("Information not found")
@@ -10,6 +14,13 @@ type HttpError interface {
 	StatusCode() int
 }
 
+// Represents an error caused by a client exceeding a quota, the client can retry after the delay
+type ThrottledError interface {
+	HttpError
+
+	RetryAfter() time.Duration
+}
+
 // Represents an error while producing that we are certain it caused side effect in the data store.
 type ProducingError interface {
 	error
@@ -25,6 +36,13 @@ func NewHttpErrorf(code int, message string, a ...interface{}) HttpError {
 	return &httpError{code, fmt.Sprintf(message, a...)}
 }
 
+func NewThrottledError(retryAfter time.Duration, message string, a ...interface{}) ThrottledError {
+	return &throttledError{
+		httpError:  httpError{http.StatusTooManyRequests, fmt.Sprintf(message, a...)},
+		retryAfter: retryAfter,
+	}
+}
+
 func NewNoWriteAttemptedError(message string, a ...interface{}) ProducingError {
 	return &producingError{
 		message:           fmt.Sprintf(message, a...),
@@ -45,6 +63,15 @@ func (e *httpError) StatusCode() int {
 	return e.code
 }
 
+type throttledError struct {
+	httpError
+	retryAfter time.Duration
+}
+
+func (e *throttledError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
 type producingError struct {
 	message           string
 	wasWriteAttempted bool
@@ -1,6 +1,9 @@
 package types
 
-import "io"
+import (
+	"io"
+	"net/http"
+)
 
 type Initializer interface {
 	Init() error
@@ -19,3 +22,13 @@ type BufferBackedWriter interface {
 	// The slice aliases the buffer content at least until the next buffer modification.
 	Bytes() []byte
 }
+
+// Appends a record to the log of a topic, routing it to the leader of the partition when necessary
+type RecordProducer interface {
+	ProduceRecord(
+		topic string,
+		partitionKey string,
+		contentType string,
+		recordHeaders http.Header,
+		body []byte) (*ProduceResponse, error)
+}
@@ -2,6 +2,7 @@ package types
 
 import (
 	"fmt"
+	"net/http"
 
 	"github.com/rs/zerolog/log"
 )
@@ -13,6 +14,7 @@ const (
 	MIMETypeNDJSON         = "application/x-ndjson"
 	MIMETypeProducerBinary = "application/vnd.polar.producer.frames" // {uint32_length}{bytes}{uint32_length}{bytes}...
 	ContentTypeHeaderKey   = "Content-Type"
+	RecordHeaderPrefix     = "X-Polar-Header-" // The prefix of the HTTP headers that are stored along with the record
 )
 
 // BrokerInfo contains information about a broker
@@ -38,16 +40,78 @@ type ConsumerGroup struct {
 	OnNewGroup OffsetResetPolicy `json:"onNewGroup"`
 }
 
+// AckSettings represents the settings of consumers that acknowledge each record
+type AckSettings struct {
+	AckMode         bool   `json:"ackMode,omitempty"`
+	DeadLetterTopic string `json:"deadLetterTopic,omitempty"` // The topic of the records negatively acknowledged too many times
+}
+
+// RecordAck represents the offsets of records of a topic token range acknowledged by a consumer in ack mode
+type RecordAck struct {
+	Topic      string     `json:"topic"`
+	Token      Token      `json:"token,string"`
+	RangeIndex RangeIndex `json:"rangeIndex"`
+	Version    GenVersion `json:"version"`
+	Offsets    []int64    `json:"offsets"`
+}
+
+func (a *RecordAck) TopicId() TopicDataId {
+	return TopicDataId{Name: a.Topic, Token: a.Token, RangeIndex: a.RangeIndex, Version: a.Version}
+}
+
+// Represents the token ranges explicitly assigned to a consumer, which doesn't participate in the rebalancing of the
+// consumer group
+type ManualAssignment struct {
+	Owned  bool            `json:"owned,omitempty"` // Determines whether all the token ranges led by the broker are read
+	Ranges []AssignedRange `json:"ranges,omitempty"`
+}
+
+type AssignedRange struct {
+	Token      Token      `json:"token,string"`
+	RangeIndex RangeIndex `json:"rangeIndex"`
+}
+
+// Represents the offset explicitly committed by a consumer with manually assigned token ranges: the offset of the next
+// record to consume
+type OffsetCommit struct {
+	Topic      string     `json:"topic"`
+	Token      Token      `json:"token,string"`
+	RangeIndex RangeIndex `json:"rangeIndex"`
+	Version    GenVersion `json:"version"`
+	Offset     int64      `json:"offset,string"`
+}
+
+func (c *OffsetCommit) TopicId() TopicDataId {
+	return TopicDataId{Name: c.Topic, Token: c.Token, RangeIndex: c.RangeIndex, Version: c.Version}
+}
+
+// ConsumerGroupInfo represents the view of a consumer group exposed by the admin API.
+// The members are only set when describing a group.
+type ConsumerGroupInfo struct {
+	Name    string                `json:"name"`
+	Topics  []string              `json:"topics"`
+	Members []ConsumerGroupMember `json:"members,omitempty"`
+}
+
+// ConsumerGroupMember represents a consumer instance of a group and the token ranges assigned to it
+type ConsumerGroupMember struct {
+	Id          string               `json:"id"`
+	Assignments []ConsumerAssignment `json:"assignments"`
+}
+
+// ConsumerAssignment represents the range indices of a token assigned to a consumer
+type ConsumerAssignment struct {
+	Token        Token `json:"token,string"`
+	ClusterSize  int   `json:"clusterSize"`
+	RangeIndices []int `json:"rangeIndices"` // Plain integers to avoid byte slice encoding
+}
+
 // BrokerIndex represents the position of a broker in the current broker list.
 // It's exposed as different type to avoid mixing it up w/ Ordinal (replica number)
 //
 // e.g. in a cluster composed of {0, 3, 1, 4, 2, 3}, the index of 3 is 1.
 type BrokerIndex int
 
-type TopicInfo struct {
-	Name string
-}
-
 type ReplicationInfo struct {
 	Leader     *BrokerInfo // Determines the leader of the replication plan, it can be nil when not determined
 	Followers  []BrokerInfo
@@ -69,6 +133,7 @@ type SegmentChunk interface {
 	DataBlock() []byte
 	StartOffset() int64
 	RecordLength() uint32
+	MaxTimestamp() int64 // The max timestamp of the records in unix micros, zero when unknown
 }
 
 // TopologyInfo represents a snapshot of the current placement of the brokers
@@ -254,6 +319,64 @@ type TopicDataId struct {
 	Version    GenVersion `json:"version"`
 }
 
+// ProduceResponse contains the location in the log of the records of a producer request
+type ProduceResponse struct {
+	Topic       string     `json:"topic"`
+	Token       Token      `json:"token,string"` // Use strings for int64 values
+	RangeIndex  RangeIndex `json:"rangeIndex"`
+	Version     GenVersion `json:"version"`
+	StartOffset int64      `json:"startOffset,string"`    // The offset of the first record of the request
+	ScheduledId string     `json:"scheduledId,omitempty"` // The id of the record when the delivery was scheduled
+	DeliverAt   int64      `json:"deliverAt,omitempty"`   // The unix time in milliseconds of the scheduled delivery
+
+	DeadLetterReason string `json:"deadLetterReason,omitempty"` // The reason the records were routed to the dead-letter topic
+}
+
+// ProducerSequenceInfo represents a request of an idempotent producer stored in a generation
+type ProducerSequenceInfo struct {
+	ProducerId string `json:"producerId"`
+	Sequence   int64  `json:"sequence"`
+	Offset     int64  `json:"offset,string"` // The offset of the first record of the request
+}
+
+// DeadLetterStats represents the amount of records rejected by the producer for a topic since the brokers started
+type DeadLetterStats struct {
+	Topic           string           `json:"topic"`
+	DeadLetterTopic string           `json:"deadLetterTopic"`
+	Records         int64            `json:"records"`           // The amount of records routed to the dead-letter topic
+	Bytes           int64            `json:"bytes"`             // The amount of body bytes routed to the dead-letter topic
+	Reasons         map[string]int64 `json:"reasons,omitempty"` // The amount of records routed by reason
+	Failed          int64            `json:"failed"`            // The amount of rejected records that could not be routed
+}
+
+// Adds the values of the other stats to this instance
+func (s *DeadLetterStats) Add(other *DeadLetterStats) {
+	s.Records += other.Records
+	s.Bytes += other.Bytes
+	s.Failed += other.Failed
+	for reason, value := range other.Reasons {
+		if s.Reasons == nil {
+			s.Reasons = make(map[string]int64)
+		}
+		s.Reasons[reason] += value
+	}
+}
+
+// ScheduledRecord represents a record that is stored by the leader and the followers of the token until the delivery
+// time, when it's appended to the topic.
+type ScheduledRecord struct {
+	Id           string      `json:"id"`
+	Topic        string      `json:"topic"`
+	Token        Token       `json:"token,string"`
+	RangeIndex   RangeIndex  `json:"rangeIndex"`
+	PartitionKey string      `json:"partitionKey,omitempty"`
+	DeliverAt    int64       `json:"deliverAt"`     // Unix time in milliseconds
+	Ttl          int64       `json:"ttl,omitempty"` // The time to live of the record in milliseconds
+	ContentType  string      `json:"contentType,omitempty"`
+	Headers      http.Header `json:"headers,omitempty"`
+	Body         []byte      `json:"body,omitempty"`
+}
+
 func (t *TopicDataId) String() string {
 	return fmt.Sprintf("'%s' %d/%d v%d", t.Name, t.Token, t.RangeIndex, t.Version)
 }
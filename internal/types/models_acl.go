@@ -0,0 +1,53 @@
+package types
+
+import "strings"
+
+// ACL operations
+const (
+	AclOperationProduce = "produce"
+	AclOperationConsume = "consume"
+)
+
+// The wildcard matching any principal, topic or group
+const AclWildcard = "*"
+
+// The principal of the records produced by the brokers themselves, like the dead-letter and scheduled records.
+//
+// Principals starting with '$' are reserved and rejected by the authenticator.
+const SystemPrincipal = "$system"
+
+// Represents a rule granting a principal the permission to perform an operation on the topics matching a pattern,
+// as stored and replicated by the brokers.
+type AclRule struct {
+	Id        string `json:"id"`
+	Principal string `json:"principal"`         // The name of the principal or "*" for any authenticated principal
+	Operation string `json:"operation"`         // "produce" or "consume"
+	Topic     string `json:"topic"`             // The topic name or a prefix followed by "*", e.g. "orders.*"
+	Group     string `json:"group,omitempty"`   // The consumer group name or pattern, empty for any group
+	Timestamp int64  `json:"timestamp"`         // The unix micros timestamp of the last modification
+	Deleted   bool   `json:"deleted,omitempty"` // Determines whether the rule was deleted (tombstone)
+}
+
+// Determines whether the rule grants the principal the permission to perform the operation on the topic and group
+func (r *AclRule) Allows(principal string, operation string, topic string, group string) bool {
+	return !r.Deleted &&
+		r.Operation == operation &&
+		(r.Principal == AclWildcard || r.Principal == principal) &&
+		aclPatternMatches(r.Topic, topic) &&
+		(r.Group == "" || operation != AclOperationConsume || aclPatternMatches(r.Group, group))
+}
+
+// Determines whether the rule has the same principal, operation and patterns as the other one
+func (r *AclRule) SameAs(other *AclRule) bool {
+	return r.Principal == other.Principal &&
+		r.Operation == other.Operation &&
+		r.Topic == other.Topic &&
+		r.Group == other.Group
+}
+
+func aclPatternMatches(pattern string, value string) bool {
+	if strings.HasSuffix(pattern, AclWildcard) {
+		return strings.HasPrefix(value, pattern[:len(pattern)-len(AclWildcard)])
+	}
+	return pattern == value
+}
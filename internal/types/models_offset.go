@@ -17,8 +17,9 @@ const (
 const OffsetCompleted = math.MaxInt64
 
 type OffsetSource struct {
-	Id        GenId `json:"id"` // Gen id of the source
-	Timestamp int64 `json:"ts"` // Timestamp in Unix Micros
+	Id        GenId `json:"id"`             // Gen id of the source
+	Timestamp int64 `json:"ts"`             // Timestamp in Unix Micros
+	Seek      bool  `json:"seek,omitempty"` // Determines whether the offset was explicitly moved by an operator
 }
 
 func NewOffsetSource(id GenId) OffsetSource {
@@ -110,6 +111,30 @@ func ParseOffsetResetPolicy(text string) (OffsetResetPolicy, error) {
 	return 0, fmt.Errorf("Invalid offset reset policy string value")
 }
 
+type SeekPosition string
+
+const (
+	SeekEarliest  SeekPosition = "earliest"
+	SeekLatest    SeekPosition = "latest"
+	SeekTimestamp SeekPosition = "timestamp"
+	SeekOffsets   SeekPosition = "offsets"
+)
+
+// Represents the position to move a consumer group to on a topic
+type SeekTarget struct {
+	Position  SeekPosition `json:"position"`
+	Timestamp *time.Time   `json:"timestamp,omitempty"` // The point in time to move to when seeking by timestamp
+	Offsets   []SeekOffset `json:"offsets,omitempty"`   // The explicit offsets when seeking by offsets
+}
+
+// Represents an explicit offset value for a token range
+type SeekOffset struct {
+	Token      Token      `json:"token,string"` // Use strings for int64 values
+	RangeIndex RangeIndex `json:"rangeIndex"`
+	Version    GenVersion `json:"version"`
+	Offset     int64      `json:"offset,string"`
+}
+
 func (p OffsetResetPolicy) String() string {
 	switch p {
 	case StartFromLatest:
@@ -151,4 +176,28 @@ type OffsetState interface {
 	// When it can not be found, it returns a negative value.
 	// When there's an unexpected  error on local and peers, it returns an error
 	MaxProducedOffset(topicId *TopicDataId) (int64, error)
+
+	// Gets the default offset values in order for a given range, ignoring the offsets stored for the consumer groups.
+	GetDefaults(topic string, token Token, rangeIndex RangeIndex, clusterSize int, policy OffsetResetPolicy) []Offset
+
+	// Gets the group and topic pairs that have stored offsets
+	Keys() []OffsetStoreKey
+
+	// Removes the offsets of the group for all the topics from memory and from the local storage
+	RemoveGroup(group string) error
+
+	// Gets the distinct offset values stored for the group and topic, in the order they were recorded
+	Values(group string, topic string) []Offset
+}
+
+// Represents the amount of records of a token range that were not consumed by a group
+type ConsumerLag struct {
+	Group       string     `json:"group"`
+	Topic       string     `json:"topic"`
+	Token       Token      `json:"token,string"`
+	RangeIndex  RangeIndex `json:"rangeIndex"`
+	Version     GenVersion `json:"version"`
+	Offset      int64      `json:"offset,string"`      // The next offset to be consumed by the group
+	MaxProduced int64      `json:"maxProduced,string"` // The offset of the last produced record
+	Lag         int64      `json:"lag"`
 }
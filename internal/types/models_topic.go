@@ -0,0 +1,141 @@
+package types
+
+import "time"
+
+// Topic modes
+const (
+	TopicModeDefault   = ""          // Append-only log
+	TopicModeCompacted = "compacted" // Only the latest record per key is retained
+)
+
+// The suffix of the name of the topic where the records rejected by the producer are routed to
+const DeadLetterTopicSuffix = ".dlq"
+
+// Represents the metadata of a topic, as stored and replicated by the brokers.
+type TopicInfo struct {
+	Name      string        `json:"name"`
+	Timestamp int64         `json:"timestamp"`         // The unix micros timestamp of the last modification
+	Deleted   bool          `json:"deleted,omitempty"` // Determines whether the topic was deleted (tombstone)
+	Settings  TopicSettings `json:"settings"`
+}
+
+// Represents the topic-level overrides of the broker settings, zero values fall back to the broker defaults.
+type TopicSettings struct {
+	Mode           string `json:"mode,omitempty"`           // The topic mode, it can not be changed after creation
+	Retention      string `json:"retention,omitempty"`      // Go duration format (e.g. "2160h") or "null" to keep the data forever
+	RetentionBytes int64  `json:"retentionBytes,omitempty"` // Maximum size in bytes of the topic data in a broker
+	MaxMessageSize int    `json:"maxMessageSize,omitempty"` // Maximum size in bytes of a producer message
+	MaxGroupSize   int    `json:"maxGroupSize,omitempty"`   // Maximum size in bytes of an uncompressed group of messages
+	Ttl            string `json:"ttl,omitempty"`            // Go duration format (e.g. "5m"), records are not served after it
+	DeadLetter     bool   `json:"deadLetter,omitempty"`     // Route the records rejected by the producer to the dead-letter topic
+	ProduceQuota   *Quota `json:"produceQuota,omitempty"`   // The maximum rates of the producers of the topic in a broker
+	ConsumeQuota   *Quota `json:"consumeQuota,omitempty"`   // The maximum rates of the consumers of the topic in a broker
+}
+
+// Represents the maximum rates of a client or a topic in a broker, zero values are not limited.
+type Quota struct {
+	BytesPerSecond    int64 `json:"bytesPerSecond,omitempty"`
+	RequestsPerSecond int64 `json:"requestsPerSecond,omitempty"`
+}
+
+// Determines whether any of the rates is limited
+func (q Quota) IsLimited() bool {
+	return q.BytesPerSecond > 0 || q.RequestsPerSecond > 0
+}
+
+// Determines whether the records are stored with the key and compacted in the background
+func (t *TopicInfo) IsCompacted() bool {
+	return t != nil && t.Settings.Mode == TopicModeCompacted
+}
+
+// Gets the amount of time to keep a log file before deleting it, falling back to the provided default.
+// It returns nil when the data should be kept forever.
+func (t *TopicInfo) Retention(defaultValue *time.Duration) *time.Duration {
+	if t == nil || t.Settings.Retention == "" {
+		return defaultValue
+	}
+	if t.Settings.Retention == "null" {
+		return nil
+	}
+	value, err := time.ParseDuration(t.Settings.Retention)
+	if err != nil {
+		return defaultValue
+	}
+	return &value
+}
+
+// Gets the maximum amount of bytes of the topic data to keep in a broker, falling back to the provided default.
+func (t *TopicInfo) RetentionBytes(defaultValue int64) int64 {
+	if t == nil || t.Settings.RetentionBytes <= 0 {
+		return defaultValue
+	}
+	return t.Settings.RetentionBytes
+}
+
+// Gets the amount of time after the record timestamp in which the records are served to consumers, zero when the
+// records don't expire.
+func (t *TopicInfo) Ttl() time.Duration {
+	if t == nil || t.Settings.Ttl == "" {
+		return 0
+	}
+	value, err := time.ParseDuration(t.Settings.Ttl)
+	if err != nil || value < 0 {
+		return 0
+	}
+	return value
+}
+
+// Gets the maximum size of a producer message, falling back to the provided default.
+func (t *TopicInfo) MaxMessageSize(defaultValue int) int {
+	if t == nil || t.Settings.MaxMessageSize <= 0 {
+		return defaultValue
+	}
+	return t.Settings.MaxMessageSize
+}
+
+// Gets the maximum size of an uncompressed group of messages, falling back to the provided default.
+func (t *TopicInfo) MaxGroupSize(defaultValue int) int {
+	if t == nil || t.Settings.MaxGroupSize <= 0 {
+		return defaultValue
+	}
+	return t.Settings.MaxGroupSize
+}
+
+// Gets the name of the topic where the records rejected by the producer are routed to, empty when the topic doesn't
+// define a dead-letter policy.
+func (t *TopicInfo) DeadLetterTopic() string {
+	if t == nil || !t.Settings.DeadLetter {
+		return ""
+	}
+	return t.Name + DeadLetterTopicSuffix
+}
+
+// Gets the maximum rates of the producers of the topic, falling back to the provided default for each rate not set.
+func (t *TopicInfo) ProduceQuota(defaultValue Quota) Quota {
+	if t == nil {
+		return defaultValue
+	}
+	return t.Settings.ProduceQuota.withDefault(defaultValue)
+}
+
+// Gets the maximum rates of the consumers of the topic, falling back to the provided default for each rate not set.
+func (t *TopicInfo) ConsumeQuota(defaultValue Quota) Quota {
+	if t == nil {
+		return defaultValue
+	}
+	return t.Settings.ConsumeQuota.withDefault(defaultValue)
+}
+
+func (q *Quota) withDefault(defaultValue Quota) Quota {
+	if q == nil {
+		return defaultValue
+	}
+	result := *q
+	if result.BytesPerSecond <= 0 {
+		result.BytesPerSecond = defaultValue.BytesPerSecond
+	}
+	if result.RequestsPerSecond <= 0 {
+		result.RequestsPerSecond = defaultValue.RequestsPerSecond
+	}
+	return result
+}
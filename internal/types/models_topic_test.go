@@ -0,0 +1,99 @@
+package types
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TopicInfo", func() {
+	defaultRetention := 24 * time.Hour
+
+	Describe("Retention()", func() {
+		It("should fall back to the default value", func() {
+			var nilInfo *TopicInfo
+			Expect(nilInfo.Retention(&defaultRetention)).To(Equal(&defaultRetention))
+			Expect((&TopicInfo{}).Retention(&defaultRetention)).To(Equal(&defaultRetention))
+			Expect((&TopicInfo{}).Retention(nil)).To(BeNil())
+		})
+
+		It("should return nil when the data should be kept forever", func() {
+			info := &TopicInfo{Settings: TopicSettings{Retention: "null"}}
+			Expect(info.Retention(&defaultRetention)).To(BeNil())
+		})
+
+		It("should parse the topic value", func() {
+			info := &TopicInfo{Settings: TopicSettings{Retention: "2h"}}
+			Expect(*info.Retention(&defaultRetention)).To(Equal(2 * time.Hour))
+			Expect(*info.Retention(nil)).To(Equal(2 * time.Hour))
+		})
+	})
+
+	Describe("RetentionBytes()", func() {
+		It("should use the topic value or fall back to the default", func() {
+			var nilInfo *TopicInfo
+			Expect(nilInfo.RetentionBytes(100)).To(Equal(int64(100)))
+			Expect((&TopicInfo{}).RetentionBytes(0)).To(Equal(int64(0)))
+			Expect((&TopicInfo{Settings: TopicSettings{RetentionBytes: 10}}).RetentionBytes(100)).To(Equal(int64(10)))
+		})
+	})
+
+	Describe("MaxMessageSize()", func() {
+		It("should use the topic value or fall back to the default", func() {
+			var nilInfo *TopicInfo
+			Expect(nilInfo.MaxMessageSize(100)).To(Equal(100))
+			Expect((&TopicInfo{}).MaxMessageSize(100)).To(Equal(100))
+			Expect((&TopicInfo{Settings: TopicSettings{MaxMessageSize: 10}}).MaxMessageSize(100)).To(Equal(10))
+		})
+	})
+
+	Describe("MaxGroupSize()", func() {
+		It("should use the topic value or fall back to the default", func() {
+			var nilInfo *TopicInfo
+			Expect(nilInfo.MaxGroupSize(100)).To(Equal(100))
+			Expect((&TopicInfo{}).MaxGroupSize(100)).To(Equal(100))
+			Expect((&TopicInfo{Settings: TopicSettings{MaxGroupSize: 10}}).MaxGroupSize(100)).To(Equal(10))
+		})
+	})
+
+	Describe("Ttl()", func() {
+		It("should parse the topic value or return zero", func() {
+			var nilInfo *TopicInfo
+			Expect(nilInfo.Ttl()).To(Equal(time.Duration(0)))
+			Expect((&TopicInfo{}).Ttl()).To(Equal(time.Duration(0)))
+			Expect((&TopicInfo{Settings: TopicSettings{Ttl: "abc"}}).Ttl()).To(Equal(time.Duration(0)))
+			Expect((&TopicInfo{Settings: TopicSettings{Ttl: "5m"}}).Ttl()).To(Equal(5 * time.Minute))
+		})
+	})
+
+	Describe("DeadLetterTopic()", func() {
+		It("should return the name of the dead-letter topic only when enabled", func() {
+			var nilInfo *TopicInfo
+			Expect(nilInfo.DeadLetterTopic()).To(Equal(""))
+			Expect((&TopicInfo{Name: "t1"}).DeadLetterTopic()).To(Equal(""))
+			Expect((&TopicInfo{Name: "t1", Settings: TopicSettings{DeadLetter: true}}).DeadLetterTopic()).To(Equal("t1.dlq"))
+		})
+	})
+
+	Describe("ProduceQuota()", func() {
+		It("should use the topic values or fall back to the default for each rate", func() {
+			defaultValue := Quota{BytesPerSecond: 100, RequestsPerSecond: 10}
+			var nilInfo *TopicInfo
+			Expect(nilInfo.ProduceQuota(defaultValue)).To(Equal(defaultValue))
+			Expect((&TopicInfo{}).ProduceQuota(defaultValue)).To(Equal(defaultValue))
+			info := &TopicInfo{Settings: TopicSettings{ProduceQuota: &Quota{BytesPerSecond: 50}}}
+			Expect(info.ProduceQuota(defaultValue)).To(Equal(Quota{BytesPerSecond: 50, RequestsPerSecond: 10}))
+			Expect(info.ConsumeQuota(defaultValue)).To(Equal(defaultValue))
+		})
+	})
+
+	Describe("IsCompacted()", func() {
+		It("should return true only for compacted topics", func() {
+			var nilInfo *TopicInfo
+			Expect(nilInfo.IsCompacted()).To(BeFalse())
+			Expect((&TopicInfo{}).IsCompacted()).To(BeFalse())
+			Expect((&TopicInfo{Settings: TopicSettings{Mode: TopicModeCompacted}}).IsCompacted()).To(BeTrue())
+		})
+	})
+})
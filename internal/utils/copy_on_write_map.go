@@ -22,6 +22,12 @@ func NewCopyOnWriteMap() *CopyOnWriteMap {
 	return c
 }
 
+// Load gets the value stored for the key, without creating it
+func (c *CopyOnWriteMap) Load(key interface{}) (value interface{}, ok bool) {
+	value, ok = c.m.Load().(map[interface{}]interface{})[key]
+	return
+}
+
 func (c *CopyOnWriteMap) LoadOrStore(key interface{}, valueCreator func() (interface{}, error)) (value interface{}, loaded bool, err error) {
 	existingMap := c.m.Load().(map[interface{}]interface{})
 	if v, ok := existingMap[key]; ok {
@@ -16,6 +16,18 @@ func TestPackage(t *testing.T) {
 }
 
 var _ = Describe("CopyOnWriteMap()", func() {
+	It("should load the values without creating them", func() {
+		m := NewCopyOnWriteMap()
+		_, ok := m.Load("a")
+		Expect(ok).To(BeFalse())
+
+		_, _, err := m.LoadOrStore("a", func() (interface{}, error) { return "value 1", nil })
+		Expect(err).NotTo(HaveOccurred())
+		v, ok := m.Load("a")
+		Expect(ok).To(BeTrue())
+		Expect(v).To(Equal("value 1"))
+	})
+
 	It("should support concurrent use", func() {
 		var wg sync.WaitGroup
 		m := NewCopyOnWriteMap()
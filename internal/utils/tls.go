@@ -0,0 +1,198 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/polarstreams/polar/internal/conf"
+	"github.com/rs/zerolog/log"
+)
+
+// Gets the TLS config for a client-facing listener, nil when TLS is not enabled.
+//
+// The certificate and the client CAs are reloaded when the files change on disk, new connections use the reloaded
+// values without restarting the listener.
+func NewServerTlsConfig(config conf.TlsConfig, nextProtos ...string) (*tls.Config, error) {
+	if config.TlsCertFile() == "" {
+		return nil, nil
+	}
+
+	r := newCertificateReloader(config.TlsCertFile(), config.TlsKeyFile(), config.TlsClientCaFile())
+	if err := r.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+	go r.reloadPeriodically(config.TlsReloadInterval())
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     nextProtos,
+		GetCertificate: r.getCertificate,
+	}
+	if r.caFile != "" {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		tlsConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			// Use the latest client CAs for each handshake
+			c := tlsConfig.Clone()
+			c.GetConfigForClient = nil
+			c.ClientCAs = r.getCaPool()
+			return c, nil
+		}
+	}
+	return tlsConfig, nil
+}
+
+// Gets the server and client TLS configs for the connections between brokers, nil when gossip TLS is not enabled.
+//
+// Each broker uses the same certificate to serve and to connect to its peers, the peer certificates must be signed by
+// one of the CAs. After verifying the chain, the server checks the identity of the client using verifyClient.
+// The client config should be cloned per connection, setting the peer host name as ServerName.
+func NewPeerTlsConfigs(
+	config conf.GossipTlsConfig,
+	verifyClient func(*x509.Certificate) error,
+) (server *tls.Config, client *tls.Config, err error) {
+	if config.GossipTlsCertFile() == "" {
+		return nil, nil, nil
+	}
+
+	r := newCertificateReloader(config.GossipTlsCertFile(), config.GossipTlsKeyFile(), config.GossipTlsCaFile())
+	if err := r.reloadIfChanged(); err != nil {
+		return nil, nil, err
+	}
+	go r.reloadPeriodically(config.TlsReloadInterval())
+
+	// The chains are verified in VerifyConnection to use the latest CAs for each handshake
+	server = &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"h2"},
+		GetCertificate: r.getCertificate,
+		ClientAuth:     tls.RequireAnyClientCert,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if err := verifyPeerChain(cs, r.getCaPool(), x509.ExtKeyUsageClientAuth); err != nil {
+				return err
+			}
+			return verifyClient(cs.PeerCertificates[0])
+		},
+	}
+	client = &tls.Config{
+		MinVersion:           tls.VersionTLS12,
+		NextProtos:           []string{"h2"},
+		GetClientCertificate: r.getClientCertificate,
+		InsecureSkipVerify:   true, // The chain and the host name are verified in VerifyConnection
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if err := verifyPeerChain(cs, r.getCaPool(), x509.ExtKeyUsageServerAuth); err != nil {
+				return err
+			}
+			return cs.PeerCertificates[0].VerifyHostname(cs.ServerName)
+		},
+	}
+	return server, client, nil
+}
+
+func verifyPeerChain(cs tls.ConnectionState, roots *x509.CertPool, usage x509.ExtKeyUsage) error {
+	if len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("Peer did not provide a certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	})
+	return err
+}
+
+// Listens on the TCP address, accepting TLS connections when the config is not nil
+func Listen(address string, tlsConfig *tls.Config) (net.Listener, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil || tlsConfig == nil {
+		return listener, err
+	}
+	return tls.NewListener(listener, tlsConfig), nil
+}
+
+// Loads the certificate and the CAs from disk when the files change
+type certificateReloader struct {
+	certFile    string
+	keyFile     string
+	caFile      string
+	certificate atomic.Value // *tls.Certificate
+	caPool      atomic.Value // *x509.CertPool
+	modTimes    []time.Time  // The modification time of the files when last loaded
+}
+
+func newCertificateReloader(certFile string, keyFile string, caFile string) *certificateReloader {
+	return &certificateReloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+}
+
+func (r *certificateReloader) reloadPeriodically(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if err := r.reloadIfChanged(); err != nil {
+			// Continue using the previous values
+			log.Err(err).Msgf("TLS certificate could not be reloaded from %s", r.certFile)
+		}
+	}
+}
+
+// Loads the files when any of them changed since the last time they were loaded
+func (r *certificateReloader) reloadIfChanged() error {
+	files := []string{r.certFile, r.keyFile}
+	if r.caFile != "" {
+		files = append(files, r.caFile)
+	}
+
+	modTimes := make([]time.Time, len(files))
+	changed := len(r.modTimes) != len(files)
+	for i, name := range files {
+		info, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		modTimes[i] = info.ModTime()
+		changed = changed || !modTimes[i].Equal(r.modTimes[i])
+	}
+	if !changed {
+		return nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	if r.caFile != "" {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("No valid certificates found in %s", r.caFile)
+		}
+		r.caPool.Store(pool)
+	}
+
+	r.certificate.Store(&certificate)
+	r.modTimes = modTimes
+	log.Info().Msgf("Loaded TLS certificate from %s", r.certFile)
+	return nil
+}
+
+func (r *certificateReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.certificate.Load().(*tls.Certificate), nil
+}
+
+func (r *certificateReloader) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.certificate.Load().(*tls.Certificate), nil
+}
+
+func (r *certificateReloader) getCaPool() *x509.CertPool {
+	return r.caPool.Load().(*x509.CertPool)
+}
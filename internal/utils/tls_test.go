@@ -0,0 +1,269 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/polarstreams/polar/internal/test/conf/mocks"
+)
+
+var _ = Describe("NewServerTlsConfig()", func() {
+	It("should return nil when TLS is not enabled", func() {
+		config := new(mocks.Config)
+		config.On("TlsCertFile").Return("")
+		Expect(NewServerTlsConfig(config)).To(BeNil())
+	})
+
+	It("should reload the certificate when the files change", func() {
+		dir := newTestDir()
+		ca, caKey := newTestCa()
+		writeTestCertificate(dir, "server", 1, ca, caKey)
+		config := newTestTlsConfig(dir, "")
+
+		tlsConfig, err := NewServerTlsConfig(config, "h2")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tlsConfig.NextProtos).To(Equal([]string{"h2"}))
+		Expect(serialNumber(tlsConfig)).To(Equal(int64(1)))
+
+		writeTestCertificate(dir, "server", 2, ca, caKey)
+		future := time.Now().Add(time.Minute)
+		for _, name := range []string{"server.crt", "server.key"} {
+			Expect(os.Chtimes(filepath.Join(dir, name), future, future)).To(Succeed())
+		}
+		Eventually(func() int64 { return serialNumber(tlsConfig) }, 2*time.Second).Should(Equal(int64(2)))
+	})
+
+	It("should require client certificates signed by the CA when the client CA is set", func() {
+		dir := newTestDir()
+		ca, caKey := newTestCa()
+		writeTestCertificate(dir, "server", 1, ca, caKey)
+		writeTestCertificate(dir, "client", 2, ca, caKey)
+		writePem(filepath.Join(dir, "ca.crt"), "CERTIFICATE", ca.Raw)
+		config := newTestTlsConfig(dir, filepath.Join(dir, "ca.crt"))
+
+		tlsConfig, err := NewServerTlsConfig(config)
+		Expect(err).NotTo(HaveOccurred())
+		listener, err := Listen("127.0.0.1:0", tlsConfig)
+		Expect(err).NotTo(HaveOccurred())
+		defer listener.Close()
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer conn.Close()
+					_, _ = conn.Write([]byte("ok"))
+				}()
+			}
+		}()
+
+		roots := x509.NewCertPool()
+		roots.AddCert(ca)
+		clientCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(readTls(listener.Addr(), &tls.Config{RootCAs: roots, ServerName: "localhost"})).NotTo(Succeed())
+		Expect(readTls(listener.Addr(), &tls.Config{
+			RootCAs:      roots,
+			ServerName:   "localhost",
+			Certificates: []tls.Certificate{clientCert},
+		})).To(Succeed())
+	})
+})
+
+var _ = Describe("NewPeerTlsConfigs()", func() {
+	It("should return nil when gossip TLS is not enabled", func() {
+		config := new(mocks.Config)
+		config.On("GossipTlsCertFile").Return("")
+		server, client, err := NewPeerTlsConfigs(config, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(server).To(BeNil())
+		Expect(client).To(BeNil())
+	})
+
+	Context("with certificates signed by the CA", func() {
+		dir := ""
+		var config *mocks.Config
+
+		BeforeEach(func() {
+			dir = newTestDir()
+			ca, caKey := newTestCa()
+			writeTestCertificate(dir, "server", 1, ca, caKey)
+			writePem(filepath.Join(dir, "ca.crt"), "CERTIFICATE", ca.Raw)
+			config = new(mocks.Config)
+			config.On("GossipTlsCertFile").Return(filepath.Join(dir, "server.crt"))
+			config.On("GossipTlsKeyFile").Return(filepath.Join(dir, "server.key"))
+			config.On("GossipTlsCaFile").Return(filepath.Join(dir, "ca.crt"))
+			config.On("TlsReloadInterval").Return(time.Minute)
+		})
+
+		It("should authenticate both peers", func() {
+			var verified *x509.Certificate
+			server, client, err := NewPeerTlsConfigs(config, func(cert *x509.Certificate) error {
+				verified = cert
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(peerHandshake(server, client, "localhost")).To(Succeed())
+			Expect(verified.SerialNumber.Int64()).To(Equal(int64(1)))
+		})
+
+		It("should fail when the client identity is rejected", func() {
+			server, client, err := NewPeerTlsConfigs(config, func(cert *x509.Certificate) error {
+				return fmt.Errorf("Rejected")
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(peerHandshake(server, client, "localhost")).To(MatchError("Rejected"))
+		})
+
+		It("should fail when the server certificate does not match the host name", func() {
+			server, client, err := NewPeerTlsConfigs(config, func(cert *x509.Certificate) error { return nil })
+			Expect(err).NotTo(HaveOccurred())
+			Expect(peerHandshake(server, client, "other-host")).NotTo(Succeed())
+		})
+
+		It("should fail when the peer certificate is not signed by the CA", func() {
+			otherDir := newTestDir()
+			otherCa, otherCaKey := newTestCa()
+			writeTestCertificate(otherDir, "server", 2, otherCa, otherCaKey)
+			otherConfig := new(mocks.Config)
+			otherConfig.On("GossipTlsCertFile").Return(filepath.Join(otherDir, "server.crt"))
+			otherConfig.On("GossipTlsKeyFile").Return(filepath.Join(otherDir, "server.key"))
+			otherConfig.On("GossipTlsCaFile").Return(filepath.Join(dir, "ca.crt"))
+			otherConfig.On("TlsReloadInterval").Return(time.Minute)
+
+			server, _, err := NewPeerTlsConfigs(config, func(cert *x509.Certificate) error { return nil })
+			Expect(err).NotTo(HaveOccurred())
+			_, otherClient, err := NewPeerTlsConfigs(otherConfig, func(cert *x509.Certificate) error { return nil })
+			Expect(err).NotTo(HaveOccurred())
+			Expect(peerHandshake(server, otherClient, "localhost")).NotTo(Succeed())
+		})
+	})
+})
+
+// Performs the TLS handshake between the peers, returning the server error when any
+func peerHandshake(serverConfig *tls.Config, clientConfig *tls.Config, serverName string) error {
+	serverConn, clientConn := tcpConnPair()
+	serverResult := make(chan error, 1)
+	go func() {
+		serverResult <- tls.Server(serverConn, serverConfig).Handshake()
+		serverConn.Close()
+	}()
+
+	config := clientConfig.Clone()
+	config.ServerName = serverName
+	clientErr := tls.Client(clientConn, config).Handshake()
+	clientConn.Close()
+	if err := <-serverResult; err != nil && clientErr == nil {
+		return err
+	}
+	return clientErr
+}
+
+// Gets both sides of a loopback TCP connection, as the TLS handshake messages are not read in lockstep
+func tcpConnPair() (net.Conn, net.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	defer listener.Close()
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	Expect(err).NotTo(HaveOccurred())
+	serverConn, err := listener.Accept()
+	Expect(err).NotTo(HaveOccurred())
+	return serverConn, clientConn
+}
+
+func newTestTlsConfig(dir string, caFile string) *mocks.Config {
+	config := new(mocks.Config)
+	config.On("TlsCertFile").Return(filepath.Join(dir, "server.crt"))
+	config.On("TlsKeyFile").Return(filepath.Join(dir, "server.key"))
+	config.On("TlsClientCaFile").Return(caFile)
+	config.On("TlsReloadInterval").Return(20 * time.Millisecond)
+	return config
+}
+
+func serialNumber(tlsConfig *tls.Config) int64 {
+	certificate, err := tlsConfig.GetCertificate(nil)
+	Expect(err).NotTo(HaveOccurred())
+	parsed, err := x509.ParseCertificate(certificate.Certificate[0])
+	Expect(err).NotTo(HaveOccurred())
+	return parsed.SerialNumber.Int64()
+}
+
+func readTls(address net.Addr, config *tls.Config) error {
+	conn, err := tls.Dial("tcp", address.String(), config)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	buf := make([]byte, 2)
+	_, err = conn.Read(buf)
+	return err
+}
+
+func newTestCa() (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(100),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+	ca, err := x509.ParseCertificate(der)
+	Expect(err).NotTo(HaveOccurred())
+	return ca, key
+}
+
+// Writes the "<name>.crt" and "<name>.key" files of a certificate signed by the CA, valid for server and client auth
+func writeTestCertificate(dir string, name string, serial int64, ca *x509.Certificate, caKey *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	Expect(err).NotTo(HaveOccurred())
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+
+	writePem(filepath.Join(dir, name+".crt"), "CERTIFICATE", der)
+	writePem(filepath.Join(dir, name+".key"), "EC PRIVATE KEY", keyDer)
+}
+
+func writePem(fileName string, blockType string, bytes []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: bytes})
+	Expect(os.WriteFile(fileName, data, 0600)).To(Succeed())
+}
+
+func newTestDir() string {
+	dir, err := ioutil.TempDir("", "tls_test")
+	Expect(err).NotTo(HaveOccurred())
+	return dir
+}
@@ -10,6 +10,7 @@ import (
 	"math/rand"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -54,11 +55,25 @@ func adaptHttpErr(err error, w http.ResponseWriter) {
 		return
 	}
 
+	SetRetryAfter(w, err)
 	w.WriteHeader(httpErr.StatusCode())
 	// The message is supposed to be user friendly
 	fmt.Fprint(w, err.Error())
 }
 
+// Sets the Retry-After header in seconds when the error was caused by the client exceeding a quota
+func SetRetryAfter(w http.ResponseWriter, err error) {
+	throttledErr, ok := err.(types.ThrottledError)
+	if !ok {
+		return
+	}
+	seconds := int(math.Ceil(throttledErr.RetryAfter().Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
 // ToPostHandle wraps a handle func with error, returns plain text "OK" and converts it to a `httprouter.Handle`
 func ToPostHandle(he HandleWithError) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -70,6 +85,22 @@ func ToPostHandle(he HandleWithError) httprouter.Handle {
 	}
 }
 
+// Gets the HTTP headers that should be stored along with the record, the ones prefixed with `X-Polar-Header-`.
+// Returns nil when there aren't any.
+func RecordHeaders(header http.Header) http.Header {
+	var result http.Header
+	for name, values := range header {
+		if len(name) > len(types.RecordHeaderPrefix) &&
+			strings.EqualFold(name[:len(types.RecordHeaderPrefix)], types.RecordHeaderPrefix) {
+			if result == nil {
+				result = http.Header{}
+			}
+			result[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+	return result
+}
+
 // Writes a text message in the response
 func RespondText(w http.ResponseWriter, message string) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -1,11 +1,15 @@
 package utils
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"time"
 
+	"github.com/julienschmidt/httprouter"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/polarstreams/polar/internal/types"
 )
 
 var _ = Describe("utils", func() {
@@ -44,6 +48,38 @@ var _ = Describe("utils", func() {
 		})
 	})
 
+	Describe("RecordHeaders()", func() {
+		It("should return the headers with the record prefix", func() {
+			header := http.Header{}
+			header.Set("Content-Type", "application/json")
+			header.Set("X-Polar-Header-Trace-Id", "abc")
+			header["x-polar-header-lower"] = []string{"a", "b"}
+			header.Set("X-Polar-Header-", "empty name")
+
+			Expect(RecordHeaders(header)).To(Equal(http.Header{
+				"X-Polar-Header-Trace-Id": {"abc"},
+				"X-Polar-Header-Lower":    {"a", "b"},
+			}))
+		})
+
+		It("should return nil when there are no record headers", func() {
+			Expect(RecordHeaders(http.Header{"Accept": {"*/*"}})).To(BeNil())
+		})
+	})
+
+	Describe("ToHandle()", func() {
+		It("should set the Retry-After header in seconds when the client was throttled", func() {
+			handle := ToHandle(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) error {
+				return types.NewThrottledError(1200*time.Millisecond, "Quota exceeded")
+			})
+			w := httptest.NewRecorder()
+			handle(w, httptest.NewRequest(http.MethodPost, "/v1/topic/abc/messages", nil), nil)
+			Expect(w.Code).To(Equal(http.StatusTooManyRequests))
+			Expect(w.Header().Get("Retry-After")).To(Equal("2"))
+			Expect(w.Body.String()).To(Equal("Quota exceeded"))
+		})
+	})
+
 	Describe("ReadIntoBuffers()", func() {
 		It("should read into the first buffer", func() {
 			buffers := [][]byte{
@@ -9,9 +9,12 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/polarstreams/polar/internal/admin"
+	"github.com/polarstreams/polar/internal/auth"
 	"github.com/polarstreams/polar/internal/conf"
 	"github.com/polarstreams/polar/internal/consuming"
 	"github.com/polarstreams/polar/internal/data"
+	"github.com/polarstreams/polar/internal/data/acls"
 	"github.com/polarstreams/polar/internal/data/topics"
 	"github.com/polarstreams/polar/internal/discovery"
 	"github.com/polarstreams/polar/internal/interbroker"
@@ -19,6 +22,7 @@ import (
 	"github.com/polarstreams/polar/internal/metrics"
 	"github.com/polarstreams/polar/internal/ownership"
 	"github.com/polarstreams/polar/internal/producing"
+	"github.com/polarstreams/polar/internal/quotas"
 	"github.com/polarstreams/polar/internal/types"
 	"github.com/polarstreams/polar/internal/utils"
 	"github.com/rs/zerolog"
@@ -65,15 +69,35 @@ func main() {
 	}
 
 	localDbClient := localdb.NewClient(config)
-	topicHandler := topics.NewHandler(config)
 	discoverer := discovery.NewDiscoverer(config, localDbClient)
 	datalog := data.NewDatalog(config)
 	gossiper := interbroker.NewGossiper(config, discoverer, localDbClient, datalog)
+	topicHandler := topics.NewHandler(config, localDbClient, discoverer, gossiper)
+	datalog.RegisterTopicGetter(topicHandler)
 	generator := ownership.NewGenerator(config, discoverer, gossiper, localDbClient)
-	producer := producing.NewProducer(config, topicHandler, discoverer, datalog, gossiper)
-	consumer := consuming.NewConsumer(config, localDbClient, discoverer, datalog, gossiper)
-
-	toInit := []types.Initializer{localDbClient, topicHandler, discoverer, gossiper, generator, producer, consumer}
+	authenticator := auth.NewAuthenticator(config)
+	aclHandler := acls.NewHandler(config, localDbClient, discoverer, gossiper)
+	limiter := quotas.NewLimiter(config, topicHandler, authenticator)
+	producer := producing.NewProducer(
+		config, topicHandler, discoverer, datalog, gossiper, localDbClient, authenticator, aclHandler, limiter)
+	consumer := consuming.NewConsumer(
+		config, localDbClient, topicHandler, discoverer, datalog, gossiper, producer, authenticator, aclHandler,
+		limiter)
+	adminServer := admin.NewAdmin(config, discoverer, topicHandler, aclHandler, consumer, producer, authenticator)
+
+	toInit := []types.Initializer{
+		localDbClient,
+		discoverer,
+		datalog,
+		gossiper,
+		topicHandler,
+		generator,
+		authenticator,
+		aclHandler,
+		limiter,
+		producer,
+		consumer,
+	}
 
 	for _, item := range toInit {
 		if err := item.Init(); err != nil {
@@ -103,6 +127,10 @@ func main() {
 		log.Fatal().Err(err).Msg("Exiting")
 	}
 
+	if err := adminServer.AcceptConnections(); err != nil {
+		log.Fatal().Err(err).Msg("Exiting")
+	}
+
 	log.Info().Msg("PolarStreams started")
 
 	sigChan := make(chan os.Signal, 1)
@@ -117,6 +145,7 @@ func main() {
 	localDbClient.MarkAsShuttingDown()
 	producer.Close()
 	consumer.Close()
+	adminServer.Close()
 	gossiper.SendGoobye()
 
 	if config.ShutdownDelay() > 0 {